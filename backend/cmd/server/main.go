@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/config"
+	"github.com/joezmuda/trackside-backend/internal/database"
+	"github.com/joezmuda/trackside-backend/internal/importer"
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/joezmuda/trackside-backend/internal/repository"
+	"github.com/joezmuda/trackside-backend/internal/router"
+	"github.com/labstack/echo/v4"
+)
+
+const usage = `Usage: server [command] [flags]
+
+Commands:
+  serve         Run migrations and start the HTTP server (default)
+  migrate       Apply migrations (migrate up [--to N]) or list them (migrate status)
+  seed          Apply migrations and load demo seed data
+  sync-tracks   Import tracks from DATA_DIR/usa-tracks.json or --file (--dry-run, --prune)
+  sync-zones    Import track zones from DATA_DIR/track-zones.json
+  sync-classes  Import car classes and PAX indexes from DATA_DIR/pax-index.csv
+  backup        Write a consistent copy of the database to a file
+  grant-role    Set a user's role, e.g. to bootstrap the first admin
+
+Run "server <command> -h" for command flags.
+`
+
+func main() {
+	args := os.Args[1:]
+	cmd := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	cfg := config.Load()
+
+	var err error
+	switch cmd {
+	case "serve":
+		err = runServe(cfg, args)
+	case "migrate":
+		err = runMigrate(cfg, args)
+	case "seed":
+		err = runSeed(cfg, args)
+	case "sync-tracks":
+		err = runSyncTracks(cfg, args)
+	case "sync-zones":
+		err = runSyncZones(cfg, args)
+	case "sync-classes":
+		err = runSyncClasses(cfg, args)
+	case "backup":
+		err = runBackup(cfg, args)
+	case "grant-role":
+		err = runGrantRole(cfg, args)
+	case "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+}
+
+// openDB connects to the configured database and brings the schema up to date.
+func openDB(cfg *config.Config) (*sql.DB, error) {
+	db, err := database.Connect(cfg.DatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := database.Migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func runServe(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	seed := fs.Bool("seed", false, "load demo seed data before serving")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if cfg.TwoFactorRole != "" && !models.ValidRole(cfg.TwoFactorRole) {
+		return fmt.Errorf("REQUIRE_2FA_ROLE must be USER, MODERATOR or ADMIN, not %q", cfg.TwoFactorRole)
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *seed {
+		if err := database.Seed(db); err != nil {
+			return fmt.Errorf("seed: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(cfg.UploadDir, 0755); err != nil {
+		return fmt.Errorf("create upload dir: %w", err)
+	}
+
+	e := echo.New()
+	e.HideBanner = true
+	router.Setup(e, db, cfg)
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("Trackside API listening on :%s", cfg.Port)
+		if err := e.Start(":" + cfg.Port); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-quit:
+	}
+
+	log.Println("Shutting down...")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return e.Shutdown(ctx)
+}
+
+func runMigrate(cfg *config.Config, args []string) error {
+	sub := "up"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		sub, args = args[0], args[1:]
+	}
+
+	switch sub {
+	case "up":
+		fs := flag.NewFlagSet("migrate up", flag.ContinueOnError)
+		to := fs.Int("to", 0, "stop after applying this version (default: latest)")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+
+		db, err := database.Connect(cfg.DatabaseURL)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return database.MigrateTo(db, *to)
+
+	case "status":
+		fs := flag.NewFlagSet("migrate status", flag.ContinueOnError)
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+
+		db, err := database.Connect(cfg.DatabaseURL)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		statuses, err := database.GetMigrationStatus(db)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return w.Flush()
+
+	default:
+		return fmt.Errorf("unknown migrate command %q (want up or status)", sub)
+	}
+}
+
+func runSeed(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return database.Seed(db)
+}
+
+func runSyncTracks(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("sync-tracks", flag.ContinueOnError)
+	dataDir := fs.String("data-dir", cfg.DataDir, "directory containing "+importer.TracksFile)
+	dryRun := fs.Bool("dry-run", false, "show what would change without saving anything")
+	prune := fs.Bool("prune", false, "archive imported tracks that are no longer in the data file")
+	path := fs.String("file", "", "sync a JSON, CSV or GeoJSON tracks file instead of the bundled one")
+	source := fs.String("source", "", "source name for the file's tracks (default: the file name)")
+	country := fs.String("country", "", "two-letter country code for tracks in the file that don't give one")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var file *importer.TrackFile
+	if *path != "" {
+		data, err := os.ReadFile(*path)
+		if err != nil {
+			return fmt.Errorf("could not read tracks file: %w", err)
+		}
+		file = &importer.TrackFile{Source: *source, Name: filepath.Base(*path), Data: data, Country: strings.ToUpper(*country)}
+		if file.Source == "" {
+			file.Source = strings.ToLower(file.Name)
+			if importer.BundledSource(file.Source) {
+				return fmt.Errorf("--source is required for a file named like the bundled %s", importer.TracksFile)
+			}
+		}
+		if !importer.ValidSource(file.Source) {
+			return fmt.Errorf("invalid source %q: use up to 50 lowercase letters, digits, dots, dashes or underscores", file.Source)
+		}
+		if file.Country != "" && !importer.ValidCountry(file.Country) {
+			return fmt.Errorf("invalid country %q: use a two-letter code", *country)
+		}
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	opts := models.SyncOptions{DryRun: *dryRun, Prune: *prune}
+	startedAt := time.Now()
+	trackRepo, userRepo := repository.NewTrackRepo(db), repository.NewUserRepo(db)
+	runSource := importer.TracksFile
+	var resp *models.SyncTracksResponse
+	if file != nil {
+		runSource = file.Source
+		resp, err = importer.SyncTrackFile(trackRepo, userRepo, *file, opts)
+	} else {
+		resp, err = importer.SyncTracks(trackRepo, userRepo, *dataDir, opts)
+	}
+	if recErr := repository.NewSyncRunRepo(db).Create(importer.TrackSyncRun(runSource, opts, startedAt, resp, err), nil); recErr != nil {
+		log.Printf("record sync run: %v", recErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, c := range resp.Changes {
+		fields := make([]string, 0, len(c.Changes))
+		for field, fc := range c.Changes {
+			fields = append(fields, fmt.Sprintf("%s: %v -> %v", field, deref(fc.From), deref(fc.To)))
+		}
+		sort.Strings(fields)
+		log.Printf("  %s %s (%s): %s", c.Action, c.Name, c.Location, strings.Join(fields, ", "))
+	}
+	verb := "Synced"
+	if *dryRun {
+		verb = "Dry run, would sync"
+	}
+	s := resp.Summary
+	log.Printf("%s %d tracks: %d created, %d updated (%d unchanged), %d skipped, %d archived, %d failed",
+		verb, s.Total, s.Created, s.Updated, s.Unchanged, s.Skipped, s.Archived, s.Failed)
+	for _, e := range resp.Errors {
+		log.Printf("  %s", e)
+	}
+	if s.Failed > 0 {
+		return fmt.Errorf("%d tracks failed to import", s.Failed)
+	}
+	return nil
+}
+
+// deref prints pointers in sync changes as their values.
+func deref(v interface{}) interface{} {
+	switch p := v.(type) {
+	case *string:
+		if p != nil {
+			return *p
+		}
+		return nil
+	case *float64:
+		if p != nil {
+			return *p
+		}
+		return nil
+	}
+	return v
+}
+
+func runSyncZones(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("sync-zones", flag.ContinueOnError)
+	dataDir := fs.String("data-dir", cfg.DataDir, "directory containing "+importer.ZonesFile)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	resp, err := importer.SyncZones(repository.NewTrackRepo(db), repository.NewZoneRepo(db), *dataDir)
+	if err != nil {
+		return err
+	}
+
+	s := resp.Summary
+	log.Printf("Synced %d zones on %d of %d tracks: %d created, %d updated, %d skipped, %d failed",
+		s.Total, s.Tracks-s.Unmatched, s.Tracks, s.Created, s.Updated, s.Skipped, s.Failed)
+	for _, t := range resp.Unmatched {
+		log.Printf("  no track matches %s", t)
+	}
+	for _, e := range resp.Errors {
+		log.Printf("  %s", e)
+	}
+	if s.Failed > 0 {
+		return fmt.Errorf("%d zones failed to import", s.Failed)
+	}
+	return nil
+}
+
+func runSyncClasses(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("sync-classes", flag.ContinueOnError)
+	dataDir := fs.String("data-dir", cfg.DataDir, "directory containing "+importer.ClassesFile)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	resp, err := importer.SyncClasses(repository.NewCarClassRepo(db), *dataDir)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Synced %d classes: %d created, %d updated, %d failed",
+		resp.Summary.Total, resp.Summary.Created, resp.Summary.Updated, resp.Summary.Failed)
+	for _, e := range resp.Errors {
+		log.Printf("  %s", e)
+	}
+	if resp.Summary.Failed > 0 {
+		return fmt.Errorf("%d classes failed to import", resp.Summary.Failed)
+	}
+	return nil
+}
+
+func runBackup(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	out := fs.String("out", "", "destination file (default: trackside-<timestamp>.db next to the database)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	dest := *out
+	if dest == "" {
+		name := "trackside-" + time.Now().UTC().Format("20060102-150405") + ".db"
+		dest = filepath.Join(filepath.Dir(cfg.DatabaseURL), name)
+	}
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+
+	db, err := database.Connect(cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := database.Backup(db, dest); err != nil {
+		return err
+	}
+	log.Printf("Backed up %s to %s", cfg.DatabaseURL, dest)
+	return nil
+}
+
+func runGrantRole(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("grant-role", flag.ContinueOnError)
+	email := fs.String("email", "", "email of the user to update (required)")
+	role := fs.String("role", string(models.RoleAdmin), "role to grant: USER, MODERATOR or ADMIN")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return errors.New("--email is required")
+	}
+	if !models.ValidRole(*role) {
+		return fmt.Errorf("invalid role %q", *role)
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	userRepo := repository.NewUserRepo(db)
+	user, err := userRepo.FindByEmail(*email)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("no user with email %s", *email)
+	}
+	if _, err := userRepo.SetRole(user.ID, *role); err != nil {
+		return err
+	}
+	log.Printf("%s is now %s (takes effect at next login)", *email, *role)
+	return nil
+}
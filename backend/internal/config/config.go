@@ -12,9 +12,34 @@ type Config struct {
 	UploadDir   string
 	CORSOrigins []string
 	DataDir     string
+	// AppURL is the frontend's address, for links in emails.
+	AppURL string
+	// MailDir, when set, is where outgoing emails are written as .eml files.
+	// Otherwise they are printed to stdout.
+	MailDir string
+	// OIDCProviders are the OpenID Connect providers users can sign in with.
+	OIDCProviders []OIDCProvider
+	// TwoFactorRole, when set, makes users holding that role or a higher one
+	// turn on two-factor authentication before they can moderate or admin.
+	TwoFactorRole string
+}
+
+// OIDCProvider is an OpenID Connect provider, such as Google or a club's
+// own identity server, configured through OIDC_<ID>_* variables.
+type OIDCProvider struct {
+	ID           string
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the frontend page the provider sends users back to.
+	// It passes the code and state on to the callback endpoint.
+	RedirectURL string
+	Scopes      []string
 }
 
 func Load() *Config {
+	appURL := strings.TrimRight(getEnv("APP_URL", "http://localhost:3000"), "/")
 	return &Config{
 		Port:        getEnv("PORT", "8080"),
 		DatabaseURL: getEnv("DATABASE_URL", "./trackside.db"),
@@ -22,7 +47,40 @@ func Load() *Config {
 		UploadDir:   getEnv("UPLOAD_DIR", "./uploads"),
 		CORSOrigins: strings.Split(getEnv("CORS_ORIGINS", "http://localhost:3000"), ","),
 		DataDir:     getEnv("DATA_DIR", "../trackside/data"),
+		AppURL:      appURL,
+		MailDir:     getEnv("MAIL_DIR", ""),
+
+		OIDCProviders: loadOIDCProviders(appURL),
+		TwoFactorRole: strings.ToUpper(getEnv("REQUIRE_2FA_ROLE", "")),
+	}
+}
+
+// loadOIDCProviders reads the providers listed in OIDC_PROVIDERS, e.g.
+// "google,okta". Each needs OIDC_<ID>_ISSUER and OIDC_<ID>_CLIENT_ID; a
+// provider missing either is left out.
+func loadOIDCProviders(appURL string) []OIDCProvider {
+	var providers []OIDCProvider
+	for _, id := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if id == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(id, "-", "_")) + "_"
+		p := OIDCProvider{
+			ID:           id,
+			Name:         getEnv(prefix+"NAME", id),
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  getEnv(prefix+"REDIRECT_URL", appURL+"/auth/callback/"+id),
+			Scopes:       strings.Fields(getEnv(prefix+"SCOPES", "openid email profile")),
+		}
+		if p.Issuer == "" || p.ClientID == "" {
+			continue
+		}
+		providers = append(providers, p)
 	}
+	return providers
 }
 
 func getEnv(key, fallback string) string {
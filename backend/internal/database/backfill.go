@@ -0,0 +1,59 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/joezmuda/trackside-backend/internal/laptime"
+)
+
+// migrationHooks run right after a migration's SQL, inside its transaction,
+// for data changes that are impractical in SQLite alone. Like migration files,
+// a released hook must not change behaviour.
+var migrationHooks = map[int]func(tx *sql.Tx) error{
+	6: backfillLapTimeMs,
+}
+
+// backfillLapTimeMs parses the free-form lapTime of existing lap records,
+// storing milliseconds and rewriting the text in canonical form.
+func backfillLapTimeMs(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, lapTime FROM "LapRecord" WHERE lapTimeMs IS NULL`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type lap struct{ id, lapTime string }
+	var laps []lap
+	for rows.Next() {
+		var l lap
+		if err := rows.Scan(&l.id, &l.lapTime); err != nil {
+			return err
+		}
+		laps = append(laps, l)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	unparsed := 0
+	for _, l := range laps {
+		ms, err := laptime.Parse(l.lapTime)
+		if err != nil {
+			log.Printf("Lap record %s: can't parse lap time %q: %v", l.id, l.lapTime, err)
+			unparsed++
+			continue
+		}
+		if _, err := tx.Exec(
+			`UPDATE "LapRecord" SET lapTime = ?, lapTimeMs = ? WHERE id = ?`,
+			laptime.Format(ms), ms, l.id,
+		); err != nil {
+			return err
+		}
+	}
+	if unparsed > 0 {
+		log.Printf("%d lap records kept their lap time text without milliseconds", unparsed)
+	}
+	return nil
+}
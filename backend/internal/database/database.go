@@ -2,16 +2,47 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/joezmuda/trackside-backend/internal/geo"
+	"github.com/mattn/go-sqlite3"
 )
 
+// driverName is the stock sqlite3 driver with Trackside's SQL functions
+// registered on every connection.
+const driverName = "sqlite3_trackside"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("distance_km", sqlDistanceKm, true)
+		},
+	})
+}
+
+// sqlDistanceKm backs distance_km(lat1, lng1, lat2, lng2). It returns NULL
+// when any coordinate is NULL, e.g. for tracks that were never geocoded.
+func sqlDistanceKm(lat1, lng1, lat2, lng2 interface{}) interface{} {
+	var coords [4]float64
+	for i, v := range []interface{}{lat1, lng1, lat2, lng2} {
+		switch n := v.(type) {
+		case float64:
+			coords[i] = n
+		case int64:
+			coords[i] = float64(n)
+		default:
+			return nil
+		}
+	}
+	return geo.DistanceKm(coords[0], coords[1], coords[2], coords[3])
+}
+
 func Connect(dsn string) (*sql.DB, error) {
 	connStr := dsn + "?_foreign_keys=on&_journal_mode=WAL"
 
-	db, err := sql.Open("sqlite3", connStr)
+	db, err := sql.Open(driverName, connStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -20,6 +51,16 @@ func Connect(dsn string) (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
+	// Track search needs FTS5, which go-sqlite3 only builds in with a tag
+	var fts5 bool
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+		return nil, fmt.Errorf("failed to check SQLite features: %w", err)
+	}
+	if !fts5 {
+		db.Close()
+		return nil, errors.New("SQLite was built without FTS5: build with -tags sqlite_fts5")
+	}
+
 	// SQLite performance tunings
 	pragmas := []string{
 		"PRAGMA journal_mode=WAL",
@@ -36,11 +77,11 @@ func Connect(dsn string) (*sql.DB, error) {
 	return db, nil
 }
 
-func Migrate(db *sql.DB) error {
-	_, err := db.Exec(schema)
-	if err != nil {
-		return fmt.Errorf("failed to run migrations: %w", err)
+// Backup writes a consistent snapshot of the database to dest using
+// VACUUM INTO, which is safe to run while the server is serving requests.
+func Backup(db *sql.DB, dest string) error {
+	if _, err := db.Exec(`VACUUM INTO ?`, dest); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
 	}
-	log.Println("Database migrations applied successfully")
 	return nil
 }
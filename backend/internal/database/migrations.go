@@ -1,180 +1,257 @@
 package database
 
-const schema = `
-CREATE TABLE IF NOT EXISTS "User" (
-    "id" TEXT NOT NULL PRIMARY KEY,
-    "name" TEXT,
-    "email" TEXT NOT NULL,
-    "emailVerified" DATETIME,
-    "passwordHash" TEXT,
-    "image" TEXT,
-    "experience" TEXT NOT NULL DEFAULT 'BEGINNER',
-    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    "updatedAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
-);
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
 
-CREATE TABLE IF NOT EXISTS "Account" (
-    "id" TEXT NOT NULL PRIMARY KEY,
-    "userId" TEXT NOT NULL,
-    "type" TEXT NOT NULL,
-    "provider" TEXT NOT NULL,
-    "providerAccountId" TEXT NOT NULL,
-    "refresh_token" TEXT,
-    "access_token" TEXT,
-    "expires_at" INTEGER,
-    "token_type" TEXT,
-    "scope" TEXT,
-    "id_token" TEXT,
-    "session_state" TEXT,
-    CONSTRAINT "Account_userId_fkey" FOREIGN KEY ("userId") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
-);
+// Migrations live in migrations/ as NNNN_description.sql and are applied in
+// version order. They are forward-only: once a version has been applied its
+// file must not change, and new schema changes always go in a new file.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
 
-CREATE TABLE IF NOT EXISTS "Session" (
-    "id" TEXT NOT NULL PRIMARY KEY,
-    "sessionToken" TEXT NOT NULL,
-    "userId" TEXT NOT NULL,
-    "expires" DATETIME NOT NULL,
-    CONSTRAINT "Session_userId_fkey" FOREIGN KEY ("userId") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+const migrationsTable = `
+CREATE TABLE IF NOT EXISTS "schema_migrations" (
+    "version" INTEGER NOT NULL PRIMARY KEY,
+    "name" TEXT NOT NULL,
+    "checksum" TEXT NOT NULL,
+    "appliedAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
+`
 
-CREATE TABLE IF NOT EXISTS "VerificationToken" (
-    "identifier" TEXT NOT NULL,
-    "token" TEXT NOT NULL,
-    "expires" DATETIME NOT NULL
-);
+type Migration struct {
+	Version  int
+	Name     string
+	Checksum string
+	SQL      string
+}
 
-CREATE TABLE IF NOT EXISTS "Car" (
-    "id" TEXT NOT NULL PRIMARY KEY,
-    "make" TEXT NOT NULL,
-    "model" TEXT NOT NULL,
-    "year" INTEGER NOT NULL,
-    "userId" TEXT NOT NULL,
-    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    "updatedAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    CONSTRAINT "Car_userId_fkey" FOREIGN KEY ("userId") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
-);
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"appliedAt"`
+}
 
-CREATE TABLE IF NOT EXISTS "CarMod" (
-    "id" TEXT NOT NULL PRIMARY KEY,
-    "name" TEXT NOT NULL,
-    "category" TEXT NOT NULL,
-    "notes" TEXT,
-    "carId" TEXT NOT NULL,
-    CONSTRAINT "CarMod_carId_fkey" FOREIGN KEY ("carId") REFERENCES "Car" ("id") ON DELETE CASCADE ON UPDATE CASCADE
-);
+// LoadMigrations returns the embedded migrations sorted by version.
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
 
-CREATE TABLE IF NOT EXISTS "Track" (
-    "id" TEXT NOT NULL PRIMARY KEY,
-    "name" TEXT NOT NULL,
-    "location" TEXT NOT NULL,
-    "state" TEXT,
-    "description" TEXT,
-    "imageUrl" TEXT,
-    "latitude" REAL,
-    "longitude" REAL,
-    "status" TEXT NOT NULL DEFAULT 'APPROVED',
-    "isImported" BOOLEAN NOT NULL DEFAULT false,
-    "uploadedById" TEXT NOT NULL,
-    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    "updatedAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    CONSTRAINT "Track_uploadedById_fkey" FOREIGN KEY ("uploadedById") REFERENCES "User" ("id") ON DELETE RESTRICT ON UPDATE CASCADE
-);
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		filename := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(filename, ".sql") {
+			continue
+		}
 
-CREATE TABLE IF NOT EXISTS "TrackImage" (
-    "id" TEXT NOT NULL PRIMARY KEY,
-    "url" TEXT NOT NULL,
-    "caption" TEXT,
-    "trackId" TEXT NOT NULL,
-    "uploadedById" TEXT NOT NULL,
-    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    CONSTRAINT "TrackImage_trackId_fkey" FOREIGN KEY ("trackId") REFERENCES "Track" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
-    CONSTRAINT "TrackImage_uploadedById_fkey" FOREIGN KEY ("uploadedById") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
-);
+		prefix, rest, ok := strings.Cut(strings.TrimSuffix(filename, ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration filename %q: want NNNN_description.sql", filename)
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("duplicate migration version %d: %s and %s", version, other, filename)
+		}
+		seen[version] = filename
 
-CREATE TABLE IF NOT EXISTS "TrackEvent" (
-    "id" TEXT NOT NULL PRIMARY KEY,
-    "eventType" TEXT NOT NULL,
-    "trackId" TEXT NOT NULL,
-    CONSTRAINT "TrackEvent_trackId_fkey" FOREIGN KEY ("trackId") REFERENCES "Track" ("id") ON DELETE CASCADE ON UPDATE CASCADE
-);
+		body, err := migrationFiles.ReadFile("migrations/" + filename)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(body)
+		migrations = append(migrations, Migration{
+			Version:  version,
+			Name:     rest,
+			Checksum: hex.EncodeToString(sum[:]),
+			SQL:      string(body),
+		})
+	}
 
-CREATE TABLE IF NOT EXISTS "TrackZone" (
-    "id" TEXT NOT NULL PRIMARY KEY,
-    "name" TEXT NOT NULL,
-    "description" TEXT,
-    "posX" REAL NOT NULL,
-    "posY" REAL NOT NULL,
-    "trackId" TEXT NOT NULL,
-    "eventType" TEXT,
-    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    CONSTRAINT "TrackZone_trackId_fkey" FOREIGN KEY ("trackId") REFERENCES "Track" ("id") ON DELETE CASCADE ON UPDATE CASCADE
-);
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
 
-CREATE TABLE IF NOT EXISTS "ZoneTip" (
-    "id" TEXT NOT NULL PRIMARY KEY,
-    "content" TEXT NOT NULL,
-    "conditions" TEXT,
-    "zoneId" TEXT NOT NULL,
-    "authorId" TEXT NOT NULL,
-    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    "updatedAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    CONSTRAINT "ZoneTip_zoneId_fkey" FOREIGN KEY ("zoneId") REFERENCES "TrackZone" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
-    CONSTRAINT "ZoneTip_authorId_fkey" FOREIGN KEY ("authorId") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
-);
+// Migrate applies every pending migration.
+func Migrate(db *sql.DB) error {
+	return MigrateTo(db, 0)
+}
 
-CREATE TABLE IF NOT EXISTS "TrackReview" (
-    "id" TEXT NOT NULL PRIMARY KEY,
-    "rating" INTEGER NOT NULL,
-    "content" TEXT,
-    "conditions" TEXT NOT NULL,
-    "trackId" TEXT NOT NULL,
-    "trackEventId" TEXT,
-    "authorId" TEXT NOT NULL,
-    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    "updatedAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    CONSTRAINT "TrackReview_trackId_fkey" FOREIGN KEY ("trackId") REFERENCES "Track" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
-    CONSTRAINT "TrackReview_trackEventId_fkey" FOREIGN KEY ("trackEventId") REFERENCES "TrackEvent" ("id") ON DELETE SET NULL ON UPDATE CASCADE,
-    CONSTRAINT "TrackReview_authorId_fkey" FOREIGN KEY ("authorId") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
-);
+// MigrateTo applies pending migrations up to and including target. A target
+// of 0 means the latest version. Each migration runs in its own transaction
+// together with its schema_migrations row, so a failure leaves the database
+// at the last successfully applied version.
+func MigrateTo(db *sql.DB, target int) error {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %w", err)
+	}
+	if target == 0 && len(migrations) > 0 {
+		target = migrations[len(migrations)-1].Version
+	}
 
-CREATE TABLE IF NOT EXISTS "LapRecord" (
-    "id" TEXT NOT NULL PRIMARY KEY,
-    "lapTime" TEXT NOT NULL,
-    "conditions" TEXT NOT NULL,
-    "notes" TEXT,
-    "tirePressureFL" REAL,
-    "tirePressureFR" REAL,
-    "tirePressureRL" REAL,
-    "tirePressureRR" REAL,
-    "fuelLevel" REAL,
-    "camberFL" REAL,
-    "camberFR" REAL,
-    "camberRL" REAL,
-    "camberRR" REAL,
-    "casterFL" REAL,
-    "casterFR" REAL,
-    "toeFL" REAL,
-    "toeFR" REAL,
-    "toeRL" REAL,
-    "toeRR" REAL,
-    "trackId" TEXT NOT NULL,
-    "trackEventId" TEXT,
-    "carId" TEXT NOT NULL,
-    "driverId" TEXT NOT NULL,
-    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    "updatedAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    CONSTRAINT "LapRecord_trackId_fkey" FOREIGN KEY ("trackId") REFERENCES "Track" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
-    CONSTRAINT "LapRecord_trackEventId_fkey" FOREIGN KEY ("trackEventId") REFERENCES "TrackEvent" ("id") ON DELETE SET NULL ON UPDATE CASCADE,
-    CONSTRAINT "LapRecord_carId_fkey" FOREIGN KEY ("carId") REFERENCES "Car" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
-    CONSTRAINT "LapRecord_driverId_fkey" FOREIGN KEY ("driverId") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
-);
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return fmt.Errorf("failed to read migration history: %w", err)
+	}
+	if err := verifyApplied(migrations, applied); err != nil {
+		return err
+	}
 
--- Indexes
-CREATE UNIQUE INDEX IF NOT EXISTS "User_email_key" ON "User"("email");
-CREATE UNIQUE INDEX IF NOT EXISTS "Account_provider_providerAccountId_key" ON "Account"("provider", "providerAccountId");
-CREATE UNIQUE INDEX IF NOT EXISTS "Session_sessionToken_key" ON "Session"("sessionToken");
-CREATE UNIQUE INDEX IF NOT EXISTS "VerificationToken_token_key" ON "VerificationToken"("token");
-CREATE UNIQUE INDEX IF NOT EXISTS "VerificationToken_identifier_token_key" ON "VerificationToken"("identifier", "token");
-CREATE UNIQUE INDEX IF NOT EXISTS "Track_name_location_key" ON "Track"("name", "location");
-CREATE UNIQUE INDEX IF NOT EXISTS "TrackEvent_trackId_eventType_key" ON "TrackEvent"("trackId", "eventType");
-`
+	current := 0
+	for v := range applied {
+		if v > current {
+			current = v
+		}
+	}
+	if target < current {
+		return fmt.Errorf("cannot migrate to version %d: database is at version %d and migrations are forward-only", target, current)
+	}
+	if target > 0 && !hasVersion(migrations, target) {
+		return fmt.Errorf("unknown migration version %d", target)
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if m.Version > target {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("failed to apply migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+		count++
+	}
+
+	if count == 0 {
+		log.Println("Database schema is up to date")
+	} else {
+		log.Printf("Database migrations applied successfully (%d)", count)
+	}
+	return nil
+}
+
+// GetMigrationStatus lists every known migration and whether it has been applied.
+func GetMigrationStatus(db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyApplied(migrations, applied); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Version: m.Version, Name: m.Name}
+		if row, ok := applied[m.Version]; ok {
+			appliedAt := row.appliedAt
+			s.Applied = true
+			s.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+func appliedMigrations(db *sql.DB) (map[int]appliedMigration, error) {
+	if _, err := db.Exec(migrationsTable); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT version, checksum, appliedAt FROM "schema_migrations"`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var version int
+		var m appliedMigration
+		if err := rows.Scan(&version, &m.checksum, &m.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = m
+	}
+	return applied, rows.Err()
+}
+
+// verifyApplied makes sure every recorded migration still exists with the
+// same contents, so an edited or deleted migration is caught before anything runs.
+func verifyApplied(migrations []Migration, applied map[int]appliedMigration) error {
+	byVersion := make(map[int]Migration, len(migrations))
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+	for version, a := range applied {
+		m, ok := byVersion[version]
+		if !ok {
+			return fmt.Errorf("database has migration %d applied but no such migration file exists", version)
+		}
+		if m.Checksum != a.checksum {
+			return fmt.Errorf("checksum mismatch for applied migration %04d_%s: migration files must not be edited after they are applied", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return err
+	}
+	if hook := migrationHooks[m.Version]; hook != nil {
+		if err := hook(tx); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(
+		`INSERT INTO "schema_migrations" (version, name, checksum, appliedAt) VALUES (?, ?, ?, ?)`,
+		m.Version, m.Name, m.Checksum, time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func hasVersion(migrations []Migration, version int) bool {
+	for _, m := range migrations {
+		if m.Version == version {
+			return true
+		}
+	}
+	return false
+}
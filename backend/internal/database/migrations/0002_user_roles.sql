@@ -0,0 +1,12 @@
+-- Role-based access control. Every user starts as USER; ADMIN and MODERATOR
+-- are granted explicitly through the admin API or `server grant-role`.
+
+ALTER TABLE "User" ADD COLUMN "role" TEXT NOT NULL DEFAULT 'USER';
+
+-- The system user that owns imported tracks has no password or linked
+-- account; anyone else who registered its address stays a USER.
+UPDATE "User" SET "role" = 'ADMIN'
+WHERE "email" = 'system@trackside.local' AND "passwordHash" IS NULL
+    AND NOT EXISTS (SELECT 1 FROM "Account" a WHERE a."userId" = "User"."id");
+
+CREATE INDEX IF NOT EXISTS "User_role_idx" ON "User"("role");
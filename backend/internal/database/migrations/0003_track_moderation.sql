@@ -0,0 +1,17 @@
+-- Moderation history for user-submitted tracks. Each approve, reject or
+-- resubmission is recorded with who made it and why.
+
+CREATE TABLE IF NOT EXISTS "TrackStatusChange" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "trackId" TEXT NOT NULL,
+    "fromStatus" TEXT,
+    "toStatus" TEXT NOT NULL,
+    "reason" TEXT,
+    "changedById" TEXT NOT NULL,
+    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT "TrackStatusChange_trackId_fkey" FOREIGN KEY ("trackId") REFERENCES "Track" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT "TrackStatusChange_changedById_fkey" FOREIGN KEY ("changedById") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS "TrackStatusChange_trackId_createdAt_idx" ON "TrackStatusChange"("trackId", "createdAt");
+CREATE INDEX IF NOT EXISTS "Track_status_idx" ON "Track"("status");
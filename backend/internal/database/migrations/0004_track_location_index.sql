@@ -0,0 +1,2 @@
+-- Supports bounding-box and radius searches on GET /api/tracks
+CREATE INDEX IF NOT EXISTS "Track_latitude_longitude_idx" ON "Track"("latitude", "longitude");
@@ -0,0 +1,33 @@
+-- Full-text index over tracks for GET /api/tracks?search= and autocomplete,
+-- ranked with FTS5's built-in bm25() and highlighted with highlight() and
+-- snippet(). FTS5 needs go-sqlite3 built with the sqlite_fts5 tag. The
+-- index keeps its own copy of the text keyed by trackId rather than
+-- pointing at Track rowids, which VACUUM is free to renumber. Triggers keep
+-- it in step with every write to Track.
+
+CREATE VIRTUAL TABLE IF NOT EXISTS "TrackSearch" USING fts5(
+    trackId UNINDEXED, name, location, state, description,
+    tokenize = 'unicode61 remove_diacritics 1'
+);
+
+-- Indexed vocabulary, used to suggest corrections for misspelled words.
+-- Lookups constrain term so only the candidates are read.
+CREATE VIRTUAL TABLE IF NOT EXISTS "TrackSearchTerms" USING fts5vocab("TrackSearch", 'row');
+
+INSERT INTO "TrackSearch" (trackId, name, location, state, description)
+SELECT id, name, location, COALESCE(state, ''), COALESCE(description, '') FROM "Track";
+
+CREATE TRIGGER IF NOT EXISTS "Track_search_insert" AFTER INSERT ON "Track" BEGIN
+    INSERT INTO "TrackSearch" (trackId, name, location, state, description)
+    VALUES (new.id, new.name, new.location, COALESCE(new.state, ''), COALESCE(new.description, ''));
+END;
+
+CREATE TRIGGER IF NOT EXISTS "Track_search_update" AFTER UPDATE OF name, location, state, description ON "Track" BEGIN
+    DELETE FROM "TrackSearch" WHERE trackId = old.id;
+    INSERT INTO "TrackSearch" (trackId, name, location, state, description)
+    VALUES (new.id, new.name, new.location, COALESCE(new.state, ''), COALESCE(new.description, ''));
+END;
+
+CREATE TRIGGER IF NOT EXISTS "Track_search_delete" AFTER DELETE ON "Track" BEGIN
+    DELETE FROM "TrackSearch" WHERE trackId = old.id;
+END;
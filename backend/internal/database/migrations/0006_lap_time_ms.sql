@@ -0,0 +1,8 @@
+-- Lap times as integer milliseconds so they can be sorted and compared.
+-- Existing rows are parsed by a Go hook in the same transaction (see
+-- backfillLapTimeMs). Rows that can't be parsed keep their text and a NULL
+-- lapTimeMs.
+
+ALTER TABLE "LapRecord" ADD COLUMN "lapTimeMs" INTEGER;
+
+CREATE INDEX IF NOT EXISTS "LapRecord_trackId_lapTimeMs_idx" ON "LapRecord"("trackId", "lapTimeMs");
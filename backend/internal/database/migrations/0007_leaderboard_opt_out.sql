@@ -0,0 +1,3 @@
+-- Drivers can hide their laps from public track leaderboards.
+
+ALTER TABLE "User" ADD COLUMN "leaderboardOptOut" BOOLEAN NOT NULL DEFAULT false;
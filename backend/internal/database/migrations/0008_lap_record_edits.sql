@@ -0,0 +1,15 @@
+-- Edit history for lap records. Each edit stores the changed fields as a
+-- JSON object of {"field": {"from": ..., "to": ...}} so leaderboard times
+-- can't be rewritten without a trace.
+
+CREATE TABLE IF NOT EXISTS "LapRecordEdit" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "lapRecordId" TEXT NOT NULL,
+    "editedById" TEXT NOT NULL,
+    "changes" TEXT NOT NULL,
+    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT "LapRecordEdit_lapRecordId_fkey" FOREIGN KEY ("lapRecordId") REFERENCES "LapRecord" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT "LapRecordEdit_editedById_fkey" FOREIGN KEY ("editedById") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS "LapRecordEdit_lapRecordId_createdAt_idx" ON "LapRecordEdit"("lapRecordId", "createdAt");
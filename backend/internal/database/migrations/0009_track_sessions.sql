@@ -0,0 +1,30 @@
+-- Track sessions: one run group or heat in a driver's day at a track. The
+-- "Session" table is taken by auth sessions, hence "TrackSession".
+-- setup is a JSON object snapshotting the car setup for the session.
+
+CREATE TABLE IF NOT EXISTS "TrackSession" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "date" TEXT NOT NULL,
+    "runGroup" TEXT,
+    "ambientTempC" REAL,
+    "trackTempC" REAL,
+    "setup" TEXT,
+    "notes" TEXT,
+    "trackId" TEXT NOT NULL,
+    "trackEventId" TEXT,
+    "carId" TEXT NOT NULL,
+    "driverId" TEXT NOT NULL,
+    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updatedAt" DATETIME NOT NULL,
+    CONSTRAINT "TrackSession_trackId_fkey" FOREIGN KEY ("trackId") REFERENCES "Track" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT "TrackSession_trackEventId_fkey" FOREIGN KEY ("trackEventId") REFERENCES "TrackEvent" ("id") ON DELETE SET NULL ON UPDATE CASCADE,
+    CONSTRAINT "TrackSession_carId_fkey" FOREIGN KEY ("carId") REFERENCES "Car" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT "TrackSession_driverId_fkey" FOREIGN KEY ("driverId") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS "TrackSession_driverId_date_idx" ON "TrackSession"("driverId", "date");
+
+ALTER TABLE "LapRecord" ADD COLUMN "sessionId" TEXT REFERENCES "TrackSession" ("id") ON DELETE SET NULL;
+ALTER TABLE "LapRecord" ADD COLUMN "lapNumber" INTEGER;
+
+CREATE UNIQUE INDEX IF NOT EXISTS "LapRecord_sessionId_lapNumber_key" ON "LapRecord"("sessionId", "lapNumber");
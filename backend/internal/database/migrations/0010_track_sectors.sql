@@ -0,0 +1,26 @@
+-- Timing sectors for a track, in lap order, and per-sector split times on
+-- lap records. A sector can be based on a TrackZone or defined by name only.
+
+CREATE TABLE IF NOT EXISTS "TrackSector" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "position" INTEGER NOT NULL,
+    "name" TEXT NOT NULL,
+    "trackId" TEXT NOT NULL,
+    "zoneId" TEXT,
+    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT "TrackSector_trackId_fkey" FOREIGN KEY ("trackId") REFERENCES "Track" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT "TrackSector_zoneId_fkey" FOREIGN KEY ("zoneId") REFERENCES "TrackZone" ("id") ON DELETE SET NULL ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS "TrackSector_trackId_position_idx" ON "TrackSector"("trackId", "position");
+
+CREATE TABLE IF NOT EXISTS "LapSplit" (
+    "lapRecordId" TEXT NOT NULL,
+    "sectorId" TEXT NOT NULL,
+    "timeMs" INTEGER NOT NULL,
+    PRIMARY KEY ("lapRecordId", "sectorId"),
+    CONSTRAINT "LapSplit_lapRecordId_fkey" FOREIGN KEY ("lapRecordId") REFERENCES "LapRecord" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT "LapSplit_sectorId_fkey" FOREIGN KEY ("sectorId") REFERENCES "TrackSector" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS "LapSplit_sectorId_idx" ON "LapSplit"("sectorId");
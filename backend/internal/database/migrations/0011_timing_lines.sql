@@ -0,0 +1,13 @@
+-- Timing lines for GPS lap detection, each given by its two end points. A
+-- track's start/finish line starts and ends laps; a sector's line marks
+-- where the sector ends. The last sector ends at the start/finish line.
+
+ALTER TABLE "Track" ADD COLUMN "startLineLat1" REAL;
+ALTER TABLE "Track" ADD COLUMN "startLineLng1" REAL;
+ALTER TABLE "Track" ADD COLUMN "startLineLat2" REAL;
+ALTER TABLE "Track" ADD COLUMN "startLineLng2" REAL;
+
+ALTER TABLE "TrackSector" ADD COLUMN "lineLat1" REAL;
+ALTER TABLE "TrackSector" ADD COLUMN "lineLng1" REAL;
+ALTER TABLE "TrackSector" ADD COLUMN "lineLat2" REAL;
+ALTER TABLE "TrackSector" ADD COLUMN "lineLng2" REAL;
@@ -0,0 +1,34 @@
+-- Event-type-specific details of lap records: a drag pass's timeslip, an
+-- autocross run's penalties and a drift run's judged scores. A lap has
+-- details of at most one kind, matching the type of its TrackEvent.
+
+CREATE TABLE IF NOT EXISTS "DragResult" (
+    "lapRecordId" TEXT NOT NULL PRIMARY KEY,
+    "reactionMs" INTEGER,
+    "sixtyFootMs" INTEGER,
+    "threeThirtyMs" INTEGER,
+    "eighthMileMs" INTEGER,
+    "quarterMileMs" INTEGER,
+    "trapSpeedMph" REAL,
+    CONSTRAINT "DragResult_lapRecordId_fkey" FOREIGN KEY ("lapRecordId") REFERENCES "LapRecord" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- adjustedTimeMs is the raw lap time plus the cone penalties, and NULL for
+-- a run that did not finish or went off course.
+CREATE TABLE IF NOT EXISTS "AutocrossResult" (
+    "lapRecordId" TEXT NOT NULL PRIMARY KEY,
+    "cones" INTEGER NOT NULL DEFAULT 0,
+    "dnf" BOOLEAN NOT NULL DEFAULT false,
+    "offCourse" BOOLEAN NOT NULL DEFAULT false,
+    "adjustedTimeMs" INTEGER,
+    CONSTRAINT "AutocrossResult_lapRecordId_fkey" FOREIGN KEY ("lapRecordId") REFERENCES "LapRecord" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS "DriftScore" (
+    "lapRecordId" TEXT NOT NULL,
+    "position" INTEGER NOT NULL,
+    "category" TEXT NOT NULL,
+    "score" REAL NOT NULL,
+    PRIMARY KEY ("lapRecordId", "position"),
+    CONSTRAINT "DriftScore_lapRecordId_fkey" FOREIGN KEY ("lapRecordId") REFERENCES "LapRecord" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
@@ -0,0 +1,17 @@
+-- Autocross car classes with their PAX index, the factor a run's time is
+-- multiplied by to compare cars across classes. Admins manage the table or
+-- import it from DATA_DIR; each car can be assigned one class.
+
+CREATE TABLE IF NOT EXISTS "CarClass" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "code" TEXT NOT NULL,
+    "name" TEXT NOT NULL,
+    "category" TEXT,
+    "paxIndex" REAL NOT NULL,
+    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updatedAt" DATETIME NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS "CarClass_code_key" ON "CarClass"("code" COLLATE NOCASE);
+
+ALTER TABLE "Car" ADD COLUMN "classId" TEXT REFERENCES "CarClass" ("id") ON DELETE SET NULL;
@@ -0,0 +1,7 @@
+-- Zones can be imported from DATA_DIR/track-zones.json. Imported zones are
+-- flagged so a re-sync updates them in place and leaves zones added by
+-- users alone.
+
+ALTER TABLE "TrackZone" ADD COLUMN "isImported" BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS "TrackZone_trackId_name_idx" ON "TrackZone"("trackId", "name" COLLATE NOCASE);
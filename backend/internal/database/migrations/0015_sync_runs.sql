@@ -0,0 +1,18 @@
+-- History of track data syncs: who ran each one, with which options, and
+-- what it did. triggeredById is null for runs from the command line.
+
+CREATE TABLE IF NOT EXISTS "SyncRun" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "source" TEXT NOT NULL,
+    "dryRun" BOOLEAN NOT NULL DEFAULT false,
+    "prune" BOOLEAN NOT NULL DEFAULT false,
+    "status" TEXT NOT NULL,
+    "summary" TEXT,
+    "error" TEXT,
+    "triggeredById" TEXT,
+    "startedAt" DATETIME NOT NULL,
+    "finishedAt" DATETIME NOT NULL,
+    CONSTRAINT "SyncRun_triggeredById_fkey" FOREIGN KEY ("triggeredById") REFERENCES "User" ("id") ON DELETE SET NULL ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS "SyncRun_startedAt_idx" ON "SyncRun"("startedAt");
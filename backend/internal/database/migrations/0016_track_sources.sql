@@ -0,0 +1,12 @@
+-- Imported tracks record the dataset they came from, so track lists from
+-- several places can be synced side by side and pruned separately. Tracks
+-- imported before this came from the bundled usa-tracks.json.
+
+ALTER TABLE "Track" ADD COLUMN "source" TEXT;
+ALTER TABLE "Track" ADD COLUMN "country" TEXT;
+
+UPDATE "Track" SET "source" = 'usa-tracks.json', "country" = 'US'
+WHERE "isImported" = true
+  AND "uploadedById" IN (SELECT "id" FROM "User" WHERE "email" = 'system@trackside.local');
+
+CREATE INDEX IF NOT EXISTS "Track_source_idx" ON "Track"("source");
@@ -0,0 +1,13 @@
+-- Login sessions for refresh tokens. Each row is one refresh token; the
+-- tokens issued by rotating a login's refresh token share its familyId,
+-- which access tokens carry as their session id. sessionToken holds the
+-- SHA-256 hash of the token, never the token itself. A token is rotated
+-- once; presenting it again revokes its whole family.
+
+ALTER TABLE "Session" ADD COLUMN "familyId" TEXT NOT NULL DEFAULT '';
+ALTER TABLE "Session" ADD COLUMN "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE "Session" ADD COLUMN "rotatedAt" DATETIME;
+ALTER TABLE "Session" ADD COLUMN "revokedAt" DATETIME;
+
+CREATE INDEX IF NOT EXISTS "Session_familyId_idx" ON "Session"("familyId");
+CREATE INDEX IF NOT EXISTS "Session_userId_idx" ON "Session"("userId");
@@ -0,0 +1,5 @@
+-- New accounts must verify their email address before adding tracks,
+-- reviews or tips. Accounts created before verification existed had no way
+-- to verify, so they count as verified from when they were created.
+
+UPDATE "User" SET "emailVerified" = "createdAt" WHERE "emailVerified" IS NULL;
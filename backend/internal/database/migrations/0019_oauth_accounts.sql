@@ -0,0 +1,17 @@
+-- Sign-in with OpenID Connect providers. "OAuthState" holds a sign-in
+-- between sending the user to the provider and their coming back: the
+-- PKCE code verifier and ID token nonce, and the user linking the provider
+-- if they were already signed in. state holds the SHA-256 hash of the
+-- state parameter. A user links at most one account per provider.
+
+CREATE TABLE IF NOT EXISTS "OAuthState" (
+    "state" TEXT NOT NULL PRIMARY KEY,
+    "provider" TEXT NOT NULL,
+    "codeVerifier" TEXT NOT NULL,
+    "nonce" TEXT NOT NULL,
+    "userId" TEXT,
+    "expires" DATETIME NOT NULL,
+    CONSTRAINT "OAuthState_userId_fkey" FOREIGN KEY ("userId") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS "Account_userId_provider_key" ON "Account"("userId", "provider");
@@ -0,0 +1,26 @@
+-- TOTP two-factor authentication. A "TwoFactor" row is created when a user
+-- starts setting it up and counts once enabledAt is set. lastStep is the
+-- time step of the last code accepted, so a code can't be used twice.
+-- After too many wrong codes, more tries are refused until lockedUntil.
+-- Recovery codes are stored as SHA-256 hashes and work once each.
+
+CREATE TABLE IF NOT EXISTS "TwoFactor" (
+    "userId" TEXT NOT NULL PRIMARY KEY,
+    "secret" TEXT NOT NULL,
+    "enabledAt" DATETIME,
+    "lastStep" INTEGER NOT NULL DEFAULT 0,
+    "failedAttempts" INTEGER NOT NULL DEFAULT 0,
+    "lockedUntil" DATETIME,
+    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT "TwoFactor_userId_fkey" FOREIGN KEY ("userId") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS "RecoveryCode" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "userId" TEXT NOT NULL,
+    "codeHash" TEXT NOT NULL,
+    "usedAt" DATETIME,
+    CONSTRAINT "RecoveryCode_userId_fkey" FOREIGN KEY ("userId") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS "RecoveryCode_userId_idx" ON "RecoveryCode"("userId");
@@ -0,0 +1,19 @@
+-- Personal access tokens for scripts and data loggers. tokenHash holds the
+-- SHA-256 hash of the token, which is only shown to the user once. scopes
+-- is a space-separated list like "lapbook:write cars:read". A token with no
+-- expiresAt works until it is revoked (deleted).
+
+CREATE TABLE IF NOT EXISTS "APIToken" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "userId" TEXT NOT NULL,
+    "name" TEXT NOT NULL,
+    "tokenHash" TEXT NOT NULL,
+    "scopes" TEXT NOT NULL,
+    "expiresAt" DATETIME,
+    "lastUsedAt" DATETIME,
+    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT "APIToken_userId_fkey" FOREIGN KEY ("userId") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS "APIToken_tokenHash_key" ON "APIToken"("tokenHash");
+CREATE INDEX IF NOT EXISTS "APIToken_userId_idx" ON "APIToken"("userId");
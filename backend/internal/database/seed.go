@@ -31,8 +31,8 @@ func Seed(db *sql.DB) error {
 	}
 
 	userID := xid.New().String()
-	_, err = db.Exec(`INSERT INTO "User" (id, name, email, passwordHash, experience, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		userID, "Demo Driver", "demo@trackside.com", string(passwordHash), "INTERMEDIATE", now, now)
+	_, err = db.Exec(`INSERT INTO "User" (id, name, email, emailVerified, passwordHash, experience, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, "Demo Driver", "demo@trackside.com", now, string(passwordHash), "INTERMEDIATE", now, now)
 	if err != nil {
 		return err
 	}
@@ -176,8 +176,8 @@ func Seed(db *sql.DB) error {
 	log.Println("Created reviews")
 
 	// Lap records
-	_, err = db.Exec(`INSERT INTO "LapRecord" (id, lapTime, conditions, notes, tirePressureFL, tirePressureFR, tirePressureRL, tirePressureRR, fuelLevel, camberFL, camberFR, camberRL, camberRR, casterFL, casterFR, toeFL, toeFR, toeRL, toeRR, trackId, trackEventId, carId, driverId, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		xid.New().String(), "1:42.856", "DRY",
+	_, err = db.Exec(`INSERT INTO "LapRecord" (id, lapTime, lapTimeMs, conditions, notes, tirePressureFL, tirePressureFR, tirePressureRL, tirePressureRR, fuelLevel, camberFL, camberFR, camberRL, camberRR, casterFL, casterFR, toeFL, toeFR, toeRL, toeRR, trackId, trackEventId, carId, driverId, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		xid.New().String(), "1:42.856", 102856, "DRY",
 		"Best time of the day. Car felt great after adjusting front camber.",
 		32.5, 32.5, 34.0, 34.0, 50.0, -2.5, -2.5, -1.8, -1.8, 5.2, 5.2, 0.1, 0.1, 0.15, 0.15,
 		track2ID, roadcourseEvent2ID, carID, userID, now, now)
@@ -185,8 +185,8 @@ func Seed(db *sql.DB) error {
 		return err
 	}
 
-	_, err = db.Exec(`INSERT INTO "LapRecord" (id, lapTime, conditions, notes, tirePressureFL, tirePressureFR, tirePressureRL, tirePressureRR, fuelLevel, camberFL, camberFR, camberRL, camberRR, trackId, trackEventId, carId, driverId, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		xid.New().String(), "1:45.112", "WET",
+	_, err = db.Exec(`INSERT INTO "LapRecord" (id, lapTime, lapTimeMs, conditions, notes, tirePressureFL, tirePressureFR, tirePressureRL, tirePressureRR, fuelLevel, camberFL, camberFR, camberRL, camberRR, trackId, trackEventId, carId, driverId, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		xid.New().String(), "1:45.112", 105112, "WET",
 		"Started raining mid-session. Dropped tire pressure to help with wet grip.",
 		30.0, 30.0, 32.0, 32.0, 40.0, -2.5, -2.5, -1.8, -1.8,
 		track2ID, roadcourseEvent2ID, carID, userID, now, now)
@@ -0,0 +1,377 @@
+// Package datalog reads the lap summaries that data loggers and lap timing
+// apps export as CSV, such as AiM Race Studio, RaceChrono and Harry's
+// LapTimer.
+//
+// The exports differ in detail but share a shape: an optional preamble of
+// "key,value" rows (track, date, sometimes coordinates) followed by a table
+// with one row per lap. The table is found by its header row, which needs a
+// lap number column and a lap time column; sector columns are named
+// "Sector 1", "Split 1" or "S1" and so on.
+package datalog
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/laptime"
+)
+
+// Formats recognised by Parse. Files that do not name the tool they came
+// from are read as FormatGeneric.
+const (
+	FormatAiM        = "aim"
+	FormatRaceChrono = "racechrono"
+	FormatHarrys     = "harrys"
+	FormatGeneric    = "generic"
+)
+
+var (
+	ErrEmpty    = errors.New("file is empty")
+	ErrNoHeader = errors.New("no lap table found: expected a header row with lap and lap time columns")
+)
+
+// Log is a parsed lap summary export.
+type Log struct {
+	Format string
+	// TrackName, Date (YYYY-MM-DD) and the coordinates are empty when the
+	// file does not include them.
+	TrackName string
+	Date      string
+	Latitude  *float64
+	Longitude *float64
+	// Sectors is the number of sector columns in the lap table.
+	Sectors int
+	Laps    []Lap
+	Errors  []RowError
+}
+
+// Lap is one row of the lap table. Row is the line number in the file.
+type Lap struct {
+	Row      int
+	Number   int
+	TimeMs   int64
+	SplitsMs []int64
+}
+
+// RowError explains why a row of the lap table was not read as a lap.
+type RowError struct {
+	Row     int
+	Message string
+}
+
+var (
+	unitSuffix    = regexp.MustCompile(`\s*[(\[].*[)\]]\s*$`)
+	sectorColumn  = regexp.MustCompile(`^(?:sector|split|s)(\d+)$`)
+	dateLayouts   = []string{"2006-01-02", "2006/01/02", "01/02/2006", "1/2/2006", "02.01.2006", "2.1.2006", "Jan 2, 2006", "January 2, 2006", "Monday, January 2, 2006", "2 Jan 2006", "2 January 2006"}
+	lapColumns    = map[string]bool{"lap": true, "lap#": true, "#": true, "lapnumber": true, "lapno": true}
+	latColumns    = map[string]bool{"lat": true, "latitude": true, "startlatitude": true}
+	lngColumns    = map[string]bool{"lon": true, "lng": true, "long": true, "longitude": true, "startlongitude": true}
+	trackKeys     = map[string]bool{"track": true, "trackname": true, "venue": true, "circuit": true}
+	dateKeys      = map[string]bool{"date": true, "sessiondate": true, "created": true}
+	summaryLabels = map[string]bool{"best": true, "bestlap": true, "average": true, "avg": true, "total": true,
+		"theoretical": true, "theoreticalbest": true, "optimal": true, "ideal": true, "rolling": true}
+)
+
+// columns holds the positions of the lap table's columns; -1 means absent.
+type columns struct {
+	lap, time, lat, lng int
+	sectors             []int
+}
+
+// Parse reads a lap summary export. Problems with the file as a whole are
+// returned as an error; rows that cannot be read are collected in
+// Log.Errors and skipped.
+func Parse(data []byte) (*Log, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, ErrEmpty
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = delimiter(data)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	r.TrimLeadingSpace = true
+
+	log := &Log{Format: FormatGeneric}
+	var cols *columns
+	var seen strings.Builder
+	lastNumber := 0
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		row, _ := r.FieldPos(0)
+		record = trimRecord(record)
+		if len(record) == 0 {
+			continue
+		}
+
+		if cols == nil {
+			seen.WriteString(strings.ToLower(strings.Join(record, " ")) + "\n")
+			if cols = findColumns(record); cols != nil {
+				log.Sectors = len(cols.sectors)
+				continue
+			}
+			log.readPreamble(record)
+			continue
+		}
+
+		lap, skip, err := cols.readLap(record, lastNumber)
+		if skip {
+			continue
+		}
+		if err != nil {
+			log.Errors = append(log.Errors, RowError{Row: row, Message: err.Error()})
+			continue
+		}
+		lap.Row = row
+		lastNumber = lap.Number
+		log.Laps = append(log.Laps, lap)
+
+		if log.Latitude == nil && cols.lat >= 0 && cols.lng >= 0 {
+			log.setPosition(cell(record, cols.lat), cell(record, cols.lng))
+		}
+	}
+	if cols == nil {
+		return nil, ErrNoHeader
+	}
+	log.Format = detectFormat(seen.String())
+	return log, nil
+}
+
+// delimiter guesses between comma and semicolon, which exports use in
+// locales with a decimal comma, from the first line.
+func delimiter(data []byte) rune {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	if bytes.Count(line, []byte(";")) > bytes.Count(line, []byte(",")) {
+		return ';'
+	}
+	return ','
+}
+
+// trimRecord trims each field and drops trailing empty ones, returning nil
+// for a blank row.
+func trimRecord(record []string) []string {
+	for i := range record {
+		record[i] = strings.TrimSpace(record[i])
+	}
+	for len(record) > 0 && record[len(record)-1] == "" {
+		record = record[:len(record)-1]
+	}
+	return record
+}
+
+// normalize lowercases a header or key, drops a trailing unit such as
+// "(s)" or "[sec]" and keeps only letters, digits and '#'.
+func normalize(s string) string {
+	s = unitSuffix.ReplaceAllString(strings.ToLower(s), "")
+	var b strings.Builder
+	for _, r := range s {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '#' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// findColumns returns the lap table's columns if record is its header row.
+func findColumns(record []string) *columns {
+	cols := &columns{lap: -1, time: -1, lat: -1, lng: -1}
+	plainTime := -1
+	sectors := map[int]int{}
+	for i, field := range record {
+		name := normalize(field)
+		switch {
+		case lapColumns[name] && cols.lap < 0:
+			cols.lap = i
+		case name == "laptime" && cols.time < 0:
+			cols.time = i
+		case name == "time" && plainTime < 0:
+			plainTime = i
+		case latColumns[name] && cols.lat < 0:
+			cols.lat = i
+		case lngColumns[name] && cols.lng < 0:
+			cols.lng = i
+		default:
+			if m := sectorColumn.FindStringSubmatch(name); m != nil {
+				n, _ := strconv.Atoi(m[1])
+				if _, dup := sectors[n]; !dup {
+					sectors[n] = i
+				}
+			}
+		}
+	}
+	// "Lap time" wins over a bare "Time", which may be the time of day
+	if cols.time < 0 {
+		cols.time = plainTime
+	}
+	if cols.lap < 0 || cols.time < 0 {
+		return nil
+	}
+
+	numbers := make([]int, 0, len(sectors))
+	for n := range sectors {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	for _, n := range numbers {
+		cols.sectors = append(cols.sectors, sectors[n])
+	}
+	return cols
+}
+
+// readPreamble picks the track, date and coordinates out of a row before
+// the lap table. Rows are "key,value" or a single "key: value" field.
+func (l *Log) readPreamble(record []string) {
+	var key, value string
+	switch {
+	case len(record) >= 2:
+		key, value = record[0], record[1]
+	case len(record) == 1:
+		var ok bool
+		if key, value, ok = strings.Cut(record[0], ":"); !ok {
+			return
+		}
+		value = strings.TrimSpace(value)
+	}
+
+	key = normalize(key)
+	switch {
+	case trackKeys[key] && l.TrackName == "":
+		l.TrackName = value
+	case dateKeys[key] && l.Date == "":
+		l.Date = parseDate(value)
+	case latColumns[key] && l.Latitude == nil:
+		if lat, err := strconv.ParseFloat(value, 64); err == nil && lat >= -90 && lat <= 90 {
+			l.Latitude = &lat
+		}
+	case lngColumns[key] && l.Longitude == nil:
+		if lng, err := strconv.ParseFloat(value, 64); err == nil && lng >= -180 && lng <= 180 {
+			l.Longitude = &lng
+		}
+	}
+}
+
+// setPosition records the coordinates from a lap row if both are valid.
+func (l *Log) setPosition(latValue, lngValue string) {
+	lat, err1 := strconv.ParseFloat(latValue, 64)
+	lng, err2 := strconv.ParseFloat(lngValue, 64)
+	if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 || (lat == 0 && lng == 0) {
+		return
+	}
+	l.Latitude, l.Longitude = &lat, &lng
+}
+
+// readLap reads one row of the lap table. skip is true for rows that are
+// not laps, such as the best lap and average rows some tools append. A row
+// without a lap number follows on from the previous lap.
+func (cols *columns) readLap(record []string, lastNumber int) (lap Lap, skip bool, err error) {
+	number := cell(record, cols.lap)
+	lapTime := cell(record, cols.time)
+	if number == "" && lapTime == "" {
+		return lap, true, nil
+	}
+
+	if number == "" {
+		lap.Number = lastNumber + 1
+	} else if n, err := strconv.Atoi(strings.TrimPrefix(number, "#")); err == nil {
+		lap.Number = n
+	} else if summaryLabels[normalize(number)] {
+		return lap, true, nil
+	} else {
+		return lap, false, fmt.Errorf("lap number %q is not a number", number)
+	}
+	if lap.Number < 1 {
+		return lap, false, errors.New("lap number must be at least 1")
+	}
+
+	if lapTime == "" {
+		return lap, false, fmt.Errorf("lap %d has no lap time", lap.Number)
+	}
+	if lap.TimeMs, err = parseTime(lapTime); err != nil {
+		return lap, false, fmt.Errorf("invalid lap time %q: %v", lapTime, err)
+	}
+
+	var missing int
+	for _, i := range cols.sectors {
+		if cell(record, i) == "" {
+			missing++
+		}
+	}
+	if missing == len(cols.sectors) {
+		return lap, false, nil
+	}
+	if missing > 0 {
+		return lap, false, fmt.Errorf("lap %d is missing %d of %d sector times", lap.Number, missing, len(cols.sectors))
+	}
+	for n, i := range cols.sectors {
+		ms, err := parseTime(cell(record, i))
+		if err != nil {
+			return lap, false, fmt.Errorf("invalid sector %d time %q: %v", n+1, cell(record, i), err)
+		}
+		lap.SplitsMs = append(lap.SplitsMs, ms)
+	}
+	return lap, false, nil
+}
+
+// parseTime reads a lap or sector time, also accepting a decimal comma.
+func parseTime(s string) (int64, error) {
+	if !strings.Contains(s, ".") && strings.Count(s, ",") == 1 {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	return laptime.Parse(s)
+}
+
+// parseDate returns value as YYYY-MM-DD, or "" if it is not a date in a
+// layout we know. A time of day after the date is ignored.
+func parseDate(value string) string {
+	candidates := []string{value}
+	if date, _, ok := strings.Cut(value, " "); ok {
+		candidates = append(candidates, date)
+	}
+	if date, _, ok := strings.Cut(value, "T"); ok {
+		candidates = append(candidates, date)
+	}
+	for _, v := range candidates {
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t.Format(time.DateOnly)
+			}
+		}
+	}
+	return ""
+}
+
+// detectFormat names the tool a file came from, using the text of its
+// preamble and header.
+func detectFormat(text string) string {
+	switch {
+	case strings.Contains(text, "racechrono"):
+		return FormatRaceChrono
+	case strings.Contains(text, "harry"):
+		return FormatHarrys
+	case strings.Contains(text, "aim csv"), strings.Contains(text, "race studio"):
+		return FormatAiM
+	}
+	return FormatGeneric
+}
+
+func cell(record []string, i int) string {
+	if i < 0 || i >= len(record) {
+		return ""
+	}
+	return record[i]
+}
@@ -0,0 +1,47 @@
+package geo
+
+import "math"
+
+const (
+	// EarthRadiusKm is the mean radius of the Earth.
+	EarthRadiusKm = 6371.0088
+	KmPerMile     = 1.609344
+
+	// kmPerDegreeLat is the length of one degree of latitude, used to turn a
+	// search radius into a cheap latitude range before computing distances.
+	kmPerDegreeLat = EarthRadiusKm * math.Pi / 180
+)
+
+// DistanceKm returns the great-circle distance between two points using the
+// haversine formula.
+func DistanceKm(lat1, lng1, lat2, lng2 float64) float64 {
+	rlat1 := radians(lat1)
+	rlat2 := radians(lat2)
+	dLat := radians(lat2 - lat1)
+	dLng := radians(lng2 - lng1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rlat1)*math.Cos(rlat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * EarthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func KmToMiles(km float64) float64 {
+	return km / KmPerMile
+}
+
+// LatitudeSpan returns how many degrees of latitude radiusKm covers. Any
+// point within radiusKm of lat lies within lat ± LatitudeSpan(radiusKm).
+func LatitudeSpan(radiusKm float64) float64 {
+	return radiusKm / kmPerDegreeLat
+}
+
+func ValidLatitude(lat float64) bool {
+	return lat >= -90 && lat <= 90
+}
+
+func ValidLongitude(lng float64) bool {
+	return lng >= -180 && lng <= 180
+}
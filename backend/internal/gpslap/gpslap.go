@@ -0,0 +1,197 @@
+// Package gpslap finds laps in GPS traces. A trace is read from a GPX file
+// or NMEA sentences; a lap runs from one crossing of a track's start/finish
+// line to the next, and sector times come from crossings of the lines that
+// end each sector. Crossing times are interpolated between fixes, so laps
+// are timed more finely than the logging rate.
+package gpslap
+
+import (
+	"math"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/geo"
+)
+
+const (
+	// MinLap is the shortest time between two counted start/finish
+	// crossings. Crossings sooner than this are GPS jitter around the line.
+	MinLap = 10 * time.Second
+	// MaxGap is the longest gap between fixes allowed within a lap. A lap
+	// with a longer gap can't be timed reliably and is rejected.
+	MaxGap = 5 * time.Second
+)
+
+// Point is one GPS fix. Line is where it was read in the file.
+type Point struct {
+	Lat  float64
+	Lng  float64
+	Time time.Time
+	Line int
+}
+
+// Line is a timing line between two points.
+type Line struct {
+	Lat1, Lng1, Lat2, Lng2 float64
+}
+
+// Lap is a detected lap. Line is the file line of the first fix of the lap.
+// SplitsMs holds one time per sector and is nil when the lap did not cross
+// every sector line in order.
+type Lap struct {
+	Number   int
+	Line     int
+	Start    time.Time
+	TimeMs   int64
+	SplitsMs []int64
+}
+
+// Rejected is a lap that was crossed but could not be timed.
+type Rejected struct {
+	Number  int
+	Line    int
+	Message string
+}
+
+// crossing is a moment the trace crossed a timing line. dir is the side it
+// crossed from, so laps are only counted in one direction.
+type crossing struct {
+	at   time.Time
+	line int
+	dir  int
+}
+
+// Detect finds laps in a trace ordered by time. sectorLines are the lines
+// ending each sector but the last, which ends at the start/finish line;
+// pass none to skip splits. Laps are numbered from 1 in the order they
+// were driven, including rejected ones.
+func Detect(points []Point, finish Line, sectorLines []Line) ([]Lap, []Rejected) {
+	starts := crossings(points, finish)
+	if len(starts) == 0 {
+		return nil, nil
+	}
+
+	// Count crossings in the direction of the first one, ignoring jitter
+	counted := []crossing{starts[0]}
+	for _, c := range starts[1:] {
+		last := counted[len(counted)-1]
+		if c.dir == last.dir && c.at.Sub(last.at) >= MinLap {
+			counted = append(counted, c)
+		}
+	}
+
+	sectors := make([][]crossing, len(sectorLines))
+	for i, l := range sectorLines {
+		sectors[i] = crossings(points, l)
+	}
+
+	var laps []Lap
+	var rejected []Rejected
+	for i := 1; i < len(counted); i++ {
+		start, end := counted[i-1], counted[i]
+		number := i
+		if gap := longestGap(points, start.at, end.at); gap > MaxGap {
+			rejected = append(rejected, Rejected{Number: number, Line: start.line,
+				Message: "GPS trace has a " + gap.Round(100*time.Millisecond).String() + " gap"})
+			continue
+		}
+		lap := Lap{Number: number, Line: start.line, Start: start.at, TimeMs: millis(end.at.Sub(start.at))}
+		lap.SplitsMs = splits(start.at, end.at, sectors)
+		laps = append(laps, lap)
+	}
+	return laps, rejected
+}
+
+// splits times each sector of a lap from the first crossing of each sector
+// line after the previous one. It returns nil if a line was not crossed.
+func splits(start, end time.Time, sectors [][]crossing) []int64 {
+	if len(sectors) == 0 {
+		return nil
+	}
+	result := make([]int64, 0, len(sectors)+1)
+	prev := start
+	for _, cs := range sectors {
+		found := false
+		for _, c := range cs {
+			if c.at.After(prev) && c.at.Before(end) {
+				result = append(result, millis(c.at.Sub(prev)))
+				prev = c.at
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	return append(result, millis(end.Sub(prev)))
+}
+
+// crossings returns every time the trace crosses l, in order.
+func crossings(points []Point, l Line) []crossing {
+	// Work in metres on a plane centred on the line; at the scale of a
+	// track the distortion is negligible.
+	lat0, lng0 := (l.Lat1+l.Lat2)/2, (l.Lng1+l.Lng2)/2
+	toPlane := func(lat, lng float64) (float64, float64) {
+		return project(lat, lng, lat0, lng0)
+	}
+	ax, ay := toPlane(l.Lat1, l.Lng1)
+	bx, by := toPlane(l.Lat2, l.Lng2)
+	ex, ey := bx-ax, by-ay
+
+	var result []crossing
+	for i := 1; i < len(points); i++ {
+		p, q := points[i-1], points[i]
+		px, py := toPlane(p.Lat, p.Lng)
+		qx, qy := toPlane(q.Lat, q.Lng)
+		dx, dy := qx-px, qy-py
+
+		d := cross(dx, dy, ex, ey)
+		if d == 0 {
+			continue
+		}
+		// p + t·(q-p) = a + u·(b-a)
+		t := cross(ax-px, ay-py, ex, ey) / d
+		u := cross(ax-px, ay-py, dx, dy) / d
+		if t < 0 || t >= 1 || u < 0 || u > 1 {
+			continue
+		}
+		elapsed := q.Time.Sub(p.Time)
+		at := p.Time.Add(time.Duration(t * float64(elapsed)))
+		dir := 1
+		if d < 0 {
+			dir = -1
+		}
+		result = append(result, crossing{at: at, line: p.Line, dir: dir})
+	}
+	return result
+}
+
+// longestGap is the longest time between fixes from start to end.
+func longestGap(points []Point, start, end time.Time) time.Duration {
+	var gap time.Duration
+	for i := 1; i < len(points); i++ {
+		p, q := points[i-1], points[i]
+		if q.Time.Before(start) || p.Time.After(end) {
+			continue
+		}
+		if d := q.Time.Sub(p.Time); d > gap {
+			gap = d
+		}
+	}
+	return gap
+}
+
+func project(lat, lng, lat0, lng0 float64) (x, y float64) {
+	const metresPerDegree = geo.EarthRadiusKm * 1000 * math.Pi / 180
+	x = (lng - lng0) * metresPerDegree * math.Cos(lat0*math.Pi/180)
+	y = (lat - lat0) * metresPerDegree
+	return x, y
+}
+
+func cross(ax, ay, bx, by float64) float64 {
+	return ax*by - ay*bx
+}
+
+func millis(d time.Duration) int64 {
+	return d.Round(time.Millisecond).Milliseconds()
+}
@@ -0,0 +1,273 @@
+package gpslap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Trace formats read by Parse.
+const (
+	FormatGPX  = "gpx"
+	FormatNMEA = "nmea"
+)
+
+var (
+	ErrUnknownFormat = errors.New("not a GPX file or NMEA log")
+	ErrNoFixes       = errors.New("no timed GPS fixes found")
+)
+
+// Trace is a parsed GPS trace, ordered by time. Dated is false for NMEA
+// logs without a date (GGA sentences only), whose times start on
+// 1970-01-01.
+type Trace struct {
+	Format string
+	Points []Point
+	Dated  bool
+}
+
+// Date returns the day the trace starts in local time at its first fix,
+// estimating the time zone from the longitude, or "" if it is undated.
+func (t *Trace) Date() string {
+	if !t.Dated || len(t.Points) == 0 {
+		return ""
+	}
+	first := t.Points[0]
+	offset := time.Duration(math.Round(first.Lng/15)) * time.Hour
+	return first.Time.Add(offset).Format(time.DateOnly)
+}
+
+// Parse reads a GPX file or NMEA log, telling them apart by content.
+// Fixes without a time, invalid fixes and NMEA sentences with a bad
+// checksum are skipped.
+func Parse(data []byte) (*Trace, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	trimmed := bytes.TrimSpace(data)
+
+	var trace *Trace
+	var err error
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		trace, err = parseGPX(data)
+	case bytes.Contains(trimmed, []byte("$GP")), bytes.Contains(trimmed, []byte("$GN")):
+		trace, err = parseNMEA(data)
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop fixes that don't move time forward, such as the GGA and RMC
+	// sentences reporting the same fix
+	points := trace.Points[:0]
+	for _, p := range trace.Points {
+		if len(points) == 0 || p.Time.After(points[len(points)-1].Time) {
+			points = append(points, p)
+		}
+	}
+	if len(points) == 0 {
+		return nil, ErrNoFixes
+	}
+	trace.Points = points
+	return trace, nil
+}
+
+func parseGPX(data []byte) (*Trace, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	trace := &Trace{Format: FormatGPX, Dated: true}
+
+	var current *Point
+	var inTime bool
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid GPX: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "trkpt", "rtept":
+				line, _ := d.InputPos()
+				current = &Point{Line: line}
+				var okLat, okLng bool
+				for _, a := range t.Attr {
+					switch a.Name.Local {
+					case "lat":
+						current.Lat, err = strconv.ParseFloat(a.Value, 64)
+						okLat = err == nil
+					case "lon":
+						current.Lng, err = strconv.ParseFloat(a.Value, 64)
+						okLng = err == nil
+					}
+				}
+				if !okLat || !okLng {
+					current = nil
+				}
+			case "time":
+				inTime = current != nil
+			}
+		case xml.CharData:
+			if inTime {
+				if ts, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(t))); err == nil {
+					current.Time = ts.UTC()
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "time":
+				inTime = false
+			case "trkpt", "rtept":
+				if current != nil && !current.Time.IsZero() {
+					trace.Points = append(trace.Points, *current)
+				}
+				current = nil
+			}
+		}
+	}
+	return trace, nil
+}
+
+func parseNMEA(data []byte) (*Trace, error) {
+	trace := &Trace{Format: FormatNMEA}
+	date := time.Unix(0, 0).UTC() // date of the fixes being read, from RMC sentences
+	var lastTime time.Time
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields, ok := nmeaFields(scanner.Text())
+		if !ok || len(fields[0]) < 5 {
+			continue
+		}
+
+		var p Point
+		var clock string
+		switch fields[0][2:] {
+		case "RMC":
+			if len(fields) < 10 || fields[2] != "A" {
+				continue
+			}
+			d, err := time.Parse("020106", fields[9])
+			if err != nil {
+				continue
+			}
+			if !trace.Dated {
+				// Fixes read before the first date were on the same day
+				for i := range trace.Points {
+					trace.Points[i].Time = trace.Points[i].Time.Add(d.Sub(date))
+				}
+			}
+			date, trace.Dated = d, true
+			clock = fields[1]
+			p.Lat, ok = nmeaCoord(fields[3], fields[4], 2)
+			if !ok {
+				continue
+			}
+			p.Lng, ok = nmeaCoord(fields[5], fields[6], 3)
+		case "GGA":
+			if len(fields) < 7 || fields[6] == "0" || fields[6] == "" {
+				continue
+			}
+			clock = fields[1]
+			p.Lat, ok = nmeaCoord(fields[2], fields[3], 2)
+			if !ok {
+				continue
+			}
+			p.Lng, ok = nmeaCoord(fields[4], fields[5], 3)
+		default:
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		ts, ok := nmeaTime(date, clock)
+		if !ok {
+			continue
+		}
+		// Without RMC dates, a clock going back by hours means midnight passed
+		if !trace.Dated && !lastTime.IsZero() && lastTime.Sub(ts) > 12*time.Hour {
+			date = date.AddDate(0, 0, 1)
+			ts = ts.AddDate(0, 0, 1)
+		}
+		lastTime = ts
+		p.Time, p.Line = ts, line
+		trace.Points = append(trace.Points, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("invalid NMEA: %w", err)
+	}
+	return trace, nil
+}
+
+// nmeaFields splits a sentence into its fields, checking the checksum when
+// there is one. The first field is the talker and type, e.g. "GPRMC".
+func nmeaFields(s string) ([]string, bool) {
+	start := strings.IndexByte(s, '$')
+	if start < 0 {
+		return nil, false
+	}
+	body := strings.TrimSpace(s[start+1:])
+	if body, sum, ok := strings.Cut(body, "*"); ok {
+		want, err := strconv.ParseUint(strings.TrimSpace(sum), 16, 8)
+		if err != nil {
+			return nil, false
+		}
+		var got byte
+		for i := 0; i < len(body); i++ {
+			got ^= body[i]
+		}
+		if uint64(got) != want {
+			return nil, false
+		}
+		return strings.Split(body, ","), true
+	}
+	return strings.Split(body, ","), true
+}
+
+// nmeaCoord reads a latitude (ddmm.mmmm, degDigits 2) or longitude
+// (dddmm.mmmm, degDigits 3) with its hemisphere.
+func nmeaCoord(value, hemisphere string, degDigits int) (float64, bool) {
+	if len(value) < degDigits+2 {
+		return 0, false
+	}
+	deg, err1 := strconv.ParseFloat(value[:degDigits], 64)
+	min, err2 := strconv.ParseFloat(value[degDigits:], 64)
+	if err1 != nil || err2 != nil || min >= 60 {
+		return 0, false
+	}
+	v := deg + min/60
+	switch hemisphere {
+	case "S", "W":
+		v = -v
+	case "N", "E":
+	default:
+		return 0, false
+	}
+	return v, true
+}
+
+// nmeaTime combines a date with an hhmmss(.sss) clock field.
+func nmeaTime(date time.Time, clock string) (time.Time, bool) {
+	if len(clock) < 6 {
+		return time.Time{}, false
+	}
+	h, err1 := strconv.Atoi(clock[0:2])
+	m, err2 := strconv.Atoi(clock[2:4])
+	sec, err3 := strconv.ParseFloat(clock[4:], 64)
+	if err1 != nil || err2 != nil || err3 != nil || h > 23 || m > 59 || sec >= 61 {
+		return time.Time{}, false
+	}
+	return date.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute +
+		time.Duration(sec*float64(time.Second))).Round(time.Millisecond), true
+}
@@ -1,89 +1,201 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/joezmuda/trackside-backend/internal/importer"
 	"github.com/joezmuda/trackside-backend/internal/middleware"
 	"github.com/joezmuda/trackside-backend/internal/models"
 	"github.com/joezmuda/trackside-backend/internal/repository"
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	defaultSyncRuns   = 20
+	maxSyncRuns       = 100
+	maxTrackFileBytes = 5 * 1024 * 1024
+)
+
 type AdminHandler struct {
-	trackRepo *repository.TrackRepo
-	userRepo  *repository.UserRepo
-	dataDir   string
+	trackRepo   *repository.TrackRepo
+	userRepo    *repository.UserRepo
+	zoneRepo    *repository.ZoneRepo
+	syncRunRepo *repository.SyncRunRepo
+	dataDir     string
 }
 
-func NewAdminHandler(trackRepo *repository.TrackRepo, userRepo *repository.UserRepo, dataDir string) *AdminHandler {
-	return &AdminHandler{trackRepo: trackRepo, userRepo: userRepo, dataDir: dataDir}
+func NewAdminHandler(trackRepo *repository.TrackRepo, userRepo *repository.UserRepo, zoneRepo *repository.ZoneRepo, syncRunRepo *repository.SyncRunRepo, dataDir string) *AdminHandler {
+	return &AdminHandler{trackRepo: trackRepo, userRepo: userRepo, zoneRepo: zoneRepo, syncRunRepo: syncRunRepo, dataDir: dataDir}
 }
 
 // POST /api/admin/sync-tracks
+//
+// Syncs the bundled tracks file, or a JSON, CSV or GeoJSON file uploaded as
+// "file". An upload's tracks are recorded under "source", which defaults to
+// the file name unless that is the bundled file's name.
 func (h *AdminHandler) SyncTracks(c echo.Context) error {
-	email := middleware.GetUserEmail(c)
+	var opts models.SyncOptions
+	for name, opt := range map[string]*bool{"dryRun": &opts.DryRun, "prune": &opts.Prune} {
+		v := c.FormValue(name)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": name + " must be true or false"})
+		}
+		*opt = b
+	}
 
-	if !strings.Contains(email, "admin") && email != "system@trackside.local" {
-		return c.JSON(http.StatusForbidden, map[string]string{"error": "Admin access required"})
+	file, status, msg := readTrackFile(c)
+	if status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
 	}
 
-	tracksPath := filepath.Join(h.dataDir, "usa-tracks.json")
-	data, err := os.ReadFile(tracksPath)
+	startedAt := time.Now()
+	source := importer.TracksFile
+	var resp *models.SyncTracksResponse
+	var err error
+	if file != nil {
+		source = file.Source
+		resp, err = importer.SyncTrackFile(h.trackRepo, h.userRepo, *file, opts)
+	} else {
+		resp, err = importer.SyncTracks(h.trackRepo, h.userRepo, h.dataDir, opts)
+	}
+	userID := middleware.GetUserID(c)
+	if recErr := h.syncRunRepo.Create(importer.TrackSyncRun(source, opts, startedAt, resp, err), &userID); recErr != nil {
+		c.Logger().Errorf("record sync run: %v", recErr)
+	}
+	var fileErr *importer.FileError
+	if file != nil && errors.As(err, &fileErr) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fileErr.Error()})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 			"error":   "Sync failed",
-			"details": "Could not read tracks data file",
+			"details": err.Error(),
 		})
 	}
+	return c.JSON(http.StatusOK, resp)
+}
 
-	var file struct {
-		Tracks []models.ImportedTrack `json:"tracks"`
+// readTrackFile reads an uploaded tracks file and its source and country
+// fields. It returns nil if no file was uploaded, or a non-zero status and
+// message to reply with if the upload is invalid.
+func readTrackFile(c echo.Context) (*importer.TrackFile, int, string) {
+	upload, err := c.FormFile("file")
+	if err != nil || upload == nil {
+		return nil, 0, ""
 	}
-	if err := json.Unmarshal(data, &file); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error":   "Sync failed",
-			"details": "Invalid JSON in tracks data file",
-		})
+	if upload.Size > maxTrackFileBytes {
+		return nil, http.StatusBadRequest, "File too large. Maximum size is 5MB"
+	}
+	src, err := upload.Open()
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Sync failed"
+	}
+	defer src.Close()
+	data, err := io.ReadAll(io.LimitReader(src, maxTrackFileBytes))
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Sync failed"
 	}
 
-	systemUser, err := h.userRepo.FindOrCreateSystem()
+	file := &importer.TrackFile{
+		Source:  strings.TrimSpace(c.FormValue("source")),
+		Name:    upload.Filename,
+		Data:    data,
+		Country: strings.ToUpper(strings.TrimSpace(c.FormValue("country"))),
+	}
+	if file.Source == "" {
+		file.Source = strings.ToLower(upload.Filename)
+		if importer.BundledSource(file.Source) {
+			return nil, http.StatusBadRequest, "source is required for a file named like the bundled " + importer.TracksFile
+		}
+	}
+	if !importer.ValidSource(file.Source) {
+		return nil, http.StatusBadRequest, "source must be up to 50 lowercase letters, digits, dots, dashes or underscores"
+	}
+	if file.Country != "" && !importer.ValidCountry(file.Country) {
+		return nil, http.StatusBadRequest, "country must be a two-letter code"
+	}
+	return file, 0, ""
+}
+
+// GET /api/admin/sync-runs
+func (h *AdminHandler) SyncRuns(c echo.Context) error {
+	limit := defaultSyncRuns
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSyncRuns {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Limit must be between 1 and 100"})
+		}
+		limit = n
+	}
+
+	runs, err := h.syncRunRepo.List(limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch sync runs"})
+	}
+	return c.JSON(http.StatusOK, runs)
+}
+
+// POST /api/admin/sync-zones
+func (h *AdminHandler) SyncZones(c echo.Context) error {
+	resp, err := importer.SyncZones(h.trackRepo, h.zoneRepo, h.dataDir)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 			"error":   "Sync failed",
 			"details": err.Error(),
 		})
 	}
+	return c.JSON(http.StatusOK, resp)
+}
 
-	var created, updated, failed int
-	var errors []string
+// PUT /api/admin/users/:id/role
+func (h *AdminHandler) GrantRole(c echo.Context) error {
+	var req models.RoleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if !models.ValidRole(req.Role) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid role"})
+	}
+	return h.setRole(c, req.Role)
+}
 
-	for _, trackData := range file.Tracks {
-		existing, _ := h.trackRepo.FindByNameAndLocation(trackData.Name, trackData.Location)
-		err := h.trackRepo.UpsertImported(trackData, systemUser.ID)
-		if err != nil {
-			failed++
-			errors = append(errors, trackData.Name+": "+err.Error())
-		} else if existing != nil {
-			updated++
-		} else {
-			created++
-		}
+// DELETE /api/admin/users/:id/role
+func (h *AdminHandler) RevokeRole(c echo.Context) error {
+	return h.setRole(c, string(models.RoleUser))
+}
+
+func (h *AdminHandler) setRole(c echo.Context, role string) error {
+	id := c.Param("id")
+
+	if id == middleware.GetUserID(c) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "You cannot change your own role"})
 	}
 
-	resp := models.SyncTracksResponse{
-		Status: "success",
+	user, err := h.userRepo.FindByID(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
 	}
-	resp.Summary.Total = len(file.Tracks)
-	resp.Summary.Created = created
-	resp.Summary.Updated = updated
-	resp.Summary.Failed = failed
-	if len(errors) > 0 {
-		resp.Errors = errors
+	if user == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
 	}
 
-	return c.JSON(http.StatusOK, resp)
+	user, err = h.userRepo.SetRole(id, role)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, models.UserRoleResponse{
+		ID:    user.ID,
+		Name:  user.Name,
+		Email: user.Email,
+		Role:  user.Role,
+	})
 }
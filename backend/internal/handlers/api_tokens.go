@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/middleware"
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/joezmuda/trackside-backend/internal/repository"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxAPITokenNameLength = 100
+	maxAPITokenDays       = 365
+)
+
+// APITokenHandler lets users manage personal access tokens for scripts and
+// data loggers.
+type APITokenHandler struct {
+	tokenRepo *repository.APITokenRepo
+}
+
+func NewAPITokenHandler(tokenRepo *repository.APITokenRepo) *APITokenHandler {
+	return &APITokenHandler{tokenRepo: tokenRepo}
+}
+
+// GET /api/profile/tokens
+func (h *APITokenHandler) List(c echo.Context) error {
+	tokens, err := h.tokenRepo.ListByUser(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// POST /api/profile/tokens
+//
+// The token is only in this response; it is stored hashed.
+func (h *APITokenHandler) Create(c echo.Context) error {
+	var req models.APITokenRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Name is required"})
+	}
+	if len(name) > maxAPITokenNameLength {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Name must be at most 100 characters"})
+	}
+	if len(req.Scopes) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "At least one scope is required"})
+	}
+	for _, scope := range req.Scopes {
+		if !models.ValidAPITokenScope(scope) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid scope: " + scope})
+		}
+	}
+	scopes := slices.Clone(req.Scopes)
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays != nil {
+		days := *req.ExpiresInDays
+		if days < 1 || days > maxAPITokenDays {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "expiresInDays must be between 1 and 365"})
+		}
+		t := time.Now().UTC().AddDate(0, 0, days)
+		expiresAt = &t
+	}
+
+	token, secret, err := h.tokenRepo.Create(middleware.GetUserID(c), name, scopes, expiresAt)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusCreated, models.APITokenResponse{APIToken: *token, Token: secret})
+}
+
+// DELETE /api/profile/tokens/:id
+func (h *APITokenHandler) Delete(c echo.Context) error {
+	deleted, err := h.tokenRepo.Delete(middleware.GetUserID(c), c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if !deleted {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Token not found"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Token revoked"})
+}
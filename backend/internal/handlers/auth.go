@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/joezmuda/trackside-backend/internal/mailer"
 	"github.com/joezmuda/trackside-backend/internal/middleware"
 	"github.com/joezmuda/trackside-backend/internal/models"
 	"github.com/joezmuda/trackside-backend/internal/repository"
@@ -12,13 +15,31 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+	verifyEmailTTL  = 48 * time.Hour
+	resetTokenTTL   = time.Hour
+	challengeTTL    = 5 * time.Minute
+)
+
+// twoFactorAudience marks challenge tokens, so they can't be mistaken for
+// access tokens or the other way round.
+const twoFactorAudience = "two-factor"
+
 type AuthHandler struct {
-	userRepo  *repository.UserRepo
-	jwtSecret string
+	userRepo    *repository.UserRepo
+	sessionRepo *repository.AuthSessionRepo
+	tokenRepo   *repository.VerificationTokenRepo
+	twoFactor   *repository.TwoFactorRepo
+	apiTokens   *repository.APITokenRepo
+	mail        mailer.Mailer
+	appURL      string
+	jwtSecret   string
 }
 
-func NewAuthHandler(userRepo *repository.UserRepo, jwtSecret string) *AuthHandler {
-	return &AuthHandler{userRepo: userRepo, jwtSecret: jwtSecret}
+func NewAuthHandler(userRepo *repository.UserRepo, sessionRepo *repository.AuthSessionRepo, tokenRepo *repository.VerificationTokenRepo, twoFactor *repository.TwoFactorRepo, apiTokens *repository.APITokenRepo, mail mailer.Mailer, appURL, jwtSecret string) *AuthHandler {
+	return &AuthHandler{userRepo: userRepo, sessionRepo: sessionRepo, tokenRepo: tokenRepo, twoFactor: twoFactor, apiTokens: apiTokens, mail: mail, appURL: appURL, jwtSecret: jwtSecret}
 }
 
 // POST /api/register
@@ -29,12 +50,16 @@ func (h *AuthHandler) Register(c echo.Context) error {
 	}
 
 	// Validation
+	req.Email = strings.TrimSpace(req.Email)
 	if len(req.Name) < 2 {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Name must be at least 2 characters"})
 	}
-	if req.Email == "" {
+	if !models.ValidEmail(req.Email) {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid email address"})
 	}
+	if models.IsSystemEmail(req.Email) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "This email address is reserved"})
+	}
 	if len(req.Password) < 8 {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Password must be at least 8 characters"})
 	}
@@ -56,6 +81,11 @@ func (h *AuthHandler) Register(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
 	}
 
+	// The account works without it; the user can ask for another email
+	if err := h.sendVerification(user); err != nil {
+		c.Logger().Errorf("send verification email: %v", err)
+	}
+
 	return c.JSON(http.StatusCreated, map[string]interface{}{
 		"id":    user.ID,
 		"name":  user.Name,
@@ -64,6 +94,9 @@ func (h *AuthHandler) Register(c echo.Context) error {
 }
 
 // POST /api/auth/login
+//
+// Replies with tokens, or with a challenge token if the user has two-factor
+// authentication on.
 func (h *AuthHandler) Login(c echo.Context) error {
 	var req models.LoginRequest
 	if err := c.Bind(&req); err != nil {
@@ -86,14 +119,262 @@ func (h *AuthHandler) Login(c echo.Context) error {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid credentials"})
 	}
 
-	// Generate JWT
+	return h.startSession(c, user)
+}
+
+// POST /api/auth/refresh
+//
+// Exchanges a refresh token for a new access token and refresh token. Each
+// refresh token works once; using one again logs out its session.
+func (h *AuthHandler) Refresh(c echo.Context) error {
+	var req models.RefreshRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if req.RefreshToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Refresh token is required"})
+	}
+
+	userID, sessionID, refreshToken, err := h.sessionRepo.Rotate(req.RefreshToken, refreshTokenTTL)
+	if errors.Is(err, repository.ErrInvalidRefreshToken) || errors.Is(err, repository.ErrRefreshTokenReused) {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid refresh token"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	// The new access token picks up any change to the user's role
+	user, err := h.userRepo.FindByID(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if user == nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid refresh token"})
+	}
+	return h.issueTokens(c, user, sessionID, refreshToken)
+}
+
+// POST /api/auth/logout
+func (h *AuthHandler) Logout(c echo.Context) error {
+	if err := h.sessionRepo.Revoke(middleware.GetSessionID(c)); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Logged out"})
+}
+
+// POST /api/auth/logout-all
+func (h *AuthHandler) LogoutAll(c echo.Context) error {
+	count, err := h.sessionRepo.RevokeAll(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":  "Logged out everywhere",
+		"sessions": count,
+	})
+}
+
+// POST /api/auth/verify-email
+func (h *AuthHandler) VerifyEmail(c echo.Context) error {
+	var req models.VerifyEmailRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if req.Token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Token is required"})
+	}
+
+	userID, err := h.tokenRepo.Consume(repository.TokenVerifyEmail, req.Token)
+	if errors.Is(err, repository.ErrInvalidToken) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid or expired token"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if err := h.userRepo.MarkEmailVerified(userID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Email verified"})
+}
+
+// POST /api/auth/resend-verification
+func (h *AuthHandler) ResendVerification(c echo.Context) error {
+	user, err := h.userRepo.FindByID(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if user == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+	if user.EmailVerified != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Email is already verified"})
+	}
+	if err := h.sendVerification(user); err != nil {
+		c.Logger().Errorf("send verification email: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Could not send email"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Verification email sent"})
+}
+
+// POST /api/auth/forgot-password
+//
+// Always answers the same way, so it can't be used to find out which
+// emails have accounts.
+func (h *AuthHandler) ForgotPassword(c echo.Context) error {
+	var req models.ForgotPasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if req.Email == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Email is required"})
+	}
+
+	user, err := h.userRepo.FindByEmail(strings.TrimSpace(req.Email))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	// Users who signed up through a provider can set a password this way too
+	if user != nil {
+		if err := h.sendPasswordReset(user); err != nil {
+			c.Logger().Errorf("send password reset email: %v", err)
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "If that email has an account, a reset link is on its way"})
+}
+
+// POST /api/auth/reset-password
+//
+// Sets a new password and logs the user out everywhere. Following the link
+// also proves the user owns the email address.
+func (h *AuthHandler) ResetPassword(c echo.Context) error {
+	var req models.ResetPasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if req.Token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Token is required"})
+	}
+	if len(req.Password) < 8 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Password must be at least 8 characters"})
+	}
+	if req.Password != req.ConfirmPassword {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Passwords do not match"})
+	}
+
+	userID, err := h.tokenRepo.Consume(repository.TokenResetPassword, req.Token)
+	if errors.Is(err, repository.ErrInvalidToken) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid or expired token"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if err := h.userRepo.SetPassword(userID, req.Password); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if err := h.userRepo.MarkEmailVerified(userID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	// Whoever the user is locking out may have logged in or made API tokens
+	if _, err := h.sessionRepo.RevokeAll(userID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if err := h.apiTokens.DeleteAll(userID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Password reset"})
+}
+
+func (h *AuthHandler) sendVerification(user *models.User) error {
+	token, err := h.tokenRepo.Create(repository.TokenVerifyEmail, user.ID, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	return h.mail.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Trackside email address",
+		Body: "Welcome to Trackside! Confirm your email address to start adding tracks, reviews and tips:\n\n" +
+			h.appURL + "/verify-email?token=" + token + "\n\nThe link expires in 48 hours.\n",
+	})
+}
+
+func (h *AuthHandler) sendPasswordReset(user *models.User) error {
+	token, err := h.tokenRepo.Create(repository.TokenResetPassword, user.ID, resetTokenTTL)
+	if err != nil {
+		return err
+	}
+	return h.mail.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Trackside password",
+		Body: "Someone asked to reset the password for your Trackside account. To choose a new one, open:\n\n" +
+			h.appURL + "/reset-password?token=" + token + "\n\nThe link expires in an hour. If it wasn't you, ignore this email.\n",
+	})
+}
+
+// startSession logs the user in: it starts a session and replies with its
+// tokens. Users with two-factor authentication on get a challenge instead,
+// and the session starts when they answer it.
+func (h *AuthHandler) startSession(c echo.Context, user *models.User) error {
+	enabled, err := h.twoFactor.IsEnabled(user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if !enabled {
+		return h.openSession(c, user)
+	}
+
+	now := time.Now()
+	challenge := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   user.ID,
+		Audience:  jwt.ClaimStrings{twoFactorAudience},
+		ExpiresAt: jwt.NewNumericDate(now.Add(challengeTTL)),
+		IssuedAt:  jwt.NewNumericDate(now),
+	})
+	token, err := challenge.SignedString([]byte(h.jwtSecret))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, models.TwoFactorChallengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpiresIn:         int(challengeTTL.Seconds()),
+	})
+}
+
+// challengeUserID returns the user a challenge token from startSession was
+// issued to, or false if it isn't valid.
+func (h *AuthHandler) challengeUserID(token string) (string, bool) {
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(h.jwtSecret), nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithAudience(twoFactorAudience), jwt.WithExpirationRequired())
+	if err != nil || claims.Subject == "" {
+		return "", false
+	}
+	return claims.Subject, true
+}
+
+// openSession starts a session for the user and replies with its tokens.
+func (h *AuthHandler) openSession(c echo.Context, user *models.User) error {
+	sessionID, refreshToken, err := h.sessionRepo.Create(user.ID, refreshTokenTTL)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return h.issueTokens(c, user, sessionID, refreshToken)
+}
+
+// issueTokens replies with a new access token for the session, along with
+// its refresh token.
+func (h *AuthHandler) issueTokens(c echo.Context, user *models.User, sessionID, refreshToken string) error {
+	now := time.Now()
 	claims := &middleware.JWTClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   user.ID,
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
-		Email: user.Email,
+		Email:     user.Email,
+		Role:      user.Role,
+		SessionID: sessionID,
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -103,15 +384,15 @@ func (h *AuthHandler) Login(c echo.Context) error {
 	}
 
 	return c.JSON(http.StatusOK, models.LoginResponse{
-		Token: tokenStr,
-		User: struct {
-			ID    string  `json:"id"`
-			Name  *string `json:"name"`
-			Email string  `json:"email"`
-		}{
-			ID:    user.ID,
-			Name:  user.Name,
-			Email: user.Email,
+		Token:        tokenStr,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		User: models.LoginUser{
+			ID:            user.ID,
+			Name:          user.Name,
+			Email:         user.Email,
+			Role:          user.Role,
+			EmailVerified: user.EmailVerified != nil,
 		},
 	})
 }
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/joezmuda/trackside-backend/internal/importer"
+	"github.com/joezmuda/trackside-backend/internal/middleware"
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/joezmuda/trackside-backend/internal/repository"
+	"github.com/labstack/echo/v4"
+)
+
+// Class categories, from least to most modified. A car belongs in the first
+// category that allows all of its mods.
+var classCategories = []string{"Street", "Street Touring", "Street Prepared", "Street Modified"}
+
+// modClassCategory is the index into classCategories of the least modified
+// category that allows each kind of mod. Mods not listed are allowed in
+// every category.
+var modClassCategory = map[models.ModCategory]int{
+	models.ModExhaust:     1,
+	models.ModSuspension:  1,
+	models.ModWheelsTires: 1,
+	models.ModBrakes:      1,
+	models.ModDrivetrain:  1,
+	models.ModEngine:      2,
+	models.ModAero:        3,
+}
+
+type CarClassHandler struct {
+	classRepo *repository.CarClassRepo
+	carRepo   *repository.CarRepo
+	dataDir   string
+}
+
+func NewCarClassHandler(classRepo *repository.CarClassRepo, carRepo *repository.CarRepo, dataDir string) *CarClassHandler {
+	return &CarClassHandler{classRepo: classRepo, carRepo: carRepo, dataDir: dataDir}
+}
+
+// GET /api/classes
+func (h *CarClassHandler) List(c echo.Context) error {
+	classes, err := h.classRepo.List(c.QueryParam("category"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch classes"})
+	}
+	return c.JSON(http.StatusOK, classes)
+}
+
+// POST /api/admin/classes
+func (h *CarClassHandler) Create(c echo.Context) error {
+	var req models.CarClassRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if status, msg := h.checkClass(&req, ""); status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	class, err := h.classRepo.Create(req)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusCreated, class)
+}
+
+// PUT /api/admin/classes/:id
+func (h *CarClassHandler) Update(c echo.Context) error {
+	id := c.Param("id")
+
+	class, err := h.classRepo.FindByID(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if class == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Class not found"})
+	}
+
+	var req models.CarClassRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if status, msg := h.checkClass(&req, id); status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	class, err = h.classRepo.Update(id, req)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, class)
+}
+
+// DELETE /api/admin/classes/:id
+func (h *CarClassHandler) Delete(c echo.Context) error {
+	id := c.Param("id")
+
+	class, err := h.classRepo.FindByID(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if class == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Class not found"})
+	}
+
+	if err := h.classRepo.Delete(id); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Class deleted"})
+}
+
+// POST /api/admin/sync-classes
+func (h *CarClassHandler) Sync(c echo.Context) error {
+	resp, err := importer.SyncClasses(h.classRepo, h.dataDir)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error":   "Sync failed",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// checkClass validates a class and makes sure no other class has its code.
+func (h *CarClassHandler) checkClass(req *models.CarClassRequest, id string) (int, string) {
+	if msg := models.NormalizeCarClass(req); msg != "" {
+		return http.StatusBadRequest, msg
+	}
+	existing, err := h.classRepo.FindByCode(req.Code)
+	if err != nil {
+		return http.StatusInternalServerError, "Internal server error"
+	}
+	if existing != nil && existing.ID != id {
+		return http.StatusConflict, "A class with that code already exists"
+	}
+	return 0, ""
+}
+
+// PUT /api/cars/:id/class
+func (h *CarClassHandler) Assign(c echo.Context) error {
+	car, err := h.carRepo.FindByIDAndUser(c.Param("id"), middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if car == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Car not found"})
+	}
+
+	var req models.CarClassAssignRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if req.ClassID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "classId is required"})
+	}
+	class, err := h.classRepo.FindByID(req.ClassID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if class == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Class not found"})
+	}
+
+	return h.setClass(c, car.ID, &class.ID)
+}
+
+// DELETE /api/cars/:id/class
+func (h *CarClassHandler) Clear(c echo.Context) error {
+	car, err := h.carRepo.FindByIDAndUser(c.Param("id"), middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if car == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Car not found"})
+	}
+	return h.setClass(c, car.ID, nil)
+}
+
+func (h *CarClassHandler) setClass(c echo.Context, carID string, classID *string) error {
+	if err := h.carRepo.SetClass(carID, classID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	car, err := h.carRepo.FindByIDAndUser(carID, middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, car)
+}
+
+// GET /api/cars/:id/class-suggestion
+func (h *CarClassHandler) Suggest(c echo.Context) error {
+	car, err := h.carRepo.FindByIDAndUser(c.Param("id"), middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if car == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Car not found"})
+	}
+
+	// The mods that need the most modified category are the reasons for it
+	level := 0
+	for _, mod := range car.Mods {
+		level = max(level, modClassCategory[models.ModCategory(mod.Category)])
+	}
+	reasons := []string{}
+	for _, mod := range car.Mods {
+		if level > 0 && modClassCategory[models.ModCategory(mod.Category)] == level {
+			reasons = append(reasons, mod.Name+" ("+strings.ToLower(strings.ReplaceAll(mod.Category, "_", " "))+")")
+		}
+	}
+
+	classes, err := h.classRepo.List(classCategories[level])
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, models.ClassSuggestion{
+		Category: classCategories[level],
+		Reasons:  reasons,
+		Classes:  classes,
+	})
+}
@@ -0,0 +1,405 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/datalog"
+	"github.com/joezmuda/trackside-backend/internal/gpslap"
+	"github.com/joezmuda/trackside-backend/internal/laptime"
+	"github.com/joezmuda/trackside-backend/internal/middleware"
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/joezmuda/trackside-backend/internal/search"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxLapImportBytes = 2 * 1024 * 1024
+	// lapImportMatchKm is how close a track must be to the coordinates in
+	// an export to be matched to it.
+	lapImportMatchKm = 5
+)
+
+// POST /api/lapbook/import
+//
+// Reads a lap summary exported by a data logger (multipart field "file")
+// into a new session. carId is required; trackId, trackEventId, date,
+// runGroup and conditions fill in or override what the file says. Rows
+// that cannot be read are skipped and listed in the response. With
+// dryRun=true nothing is saved and the response previews the import.
+func (h *SessionHandler) Import(c echo.Context) error {
+	data, form, status, msg := readLapImport(c)
+	if status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	log, err := datalog.Parse(data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Could not read file: " + err.Error()})
+	}
+
+	result := newLapImportResult(form, log.Format, log.Date)
+	for _, e := range log.Errors {
+		result.Errors = append(result.Errors, models.LapImportError{Row: e.Row, Message: e.Message})
+	}
+	if _, err := time.Parse(time.DateOnly, result.Date); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
+	}
+
+	var near *models.GeoPoint
+	if log.Latitude != nil && log.Longitude != nil {
+		near = &models.GeoPoint{Lat: *log.Latitude, Lng: *log.Longitude}
+	}
+	track, status, msg := h.importTrack(c, &form, result, near, log.TrackName)
+	if status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	useSplits := log.Sectors > 0
+	if track != nil && useSplits {
+		sectors, err := h.trackRepo.GetSectors(track.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		}
+		if len(sectors) != log.Sectors {
+			useSplits = false
+			result.Warnings = append(result.Warnings, fmt.Sprintf(
+				"The file has %d sectors but %s has %d; splits were not imported", log.Sectors, track.Name, len(sectors)))
+		}
+	}
+
+	seen := map[int]bool{}
+	for _, lap := range log.Laps {
+		if seen[lap.Number] {
+			result.Errors = append(result.Errors, models.LapImportError{Row: lap.Row, Message: fmt.Sprintf("lap %d appears more than once", lap.Number)})
+			continue
+		}
+		imported := models.ImportedLap{
+			Row:       lap.Row,
+			LapNumber: lap.Number,
+			LapTime:   laptime.Format(lap.TimeMs),
+			LapTimeMs: lap.TimeMs,
+		}
+		if useSplits && len(lap.SplitsMs) > 0 {
+			var sum int64
+			for _, ms := range lap.SplitsMs {
+				imported.Splits = append(imported.Splits, laptime.Format(ms))
+				sum += ms
+			}
+			tolerance := splitToleranceMs * int64(len(lap.SplitsMs))
+			if diff := sum - lap.TimeMs; diff < -tolerance || diff > tolerance {
+				result.Errors = append(result.Errors, models.LapImportError{Row: lap.Row, Message: fmt.Sprintf(
+					"sector times add up to %s but the lap time is %s", laptime.Format(sum), imported.LapTime)})
+				continue
+			}
+			imported.SplitsMs = lap.SplitsMs
+		}
+		seen[lap.Number] = true
+		result.Laps = append(result.Laps, imported)
+	}
+	sort.SliceStable(result.Errors, func(i, j int) bool { return result.Errors[i].Row < result.Errors[j].Row })
+
+	return h.saveLapImport(c, form, result)
+}
+
+// lapImportForm holds the form fields shared by the lap imports.
+type lapImportForm struct {
+	dryRun     bool
+	conditions string
+	trackID    string
+	session    models.TrackSessionRequest
+}
+
+// readLapImport reads the uploaded file and form fields of a lap import. It
+// returns a non-zero status and message to reply with if they are invalid.
+func readLapImport(c echo.Context) ([]byte, lapImportForm, int, string) {
+	var form lapImportForm
+	file, err := c.FormFile("file")
+	if err != nil || file == nil {
+		return nil, form, http.StatusBadRequest, "No file provided"
+	}
+	if file.Size > maxLapImportBytes {
+		return nil, form, http.StatusBadRequest, "File too large. Maximum size is 2MB"
+	}
+	src, err := file.Open()
+	if err != nil {
+		return nil, form, http.StatusInternalServerError, "Import failed"
+	}
+	defer src.Close()
+	data, err := io.ReadAll(io.LimitReader(src, maxLapImportBytes))
+	if err != nil {
+		return nil, form, http.StatusInternalServerError, "Import failed"
+	}
+
+	form.dryRun, _ = strconv.ParseBool(c.FormValue("dryRun"))
+	form.conditions = c.FormValue("conditions")
+	if form.conditions == "" {
+		form.conditions = string(models.ConditionDry)
+	}
+	if !models.ValidDrivingCondition(form.conditions) {
+		return nil, form, http.StatusBadRequest, "Invalid conditions"
+	}
+	form.trackID = c.FormValue("trackId")
+	form.session = models.TrackSessionRequest{Date: c.FormValue("date"), CarID: c.FormValue("carId")}
+	if form.session.CarID == "" {
+		return nil, form, http.StatusBadRequest, "Car is required"
+	}
+	if runGroup := c.FormValue("runGroup"); runGroup != "" {
+		form.session.RunGroup = &runGroup
+	}
+	if eventID := c.FormValue("trackEventId"); eventID != "" {
+		form.session.TrackEventID = &eventID
+	}
+	return data, form, 0, ""
+}
+
+// newLapImportResult starts the report for an import. The session is dated
+// by the form, else by the file, else today.
+func newLapImportResult(form lapImportForm, format, fileDate string) *models.LapImportResult {
+	result := &models.LapImportResult{
+		DryRun:   form.dryRun,
+		Format:   format,
+		Date:     form.session.Date,
+		Laps:     []models.ImportedLap{},
+		Errors:   []models.LapImportError{},
+		Warnings: []string{},
+	}
+	if result.Date == "" {
+		result.Date = fileDate
+	}
+	if result.Date == "" {
+		result.Date = time.Now().UTC().Format(time.DateOnly)
+		result.Warnings = append(result.Warnings, "The file has no date; the session is dated today")
+	}
+	return result
+}
+
+// importTrack matches an import to a track and checks the session it will
+// create. Only a dry run may go without a track, in which case it returns
+// nil and the report says so.
+func (h *SessionHandler) importTrack(c echo.Context, form *lapImportForm, result *models.LapImportResult, near *models.GeoPoint, name string) (*models.TrackBrief, int, string) {
+	form.session.Date = result.Date
+	track, match, status, msg := h.matchTrack(c, form.trackID, near, name)
+	if status != 0 {
+		return nil, status, msg
+	}
+	result.Track = track
+	result.TrackMatch = match
+
+	if track == nil {
+		if !form.dryRun {
+			return nil, http.StatusBadRequest, "Could not match the file to a track; choose one with trackId"
+		}
+		result.Warnings = append(result.Warnings, "No track matched the file; choose one with trackId")
+		owns, err := h.carRepo.ExistsForUser(form.session.CarID, middleware.GetUserID(c))
+		if err != nil {
+			return nil, http.StatusInternalServerError, "Internal server error"
+		}
+		if !owns {
+			return nil, http.StatusNotFound, "Car not found"
+		}
+		return nil, 0, ""
+	}
+
+	form.session.TrackID = track.ID
+	if status, msg := h.validateSession(c, &form.session); status != 0 {
+		return nil, status, msg
+	}
+	return track, 0, ""
+}
+
+// saveLapImport replies with the report for a dry run, and otherwise saves
+// the session and its laps.
+func (h *SessionHandler) saveLapImport(c echo.Context, form lapImportForm, result *models.LapImportResult) error {
+	if form.dryRun {
+		return c.JSON(http.StatusOK, result)
+	}
+	if len(result.Laps) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "No laps could be imported"})
+	}
+
+	session, err := h.sessionRepo.Import(form.session, form.conditions, result.Laps, middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Import failed"})
+	}
+	result.Session = session
+	return c.JSON(http.StatusCreated, result)
+}
+
+// matchTrack picks the track for an import: the one given by trackId, else
+// the nearest approved track to the file's coordinates, else an approved
+// track named like the file's track. It returns nil if none fits, or a
+// non-zero status and message if trackId is not a visible track.
+func (h *SessionHandler) matchTrack(c echo.Context, trackID string, near *models.GeoPoint, name string) (*models.TrackBrief, string, int, string) {
+	if trackID != "" {
+		track, err := h.trackRepo.FindByID(trackID)
+		if err != nil {
+			return nil, "", http.StatusInternalServerError, "Internal server error"
+		}
+		if track == nil || !canView(c, track.Status, track.UploadedByID) {
+			return nil, "", http.StatusNotFound, "Track not found"
+		}
+		return &models.TrackBrief{ID: track.ID, Name: track.Name, Location: track.Location}, models.TrackMatchID, 0, ""
+	}
+
+	if near != nil {
+		nearest, err := h.trackRepo.List(models.TrackListParams{
+			Sort:     models.TrackSortDistance,
+			Limit:    1,
+			Near:     near,
+			RadiusKm: lapImportMatchKm,
+		})
+		if err != nil {
+			return nil, "", http.StatusInternalServerError, "Internal server error"
+		}
+		if len(nearest.Tracks) > 0 {
+			t := nearest.Tracks[0]
+			return &models.TrackBrief{ID: t.ID, Name: t.Name, Location: t.Location}, models.TrackMatchGPS, 0, ""
+		}
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", 0, ""
+	}
+	tracks, err := h.trackRepo.FindApprovedByName(name)
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, "Internal server error"
+	}
+	if len(tracks) == 1 {
+		return &tracks[0], models.TrackMatchName, 0, ""
+	}
+	if len(tracks) > 1 {
+		// Several tracks share the name; without coordinates we can't tell which
+		return nil, "", 0, ""
+	}
+
+	// Loggers often use a short name ("Laguna Seca" for "WeatherTech Raceway
+	// Laguna Seca"), so take the best search hit if one name contains the other.
+	suggestions, err := h.trackRepo.Suggest(name, 1)
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, "Internal server error"
+	}
+	if len(suggestions) > 0 && namesOverlap(suggestions[0].Name, name) {
+		s := suggestions[0]
+		return &models.TrackBrief{ID: s.ID, Name: s.Name, Location: s.Location}, models.TrackMatchName, 0, ""
+	}
+	return nil, "", 0, ""
+}
+
+// namesOverlap reports whether the words of one name appear, in order, in
+// the other.
+func namesOverlap(a, b string) bool {
+	wa := " " + strings.Join(search.Tokenize(a), " ") + " "
+	wb := " " + strings.Join(search.Tokenize(b), " ") + " "
+	if strings.TrimSpace(wa) == "" || strings.TrimSpace(wb) == "" {
+		return false
+	}
+	return strings.Contains(wa, wb) || strings.Contains(wb, wa)
+}
+
+// POST /api/lapbook/import/gps
+//
+// Finds laps in a GPX file or NMEA log (multipart field "file") by where the
+// trace crosses the track's start/finish line, and imports them as a new
+// session like a CSV import. Laps get splits when every sector but the last
+// has a timing line. The track is matched by the trace's first fix unless
+// trackId is given.
+func (h *SessionHandler) ImportGPS(c echo.Context) error {
+	data, form, status, msg := readLapImport(c)
+	if status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	trace, err := gpslap.Parse(data)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Could not read file: " + err.Error()})
+	}
+
+	result := newLapImportResult(form, trace.Format, trace.Date())
+	if _, err := time.Parse(time.DateOnly, result.Date); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
+	}
+
+	first := trace.Points[0]
+	brief, status, msg := h.importTrack(c, &form, result, &models.GeoPoint{Lat: first.Lat, Lng: first.Lng}, "")
+	if status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+	if brief == nil {
+		return h.saveLapImport(c, form, result)
+	}
+
+	track, err := h.trackRepo.FindByID(brief.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if track.StartFinishLine == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "This track has no start/finish line to detect laps with"})
+	}
+	sectors, err := h.trackRepo.GetSectors(track.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	sectorLines, ok := sectorTimingLines(sectors)
+	if !ok {
+		result.Warnings = append(result.Warnings, "Not every sector has a timing line; splits were not detected")
+	}
+
+	laps, rejected := gpslap.Detect(trace.Points, gpsLine(*track.StartFinishLine), sectorLines)
+	for _, r := range rejected {
+		result.Errors = append(result.Errors, models.LapImportError{Row: r.Line, Message: fmt.Sprintf("lap %d: %s", r.Number, r.Message)})
+	}
+	for _, lap := range laps {
+		if lap.TimeMs > laptime.Max {
+			result.Errors = append(result.Errors, models.LapImportError{Row: lap.Line, Message: fmt.Sprintf("lap %d is too long", lap.Number)})
+			continue
+		}
+		imported := models.ImportedLap{
+			Row:       lap.Line,
+			LapNumber: lap.Number,
+			LapTime:   laptime.Format(lap.TimeMs),
+			LapTimeMs: lap.TimeMs,
+			SplitsMs:  lap.SplitsMs,
+		}
+		for _, ms := range lap.SplitsMs {
+			imported.Splits = append(imported.Splits, laptime.Format(ms))
+		}
+		if len(sectorLines) > 0 && lap.SplitsMs == nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("Lap %d did not cross every sector line and has no splits", lap.Number))
+		}
+		result.Laps = append(result.Laps, imported)
+	}
+	if len(laps) == 0 && len(rejected) == 0 {
+		result.Warnings = append(result.Warnings, "No laps found; the trace does not cross the start/finish line twice")
+	}
+	sort.SliceStable(result.Errors, func(i, j int) bool { return result.Errors[i].Row < result.Errors[j].Row })
+
+	return h.saveLapImport(c, form, result)
+}
+
+// sectorTimingLines returns the lines ending each sector but the last. It
+// returns no lines for a track with fewer than two sectors, and false if a
+// sector that needs a line has none.
+func sectorTimingLines(sectors []models.TrackSector) ([]gpslap.Line, bool) {
+	if len(sectors) < 2 {
+		return nil, true
+	}
+	lines := make([]gpslap.Line, 0, len(sectors)-1)
+	for _, s := range sectors[:len(sectors)-1] {
+		if s.Line == nil {
+			return nil, false
+		}
+		lines = append(lines, gpsLine(*s.Line))
+	}
+	return lines, true
+}
+
+func gpsLine(l models.TimingLine) gpslap.Line {
+	return gpslap.Line{Lat1: l.Lat1, Lng1: l.Lng1, Lat2: l.Lat2, Lng2: l.Lng2}
+}
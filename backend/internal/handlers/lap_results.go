@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/joezmuda/trackside-backend/internal/laptime"
+	"github.com/joezmuda/trackside-backend/internal/models"
+)
+
+// Limits on event-specific lap results.
+const (
+	maxReactionMs       = 10_000
+	maxTrapSpeedMph     = 400
+	maxCones            = 100
+	maxDriftScores      = 10
+	maxDriftScore       = 100
+	maxDriftCategoryLen = 40
+)
+
+// validateResult checks a lap's event-specific result against the type of
+// its event and its lap time, and puts the result into canonical form with
+// its derived fields filled in. It returns a message to reply with if the
+// result is invalid. A result with nothing in it is cleared.
+func validateResult(req *models.LapRecordRequest, eventType string, lapTimeMs int64) string {
+	r := req.Result
+	if r == nil {
+		return ""
+	}
+
+	var want models.EventType
+	kinds := 0
+	if r.Drag != nil {
+		want = models.EventDrag
+		kinds++
+	}
+	if r.Autocross != nil {
+		want = models.EventAutocross
+		kinds++
+	}
+	if r.Drift != nil {
+		want = models.EventDrift
+		kinds++
+	}
+	switch {
+	case kinds == 0:
+		req.Result = nil
+		return ""
+	case kinds > 1:
+		return "A lap can only have a result for one event type"
+	case eventType != string(want):
+		return fmt.Sprintf("A %s result needs the lap to be at a %s event", strings.ToLower(string(want)), want)
+	}
+
+	switch {
+	case r.Drag != nil:
+		return validateDrag(r.Drag, lapTimeMs)
+	case r.Autocross != nil:
+		return validateAutocross(r.Autocross, lapTimeMs)
+	default:
+		return validateDrift(r.Drift)
+	}
+}
+
+// dragElapsedTime returns the elapsed time of a drag pass from its timeslip,
+// to use as the lap time when none is given: the 1/4 mile time, or the 1/8
+// mile time for an eighth-mile pass.
+func dragElapsedTime(r *models.LapResult) string {
+	if r == nil || r.Drag == nil {
+		return ""
+	}
+	for _, t := range []*string{r.Drag.QuarterMile, r.Drag.EighthMile} {
+		if t != nil && strings.TrimSpace(*t) != "" {
+			return strings.TrimSpace(*t)
+		}
+	}
+	return ""
+}
+
+func validateDrag(d *models.DragResult, lapTimeMs int64) string {
+	if d.ReactionTime == nil && d.SixtyFoot == nil && d.ThreeThirty == nil &&
+		d.EighthMile == nil && d.QuarterMile == nil && d.TrapSpeedMph == nil {
+		return "A drag result needs at least one time or the trap speed"
+	}
+
+	d.RedLight = false
+	if d.ReactionTime != nil {
+		s, negative := strings.CutPrefix(strings.TrimSpace(*d.ReactionTime), "-")
+		ms, err := laptime.Parse(s)
+		if err == laptime.ErrZero {
+			ms, err = 0, nil
+		}
+		if err != nil {
+			return "Invalid reaction time: " + err.Error()
+		}
+		if ms > maxReactionMs {
+			return "Reaction time must be under 10 seconds"
+		}
+		if negative {
+			ms = -ms
+		}
+		formatted := laptime.Format(ms)
+		d.ReactionTime = &formatted
+		d.RedLight = ms < 0
+	}
+
+	// Incremental times must increase along the strip and fit in the pass
+	increments := []struct {
+		name string
+		time **string
+	}{
+		{"60 ft", &d.SixtyFoot}, {"330 ft", &d.ThreeThirty}, {"1/8 mile", &d.EighthMile}, {"1/4 mile", &d.QuarterMile},
+	}
+	var prev int64
+	for _, inc := range increments {
+		if *inc.time == nil {
+			continue
+		}
+		ms, err := laptime.Parse(**inc.time)
+		if err != nil {
+			return fmt.Sprintf("Invalid %s time: %v", inc.name, err)
+		}
+		if ms <= prev {
+			return fmt.Sprintf("The %s time must be later than the times before it", inc.name)
+		}
+		if ms > lapTimeMs {
+			return fmt.Sprintf("The %s time can't be longer than the elapsed time", inc.name)
+		}
+		formatted := laptime.Format(ms)
+		*inc.time = &formatted
+		prev = ms
+	}
+	if d.QuarterMile != nil {
+		if ms, _ := laptime.Parse(*d.QuarterMile); ms != lapTimeMs {
+			return "The 1/4 mile time must match the elapsed time"
+		}
+	}
+
+	if d.TrapSpeedMph != nil && (math.IsNaN(*d.TrapSpeedMph) || *d.TrapSpeedMph <= 0 || *d.TrapSpeedMph > maxTrapSpeedMph) {
+		return "Trap speed must be between 0 and 400 mph"
+	}
+	return ""
+}
+
+func validateAutocross(a *models.AutocrossResult, lapTimeMs int64) string {
+	if a.Cones < 0 || a.Cones > maxCones {
+		return "Cones must be between 0 and 100"
+	}
+	a.AdjustedTime, a.AdjustedTimeMs = nil, nil
+	if a.DNF || a.OffCourse {
+		return ""
+	}
+	adjusted := lapTimeMs + int64(a.Cones)*models.ConePenaltyMs
+	formatted := laptime.Format(adjusted)
+	a.AdjustedTimeMs, a.AdjustedTime = &adjusted, &formatted
+	return ""
+}
+
+func validateDrift(d *models.DriftResult) string {
+	if len(d.Scores) == 0 {
+		return "A drift result needs at least one score"
+	}
+	if len(d.Scores) > maxDriftScores {
+		return "A drift result can have at most 10 scores"
+	}
+	seen := make(map[string]bool, len(d.Scores))
+	for i := range d.Scores {
+		s := &d.Scores[i]
+		s.Category = strings.TrimSpace(s.Category)
+		if s.Category == "" || len(s.Category) > maxDriftCategoryLen {
+			return "Each drift score needs a category of up to 40 characters"
+		}
+		key := strings.ToLower(s.Category)
+		if seen[key] {
+			return fmt.Sprintf("Category %q is scored more than once", s.Category)
+		}
+		seen[key] = true
+		if math.IsNaN(s.Score) || s.Score < 0 || s.Score > maxDriftScore {
+			return "Drift scores must be between 0 and 100"
+		}
+	}
+	d.Total = models.DriftTotal(d.Scores)
+	return ""
+}
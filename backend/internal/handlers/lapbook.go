@@ -1,22 +1,30 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/joezmuda/trackside-backend/internal/laptime"
 	"github.com/joezmuda/trackside-backend/internal/middleware"
 	"github.com/joezmuda/trackside-backend/internal/models"
 	"github.com/joezmuda/trackside-backend/internal/repository"
 	"github.com/labstack/echo/v4"
 )
 
+// splitToleranceMs is how far, per sector, splits may add up to more or
+// less than the lap time, to allow for loggers that round each split.
+const splitToleranceMs = 10
+
 type LapbookHandler struct {
 	lapbookRepo *repository.LapbookRepo
+	sessionRepo *repository.SessionRepo
 	carRepo     *repository.CarRepo
 	trackRepo   *repository.TrackRepo
 }
 
-func NewLapbookHandler(lapbookRepo *repository.LapbookRepo, carRepo *repository.CarRepo, trackRepo *repository.TrackRepo) *LapbookHandler {
-	return &LapbookHandler{lapbookRepo: lapbookRepo, carRepo: carRepo, trackRepo: trackRepo}
+func NewLapbookHandler(lapbookRepo *repository.LapbookRepo, sessionRepo *repository.SessionRepo, carRepo *repository.CarRepo, trackRepo *repository.TrackRepo) *LapbookHandler {
+	return &LapbookHandler{lapbookRepo: lapbookRepo, sessionRepo: sessionRepo, carRepo: carRepo, trackRepo: trackRepo}
 }
 
 // GET /api/lapbook
@@ -25,14 +33,73 @@ func (h *LapbookHandler) List(c echo.Context) error {
 	trackID := c.QueryParam("trackId")
 	eventType := c.QueryParam("eventType")
 	carID := c.QueryParam("carId")
+	sessionID := c.QueryParam("sessionId")
 
-	records, err := h.lapbookRepo.List(userID, trackID, eventType, carID)
+	records, err := h.lapbookRepo.List(userID, trackID, eventType, carID, sessionID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch lap records"})
 	}
 	return c.JSON(http.StatusOK, records)
 }
 
+// GET /api/lapbook/stats
+func (h *LapbookHandler) Stats(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	eventType := c.QueryParam("eventType")
+	conditions := c.QueryParam("conditions")
+
+	if eventType != "" && !models.ValidEventType(eventType) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid event type"})
+	}
+	if conditions != "" && !models.ValidDrivingCondition(conditions) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid conditions"})
+	}
+
+	stats, err := h.lapbookRepo.Stats(userID, c.QueryParam("trackId"), eventType, c.QueryParam("carId"), conditions)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to compute lap stats"})
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+// GET /api/lapbook/theoretical-best
+func (h *LapbookHandler) TheoreticalBest(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	trackID := c.QueryParam("trackId")
+	eventType := c.QueryParam("eventType")
+	conditions := c.QueryParam("conditions")
+
+	if trackID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "trackId is required"})
+	}
+	if eventType != "" && !models.ValidEventType(eventType) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid event type"})
+	}
+	if conditions != "" && !models.ValidDrivingCondition(conditions) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid conditions"})
+	}
+
+	track, err := h.trackRepo.FindByID(trackID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if track == nil || !canView(c, track.Status, track.UploadedByID) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Track not found"})
+	}
+
+	sectors, err := h.trackRepo.GetSectors(trackID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	brief := models.TrackBrief{ID: track.ID, Name: track.Name, Location: track.Location}
+	result, err := h.lapbookRepo.TheoreticalBest(userID, brief, sectors, c.QueryParam("carId"), eventType, conditions)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to compute theoretical best"})
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
 // POST /api/lapbook
 func (h *LapbookHandler) Create(c echo.Context) error {
 	userID := middleware.GetUserID(c)
@@ -42,39 +109,248 @@ func (h *LapbookHandler) Create(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 	}
 
+	lap, status, msg := h.validateRecord(c, "", &req)
+	if status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	record, err := h.lapbookRepo.Create(req, lap.lapTimeMs, lap.splitsMs, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusCreated, record)
+}
+
+// PUT /api/lapbook/:id
+func (h *LapbookHandler) Update(c echo.Context) error {
+	return h.update(c, false)
+}
+
+// PATCH /api/lapbook/:id
+func (h *LapbookHandler) Patch(c echo.Context) error {
+	return h.update(c, true)
+}
+
+// update replaces a lap record with the request body. For a patch the body
+// is applied on top of the stored record, so omitted fields are kept and
+// null clears an optional field.
+func (h *LapbookHandler) update(c echo.Context, patch bool) error {
+	userID := middleware.GetUserID(c)
+	id := c.Param("id")
+
+	current, err := h.lapbookRepo.GetEditable(id, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if current == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Record not found"})
+	}
+
+	var req models.LapRecordRequest
+	if patch {
+		req = *current
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	lap, status, msg := h.validateRecord(c, id, &req)
+	if status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	record, err := h.lapbookRepo.Update(id, userID, req, lap.lapTimeMs, lap.splitsMs)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if record == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Record not found"})
+	}
+	return c.JSON(http.StatusOK, record)
+}
+
+// GET /api/lapbook/:id/history
+func (h *LapbookHandler) History(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	id := c.Param("id")
+
+	record, err := h.lapbookRepo.FindByIDAndDriver(id, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if record == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Record not found"})
+	}
+
+	history, err := h.lapbookRepo.History(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, history)
+}
+
+// parsedLap holds the times parsed from a lap record request. lapTimeMs is
+// nil for a judged run logged without a time.
+type parsedLap struct {
+	lapTimeMs *int64
+	splitsMs  []int64
+}
+
+// validateRecord checks a lap record request before it is created or
+// updated (id is empty for a new record) and puts the lap and split times
+// into canonical form. The lap time can only be left out at events ranked
+// by judged scores. It returns the parsed times, or a non-zero status and
+// message to reply with.
+func (h *LapbookHandler) validateRecord(c echo.Context, id string, req *models.LapRecordRequest) (parsedLap, int, string) {
+	var lap parsedLap
+	req.LapTime = strings.TrimSpace(req.LapTime)
 	if req.LapTime == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Lap time is required"})
+		req.LapTime = dragElapsedTime(req.Result)
+	}
+	var lapTimeMs int64
+	if req.LapTime != "" {
+		ms, err := laptime.Parse(req.LapTime)
+		if err != nil {
+			return lap, http.StatusBadRequest, "Invalid lap time: " + err.Error()
+		}
+		lapTimeMs = ms
+		lap.lapTimeMs = &lapTimeMs
+		req.LapTime = laptime.Format(lapTimeMs)
 	}
 	if !models.ValidDrivingCondition(req.Conditions) {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid conditions"})
+		return lap, http.StatusBadRequest, "Invalid conditions"
+	}
+	if status, msg := h.applySession(c, id, req); status != 0 {
+		return lap, status, msg
 	}
 	if req.TrackID == "" || req.CarID == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Track and car are required"})
+		return lap, http.StatusBadRequest, "Track and car are required"
 	}
 
 	// Verify car belongs to user
-	owns, err := h.carRepo.ExistsForUser(req.CarID, userID)
+	owns, err := h.carRepo.ExistsForUser(req.CarID, middleware.GetUserID(c))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		return lap, http.StatusInternalServerError, "Internal server error"
 	}
 	if !owns {
-		return c.JSON(http.StatusNotFound, map[string]string{"error": "Car not found"})
+		return lap, http.StatusNotFound, "Car not found"
 	}
 
 	// Verify track exists
-	exists, err := h.trackRepo.Exists(req.TrackID)
+	exists, err := trackVisible(c, h.trackRepo, req.TrackID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		return lap, http.StatusInternalServerError, "Internal server error"
 	}
 	if !exists {
-		return c.JSON(http.StatusNotFound, map[string]string{"error": "Track not found"})
+		return lap, http.StatusNotFound, "Track not found"
 	}
 
-	record, err := h.lapbookRepo.Create(req, userID)
+	// The event, if any, must be one of the track's events
+	var eventType string
+	if req.TrackEventID != nil {
+		event, err := trackEvent(h.trackRepo, req.TrackID, *req.TrackEventID)
+		if err != nil {
+			return lap, http.StatusInternalServerError, "Internal server error"
+		}
+		if event == nil {
+			return lap, http.StatusBadRequest, "Event does not belong to this track"
+		}
+		eventType = event.EventType
+	}
+	if lap.lapTimeMs == nil && repository.RankingFor(eventType, false) != models.RankByScore {
+		return lap, http.StatusBadRequest, "Lap time is required"
+	}
+	if msg := validateResult(req, eventType, lapTimeMs); msg != "" {
+		return lap, http.StatusBadRequest, msg
+	}
+
+	// Splits, if any, cover every sector and add up to the lap time
+	if len(req.Splits) == 0 {
+		req.Splits = nil
+		return lap, 0, ""
+	}
+	sectors, err := h.trackRepo.GetSectors(req.TrackID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		return lap, http.StatusInternalServerError, "Internal server error"
 	}
-	return c.JSON(http.StatusCreated, record)
+	if len(sectors) == 0 {
+		return lap, http.StatusBadRequest, "This track has no sectors to record splits for"
+	}
+	if lap.lapTimeMs == nil {
+		return lap, http.StatusBadRequest, "Splits need a lap time"
+	}
+	if len(req.Splits) != len(sectors) {
+		return lap, http.StatusBadRequest, fmt.Sprintf("Expected %d splits, one per sector", len(sectors))
+	}
+	var sum int64
+	for i, split := range req.Splits {
+		ms, err := laptime.Parse(split)
+		if err != nil {
+			return lap, http.StatusBadRequest, fmt.Sprintf("Invalid split %d: %v", i+1, err)
+		}
+		req.Splits[i] = laptime.Format(ms)
+		lap.splitsMs = append(lap.splitsMs, ms)
+		sum += ms
+	}
+	tolerance := splitToleranceMs * int64(len(sectors))
+	if diff := sum - lapTimeMs; diff < -tolerance || diff > tolerance {
+		return lap, http.StatusBadRequest, "Splits must add up to the lap time"
+	}
+
+	return lap, 0, ""
+}
+
+// applySession checks the session a lap is attached to and fills in the
+// track, car and event from it where the request leaves them out. A lap in
+// a session gets the next lap number unless it has one; a lap outside a
+// session has no lap number.
+func (h *LapbookHandler) applySession(c echo.Context, id string, req *models.LapRecordRequest) (int, string) {
+	if req.SessionID == nil {
+		req.LapNumber = nil
+		return 0, ""
+	}
+
+	session, err := h.sessionRepo.FindByIDAndDriver(*req.SessionID, middleware.GetUserID(c))
+	if err != nil {
+		return http.StatusInternalServerError, "Internal server error"
+	}
+	if session == nil {
+		return http.StatusNotFound, "Session not found"
+	}
+
+	if req.TrackID == "" {
+		req.TrackID = session.TrackID
+	}
+	if req.CarID == "" {
+		req.CarID = session.CarID
+	}
+	if req.TrackEventID == nil {
+		req.TrackEventID = session.TrackEventID
+	}
+	if req.TrackID != session.TrackID || req.CarID != session.CarID ||
+		(session.TrackEventID != nil && *req.TrackEventID != *session.TrackEventID) {
+		return http.StatusBadRequest, "Lap must match the session's track, car and event"
+	}
+
+	if req.LapNumber == nil {
+		next, err := h.lapbookRepo.NextLapNumber(session.ID, id)
+		if err != nil {
+			return http.StatusInternalServerError, "Internal server error"
+		}
+		req.LapNumber = &next
+		return 0, ""
+	}
+	if *req.LapNumber < 1 {
+		return http.StatusBadRequest, "Lap number must be at least 1"
+	}
+	taken, err := h.lapbookRepo.LapNumberTaken(session.ID, *req.LapNumber, id)
+	if err != nil {
+		return http.StatusInternalServerError, "Internal server error"
+	}
+	if taken {
+		return http.StatusConflict, "Lap number is already used in this session"
+	}
+	return 0, ""
 }
 
 // DELETE /api/lapbook/:id
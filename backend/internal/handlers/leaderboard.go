@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/joezmuda/trackside-backend/internal/repository"
+	"github.com/labstack/echo/v4"
+)
+
+type LeaderboardHandler struct {
+	leaderboardRepo *repository.LeaderboardRepo
+	trackRepo       *repository.TrackRepo
+}
+
+func NewLeaderboardHandler(leaderboardRepo *repository.LeaderboardRepo, trackRepo *repository.TrackRepo) *LeaderboardHandler {
+	return &LeaderboardHandler{leaderboardRepo: leaderboardRepo, trackRepo: trackRepo}
+}
+
+const (
+	defaultLeaderboardSize = 50
+	maxLeaderboardSize     = 100
+)
+
+// GET /api/tracks/:id/leaderboard
+func (h *LeaderboardHandler) Get(c echo.Context) error {
+	trackID := c.Param("id")
+
+	params := models.LeaderboardParams{
+		EventType:  c.QueryParam("eventType"),
+		Conditions: c.QueryParam("conditions"),
+		CarMake:    c.QueryParam("carMake"),
+		CarModel:   c.QueryParam("carModel"),
+		From:       c.QueryParam("from"),
+		To:         c.QueryParam("to"),
+		Limit:      defaultLeaderboardSize,
+	}
+
+	if params.EventType != "" && !models.ValidEventType(params.EventType) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid event type"})
+	}
+	if v := c.QueryParam("pax"); v != "" {
+		pax, err := strconv.ParseBool(v)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "pax must be true or false"})
+		}
+		if pax && params.EventType != string(models.EventAutocross) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "PAX times are only for autocross leaderboards"})
+		}
+		params.Pax = pax
+	}
+	if params.Conditions != "" && !models.ValidDrivingCondition(params.Conditions) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid conditions"})
+	}
+	for _, d := range []string{params.From, params.To} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse(time.DateOnly, d); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Dates must be YYYY-MM-DD"})
+		}
+	}
+	if params.From != "" && params.To != "" && params.From > params.To {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "from must not be after to"})
+	}
+	if v := c.QueryParam("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxLeaderboardSize {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Limit must be between 1 and 100"})
+		}
+		params.Limit = limit
+	}
+
+	track, err := h.trackRepo.FindByID(trackID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if track == nil || !canView(c, track.Status, track.UploadedByID) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Track not found"})
+	}
+
+	// Lap times, autocross runs and drag passes don't compare, so a track
+	// with several event types needs one picked
+	if params.EventType == "" {
+		events, err := h.trackRepo.GetEvents(trackID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		}
+		if len(events) > 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "eventType is required for a track with more than one event type"})
+		}
+	}
+
+	entries, total, err := h.leaderboardRepo.ForTrack(trackID, params)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch leaderboard"})
+	}
+
+	return c.JSON(http.StatusOK, models.LeaderboardResponse{
+		Track:    models.TrackBrief{ID: track.ID, Name: track.Name, Location: track.Location},
+		RankedBy: repository.RankingFor(params.EventType, params.Pax),
+		Entries:  entries,
+		Total:    total,
+	})
+}
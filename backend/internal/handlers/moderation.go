@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/joezmuda/trackside-backend/internal/middleware"
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/joezmuda/trackside-backend/internal/repository"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultQueuePage = 50
+	maxQueuePage     = 100
+)
+
+type ModerationHandler struct {
+	trackRepo *repository.TrackRepo
+}
+
+func NewModerationHandler(trackRepo *repository.TrackRepo) *ModerationHandler {
+	return &ModerationHandler{trackRepo: trackRepo}
+}
+
+// GET /api/moderation/tracks
+func (h *ModerationHandler) Queue(c echo.Context) error {
+	status := c.QueryParam("status")
+	if status == "" {
+		status = string(models.TrackStatusPending)
+	}
+	switch models.TrackStatus(status) {
+	case models.TrackStatusPending, models.TrackStatusApproved, models.TrackStatusRejected, models.TrackStatusArchived:
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid status"})
+	}
+
+	limit, offset := defaultQueuePage, 0
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxQueuePage {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Limit must be between 1 and 100"})
+		}
+		limit = n
+	}
+	if v := c.QueryParam("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Offset must be a non-negative number"})
+		}
+		offset = n
+	}
+
+	tracks, err := h.trackRepo.ListByStatus(status, limit, offset)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch tracks"})
+	}
+	return c.JSON(http.StatusOK, tracks)
+}
+
+// POST /api/moderation/tracks/:id/approve
+func (h *ModerationHandler) Approve(c echo.Context) error {
+	return h.decide(c, models.TrackStatusApproved)
+}
+
+// POST /api/moderation/tracks/:id/reject
+func (h *ModerationHandler) Reject(c echo.Context) error {
+	return h.decide(c, models.TrackStatusRejected)
+}
+
+func (h *ModerationHandler) decide(c echo.Context, status models.TrackStatus) error {
+	userID := middleware.GetUserID(c)
+	id := c.Param("id")
+
+	var req models.ModerationRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if req.Reason != nil {
+		trimmed := strings.TrimSpace(*req.Reason)
+		req.Reason = &trimmed
+		if trimmed == "" {
+			req.Reason = nil
+		}
+	}
+	if status == models.TrackStatusRejected && req.Reason == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A reason is required when rejecting a track"})
+	}
+
+	track, err := h.trackRepo.FindByID(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if track == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Track not found"})
+	}
+	if track.Status == string(status) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Track is already " + strings.ToLower(string(status))})
+	}
+
+	updated, err := h.trackRepo.SetStatus(id, string(status), req.Reason, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, updated)
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/middleware"
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/joezmuda/trackside-backend/internal/oidc"
+	"github.com/joezmuda/trackside-backend/internal/repository"
+	"github.com/labstack/echo/v4"
+)
+
+// oauthStateTTL is how long a user has to sign in at the provider.
+const oauthStateTTL = 10 * time.Minute
+
+// OIDCHandler signs users in with OpenID Connect providers and manages the
+// provider accounts linked to their profile. Logging in issues the same
+// tokens as a password login.
+type OIDCHandler struct {
+	auth        *AuthHandler
+	userRepo    *repository.UserRepo
+	accountRepo *repository.AccountRepo
+	stateRepo   *repository.OAuthStateRepo
+	providers   []*oidc.Provider
+}
+
+func NewOIDCHandler(auth *AuthHandler, userRepo *repository.UserRepo, accountRepo *repository.AccountRepo, stateRepo *repository.OAuthStateRepo, providers []*oidc.Provider) *OIDCHandler {
+	return &OIDCHandler{auth: auth, userRepo: userRepo, accountRepo: accountRepo, stateRepo: stateRepo, providers: providers}
+}
+
+// GET /api/auth/oidc/providers
+func (h *OIDCHandler) Providers(c echo.Context) error {
+	providers := make([]models.OIDCProviderInfo, 0, len(h.providers))
+	for _, p := range h.providers {
+		providers = append(providers, models.OIDCProviderInfo{ID: p.ID, Name: p.Name})
+	}
+	return c.JSON(http.StatusOK, providers)
+}
+
+// POST /api/auth/oidc/:provider/start
+//
+// Starts signing in: the frontend sends the user to the returned URL, and
+// the provider sends them back to its redirect URL with a code and state
+// to post to the callback.
+func (h *OIDCHandler) Start(c echo.Context) error {
+	return h.start(c, nil)
+}
+
+// POST /api/profile/accounts/:provider
+//
+// Starts linking a provider account to the signed-in user. The callback
+// must be called with the same user's token.
+func (h *OIDCHandler) Link(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	return h.start(c, &userID)
+}
+
+func (h *OIDCHandler) start(c echo.Context, userID *string) error {
+	p := h.provider(c.Param("provider"))
+	if p == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown sign-in provider"})
+	}
+
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	nonce, err := oidc.NewNonce()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	state, err := h.stateRepo.Create(models.OAuthState{
+		Provider:     p.ID,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		UserID:       userID,
+	}, oauthStateTTL)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	url, err := p.AuthURL(c.Request().Context(), state, nonce, verifier)
+	if err != nil {
+		c.Logger().Errorf("oidc %s: %v", p.ID, err)
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": "Could not reach " + p.Name})
+	}
+	return c.JSON(http.StatusOK, models.OIDCStartResponse{URL: url, State: state})
+}
+
+// POST /api/auth/oidc/:provider/callback
+//
+// Finishes signing in or linking. A provider account already linked to a
+// user logs them in; otherwise it is linked to the user with the same
+// email, if the provider has verified it, or a new user is created.
+func (h *OIDCHandler) Callback(c echo.Context) error {
+	p := h.provider(c.Param("provider"))
+	if p == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown sign-in provider"})
+	}
+	var req models.OIDCCallbackRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if req.Code == "" || req.State == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Code and state are required"})
+	}
+
+	state, err := h.stateRepo.Consume(req.State)
+	if errors.Is(err, repository.ErrInvalidOAuthState) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Sign-in expired, please try again"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if state.Provider != p.ID {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Sign-in expired, please try again"})
+	}
+	// Otherwise someone could get a victim to finish linking the victim's
+	// provider account to theirs
+	if state.UserID != nil && *state.UserID != middleware.GetUserID(c) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Sign in as the user linking the account"})
+	}
+
+	tokens, claims, err := p.Exchange(c.Request().Context(), req.Code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		c.Logger().Errorf("oidc %s: %v", p.ID, err)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Could not sign in with " + p.Name})
+	}
+	account := models.Account{
+		Provider:          p.ID,
+		ProviderAccountID: claims.Subject,
+		AccessToken:       optionalString(tokens.AccessToken),
+		RefreshToken:      optionalString(tokens.RefreshToken),
+		TokenType:         optionalString(tokens.TokenType),
+		Scope:             optionalString(tokens.Scope),
+		IDToken:           optionalString(tokens.IDToken),
+	}
+	if tokens.ExpiresIn > 0 {
+		expiresAt := time.Now().Unix() + tokens.ExpiresIn
+		account.ExpiresAt = &expiresAt
+	}
+
+	linkedTo, err := h.accountRepo.FindUserID(p.ID, claims.Subject)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+
+	if state.UserID != nil {
+		account.UserID = *state.UserID
+		if linkedTo != "" && linkedTo != account.UserID {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "That " + p.Name + " account is linked to another user"})
+		}
+		if status, msg := h.saveAccount(account); status != 0 {
+			return c.JSON(status, map[string]string{"error": msg})
+		}
+		return c.JSON(http.StatusOK, models.LinkedAccount{Provider: p.ID, Name: p.Name, ProviderAccountID: claims.Subject})
+	}
+
+	var user *models.User
+	if linkedTo != "" {
+		user, err = h.userRepo.FindByID(linkedTo)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		}
+	} else {
+		var status int
+		var msg string
+		user, status, msg = h.findOrCreateUser(p, claims)
+		if status != 0 {
+			return c.JSON(status, map[string]string{"error": msg})
+		}
+	}
+	if user == nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Could not sign in with " + p.Name})
+	}
+
+	account.UserID = user.ID
+	if status, msg := h.saveAccount(account); status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+	return h.auth.startSession(c, user)
+}
+
+// findOrCreateUser finds the user to link a new provider account to by its
+// verified email, or creates one.
+func (h *OIDCHandler) findOrCreateUser(p *oidc.Provider, claims *oidc.Claims) (*models.User, int, string) {
+	email := strings.TrimSpace(claims.Email)
+	if !claims.EmailVerified || !models.ValidEmail(email) {
+		return nil, http.StatusBadRequest, p.Name + " did not share a verified email address"
+	}
+	if models.IsSystemEmail(email) {
+		return nil, http.StatusBadRequest, "This email address is reserved"
+	}
+
+	user, err := h.userRepo.FindByEmail(email)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Internal server error"
+	}
+	if user == nil {
+		name := strings.TrimSpace(claims.Name)
+		if name == "" {
+			name = email[:strings.Index(email, "@")]
+		}
+		user, err = h.userRepo.CreateFromProvider(name, email, optionalString(claims.Picture))
+		if err != nil {
+			return nil, http.StatusInternalServerError, "Internal server error"
+		}
+		return user, 0, ""
+	}
+
+	if user.EmailVerified == nil {
+		// Whoever registered this email never showed they own it, and the
+		// provider just showed the user does. Their password, sessions, API
+		// tokens and two-factor setup shouldn't come with the account.
+		if err := h.userRepo.RemovePassword(user.ID); err != nil {
+			return nil, http.StatusInternalServerError, "Internal server error"
+		}
+		if err := h.auth.twoFactor.Disable(user.ID); err != nil {
+			return nil, http.StatusInternalServerError, "Internal server error"
+		}
+		if _, err := h.auth.sessionRepo.RevokeAll(user.ID); err != nil {
+			return nil, http.StatusInternalServerError, "Internal server error"
+		}
+		if err := h.auth.apiTokens.DeleteAll(user.ID); err != nil {
+			return nil, http.StatusInternalServerError, "Internal server error"
+		}
+		if err := h.userRepo.MarkEmailVerified(user.ID); err != nil {
+			return nil, http.StatusInternalServerError, "Internal server error"
+		}
+		if user, err = h.userRepo.FindByID(user.ID); err != nil {
+			return nil, http.StatusInternalServerError, "Internal server error"
+		}
+	}
+	return user, 0, ""
+}
+
+// saveAccount links the account to its user, who can have only one
+// account per provider.
+func (h *OIDCHandler) saveAccount(account models.Account) (int, string) {
+	existing, err := h.accountRepo.FindProviderAccountID(account.UserID, account.Provider)
+	if err != nil {
+		return http.StatusInternalServerError, "Internal server error"
+	}
+	if existing != "" && existing != account.ProviderAccountID {
+		name := h.provider(account.Provider).Name
+		return http.StatusConflict, "A different " + name + " account is already linked"
+	}
+	if err := h.accountRepo.Save(account); err != nil {
+		return http.StatusInternalServerError, "Internal server error"
+	}
+	return 0, ""
+}
+
+// GET /api/profile/accounts
+func (h *OIDCHandler) Accounts(c echo.Context) error {
+	accounts, err := h.accountRepo.ListByUser(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	for i := range accounts {
+		accounts[i].Name = accounts[i].Provider
+		if p := h.provider(accounts[i].Provider); p != nil {
+			accounts[i].Name = p.Name
+		}
+	}
+	return c.JSON(http.StatusOK, accounts)
+}
+
+// DELETE /api/profile/accounts/:provider
+//
+// Unlinks a provider account, unless it's the only way the user can sign in.
+func (h *OIDCHandler) Unlink(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	provider := c.Param("provider")
+
+	user, err := h.userRepo.FindByID(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if user == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+	accounts, err := h.accountRepo.ListByUser(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	linked := false
+	for _, a := range accounts {
+		linked = linked || a.Provider == provider
+	}
+	if !linked {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Account not linked"})
+	}
+	if user.PasswordHash == nil && len(accounts) == 1 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Set a password before unlinking your only sign-in method"})
+	}
+
+	if _, err := h.accountRepo.Delete(userID, provider); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Account unlinked"})
+}
+
+func (h *OIDCHandler) provider(id string) *oidc.Provider {
+	for _, p := range h.providers {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
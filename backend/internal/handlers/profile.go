@@ -47,15 +47,16 @@ func (h *ProfileHandler) Update(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid experience level"})
 	}
 
-	user, err := h.userRepo.UpdateProfile(userID, req.Name, req.Experience)
+	user, err := h.userRepo.UpdateProfile(userID, req.Name, req.Experience, req.LeaderboardOptOut)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"id":         user.ID,
-		"name":       user.Name,
-		"email":      user.Email,
-		"experience": user.Experience,
+		"id":                user.ID,
+		"name":              user.Name,
+		"email":             user.Email,
+		"experience":        user.Experience,
+		"leaderboardOptOut": user.LeaderboardOptOut,
 	})
 }
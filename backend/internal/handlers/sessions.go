@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/middleware"
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/joezmuda/trackside-backend/internal/repository"
+	"github.com/labstack/echo/v4"
+)
+
+const maxRunGroupLength = 50
+
+type SessionHandler struct {
+	sessionRepo *repository.SessionRepo
+	lapbookRepo *repository.LapbookRepo
+	carRepo     *repository.CarRepo
+	trackRepo   *repository.TrackRepo
+}
+
+func NewSessionHandler(sessionRepo *repository.SessionRepo, lapbookRepo *repository.LapbookRepo, carRepo *repository.CarRepo, trackRepo *repository.TrackRepo) *SessionHandler {
+	return &SessionHandler{sessionRepo: sessionRepo, lapbookRepo: lapbookRepo, carRepo: carRepo, trackRepo: trackRepo}
+}
+
+// GET /api/sessions
+func (h *SessionHandler) List(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	date := c.QueryParam("date")
+	if date != "" {
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
+		}
+	}
+
+	sessions, err := h.sessionRepo.List(userID, c.QueryParam("trackId"), c.QueryParam("carId"), date)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch sessions"})
+	}
+	return c.JSON(http.StatusOK, sessions)
+}
+
+// GET /api/sessions/:id
+func (h *SessionHandler) Get(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	id := c.Param("id")
+
+	session, err := h.sessionRepo.FindWithDetails(id, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if session == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Session not found"})
+	}
+
+	laps, err := h.lapbookRepo.ListForSession(id, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	session.Laps = laps
+	return c.JSON(http.StatusOK, session)
+}
+
+// POST /api/sessions
+func (h *SessionHandler) Create(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+
+	var req models.TrackSessionRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if status, msg := h.validateSession(c, &req); status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	session, err := h.sessionRepo.Create(req, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusCreated, session)
+}
+
+// PUT /api/sessions/:id
+func (h *SessionHandler) Update(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	id := c.Param("id")
+
+	current, err := h.sessionRepo.FindByIDAndDriver(id, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if current == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Session not found"})
+	}
+
+	var req models.TrackSessionRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if status, msg := h.validateSession(c, &req); status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	// Laps must keep matching their session
+	if req.TrackID != current.TrackID || req.CarID != current.CarID || !sameEvent(req.TrackEventID, current.TrackEventID) {
+		laps, err := h.sessionRepo.LapCount(id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		}
+		if laps > 0 {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Cannot change the track, car or event of a session with laps"})
+		}
+	}
+
+	session, err := h.sessionRepo.Update(id, userID, req)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, session)
+}
+
+// DELETE /api/sessions/:id
+func (h *SessionHandler) Delete(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	id := c.Param("id")
+
+	session, err := h.sessionRepo.FindByIDAndDriver(id, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if session == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Session not found"})
+	}
+
+	if err := h.sessionRepo.Delete(id); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"success": true})
+}
+
+// validateSession checks a session request and normalises its run group
+// and setup. It returns a non-zero status and message to reply with if the
+// request is invalid.
+func (h *SessionHandler) validateSession(c echo.Context, req *models.TrackSessionRequest) (int, string) {
+	if _, err := time.Parse(time.DateOnly, req.Date); err != nil {
+		return http.StatusBadRequest, "date must be YYYY-MM-DD"
+	}
+	if req.RunGroup != nil {
+		runGroup := strings.TrimSpace(*req.RunGroup)
+		if len(runGroup) > maxRunGroupLength {
+			return http.StatusBadRequest, "Run group is too long"
+		}
+		req.RunGroup = &runGroup
+		if runGroup == "" {
+			req.RunGroup = nil
+		}
+	}
+	if req.AmbientTempC != nil && (*req.AmbientTempC < -50 || *req.AmbientTempC > 60) {
+		return http.StatusBadRequest, "ambientTempC must be between -50 and 60"
+	}
+	if req.TrackTempC != nil && (*req.TrackTempC < -50 || *req.TrackTempC > 90) {
+		return http.StatusBadRequest, "trackTempC must be between -50 and 90"
+	}
+	if len(req.Setup) > 0 && string(req.Setup) != "null" {
+		if req.Setup[0] != '{' {
+			return http.StatusBadRequest, "setup must be a JSON object"
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, req.Setup); err != nil {
+			return http.StatusBadRequest, "setup must be a JSON object"
+		}
+		req.Setup = compact.Bytes()
+	}
+	if req.TrackID == "" || req.CarID == "" {
+		return http.StatusBadRequest, "Track and car are required"
+	}
+
+	owns, err := h.carRepo.ExistsForUser(req.CarID, middleware.GetUserID(c))
+	if err != nil {
+		return http.StatusInternalServerError, "Internal server error"
+	}
+	if !owns {
+		return http.StatusNotFound, "Car not found"
+	}
+
+	exists, err := trackVisible(c, h.trackRepo, req.TrackID)
+	if err != nil {
+		return http.StatusInternalServerError, "Internal server error"
+	}
+	if !exists {
+		return http.StatusNotFound, "Track not found"
+	}
+
+	if req.TrackEventID != nil {
+		event, err := trackEvent(h.trackRepo, req.TrackID, *req.TrackEventID)
+		if err != nil {
+			return http.StatusInternalServerError, "Internal server error"
+		}
+		if event == nil {
+			return http.StatusBadRequest, "Event does not belong to this track"
+		}
+	}
+	return 0, ""
+}
+
+func sameEvent(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -21,6 +21,15 @@ func NewTrackImageHandler(trackRepo *repository.TrackRepo) *TrackImageHandler {
 // GET /api/tracks/:id/images
 func (h *TrackImageHandler) List(c echo.Context) error {
 	trackID := c.Param("id")
+
+	visible, err := trackVisible(c, h.trackRepo, trackID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if !visible {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Track not found"})
+	}
+
 	images, err := h.trackRepo.GetImages(trackID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
@@ -43,7 +52,7 @@ func (h *TrackImageHandler) Create(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid image URL"})
 	}
 
-	exists, err := h.trackRepo.Exists(trackID)
+	exists, err := trackVisible(c, h.trackRepo, trackID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
 	}
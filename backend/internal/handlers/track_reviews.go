@@ -23,7 +23,7 @@ func (h *TrackReviewHandler) Create(c echo.Context) error {
 	userID := middleware.GetUserID(c)
 	trackID := c.Param("id")
 
-	exists, err := h.trackRepo.Exists(trackID)
+	exists, err := trackVisible(c, h.trackRepo, trackID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
 	}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/joezmuda/trackside-backend/internal/geo"
+	"github.com/joezmuda/trackside-backend/internal/middleware"
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/joezmuda/trackside-backend/internal/repository"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxTrackSectors = 50
+	// Timing lines run across the track, so a few metres to a few hundred.
+	minTimingLineMetres = 1
+	maxTimingLineMetres = 500
+)
+
+type TrackSectorHandler struct {
+	trackRepo *repository.TrackRepo
+	zoneRepo  *repository.ZoneRepo
+}
+
+func NewTrackSectorHandler(trackRepo *repository.TrackRepo, zoneRepo *repository.ZoneRepo) *TrackSectorHandler {
+	return &TrackSectorHandler{trackRepo: trackRepo, zoneRepo: zoneRepo}
+}
+
+// GET /api/tracks/:id/sectors
+func (h *TrackSectorHandler) List(c echo.Context) error {
+	trackID := c.Param("id")
+
+	exists, err := trackVisible(c, h.trackRepo, trackID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if !exists {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Track not found"})
+	}
+
+	sectors, err := h.trackRepo.GetSectors(trackID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, sectors)
+}
+
+// PUT /api/tracks/:id/sectors
+//
+// Sectors decide how every driver's splits at the track are read, so only
+// the track's uploader or a moderator may change them.
+func (h *TrackSectorHandler) Replace(c echo.Context) error {
+	trackID := c.Param("id")
+
+	if status, msg := h.checkTimingEditor(c, trackID); status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	var req models.TrackSectorsRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if len(req.Sectors) > maxTrackSectors {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Too many sectors"})
+	}
+
+	existing, err := h.trackRepo.GetSectors(trackID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	known := make(map[string]bool, len(existing))
+	for _, s := range existing {
+		known[s.ID] = true
+	}
+
+	seen := map[string]bool{}
+	for i := range req.Sectors {
+		s := &req.Sectors[i]
+		if s.ID != nil {
+			if !known[*s.ID] || seen[*s.ID] {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unknown or repeated sector id"})
+			}
+			seen[*s.ID] = true
+		}
+		s.Name = strings.TrimSpace(s.Name)
+		if s.ZoneID != nil {
+			zone, err := h.zoneRepo.FindZoneForTrack(*s.ZoneID, trackID)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+			}
+			if zone == nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Zone does not belong to this track"})
+			}
+			if s.Name == "" {
+				s.Name = zone.Name
+			}
+		}
+		if s.Name == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Each sector needs a name or a zone"})
+		}
+		if s.Line != nil {
+			if msg := validateTimingLine(*s.Line); msg != "" {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+			}
+		}
+	}
+
+	sectors, err := h.trackRepo.SetSectors(trackID, req.Sectors)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, sectors)
+}
+
+// PUT /api/tracks/:id/start-finish
+func (h *TrackSectorHandler) SetStartFinish(c echo.Context) error {
+	trackID := c.Param("id")
+
+	if status, msg := h.checkTimingEditor(c, trackID); status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	var line models.TimingLine
+	if err := c.Bind(&line); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if msg := validateTimingLine(line); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
+	track, err := h.trackRepo.SetStartFinishLine(trackID, &line)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, track)
+}
+
+// DELETE /api/tracks/:id/start-finish
+func (h *TrackSectorHandler) ClearStartFinish(c echo.Context) error {
+	trackID := c.Param("id")
+
+	if status, msg := h.checkTimingEditor(c, trackID); status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	track, err := h.trackRepo.SetStartFinishLine(trackID, nil)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, track)
+}
+
+// checkTimingEditor checks that the track exists and that the current user
+// may change its sectors and timing lines. It returns a non-zero status and
+// message to reply with otherwise.
+func (h *TrackSectorHandler) checkTimingEditor(c echo.Context, trackID string) (int, string) {
+	track, err := h.trackRepo.FindByID(trackID)
+	if err != nil {
+		return http.StatusInternalServerError, "Internal server error"
+	}
+	if track == nil || !canView(c, track.Status, track.UploadedByID) {
+		return http.StatusNotFound, "Track not found"
+	}
+	if track.UploadedByID != middleware.GetUserID(c) && !canModerate(c) {
+		return http.StatusForbidden, "Only the track's uploader or a moderator can define sectors"
+	}
+	return 0, ""
+}
+
+// validateTimingLine returns why a timing line is invalid, or "".
+func validateTimingLine(l models.TimingLine) string {
+	for _, lat := range []float64{l.Lat1, l.Lat2} {
+		if lat < -90 || lat > 90 {
+			return "Latitude must be between -90 and 90"
+		}
+	}
+	for _, lng := range []float64{l.Lng1, l.Lng2} {
+		if lng < -180 || lng > 180 {
+			return "Longitude must be between -180 and 180"
+		}
+	}
+	metres := geo.DistanceKm(l.Lat1, l.Lng1, l.Lat2, l.Lng2) * 1000
+	if metres < minTimingLineMetres || metres > maxTimingLineMetres {
+		return "A timing line must be between 1 and 500 metres long"
+	}
+	return ""
+}
@@ -24,7 +24,7 @@ func (h *TrackZoneHandler) Create(c echo.Context) error {
 	_ = userID
 	trackID := c.Param("id")
 
-	exists, err := h.trackRepo.Exists(trackID)
+	exists, err := trackVisible(c, h.trackRepo, trackID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
 	}
@@ -56,7 +56,7 @@ func (h *TrackZoneHandler) Update(c echo.Context) error {
 	trackID := c.Param("id")
 	zoneID := c.Param("zoneId")
 
-	exists, err := h.trackRepo.Exists(trackID)
+	exists, err := trackVisible(c, h.trackRepo, trackID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
 	}
@@ -89,7 +89,7 @@ func (h *TrackZoneHandler) Delete(c echo.Context) error {
 	trackID := c.Param("id")
 	zoneID := c.Param("zoneId")
 
-	exists, err := h.trackRepo.Exists(trackID)
+	exists, err := trackVisible(c, h.trackRepo, trackID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
 	}
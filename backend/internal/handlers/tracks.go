@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/joezmuda/trackside-backend/internal/geo"
 	"github.com/joezmuda/trackside-backend/internal/middleware"
 	"github.com/joezmuda/trackside-backend/internal/models"
 	"github.com/joezmuda/trackside-backend/internal/repository"
@@ -17,13 +21,62 @@ func NewTrackHandler(trackRepo *repository.TrackRepo) *TrackHandler {
 	return &TrackHandler{trackRepo: trackRepo}
 }
 
+const (
+	defaultTrackPageSize = 50
+	maxTrackPageSize     = 100
+	maxTrackRadiusKm     = 5000
+	defaultSuggestions   = 8
+	maxSuggestions       = 20
+)
+
 // GET /api/tracks
 func (h *TrackHandler) List(c echo.Context) error {
-	search := c.QueryParam("search")
-	eventType := c.QueryParam("eventType")
-	state := c.QueryParam("state")
+	params := models.TrackListParams{
+		Search:    c.QueryParam("search"),
+		EventType: c.QueryParam("eventType"),
+		State:     c.QueryParam("state"),
+		Country:   c.QueryParam("country"),
+		Sort:      c.QueryParam("sort"),
+		Limit:     defaultTrackPageSize,
+		Cursor:    c.QueryParam("cursor"),
+	}
+
+	if msg := parseGeoParams(c, &params); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
 
-	tracks, err := h.trackRepo.List(search, eventType, state)
+	params.Search = strings.TrimSpace(params.Search)
+	if params.Sort == "" {
+		switch {
+		case params.Search != "":
+			params.Sort = models.TrackSortRelevance
+		case params.Near != nil:
+			params.Sort = models.TrackSortDistance
+		default:
+			params.Sort = models.TrackSortNewest
+		}
+	}
+	if !models.ValidTrackSort(params.Sort) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid sort. Use name, rating, reviews, laps, distance, relevance or newest"})
+	}
+	if params.Sort == models.TrackSortDistance && params.Near == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Sorting by distance requires lat and lng"})
+	}
+	if params.Sort == models.TrackSortRelevance && params.Search == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Sorting by relevance requires a search"})
+	}
+	if v := c.QueryParam("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxTrackPageSize {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Limit must be between 1 and 100"})
+		}
+		params.Limit = limit
+	}
+
+	tracks, err := h.trackRepo.List(params)
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid cursor"})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 			"error":   "Failed to fetch tracks",
@@ -33,6 +86,71 @@ func (h *TrackHandler) List(c echo.Context) error {
 	return c.JSON(http.StatusOK, tracks)
 }
 
+// GET /api/tracks/autocomplete?q=
+func (h *TrackHandler) Autocomplete(c echo.Context) error {
+	limit := defaultSuggestions
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSuggestions {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Limit must be between 1 and 20"})
+		}
+		limit = n
+	}
+
+	suggestions, err := h.trackRepo.Suggest(c.QueryParam("q"), limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to search tracks"})
+	}
+	return c.JSON(http.StatusOK, suggestions)
+}
+
+// parseGeoParams reads lat/lng/radius and bbox into params. It returns a
+// user-facing error message, or "" if the parameters are valid or absent.
+func parseGeoParams(c echo.Context, params *models.TrackListParams) string {
+	latStr, lngStr, radiusStr := c.QueryParam("lat"), c.QueryParam("lng"), c.QueryParam("radius")
+	if latStr != "" || lngStr != "" {
+		lat, latErr := strconv.ParseFloat(latStr, 64)
+		lng, lngErr := strconv.ParseFloat(lngStr, 64)
+		if latErr != nil || lngErr != nil || !geo.ValidLatitude(lat) || !geo.ValidLongitude(lng) {
+			return "lat and lng must both be given, with lat between -90 and 90 and lng between -180 and 180"
+		}
+		params.Near = &models.GeoPoint{Lat: lat, Lng: lng}
+	}
+
+	if radiusStr != "" {
+		if params.Near == nil {
+			return "radius requires lat and lng"
+		}
+		radius, err := strconv.ParseFloat(radiusStr, 64)
+		if err != nil || radius <= 0 || radius > maxTrackRadiusKm {
+			return "radius must be between 0 and 5000 km"
+		}
+		params.RadiusKm = radius
+	}
+
+	if v := c.QueryParam("bbox"); v != "" {
+		parts := strings.Split(v, ",")
+		if len(parts) != 4 {
+			return "bbox must be minLng,minLat,maxLng,maxLat"
+		}
+		var n [4]float64
+		for i, p := range parts {
+			f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+			if err != nil {
+				return "bbox must be minLng,minLat,maxLng,maxLat"
+			}
+			n[i] = f
+		}
+		box := &models.BoundingBox{MinLng: n[0], MinLat: n[1], MaxLng: n[2], MaxLat: n[3]}
+		if !geo.ValidLongitude(box.MinLng) || !geo.ValidLongitude(box.MaxLng) ||
+			!geo.ValidLatitude(box.MinLat) || !geo.ValidLatitude(box.MaxLat) || box.MinLat > box.MaxLat {
+			return "bbox is out of range"
+		}
+		params.BBox = box
+	}
+	return ""
+}
+
 // POST /api/tracks
 func (h *TrackHandler) Create(c echo.Context) error {
 	userID := middleware.GetUserID(c)
@@ -57,7 +175,13 @@ func (h *TrackHandler) Create(c echo.Context) error {
 		}
 	}
 
-	track, err := h.trackRepo.Create(req, userID)
+	// Moderators' own submissions skip the review queue
+	status := string(models.TrackStatusPending)
+	if canModerate(c) {
+		status = string(models.TrackStatusApproved)
+	}
+
+	track, err := h.trackRepo.Create(req, userID, status)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
 	}
@@ -73,7 +197,7 @@ func (h *TrackHandler) GetByID(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
 	}
-	if detail == nil {
+	if detail == nil || !canView(c, detail.Status, detail.UploadedByID) {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "Track not found"})
 	}
 	return c.JSON(http.StatusOK, detail)
@@ -100,9 +224,94 @@ func (h *TrackHandler) Update(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 	}
 
-	updated, err := h.trackRepo.Update(id, req)
+	// Edits go back to the review queue: a rejected track is resubmitted,
+	// and an approved one is hidden until a moderator approves the edit
+	var reason string
+	switch {
+	case track.Status == string(models.TrackStatusRejected):
+		reason = "Resubmitted after edits"
+	case track.Status == string(models.TrackStatusApproved) && !canModerate(c):
+		reason = "Edited after approval"
+	}
+	var updated *models.Track
+	if reason != "" {
+		updated, err = h.trackRepo.UpdateForReview(id, req, reason, userID)
+	} else {
+		updated, err = h.trackRepo.Update(id, req)
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
 	}
 	return c.JSON(http.StatusOK, updated)
 }
+
+// GET /api/tracks/mine
+func (h *TrackHandler) Mine(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+
+	tracks, err := h.trackRepo.ListByUploader(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch tracks"})
+	}
+	return c.JSON(http.StatusOK, tracks)
+}
+
+// GET /api/tracks/:id/status-history
+func (h *TrackHandler) StatusHistory(c echo.Context) error {
+	id := c.Param("id")
+
+	track, err := h.trackRepo.FindByID(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if track == nil || !canView(c, track.Status, track.UploadedByID) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Track not found"})
+	}
+	if track.UploadedByID != middleware.GetUserID(c) && !canModerate(c) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Forbidden"})
+	}
+
+	history, err := h.trackRepo.GetStatusHistory(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, history)
+}
+
+// canModerate reports whether the authenticated user may see and act on
+// tracks that are not yet approved. Moderators who need two-factor
+// authentication can't until they turn it on.
+func canModerate(c echo.Context) bool {
+	return middleware.HasRolePowers(c, string(models.RoleModerator))
+}
+
+// canView reports whether the current user may see a track with the given
+// status and uploader. Unapproved tracks are hidden from everyone else.
+func canView(c echo.Context, status, uploadedByID string) bool {
+	if status == string(models.TrackStatusApproved) {
+		return true
+	}
+	userID := middleware.GetUserID(c)
+	return (userID != "" && userID == uploadedByID) || canModerate(c)
+}
+
+// trackVisible checks that a track exists and is visible to the current user,
+// for endpoints that attach data (reviews, zones, images, laps) to a track.
+func trackVisible(c echo.Context, trackRepo *repository.TrackRepo, trackID string) (bool, error) {
+	return trackRepo.IsVisible(trackID, middleware.GetUserID(c), canModerate(c))
+}
+
+// trackEvent returns the track's event with the given id, or nil if the
+// track has no such event.
+func trackEvent(trackRepo *repository.TrackRepo, trackID, eventID string) (*models.TrackEvent, error) {
+	events, err := trackRepo.GetEvents(trackID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range events {
+		if events[i].ID == eventID {
+			return &events[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/middleware"
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/joezmuda/trackside-backend/internal/repository"
+	"github.com/joezmuda/trackside-backend/internal/totp"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	totpIssuer = "Trackside"
+	// After maxCodeAttempts wrong codes in a row, codes are refused for
+	// codeLockout, so a six-digit code can't be guessed.
+	maxCodeAttempts = 5
+	codeLockout     = 15 * time.Minute
+)
+
+// TwoFactorHandler sets up TOTP two-factor authentication and finishes
+// logins that need it. Users holding requiredRole or a higher one can't
+// turn it off.
+type TwoFactorHandler struct {
+	auth         *AuthHandler
+	userRepo     *repository.UserRepo
+	twoFactor    *repository.TwoFactorRepo
+	requiredRole string
+}
+
+func NewTwoFactorHandler(auth *AuthHandler, userRepo *repository.UserRepo, twoFactor *repository.TwoFactorRepo, requiredRole string) *TwoFactorHandler {
+	return &TwoFactorHandler{auth: auth, userRepo: userRepo, twoFactor: twoFactor, requiredRole: requiredRole}
+}
+
+// GET /api/auth/2fa
+func (h *TwoFactorHandler) Status(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	user, err := h.userRepo.FindByID(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if user == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+	enabled, err := h.twoFactor.IsEnabled(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	left, err := h.twoFactor.RecoveryCodesLeft(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, models.TwoFactorStatus{
+		Enabled:           enabled,
+		Required:          h.required(user),
+		RecoveryCodesLeft: left,
+	})
+}
+
+// POST /api/auth/2fa/setup
+//
+// Creates a secret for the user's authenticator app. Two-factor
+// authentication stays off until a code from the app is confirmed.
+func (h *TwoFactorHandler) Setup(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	user, err := h.userRepo.FindByID(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if user == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+	enabled, err := h.twoFactor.IsEnabled(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if enabled {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Two-factor authentication is already on"})
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if err := h.twoFactor.Start(userID, secret); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, models.TwoFactorSetupResponse{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+// POST /api/auth/2fa/enable
+//
+// Turns two-factor authentication on with a code from the app, and returns
+// the recovery codes. Other sessions, which didn't need a code, are logged
+// out.
+func (h *TwoFactorHandler) Enable(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	var req models.TwoFactorCodeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	tf, err := h.twoFactor.Get(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if tf == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Set up two-factor authentication first"})
+	}
+	if tf.EnabledAt != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Two-factor authentication is already on"})
+	}
+	// There are no recovery codes yet
+	if status, msg := h.checkCode(userID, tf, req.Code, "", http.StatusBadRequest); status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	codes, err := h.twoFactor.Enable(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if _, err := h.auth.sessionRepo.RevokeOthers(userID, middleware.GetSessionID(c)); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// POST /api/auth/2fa/disable
+func (h *TwoFactorHandler) Disable(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	var req models.TwoFactorCodeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	user, err := h.userRepo.FindByID(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if user == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+	if h.required(user) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Your role requires two-factor authentication"})
+	}
+	tf, status, msg := h.enabled(userID)
+	if status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+	if status, msg := h.checkCode(userID, tf, req.Code, req.RecoveryCode, http.StatusBadRequest); status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	if err := h.twoFactor.Disable(userID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Two-factor authentication turned off"})
+}
+
+// POST /api/auth/2fa/recovery-codes
+//
+// Replaces the user's recovery codes with new ones.
+func (h *TwoFactorHandler) RecoveryCodes(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	var req models.TwoFactorCodeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	tf, status, msg := h.enabled(userID)
+	if status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+	if status, msg := h.checkCode(userID, tf, req.Code, req.RecoveryCode, http.StatusBadRequest); status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+
+	codes, err := h.twoFactor.ReplaceRecoveryCodes(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	return c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// POST /api/auth/2fa/verify
+//
+// Finishes a login with the challenge token from POST /api/auth/login and a
+// code from the app or a recovery code, and replies with the tokens.
+func (h *TwoFactorHandler) Verify(c echo.Context) error {
+	var req models.TwoFactorVerifyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	userID, ok := h.auth.challengeUserID(req.ChallengeToken)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Login expired, please log in again"})
+	}
+	user, err := h.userRepo.FindByID(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	tf, err := h.twoFactor.Get(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if user == nil || tf == nil || tf.EnabledAt == nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Login expired, please log in again"})
+	}
+	if status, msg := h.checkCode(userID, tf, req.Code, req.RecoveryCode, http.StatusUnauthorized); status != 0 {
+		return c.JSON(status, map[string]string{"error": msg})
+	}
+	return h.auth.openSession(c, user)
+}
+
+// enabled returns the user's TOTP setup if two-factor authentication is on.
+func (h *TwoFactorHandler) enabled(userID string) (*models.TwoFactor, int, string) {
+	tf, err := h.twoFactor.Get(userID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Internal server error"
+	}
+	if tf == nil || tf.EnabledAt == nil {
+		return nil, http.StatusBadRequest, "Two-factor authentication is off"
+	}
+	return tf, 0, ""
+}
+
+// checkCode accepts a code from the user's authenticator app, or one of
+// their recovery codes. A code from the app works once. Wrong codes count
+// towards a lockout and get failStatus.
+func (h *TwoFactorHandler) checkCode(userID string, tf *models.TwoFactor, code, recoveryCode string, failStatus int) (int, string) {
+	if tf.LockedUntil != nil && tf.LockedUntil.After(time.Now()) {
+		return http.StatusTooManyRequests, "Too many wrong codes, try again later"
+	}
+	if code == "" && recoveryCode == "" {
+		return http.StatusBadRequest, "Code is required"
+	}
+
+	var ok bool
+	var err error
+	if recoveryCode != "" {
+		ok, err = h.twoFactor.UseRecoveryCode(userID, recoveryCode)
+	} else if step, valid := totp.Validate(tf.Secret, code, time.Now()); valid {
+		ok, err = h.twoFactor.UseStep(userID, step)
+	}
+	if err != nil {
+		return http.StatusInternalServerError, "Internal server error"
+	}
+	if !ok {
+		if err := h.twoFactor.RecordFailure(userID, maxCodeAttempts, codeLockout); err != nil {
+			return http.StatusInternalServerError, "Internal server error"
+		}
+		return failStatus, "Invalid code"
+	}
+	return 0, ""
+}
+
+// required reports whether the user's role means they must keep two-factor
+// authentication on.
+func (h *TwoFactorHandler) required(user *models.User) bool {
+	return h.requiredRole != "" && models.HasRole(user.Role, h.requiredRole)
+}
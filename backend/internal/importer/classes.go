@@ -0,0 +1,104 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/joezmuda/trackside-backend/internal/repository"
+)
+
+// ClassesFile is the name of the car class index table inside DATA_DIR.
+const ClassesFile = "pax-index.csv"
+
+// classColumns are the header names accepted for each column of the index
+// table. The category column is optional.
+var classColumns = map[string][]string{
+	"code":     {"code", "class"},
+	"name":     {"name", "description"},
+	"paxIndex": {"pax", "index", "paxindex", "pax index"},
+	"category": {"category", "group"},
+}
+
+// SyncClasses reads DATA_DIR/pax-index.csv and upserts every row as a car
+// class, matched by code. Rows that can't be imported are collected in the
+// response with their line number; only problems with the file itself
+// return an error.
+func SyncClasses(classRepo *repository.CarClassRepo, dataDir string) (*models.SyncClassesResponse, error) {
+	f, err := os.Open(filepath.Join(dataDir, ClassesFile))
+	if err != nil {
+		return nil, fmt.Errorf("could not read class index file: %w", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid class index file: %w", err)
+	}
+	cols := matchColumns(header, classColumns)
+	for _, col := range []string{"code", "name", "paxIndex"} {
+		if _, ok := cols[col]; !ok {
+			return nil, fmt.Errorf("class index file has no %s column", col)
+		}
+	}
+
+	resp := &models.SyncClassesResponse{Status: "success"}
+	field := func(row []string, col string) string {
+		i, ok := cols[col]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid class index file: %w", err)
+		}
+		line, _ := r.FieldPos(0)
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		resp.Summary.Total++
+
+		req := models.CarClassRequest{Code: field(row, "code"), Name: field(row, "name")}
+		if category := field(row, "category"); category != "" {
+			req.Category = &category
+		}
+		index, err := strconv.ParseFloat(field(row, "paxIndex"), 64)
+		msg := "Invalid PAX index"
+		if err == nil {
+			req.PaxIndex = index
+			msg = models.NormalizeCarClass(&req)
+		}
+		if msg == "" {
+			created, err := classRepo.Upsert(req)
+			switch {
+			case err != nil:
+				msg = err.Error()
+			case created:
+				resp.Summary.Created++
+			default:
+				resp.Summary.Updated++
+			}
+		}
+		if msg != "" {
+			resp.Summary.Failed++
+			resp.Errors = append(resp.Errors, fmt.Sprintf("line %d: %s", line, msg))
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,371 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/joezmuda/trackside-backend/internal/models"
+)
+
+// TrackFile is a tracks data file to sync: the bundled usa-tracks.json or a
+// file uploaded by an admin. Source is recorded on every track the file
+// imports, so datasets from different places can be synced side by side
+// and pruned separately. Country is used for tracks that don't give one.
+type TrackFile struct {
+	Source  string
+	Name    string
+	Data    []byte
+	Country string
+}
+
+// FileError is a problem with a tracks data file as a whole, rather than
+// with one of its rows.
+type FileError struct {
+	Err error
+}
+
+func (e *FileError) Error() string { return e.Err.Error() }
+
+func (e *FileError) Unwrap() error { return e.Err }
+
+var sourcePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,49}$`)
+
+// ValidSource reports whether s can name a track source: up to 50 lowercase
+// letters, digits, dots, dashes and underscores, like "usa-tracks.json".
+func ValidSource(s string) bool {
+	return sourcePattern.MatchString(s)
+}
+
+// BundledSource reports whether s is the source of the bundled tracks
+// file. Uploads named like it must give their own source, so that syncing
+// them can't change or prune the bundled tracks by accident.
+func BundledSource(s string) bool {
+	return s == TracksFile
+}
+
+// ValidCountry reports whether s is a two-letter upper-case country code.
+func ValidCountry(s string) bool {
+	return len(s) == 2 && s[0] >= 'A' && s[0] <= 'Z' && s[1] >= 'A' && s[1] <= 'Z'
+}
+
+// trackColumns are the CSV header names accepted for each track field. The
+// state, country, types and description columns are optional.
+var trackColumns = map[string][]string{
+	"name":        {"name", "track", "track name"},
+	"location":    {"location", "city"},
+	"state":       {"state", "region", "province", "county"},
+	"country":     {"country", "country code"},
+	"types":       {"types", "type", "events", "event types"},
+	"latitude":    {"latitude", "lat"},
+	"longitude":   {"longitude", "lng", "lon", "long"},
+	"description": {"description", "notes"},
+}
+
+// trackRow is a track as read from a data file, before validation. The
+// coordinates are pointers so a missing one can be told apart from zero.
+type trackRow struct {
+	Name        string   `json:"name"`
+	Location    string   `json:"location"`
+	State       string   `json:"state"`
+	Country     string   `json:"country"`
+	Types       []string `json:"types"`
+	Latitude    *float64 `json:"latitude"`
+	Longitude   *float64 `json:"longitude"`
+	Description string   `json:"description"`
+}
+
+// ParseTracks reads a tracks data file: JSON with a "tracks" array (or a
+// bare array), CSV with a header row, or a GeoJSON FeatureCollection of
+// points with the track fields as properties. The format comes from the
+// file extension, or the content if the extension doesn't say. Tracks that
+// fail validation are left out and reported by row; only a file that can't
+// be read at all returns an error, a *FileError.
+func ParseTracks(name string, data []byte) ([]models.ImportedTrack, []string, error) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	trimmed := bytes.TrimSpace(data)
+
+	isCSV := len(trimmed) > 0 && trimmed[0] != '{' && trimmed[0] != '['
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		isCSV = true
+	case ".json", ".geojson":
+		isCSV = false
+	}
+
+	var rows []trackRow
+	var labels, rowErrors []string
+	var err error
+	if isCSV {
+		rows, labels, rowErrors, err = readTracksCSV(data)
+	} else {
+		rows, labels, rowErrors, err = readTracksJSON(trimmed)
+	}
+	if err != nil {
+		return nil, nil, &FileError{Err: err}
+	}
+
+	tracks := make([]models.ImportedTrack, 0, len(rows))
+	seen := make(map[string]string, len(rows))
+	for i, row := range rows {
+		track, msg := validateTrackRow(row)
+		if msg == "" {
+			key := trackKey(track.Name, track.Location)
+			if first, ok := seen[key]; ok {
+				msg = "duplicate of " + first
+			}
+			seen[key] = labels[i]
+		}
+		if msg != "" {
+			rowErrors = append(rowErrors, labels[i]+": "+msg)
+			continue
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, rowErrors, nil
+}
+
+// readTracksJSON reads a JSON or GeoJSON tracks file. Rows whose fields have
+// the wrong type are reported as row errors.
+func readTracksJSON(data []byte) ([]trackRow, []string, []string, error) {
+	var items []json.RawMessage
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid JSON in tracks data file: %w", err)
+		}
+		return decodeTrackRows(items, "track", decodeTrack)
+	}
+
+	var file struct {
+		Type     string            `json:"type"`
+		Tracks   []json.RawMessage `json:"tracks"`
+		Features []json.RawMessage `json:"features"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid JSON in tracks data file: %w", err)
+	}
+	switch {
+	case file.Type == "FeatureCollection":
+		return decodeTrackRows(file.Features, "feature", decodeFeature)
+	case file.Type != "":
+		return nil, nil, nil, fmt.Errorf("GeoJSON tracks data file must be a FeatureCollection, not %s", file.Type)
+	case file.Tracks == nil:
+		return nil, nil, nil, errors.New(`tracks data file has no "tracks" array`)
+	}
+	return decodeTrackRows(file.Tracks, "track", decodeTrack)
+}
+
+func decodeTrackRows(items []json.RawMessage, noun string, decode func(json.RawMessage) (trackRow, error)) ([]trackRow, []string, []string, error) {
+	rows := make([]trackRow, 0, len(items))
+	labels := make([]string, 0, len(items))
+	var rowErrors []string
+	for i, item := range items {
+		label := fmt.Sprintf("%s %d", noun, i+1)
+		row, err := decode(item)
+		if err != nil {
+			rowErrors = append(rowErrors, label+": "+err.Error())
+			continue
+		}
+		rows = append(rows, row)
+		labels = append(labels, label)
+	}
+	return rows, labels, rowErrors, nil
+}
+
+func decodeTrack(item json.RawMessage) (trackRow, error) {
+	var row trackRow
+	return row, jsonFieldError(json.Unmarshal(item, &row))
+}
+
+func decodeFeature(item json.RawMessage) (trackRow, error) {
+	var feature struct {
+		Type     string `json:"type"`
+		Geometry *struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+		Properties trackRow `json:"properties"`
+	}
+	if err := jsonFieldError(json.Unmarshal(item, &feature)); err != nil {
+		return trackRow{}, err
+	}
+	if feature.Type != "Feature" {
+		return trackRow{}, errors.New("not a Feature")
+	}
+	if feature.Geometry == nil || feature.Geometry.Type != "Point" {
+		return trackRow{}, errors.New("geometry must be a Point")
+	}
+	var coords []float64
+	if err := json.Unmarshal(feature.Geometry.Coordinates, &coords); err != nil || len(coords) < 2 {
+		return trackRow{}, errors.New("coordinates must be a longitude and latitude")
+	}
+	// GeoJSON coordinates are longitude first
+	row := feature.Properties
+	row.Longitude, row.Latitude = &coords[0], &coords[1]
+	return row, nil
+}
+
+// jsonFieldError names the field behind a JSON type error.
+func jsonFieldError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		return err
+	}
+	if typeErr.Field == "" {
+		return errors.New("must be an object")
+	}
+	field := typeErr.Field[strings.LastIndex(typeErr.Field, ".")+1:]
+	return fmt.Errorf("%s must be a %s", field, jsonTypeName(typeErr.Type.Kind().String()))
+}
+
+func jsonTypeName(kind string) string {
+	switch kind {
+	case "float64", "ptr":
+		return "number"
+	case "slice":
+		return "list"
+	}
+	return kind
+}
+
+// matchColumns finds the index of each column in a CSV header row, given
+// the header names accepted for it. Names are matched without regard to
+// case, spaces around them or a leading byte order mark, and the first
+// match wins.
+func matchColumns(header []string, columns map[string][]string) map[string]int {
+	cols := make(map[string]int, len(columns))
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		for col, names := range columns {
+			if _, ok := cols[col]; !ok && slices.Contains(names, h) {
+				cols[col] = i
+			}
+		}
+	}
+	return cols
+}
+
+// readTracksCSV reads a CSV tracks file with a header row. Event types are
+// separated by semicolons or pipes.
+func readTracksCSV(data []byte) ([]trackRow, []string, []string, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid CSV tracks data file: %w", err)
+	}
+	cols := matchColumns(header, trackColumns)
+	for _, col := range []string{"name", "location", "latitude", "longitude"} {
+		if _, ok := cols[col]; !ok {
+			return nil, nil, nil, fmt.Errorf("tracks data file has no %s column", col)
+		}
+	}
+
+	field := func(row []string, col string) string {
+		i, ok := cols[col]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	var rows []trackRow
+	var labels, rowErrors []string
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid CSV tracks data file: %w", err)
+		}
+		line, _ := r.FieldPos(0)
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		label := fmt.Sprintf("line %d", line)
+
+		row := trackRow{
+			Name:        field(record, "name"),
+			Location:    field(record, "location"),
+			State:       field(record, "state"),
+			Country:     field(record, "country"),
+			Description: field(record, "description"),
+			Types: strings.FieldsFunc(field(record, "types"), func(r rune) bool {
+				return r == ';' || r == '|'
+			}),
+		}
+		msg := ""
+		for _, c := range []struct {
+			col string
+			dst **float64
+		}{{"latitude", &row.Latitude}, {"longitude", &row.Longitude}} {
+			v := field(record, c.col)
+			if v == "" {
+				continue
+			}
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				msg = c.col + " must be a number"
+				break
+			}
+			*c.dst = &f
+		}
+		if msg != "" {
+			rowErrors = append(rowErrors, label+": "+msg)
+			continue
+		}
+		rows = append(rows, row)
+		labels = append(labels, label)
+	}
+	return rows, labels, rowErrors, nil
+}
+
+// validateTrackRow checks a track from a data file and tidies it up for
+// import. It returns a message saying what is wrong if it can't be imported.
+func validateTrackRow(row trackRow) (models.ImportedTrack, string) {
+	track := models.ImportedTrack{
+		Name:        strings.TrimSpace(row.Name),
+		Location:    strings.TrimSpace(row.Location),
+		State:       strings.TrimSpace(row.State),
+		Country:     strings.ToUpper(strings.TrimSpace(row.Country)),
+		Description: strings.TrimSpace(row.Description),
+	}
+	switch {
+	case track.Name == "":
+		return track, "name is required"
+	case track.Location == "":
+		return track, "location is required"
+	case row.Latitude == nil:
+		return track, "latitude is required"
+	case row.Longitude == nil:
+		return track, "longitude is required"
+	case *row.Latitude < -90 || *row.Latitude > 90:
+		return track, "latitude must be between -90 and 90"
+	case *row.Longitude < -180 || *row.Longitude > 180:
+		return track, "longitude must be between -180 and 180"
+	case track.Country != "" && !ValidCountry(track.Country):
+		return track, "country must be a two-letter code"
+	case len(row.Types) == 0:
+		return track, "at least one event type is required"
+	}
+	track.Latitude, track.Longitude = *row.Latitude, *row.Longitude
+
+	for _, t := range row.Types {
+		t = strings.ToUpper(strings.TrimSpace(t))
+		if !models.ValidEventType(t) {
+			return track, fmt.Sprintf("unknown event type %q", t)
+		}
+		track.Types = append(track.Types, t)
+	}
+	return track, ""
+}
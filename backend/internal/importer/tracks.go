@@ -0,0 +1,228 @@
+package importer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/joezmuda/trackside-backend/internal/repository"
+)
+
+// TracksFile is the name of the bundled track list inside DATA_DIR.
+const TracksFile = "usa-tracks.json"
+
+// SyncTracks syncs the bundled DATA_DIR/usa-tracks.json; see SyncTrackFile.
+func SyncTracks(trackRepo *repository.TrackRepo, userRepo *repository.UserRepo, dataDir string, opts models.SyncOptions) (*models.SyncTracksResponse, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, TracksFile))
+	if err != nil {
+		return nil, fmt.Errorf("could not read tracks data file: %w", err)
+	}
+	file := TrackFile{Source: TracksFile, Name: TracksFile, Data: data, Country: "US"}
+	return SyncTrackFile(trackRepo, userRepo, file, opts)
+}
+
+// SyncTrackFile upserts every track in a data file as an imported track
+// owned by the system user, restoring tracks archived by an earlier sync.
+// Tracks imported from another source are left alone. With opts.Prune,
+// tracks imported from the same source that are no longer in the file are
+// archived; tracks submitted by users are never archived, and nothing is
+// archived if some rows of the file couldn't be read. A dry run reports the
+// same changes without saving anything. Invalid rows and per-track failures
+// are collected in the response; only problems with the file itself return
+// an error.
+func SyncTrackFile(trackRepo *repository.TrackRepo, userRepo *repository.UserRepo, file TrackFile, opts models.SyncOptions) (*models.SyncTracksResponse, error) {
+	tracks, rowErrors, err := ParseTracks(file.Name, file.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	// A dry run must not create the system user; without one there is
+	// nothing imported to prune
+	systemUser, err := userRepo.FindSystem()
+	if err == nil && systemUser == nil && !opts.DryRun {
+		systemUser, err = userRepo.FindOrCreateSystem()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &models.SyncTracksResponse{
+		Status:  "success",
+		DryRun:  opts.DryRun,
+		Changes: []models.TrackSyncChange{},
+		Errors:  rowErrors,
+	}
+	resp.Summary.Total = len(tracks) + len(rowErrors)
+	resp.Summary.Failed = len(rowErrors)
+	inFile := make(map[string]bool, len(tracks))
+
+	for _, trackData := range tracks {
+		if trackData.Country == "" {
+			trackData.Country = file.Country
+		}
+		inFile[trackKey(trackData.Name, trackData.Location)] = true
+		existing, err := trackRepo.FindByNameAndLocation(trackData.Name, trackData.Location)
+		if err != nil {
+			resp.Summary.Failed++
+			resp.Errors = append(resp.Errors, trackData.Name+": "+err.Error())
+			continue
+		}
+		if existing != nil && existing.Source != nil && *existing.Source != file.Source {
+			resp.Summary.Skipped++
+			resp.Errors = append(resp.Errors, trackData.Name+": already imported from "+*existing.Source)
+			continue
+		}
+
+		change := trackChange(existing, trackData, file.Source)
+		if !opts.DryRun {
+			err = trackRepo.UpsertImported(trackData, file.Source, systemUser.ID)
+			if err == nil && existing != nil && existing.Status == string(models.TrackStatusArchived) {
+				reason := "Back in " + file.Name
+				_, err = trackRepo.SetStatus(existing.ID, string(models.TrackStatusApproved), &reason, systemUser.ID)
+			}
+			if err != nil {
+				resp.Summary.Failed++
+				resp.Errors = append(resp.Errors, trackData.Name+": "+err.Error())
+				continue
+			}
+		}
+
+		switch {
+		case existing == nil:
+			resp.Summary.Created++
+		case len(change.Changes) == 0:
+			resp.Summary.Updated++
+			resp.Summary.Unchanged++
+			continue
+		default:
+			resp.Summary.Updated++
+		}
+		resp.Changes = append(resp.Changes, change)
+	}
+
+	if opts.Prune && len(rowErrors) > 0 {
+		resp.Errors = append(resp.Errors, fmt.Sprintf("not pruning: %d rows could not be read", len(rowErrors)))
+	} else if opts.Prune && systemUser != nil {
+		imported, err := trackRepo.ListImported(systemUser.ID, file.Source)
+		if err != nil {
+			return nil, err
+		}
+		reason := "No longer in " + file.Name
+		for _, t := range imported {
+			if inFile[trackKey(t.Name, t.Location)] {
+				continue
+			}
+			if !opts.DryRun {
+				if _, err := trackRepo.SetStatus(t.ID, string(models.TrackStatusArchived), &reason, systemUser.ID); err != nil {
+					resp.Errors = append(resp.Errors, t.Name+": "+err.Error())
+					continue
+				}
+			}
+			id := t.ID
+			resp.Summary.Archived++
+			resp.Changes = append(resp.Changes, models.TrackSyncChange{
+				TrackID:  &id,
+				Name:     t.Name,
+				Location: t.Location,
+				Action:   models.SyncArchive,
+				Changes: map[string]models.FieldChange{
+					"status": {From: string(models.TrackStatusApproved), To: string(models.TrackStatusArchived)},
+				},
+			})
+		}
+	}
+	return resp, nil
+}
+
+// TrackSyncRun describes a finished sync of the given source for the sync
+// history, from what SyncTracks or SyncTrackFile returned.
+func TrackSyncRun(source string, opts models.SyncOptions, startedAt time.Time, resp *models.SyncTracksResponse, err error) *models.SyncRun {
+	run := &models.SyncRun{
+		Source:     source,
+		DryRun:     opts.DryRun,
+		Prune:      opts.Prune,
+		Status:     "success",
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+	}
+	if err != nil {
+		msg := err.Error()
+		run.Status, run.Error = "failed", &msg
+		return run
+	}
+	run.Summary = &resp.Summary
+	return run
+}
+
+func trackKey(name, location string) string {
+	return name + "\x00" + location
+}
+
+// trackChange works out what syncing an entry of a data file from source
+// does to the matching track, or to a new track if existing is nil. It
+// covers the fields UpsertImported writes.
+func trackChange(existing *models.Track, data models.ImportedTrack, source string) models.TrackSyncChange {
+	change := models.TrackSyncChange{
+		Name:     data.Name,
+		Location: data.Location,
+		Changes:  map[string]models.FieldChange{},
+	}
+	if existing == nil {
+		change.Action = models.SyncCreate
+		change.Changes["description"] = models.FieldChange{To: data.Description}
+		change.Changes["state"] = models.FieldChange{To: optionalString(data.State)}
+		change.Changes["country"] = models.FieldChange{To: optionalString(data.Country)}
+		change.Changes["source"] = models.FieldChange{To: source}
+		change.Changes["latitude"] = models.FieldChange{To: data.Latitude}
+		change.Changes["longitude"] = models.FieldChange{To: data.Longitude}
+		change.Changes["eventTypes"] = models.FieldChange{To: data.Types}
+		return change
+	}
+
+	id := existing.ID
+	change.TrackID = &id
+	change.Action = models.SyncUpdate
+	if from := derefString(existing.Description); from != data.Description {
+		change.Changes["description"] = models.FieldChange{From: existing.Description, To: data.Description}
+	}
+	if from := derefString(existing.State); from != data.State {
+		change.Changes["state"] = models.FieldChange{From: existing.State, To: optionalString(data.State)}
+	}
+	if from := derefString(existing.Country); from != data.Country {
+		change.Changes["country"] = models.FieldChange{From: existing.Country, To: optionalString(data.Country)}
+	}
+	if from := derefString(existing.Source); from != source {
+		change.Changes["source"] = models.FieldChange{From: existing.Source, To: source}
+	}
+	if existing.Latitude == nil || *existing.Latitude != data.Latitude {
+		change.Changes["latitude"] = models.FieldChange{From: existing.Latitude, To: data.Latitude}
+	}
+	if existing.Longitude == nil || *existing.Longitude != data.Longitude {
+		change.Changes["longitude"] = models.FieldChange{From: existing.Longitude, To: data.Longitude}
+	}
+	if !existing.IsImported {
+		change.Changes["isImported"] = models.FieldChange{From: false, To: true}
+	}
+	if existing.Status == string(models.TrackStatusArchived) {
+		change.Changes["status"] = models.FieldChange{From: existing.Status, To: string(models.TrackStatusApproved)}
+	}
+	return change
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// optionalString reports an empty state or country as null, the way
+// UpsertImported stores it.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
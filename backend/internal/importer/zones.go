@@ -0,0 +1,81 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/joezmuda/trackside-backend/internal/repository"
+)
+
+// ZonesFile is the name of the bundled zone list inside DATA_DIR.
+const ZonesFile = "track-zones.json"
+
+// SyncZones reads DATA_DIR/track-zones.json and upserts the zones of every
+// track it can match by name and location as imported zones. Zones that
+// share a name with a zone added by a user are skipped rather than
+// duplicated. Tracks that aren't in the database are listed as unmatched,
+// so run SyncTracks first.
+func SyncZones(trackRepo *repository.TrackRepo, zoneRepo *repository.ZoneRepo, dataDir string) (*models.SyncZonesResponse, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, ZonesFile))
+	if err != nil {
+		return nil, fmt.Errorf("could not read zones data file: %w", err)
+	}
+
+	var file struct {
+		Zones []models.ImportedZones `json:"zones"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid JSON in zones data file: %w", err)
+	}
+
+	resp := &models.SyncZonesResponse{Status: "success"}
+	resp.Summary.Tracks = len(file.Zones)
+
+	for _, entry := range file.Zones {
+		label := entry.TrackName + " (" + entry.TrackLocation + ")"
+		track, err := trackRepo.FindByNameAndLocation(entry.TrackName, entry.TrackLocation)
+		if err != nil {
+			resp.Summary.Failed += len(entry.Zones)
+			resp.Errors = append(resp.Errors, label+": "+err.Error())
+			continue
+		}
+		if track == nil {
+			resp.Summary.Unmatched++
+			resp.Unmatched = append(resp.Unmatched, label)
+			continue
+		}
+
+		for _, zone := range entry.Zones {
+			resp.Summary.Total++
+			zone.Name = strings.TrimSpace(zone.Name)
+			if zone.Name == "" || zone.PosX < 0 || zone.PosX > 100 || zone.PosY < 0 || zone.PosY > 100 {
+				resp.Summary.Failed++
+				resp.Errors = append(resp.Errors, fmt.Sprintf("%s: zone %q needs a name and a position between 0 and 100", label, zone.Name))
+				continue
+			}
+
+			existing, err := zoneRepo.FindByTrackAndName(track.ID, zone.Name)
+			if err == nil && existing != nil && !existing.IsImported {
+				resp.Summary.Skipped++
+				continue
+			}
+			if err == nil {
+				err = zoneRepo.UpsertImported(track.ID, zone)
+			}
+			switch {
+			case err != nil:
+				resp.Summary.Failed++
+				resp.Errors = append(resp.Errors, label+": "+zone.Name+": "+err.Error())
+			case existing != nil:
+				resp.Summary.Updated++
+			default:
+				resp.Summary.Created++
+			}
+		}
+	}
+	return resp, nil
+}
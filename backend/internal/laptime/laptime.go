@@ -0,0 +1,106 @@
+// Package laptime parses and formats lap times, which are stored as whole
+// milliseconds.
+package laptime
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Max is the longest lap time accepted.
+const Max = 24*60*60*1000 - 1
+
+var (
+	ErrEmpty    = errors.New("lap time is empty")
+	ErrFormat   = errors.New("use the format 1:23.456, 83.456 or 1:02:03.456")
+	ErrDecimals = errors.New("at most 3 decimal places are allowed")
+	ErrRange    = errors.New("minutes and seconds after the first field must be 00 to 59")
+	ErrZero     = errors.New("must be greater than zero")
+	ErrTooLong  = errors.New("must be under 24 hours")
+)
+
+// Parse reads a lap time written as ss.fff, m:ss.fff or h:mm:ss.fff and
+// returns it in milliseconds. The fraction is optional and may have one to
+// three digits. Every field after the first must be two digits below 60.
+func Parse(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, ErrEmpty
+	}
+
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	var ms int64
+	if hasFrac {
+		if frac == "" || !digits(frac) {
+			return 0, ErrFormat
+		}
+		if len(frac) > 3 {
+			return 0, ErrDecimals
+		}
+		f, _ := strconv.ParseInt(frac+strings.Repeat("0", 3-len(frac)), 10, 64)
+		ms = f
+	}
+
+	fields := strings.Split(whole, ":")
+	if len(fields) > 3 {
+		return 0, ErrFormat
+	}
+
+	var total int64
+	for i, field := range fields {
+		if field == "" || !digits(field) || len(field) > 6 {
+			return 0, ErrFormat
+		}
+		n, _ := strconv.ParseInt(field, 10, 64)
+		if i > 0 {
+			if len(field) != 2 {
+				return 0, ErrFormat
+			}
+			if n > 59 {
+				return 0, ErrRange
+			}
+		}
+		total = total*60 + n
+	}
+
+	ms += total * 1000
+	if ms == 0 {
+		return 0, ErrZero
+	}
+	if ms > Max {
+		return 0, ErrTooLong
+	}
+	return ms, nil
+}
+
+// Format writes ms in canonical form: 12.345 under a minute, 1:23.456 under
+// an hour and 1:02:03.456 otherwise, always with three decimals.
+func Format(ms int64) string {
+	if ms < 0 {
+		return "-" + Format(-ms)
+	}
+	h := ms / 3_600_000
+	m := ms / 60_000 % 60
+	s := ms / 1000 % 60
+	f := ms % 1000
+
+	switch {
+	case h > 0:
+		return fmt.Sprintf("%d:%02d:%02d.%03d", h, m, s, f)
+	case m > 0:
+		return fmt.Sprintf("%d:%02d.%03d", m, s, f)
+	default:
+		return fmt.Sprintf("%d.%03d", s, f)
+	}
+}
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+// Package mailer sends the emails the app needs, such as verification and
+// password reset links. Mailer is the extension point for a real delivery
+// service; the senders here are for local development and tests.
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// New returns a FileMailer writing to dir, or a WriterMailer printing to
+// stdout if dir is empty.
+func New(dir string) Mailer {
+	if dir == "" {
+		return &WriterMailer{W: os.Stdout}
+	}
+	return &FileMailer{Dir: dir}
+}
+
+// WriterMailer prints each message to W instead of sending it.
+type WriterMailer struct {
+	W  io.Writer
+	mu sync.Mutex
+}
+
+func (m *WriterMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := io.WriteString(m.W, format(msg)+"\n")
+	return err
+}
+
+// FileMailer writes each message to its own .eml file in Dir, named so that
+// they sort oldest first.
+type FileMailer struct {
+	Dir string
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), xid.New().String())
+	return os.WriteFile(filepath.Join(m.Dir, name), []byte(format(msg)), 0644)
+}
+
+func format(msg Message) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(msg.Body)
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+// APITokenStore looks up personal access tokens.
+type APITokenStore interface {
+	Authenticate(token string) (*models.APITokenOwner, error)
+}
+
+// authenticateAPIToken checks an API token and that it has the scope the
+// route needs. Routes outside the resources tokens can be scoped to (the
+// profile, logins, moderation, admin) can't be used with one.
+func authenticateAPIToken(c echo.Context, tokens APITokenStore, token string) (*models.APITokenOwner, int, string) {
+	owner, err := tokens.Authenticate(token)
+	if err != nil {
+		c.Logger().Errorf("check API token: %v", err)
+		return nil, http.StatusInternalServerError, "Internal server error"
+	}
+	if owner == nil {
+		return nil, http.StatusUnauthorized, "Unauthorized"
+	}
+	scope := requiredScope(c)
+	if scope == "" {
+		return nil, http.StatusForbidden, "API tokens can't be used here"
+	}
+	if !hasScope(owner.Scopes, scope) {
+		return nil, http.StatusForbidden, "Token is missing the " + scope + " scope"
+	}
+	return owner, 0, ""
+}
+
+// requiredScope returns the scope an API token needs for the route, e.g.
+// "lapbook:read" for GET /api/lapbook/:id, or "" if tokens can't be used
+// for it.
+func requiredScope(c echo.Context) string {
+	resource, _, _ := strings.Cut(strings.TrimPrefix(c.Path(), "/api/"), "/")
+	if !slices.Contains(models.APITokenResources, resource) {
+		return ""
+	}
+	switch c.Request().Method {
+	case http.MethodGet, http.MethodHead:
+		return resource + ":read"
+	}
+	return resource + ":write"
+}
+
+// hasScope reports whether scopes grant scope. A write scope includes read.
+func hasScope(scopes []string, scope string) bool {
+	if slices.Contains(scopes, scope) {
+		return true
+	}
+	resource, access, _ := strings.Cut(scope, ":")
+	return access == "read" && slices.Contains(scopes, resource+":write")
+}
+
+// setAPITokenOwner identifies the caller as the token's owner. Requests made
+// with a token always have the USER role, so moderating needs a login.
+func setAPITokenOwner(c echo.Context, owner *models.APITokenOwner) {
+	c.Set("userId", owner.UserID)
+	c.Set("email", owner.Email)
+	c.Set("role", string(models.RoleUser))
+}
@@ -5,47 +5,111 @@ import (
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/joezmuda/trackside-backend/internal/models"
 	"github.com/labstack/echo/v4"
 )
 
 type JWTClaims struct {
 	jwt.RegisteredClaims
 	Email string `json:"email"`
+	Role  string `json:"role"`
+	// SessionID is the login session the token was issued for. Tokens are
+	// only accepted while their session is active.
+	SessionID string `json:"sid"`
 }
 
-func AuthMiddleware(jwtSecret string) echo.MiddlewareFunc {
+// SessionStore reports whether a login session is still active, i.e. the
+// user hasn't logged out of it.
+type SessionStore interface {
+	IsActive(sessionID, userID string) (bool, error)
+}
+
+// AuthMiddleware accepts an access token (JWT) from a login, or an API
+// token with the scope the route needs.
+func AuthMiddleware(jwtSecret string, sessions SessionStore, tokens APITokenStore) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			authHeader := c.Request().Header.Get("Authorization")
-			if authHeader == "" {
-				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+			tokenStr := bearerToken(c)
+			if strings.HasPrefix(tokenStr, models.APITokenPrefix) {
+				owner, status, msg := authenticateAPIToken(c, tokens, tokenStr)
+				if status != 0 {
+					return c.JSON(status, map[string]string{"error": msg})
+				}
+				setAPITokenOwner(c, owner)
+				return next(c)
 			}
 
-			parts := strings.SplitN(authHeader, " ", 2)
-			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+			claims, ok := parseBearerToken(c, tokenStr, jwtSecret, sessions)
+			if !ok {
 				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
 			}
+			setClaims(c, claims)
+			return next(c)
+		}
+	}
+}
 
-			tokenStr := parts[1]
-			claims := &JWTClaims{}
-			token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, jwt.ErrSignatureInvalid
+// OptionalAuthMiddleware identifies the caller when a valid token is sent but
+// lets anonymous requests through, for public routes whose output depends on
+// who is asking (e.g. a pending track is visible to its uploader).
+func OptionalAuthMiddleware(jwtSecret string, sessions SessionStore, tokens APITokenStore) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			tokenStr := bearerToken(c)
+			if strings.HasPrefix(tokenStr, models.APITokenPrefix) {
+				if owner, status, _ := authenticateAPIToken(c, tokens, tokenStr); status == 0 {
+					setAPITokenOwner(c, owner)
 				}
-				return []byte(jwtSecret), nil
-			})
-
-			if err != nil || !token.Valid {
-				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+			} else if claims, ok := parseBearerToken(c, tokenStr, jwtSecret, sessions); ok {
+				setClaims(c, claims)
 			}
-
-			c.Set("userId", claims.Subject)
-			c.Set("email", claims.Email)
 			return next(c)
 		}
 	}
 }
 
+// bearerToken returns the token from the Authorization header, or "".
+func bearerToken(c echo.Context) string {
+	authHeader := c.Request().Header.Get("Authorization")
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		return ""
+	}
+	return parts[1]
+}
+
+func parseBearerToken(c echo.Context, tokenStr, jwtSecret string, sessions SessionStore) (*JWTClaims, bool) {
+	if tokenStr == "" {
+		return nil, false
+	}
+	claims := &JWTClaims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(jwtSecret), nil
+	})
+
+	if err != nil || !token.Valid || claims.SessionID == "" {
+		return nil, false
+	}
+	active, err := sessions.IsActive(claims.SessionID, claims.Subject)
+	if err != nil {
+		c.Logger().Errorf("check session: %v", err)
+	}
+	if !active {
+		return nil, false
+	}
+	return claims, true
+}
+
+func setClaims(c echo.Context, claims *JWTClaims) {
+	c.Set("userId", claims.Subject)
+	c.Set("email", claims.Email)
+	c.Set("role", claims.Role)
+	c.Set("sessionId", claims.SessionID)
+}
+
 // GetUserID extracts the user ID from the Echo context (set by AuthMiddleware).
 func GetUserID(c echo.Context) string {
 	if v, ok := c.Get("userId").(string); ok {
@@ -61,3 +125,125 @@ func GetUserEmail(c echo.Context) string {
 	}
 	return ""
 }
+
+// GetSessionID extracts the login session ID from the Echo context.
+func GetSessionID(c echo.Context) string {
+	if v, ok := c.Get("sessionId").(string); ok {
+		return v
+	}
+	return ""
+}
+
+// GetUserRole extracts the user role from the Echo context.
+func GetUserRole(c echo.Context) string {
+	if v, ok := c.Get("role").(string); ok {
+		return v
+	}
+	return ""
+}
+
+// RequireRole only lets requests through when the authenticated user holds
+// role or a higher one (ADMIN > MODERATOR > USER). Must run after AuthMiddleware.
+func RequireRole(role models.Role) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !models.HasRole(GetUserRole(c), string(role)) {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "Forbidden"})
+			}
+			return next(c)
+		}
+	}
+}
+
+// EmailVerifier reports whether a user has verified their email address.
+type EmailVerifier interface {
+	IsEmailVerified(userID string) (bool, error)
+}
+
+// RequireVerifiedEmail only lets requests through from users who have
+// verified their email address. Must run after AuthMiddleware.
+func RequireVerifiedEmail(users EmailVerifier) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			verified, err := users.IsEmailVerified(GetUserID(c))
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+			}
+			if !verified {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "Verify your email address first"})
+			}
+			return next(c)
+		}
+	}
+}
+
+// TwoFactorChecker reports whether a user has two-factor authentication on.
+type TwoFactorChecker interface {
+	IsEnabled(userID string) (bool, error)
+}
+
+// twoFactorPolicy is the two-factor requirement TwoFactorPolicy puts in the
+// context for HasRolePowers.
+type twoFactorPolicy struct {
+	checker TwoFactorChecker
+	role    string
+}
+
+// TwoFactorPolicy makes the powers of role and higher ones, wherever a
+// handler checks them with HasRolePowers, depend on the user having
+// two-factor authentication on. It lets every request through, so it can
+// cover routes that only act differently for moderators.
+func TwoFactorPolicy(checker TwoFactorChecker, role string) echo.MiddlewareFunc {
+	policy := &twoFactorPolicy{checker: checker, role: role}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("twoFactorPolicy", policy)
+			return next(c)
+		}
+	}
+}
+
+// HasRolePowers reports whether the authenticated user holds role or a
+// higher one and, if TwoFactorPolicy requires it for their role, has
+// two-factor authentication on. The check is made once per request.
+func HasRolePowers(c echo.Context, role string) bool {
+	userRole := GetUserRole(c)
+	if !models.HasRole(userRole, role) {
+		return false
+	}
+	policy, ok := c.Get("twoFactorPolicy").(*twoFactorPolicy)
+	if !ok || policy.role == "" || !models.HasRole(userRole, policy.role) {
+		return true
+	}
+	if enabled, ok := c.Get("twoFactorEnabled").(bool); ok {
+		return enabled
+	}
+	enabled, err := policy.checker.IsEnabled(GetUserID(c))
+	if err != nil {
+		c.Logger().Errorf("check two-factor: %v", err)
+		return false
+	}
+	c.Set("twoFactorEnabled", enabled)
+	return enabled
+}
+
+// RequireTwoFactor only lets users holding role or a higher one through if
+// they have two-factor authentication on; other users pass. It does nothing
+// when role is empty. Must run after AuthMiddleware.
+func RequireTwoFactor(checker TwoFactorChecker, role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if role == "" || !models.HasRole(GetUserRole(c), role) {
+				return next(c)
+			}
+			enabled, err := checker.IsEnabled(GetUserID(c))
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+			}
+			if !enabled {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "Turn on two-factor authentication first"})
+			}
+			return next(c)
+		}
+	}
+}
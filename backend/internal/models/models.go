@@ -1,6 +1,13 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"math"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+)
 
 // ─── Enums ──────────────────────────────────────────────────────────────────────
 
@@ -52,6 +59,7 @@ const (
 	TrackStatusPending  TrackStatus = "PENDING"
 	TrackStatusApproved TrackStatus = "APPROVED"
 	TrackStatusRejected TrackStatus = "REJECTED"
+	TrackStatusArchived TrackStatus = "ARCHIVED"
 )
 
 type EventType string
@@ -86,28 +94,65 @@ func ValidDrivingCondition(s string) bool {
 	return false
 }
 
+type Role string
+
+const (
+	RoleUser      Role = "USER"
+	RoleModerator Role = "MODERATOR"
+	RoleAdmin     Role = "ADMIN"
+)
+
+func ValidRole(s string) bool {
+	switch Role(s) {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// roleRank orders roles so that higher roles inherit everything lower ones can do.
+var roleRank = map[Role]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// HasRole reports whether a user with role have may act as role want.
+func HasRole(have, want string) bool {
+	h, ok := roleRank[Role(have)]
+	if !ok {
+		return false
+	}
+	return h >= roleRank[Role(want)]
+}
+
 // ─── Core Models ────────────────────────────────────────────────────────────────
 
 type User struct {
-	ID           string    `json:"id"`
-	Name         *string   `json:"name"`
-	Email        string    `json:"email"`
-	PasswordHash *string   `json:"-"`
-	Image        *string   `json:"image"`
-	Experience   string    `json:"experience"`
-	CreatedAt    time.Time `json:"createdAt"`
-	UpdatedAt    time.Time `json:"updatedAt"`
+	ID           string  `json:"id"`
+	Name         *string `json:"name"`
+	Email        string  `json:"email"`
+	PasswordHash *string `json:"-"`
+	Image        *string `json:"image"`
+	Experience   string  `json:"experience"`
+	Role         string  `json:"role"`
+	// LeaderboardOptOut hides the user's laps from public leaderboards.
+	LeaderboardOptOut bool       `json:"leaderboardOptOut"`
+	EmailVerified     *time.Time `json:"emailVerified"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	UpdatedAt         time.Time  `json:"updatedAt"`
 }
 
 type Car struct {
-	ID        string    `json:"id"`
-	Make      string    `json:"make"`
-	Model     string    `json:"model"`
-	Year      int       `json:"year"`
-	UserID    string    `json:"userId"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
-	Mods      []CarMod  `json:"mods"`
+	ID        string         `json:"id"`
+	Make      string         `json:"make"`
+	Model     string         `json:"model"`
+	Year      int            `json:"year"`
+	UserID    string         `json:"userId"`
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+	Mods      []CarMod       `json:"mods"`
+	Class     *CarClassBrief `json:"class"`
 }
 
 type CarMod struct {
@@ -118,20 +163,49 @@ type CarMod struct {
 	CarID    string  `json:"carId"`
 }
 
+// CarClass is an autocross class, such as "STR" in the "Street Touring"
+// category. Raw times are multiplied by PaxIndex to compare cars across
+// classes.
+type CarClass struct {
+	ID        string    `json:"id"`
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	Category  *string   `json:"category"`
+	PaxIndex  float64   `json:"paxIndex"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
 type Track struct {
 	ID           string     `json:"id"`
 	Name         string     `json:"name"`
 	Location     string     `json:"location"`
 	State        *string    `json:"state"`
+	Country      *string    `json:"country"`
 	Description  *string    `json:"description"`
 	ImageURL     *string    `json:"imageUrl"`
 	Latitude     *float64   `json:"latitude"`
 	Longitude    *float64   `json:"longitude"`
 	Status       string     `json:"status"`
 	IsImported   bool       `json:"isImported"`
+	Source       *string    `json:"source"`
 	UploadedByID string     `json:"uploadedById"`
 	CreatedAt    time.Time  `json:"createdAt"`
 	UpdatedAt    time.Time  `json:"updatedAt"`
+
+	// StartFinishLine, when set, lets laps be detected in GPS traces.
+	StartFinishLine *TimingLine `json:"startFinishLine"`
+}
+
+type TrackStatusChange struct {
+	ID          string    `json:"id"`
+	TrackID     string    `json:"trackId"`
+	FromStatus  *string   `json:"fromStatus"`
+	ToStatus    string    `json:"toStatus"`
+	Reason      *string   `json:"reason"`
+	ChangedByID string    `json:"changedById"`
+	CreatedAt   time.Time `json:"createdAt"`
+	ChangedBy   UserBrief `json:"changedBy"`
 }
 
 type TrackImage struct {
@@ -157,9 +231,33 @@ type TrackZone struct {
 	PosY        float64   `json:"posY"`
 	TrackID     string    `json:"trackId"`
 	EventType   *string   `json:"eventType"`
+	IsImported  bool      `json:"isImported"`
 	CreatedAt   time.Time `json:"createdAt"`
 }
 
+// TimingLine is a line across the track between two points, crossed to
+// time laps and sectors in GPS traces.
+type TimingLine struct {
+	Lat1 float64 `json:"lat1"`
+	Lng1 float64 `json:"lng1"`
+	Lat2 float64 `json:"lat2"`
+	Lng2 float64 `json:"lng2"`
+}
+
+// TrackSector is one timing sector of a track. Position is the sector's
+// 1-based place in lap order. A sector may be based on a TrackZone. Line,
+// if set, marks where the sector ends for GPS timing; the last sector ends
+// at the track's start/finish line.
+type TrackSector struct {
+	ID        string      `json:"id"`
+	Position  int         `json:"position"`
+	Name      string      `json:"name"`
+	TrackID   string      `json:"trackId"`
+	ZoneID    *string     `json:"zoneId"`
+	Line      *TimingLine `json:"line"`
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
 type ZoneTip struct {
 	ID         string    `json:"id"`
 	Content    string    `json:"content"`
@@ -185,6 +283,7 @@ type TrackReview struct {
 type LapRecord struct {
 	ID              string    `json:"id"`
 	LapTime         string    `json:"lapTime"`
+	LapTimeMs       *int64    `json:"lapTimeMs"`
 	Conditions      string    `json:"conditions"`
 	Notes           *string   `json:"notes"`
 	TirePressureFL  *float64  `json:"tirePressureFL"`
@@ -205,6 +304,8 @@ type LapRecord struct {
 	TrackID         string    `json:"trackId"`
 	TrackEventID    *string   `json:"trackEventId"`
 	CarID           string    `json:"carId"`
+	SessionID       *string   `json:"sessionId"`
+	LapNumber       *int      `json:"lapNumber"`
 	DriverID        string    `json:"driverId"`
 	CreatedAt       time.Time `json:"createdAt"`
 	UpdatedAt       time.Time `json:"updatedAt"`
@@ -243,6 +344,30 @@ type CarWithID struct {
 	Year  int    `json:"year"`
 }
 
+type CarClassBrief struct {
+	ID       string  `json:"id"`
+	Code     string  `json:"code"`
+	Name     string  `json:"name"`
+	PaxIndex float64 `json:"paxIndex"`
+}
+
+// PaxTime is an autocross run's time multiplied by its car's class index:
+// the adjusted time if the run has a result, otherwise the raw time.
+type PaxTime struct {
+	Class    string  `json:"class"`
+	PaxIndex float64 `json:"paxIndex"`
+	Time     string  `json:"time"`
+	TimeMs   int64   `json:"timeMs"`
+}
+
+// ClassSuggestion is the class category a car's mods point to, with the
+// classes in it. It is a starting point, not a rules check.
+type ClassSuggestion struct {
+	Category string     `json:"category"`
+	Reasons  []string   `json:"reasons"`
+	Classes  []CarClass `json:"classes"`
+}
+
 type TrackBrief struct {
 	ID       string `json:"id"`
 	Name     string `json:"name"`
@@ -260,12 +385,14 @@ type TrackListItem struct {
 	Name         string      `json:"name"`
 	Location     string      `json:"location"`
 	State        *string     `json:"state"`
+	Country      *string     `json:"country"`
 	Description  *string     `json:"description"`
 	ImageURL     *string     `json:"imageUrl"`
 	Latitude     *float64    `json:"latitude"`
 	Longitude    *float64    `json:"longitude"`
 	Status       string      `json:"status"`
 	IsImported   bool        `json:"isImported"`
+	Source       *string     `json:"source"`
 	UploadedByID string      `json:"uploadedById"`
 	CreatedAt    time.Time   `json:"createdAt"`
 	UpdatedAt    time.Time   `json:"updatedAt"`
@@ -273,6 +400,27 @@ type TrackListItem struct {
 	UploadedBy   UserBrief    `json:"uploadedBy"`
 	Count        TrackCounts  `json:"_count"`
 	AvgRating    float64      `json:"avgRating"`
+	StatusReason *string      `json:"statusReason,omitempty"`
+	DistanceKm   *float64     `json:"distanceKm,omitempty"`
+	DistanceMi   *float64     `json:"distanceMi,omitempty"`
+	// Snippet is HTML-escaped text around a search match, with matched
+	// terms wrapped in <mark>. Only set for searches.
+	Snippet *string `json:"snippet,omitempty"`
+}
+
+type TrackSuggestion struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	Location string  `json:"location"`
+	State    *string `json:"state"`
+	// Highlight is the HTML-escaped name with matched terms in <mark>.
+	Highlight string `json:"highlight"`
+}
+
+type TrackListResponse struct {
+	Tracks     []TrackListItem `json:"tracks"`
+	NextCursor *string         `json:"nextCursor"`
+	Total      int             `json:"total"`
 }
 
 type ZoneTipWithAuthor struct {
@@ -294,6 +442,7 @@ type TrackZoneWithTips struct {
 	PosY        float64             `json:"posY"`
 	TrackID     string              `json:"trackId"`
 	EventType   *string             `json:"eventType"`
+	IsImported  bool                `json:"isImported"`
 	CreatedAt   time.Time           `json:"createdAt"`
 	Tips        []ZoneTipWithAuthor `json:"tips"`
 }
@@ -317,12 +466,14 @@ type TrackDetail struct {
 	Name         string              `json:"name"`
 	Location     string              `json:"location"`
 	State        *string             `json:"state"`
+	Country      *string             `json:"country"`
 	Description  *string             `json:"description"`
 	ImageURL     *string             `json:"imageUrl"`
 	Latitude     *float64            `json:"latitude"`
 	Longitude    *float64            `json:"longitude"`
 	Status       string              `json:"status"`
 	IsImported   bool                `json:"isImported"`
+	Source       *string             `json:"source"`
 	UploadedByID string              `json:"uploadedById"`
 	CreatedAt    time.Time           `json:"createdAt"`
 	UpdatedAt    time.Time           `json:"updatedAt"`
@@ -332,6 +483,8 @@ type TrackDetail struct {
 	Reviews      []ReviewWithAuthor  `json:"reviews"`
 	Count        TrackCounts         `json:"_count"`
 	AvgRating    float64             `json:"avgRating"`
+
+	StartFinishLine *TimingLine `json:"startFinishLine"`
 }
 
 type TrackImageWithUploader struct {
@@ -345,6 +498,9 @@ type TrackImageWithUploader struct {
 type LapRecordWithDetails struct {
 	ID              string      `json:"id"`
 	LapTime         string      `json:"lapTime"`
+	LapTimeMs       *int64      `json:"lapTimeMs"`
+	IsPersonalBest  bool        `json:"isPersonalBest"`
+	Edited          bool        `json:"edited"`
 	Conditions      string      `json:"conditions"`
 	Notes           *string     `json:"notes"`
 	TirePressureFL  *float64    `json:"tirePressureFL"`
@@ -365,19 +521,232 @@ type LapRecordWithDetails struct {
 	TrackID         string      `json:"trackId"`
 	TrackEventID    *string     `json:"trackEventId"`
 	CarID           string      `json:"carId"`
+	SessionID       *string     `json:"sessionId"`
+	LapNumber       *int        `json:"lapNumber"`
 	DriverID        string      `json:"driverId"`
 	CreatedAt       time.Time   `json:"createdAt"`
 	UpdatedAt       time.Time   `json:"updatedAt"`
 	Track           TrackBrief  `json:"track"`
 	TrackEvent      *TrackEvent `json:"trackEvent"`
 	Car             CarWithID   `json:"car"`
+	Splits          []LapSplit  `json:"splits"`
+	Result          *LapResult  `json:"result"`
+	Pax             *PaxTime    `json:"pax"`
+}
+
+// LapSplit is a lap's time through one sector.
+type LapSplit struct {
+	SectorID string `json:"sectorId"`
+	Position int    `json:"position"`
+	Name     string `json:"name"`
+	TimeMs   int64  `json:"timeMs"`
+	Time     string `json:"time"`
+}
+
+// ConePenaltyMs is added to an autocross run's raw time for each cone hit.
+const ConePenaltyMs = 2000
+
+// LapResult holds the details of a lap that depend on the type of its
+// event. Only the field matching the event's type is set.
+type LapResult struct {
+	Drag      *DragResult      `json:"drag,omitempty"`
+	Autocross *AutocrossResult `json:"autocross,omitempty"`
+	Drift     *DriftResult     `json:"drift,omitempty"`
+}
+
+// DragResult is a drag pass's timeslip. The lap time is the elapsed time of
+// the whole pass. Times are written like lap times; a negative reaction
+// time is a red light.
+type DragResult struct {
+	ReactionTime *string  `json:"reactionTime"`
+	SixtyFoot    *string  `json:"sixtyFoot"`
+	ThreeThirty  *string  `json:"threeThirty"`
+	EighthMile   *string  `json:"eighthMile"`
+	QuarterMile  *string  `json:"quarterMile"`
+	TrapSpeedMph *float64 `json:"trapSpeedMph"`
+	RedLight     bool     `json:"redLight"`
+}
+
+// AutocrossResult is an autocross run's penalties. The lap time is the raw
+// time; the adjusted time adds ConePenaltyMs per cone and is nil for a run
+// that did not finish or went off course.
+type AutocrossResult struct {
+	Cones          int     `json:"cones"`
+	DNF            bool    `json:"dnf"`
+	OffCourse      bool    `json:"offCourse"`
+	AdjustedTime   *string `json:"adjustedTime"`
+	AdjustedTimeMs *int64  `json:"adjustedTimeMs"`
+}
+
+// DriftResult is a drift run's judged scores, one per category, and their total.
+type DriftResult struct {
+	Scores []DriftScore `json:"scores"`
+	Total  float64      `json:"total"`
+}
+
+type DriftScore struct {
+	Category string  `json:"category"`
+	Score    float64 `json:"score"`
+}
+
+// DriftTotal adds up drift scores, rounded to hundredths so the total
+// doesn't pick up floating point noise.
+func DriftTotal(scores []DriftScore) float64 {
+	var total float64
+	for _, s := range scores {
+		total += s.Score
+	}
+	return math.Round(total*100) / 100
+}
+
+// TheoreticalBest combines a driver's fastest split in each sector of a
+// track and compares it with their personal best lap, which is their
+// fastest lap with a split for every sector.
+type TheoreticalBest struct {
+	Track             TrackBrief   `json:"track"`
+	Sectors           []SectorBest `json:"sectors"`
+	TheoreticalBestMs *int64       `json:"theoreticalBestMs"`
+	TheoreticalBest   *string      `json:"theoreticalBest"`
+	PersonalBest      *LapTimeRef  `json:"personalBest"`
+	// GainMs is how much faster the theoretical best is than the personal best.
+	GainMs *int64 `json:"gainMs"`
+	// WeakestSectorID is the sector where the personal best lost the most
+	// time against the best split.
+	WeakestSectorID *string `json:"weakestSectorId"`
+}
+
+type SectorBest struct {
+	SectorID    string  `json:"sectorId"`
+	Position    int     `json:"position"`
+	Name        string  `json:"name"`
+	BestMs      *int64  `json:"bestMs"`
+	Best        *string `json:"best"`
+	LapRecordID *string `json:"lapRecordId"`
+	// PersonalBestMs is the personal best lap's split in this sector and
+	// LossMs how much slower it was than the best split.
+	PersonalBestMs *int64 `json:"personalBestMs"`
+	LossMs         *int64 `json:"lossMs"`
+}
+
+type LapTimeRef struct {
+	LapRecordID string `json:"lapRecordId"`
+	LapTime     string `json:"lapTime"`
+	LapTimeMs   int64  `json:"lapTimeMs"`
+}
+
+// LapRecordEdit is one entry in a lap record's edit history. Changes maps
+// each edited field to its previous and new value.
+type LapRecordEdit struct {
+	ID          string                 `json:"id"`
+	LapRecordID string                 `json:"lapRecordId"`
+	EditedByID  string                 `json:"editedById"`
+	Changes     map[string]FieldChange `json:"changes"`
+	CreatedAt   time.Time              `json:"createdAt"`
+	EditedBy    UserBrief              `json:"editedBy"`
+}
+
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// TrackSession is one run group or heat in a driver's day at a track. Laps
+// in a session share its track, event and car.
+type TrackSession struct {
+	ID           string          `json:"id"`
+	Date         string          `json:"date"`
+	RunGroup     *string         `json:"runGroup"`
+	AmbientTempC *float64        `json:"ambientTempC"`
+	TrackTempC   *float64        `json:"trackTempC"`
+	Setup        json.RawMessage `json:"setup"`
+	Notes        *string         `json:"notes"`
+	TrackID      string          `json:"trackId"`
+	TrackEventID *string         `json:"trackEventId"`
+	CarID        string          `json:"carId"`
+	DriverID     string          `json:"driverId"`
+	CreatedAt    time.Time       `json:"createdAt"`
+	UpdatedAt    time.Time       `json:"updatedAt"`
+}
+
+type TrackSessionWithDetails struct {
+	TrackSession
+	Track      TrackBrief     `json:"track"`
+	TrackEvent *TrackEvent    `json:"trackEvent"`
+	Car        CarWithID      `json:"car"`
+	Summary    SessionSummary `json:"summary"`
+	// Laps is only included when fetching a single session.
+	Laps []LapRecordWithDetails `json:"laps,omitempty"`
+}
+
+// SessionSummary describes the timed laps in a session. The times are nil
+// for a session without any.
+type SessionSummary struct {
+	LapCount    int      `json:"lapCount"`
+	BestMs      *int64   `json:"bestMs"`
+	BestLapTime *string  `json:"bestLapTime"`
+	AverageMs   *int64   `json:"averageMs"`
+	StdDevMs    *float64 `json:"stdDevMs"`
+	// TheoreticalBestMs adds up the session's fastest split in each sector.
+	// Nil unless the laps have splits covering every sector.
+	TheoreticalBestMs *int64  `json:"theoreticalBestMs"`
+	TheoreticalBest   *string `json:"theoreticalBest"`
+}
+
+type TrackSessionRequest struct {
+	Date         string          `json:"date"`
+	RunGroup     *string         `json:"runGroup"`
+	AmbientTempC *float64        `json:"ambientTempC"`
+	TrackTempC   *float64        `json:"trackTempC"`
+	Setup        json.RawMessage `json:"setup"`
+	Notes        *string         `json:"notes"`
+	TrackID      string          `json:"trackId"`
+	TrackEventID *string         `json:"trackEventId"`
+	CarID        string          `json:"carId"`
+}
+
+// LapImportResult describes a data-logger export read into the lapbook, or
+// what would be imported for a dry run. Session is set once laps are saved.
+type LapImportResult struct {
+	DryRun     bool                     `json:"dryRun"`
+	Format     string                   `json:"format"`
+	Track      *TrackBrief              `json:"track"`
+	TrackMatch string                   `json:"trackMatch,omitempty"`
+	Date       string                   `json:"date"`
+	Laps       []ImportedLap            `json:"laps"`
+	Errors     []LapImportError         `json:"errors"`
+	Warnings   []string                 `json:"warnings"`
+	Session    *TrackSessionWithDetails `json:"session,omitempty"`
 }
 
+// ImportedLap is a lap read from an export. Row is its line in the file.
+type ImportedLap struct {
+	Row       int      `json:"row"`
+	LapNumber int      `json:"lapNumber"`
+	LapTime   string   `json:"lapTime"`
+	LapTimeMs int64    `json:"lapTimeMs"`
+	Splits    []string `json:"splits,omitempty"`
+	SplitsMs  []int64  `json:"splitsMs,omitempty"`
+}
+
+// LapImportError explains why a row of an export was not imported.
+type LapImportError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+// Ways an import's track was chosen.
+const (
+	TrackMatchID   = "id"
+	TrackMatchGPS  = "gps"
+	TrackMatchName = "name"
+)
+
 type ProfileResponse struct {
 	ID         string    `json:"id"`
 	Name       *string   `json:"name"`
 	Email      string    `json:"email"`
 	Experience string    `json:"experience"`
+	Role       string    `json:"role"`
 	Image      *string   `json:"image"`
 	CreatedAt  time.Time `json:"createdAt"`
 	Cars       []Car     `json:"cars"`
@@ -387,6 +756,8 @@ type ProfileResponse struct {
 		Tracks       int `json:"tracks"`
 		ZoneTips     int `json:"zoneTips"`
 	} `json:"_count"`
+	LeaderboardOptOut bool       `json:"leaderboardOptOut"`
+	EmailVerified     *time.Time `json:"emailVerified"`
 }
 
 // ─── Request DTOs ───────────────────────────────────────────────────────────────
@@ -403,18 +774,210 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+type LoginUser struct {
+	ID            string  `json:"id"`
+	Name          *string `json:"name"`
+	Email         string  `json:"email"`
+	Role          string  `json:"role"`
+	EmailVerified bool    `json:"emailVerified"`
+}
+
+// LoginResponse carries a short-lived access token, sent as the Bearer
+// token, and a refresh token for POST /api/auth/refresh. ExpiresIn is the
+// access token's lifetime in seconds.
 type LoginResponse struct {
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresIn    int       `json:"expiresIn"`
+	User         LoginUser `json:"user"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token           string `json:"token"`
+	Password        string `json:"password"`
+	ConfirmPassword string `json:"confirmPassword"`
+}
+
+// TwoFactor is a user's TOTP setup. It is pending until EnabledAt is set.
+type TwoFactor struct {
+	Secret         string
+	EnabledAt      *time.Time
+	LastStep       int64
+	FailedAttempts int
+	LockedUntil    *time.Time
+}
+
+// TwoFactorChallengeResponse is what login returns instead of tokens when
+// the user has two-factor authentication on. The challenge token and a
+// code go to POST /api/auth/2fa/verify.
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"twoFactorRequired"`
+	ChallengeToken    string `json:"challengeToken"`
+	ExpiresIn         int    `json:"expiresIn"`
+}
+
+// TwoFactorSetupResponse carries a new TOTP secret and the otpauth:// URI
+// for the frontend to show as a QR code.
+type TwoFactorSetupResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TwoFactorStatus struct {
+	Enabled bool `json:"enabled"`
+	// Required is true when the user's role must use two-factor
+	// authentication.
+	Required          bool `json:"required"`
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+}
+
+// TwoFactorCodeRequest carries a code from the user's authenticator app,
+// or one of their recovery codes instead.
+type TwoFactorCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type TwoFactorVerifyRequest struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recoveryCode"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// OIDCProviderInfo is a sign-in provider as listed for the login page.
+type OIDCProviderInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// OIDCStartResponse is where to send the user to sign in with a provider.
+// The frontend should check that the state it gets back matches.
+type OIDCStartResponse struct {
+	URL   string `json:"url"`
+	State string `json:"state"`
+}
+
+// OIDCCallbackRequest carries the query parameters the provider sent the
+// user back with.
+type OIDCCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// OAuthState is a sign-in with a provider that is waiting for the user to
+// come back. UserID is set when a signed-in user is linking the provider.
+type OAuthState struct {
+	Provider     string
+	CodeVerifier string
+	Nonce        string
+	UserID       *string
+}
+
+// Account links a user to their account with an OIDC provider, and keeps
+// the provider's latest tokens.
+type Account struct {
+	UserID            string
+	Provider          string
+	ProviderAccountID string
+	AccessToken       *string
+	RefreshToken      *string
+	ExpiresAt         *int64
+	TokenType         *string
+	Scope             *string
+	IDToken           *string
+}
+
+// LinkedAccount is a provider account as shown on the user's profile.
+type LinkedAccount struct {
+	Provider          string `json:"provider"`
+	Name              string `json:"name"`
+	ProviderAccountID string `json:"providerAccountId"`
+}
+
+// SystemEmail is the email address of the system user that owns imported
+// tracks. Nobody can sign up or sign in with it.
+const SystemEmail = "system@trackside.local"
+
+// IsSystemEmail reports whether s is the system user's address, in any case.
+func IsSystemEmail(s string) bool {
+	return strings.EqualFold(strings.TrimSpace(s), SystemEmail)
+}
+
+// ValidEmail reports whether s is a plain email address, without a display
+// name or angle brackets.
+func ValidEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s && strings.Contains(s[strings.LastIndex(s, "@"):], ".")
+}
+
+// APITokenPrefix starts every API token, so they can be told apart from
+// JWTs (and spotted if they leak).
+const APITokenPrefix = "tsk_"
+
+// APITokenResources are the resources API tokens can be scoped to. A scope
+// is "<resource>:read" or "<resource>:write"; write includes read.
+var APITokenResources = []string{"cars", "lapbook", "sessions", "tracks"}
+
+// ValidAPITokenScope reports whether s names a scope an API token can have.
+func ValidAPITokenScope(s string) bool {
+	resource, access, ok := strings.Cut(s, ":")
+	return ok && slices.Contains(APITokenResources, resource) && (access == "read" || access == "write")
+}
+
+// APIToken is a personal access token as listed on the user's profile. The
+// token itself is only shown when it is created.
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// APITokenRequest creates an API token. It never expires when
+// ExpiresInDays is omitted.
+type APITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays *int     `json:"expiresInDays"`
+}
+
+type APITokenResponse struct {
+	APIToken
 	Token string `json:"token"`
-	User  struct {
-		ID    string  `json:"id"`
-		Name  *string `json:"name"`
-		Email string  `json:"email"`
-	} `json:"user"`
+}
+
+// APITokenOwner is who a request made with an API token acts for, and
+// what the token allows.
+type APITokenOwner struct {
+	TokenID string
+	UserID  string
+	Email   string
+	Scopes  []string
 }
 
 type ProfileUpdateRequest struct {
 	Name       string `json:"name"`
 	Experience string `json:"experience"`
+	// LeaderboardOptOut is left unchanged when omitted.
+	LeaderboardOptOut *bool `json:"leaderboardOptOut"`
 }
 
 type CarRequest struct {
@@ -423,6 +986,48 @@ type CarRequest struct {
 	Year  int    `json:"year"`
 }
 
+type CarClassRequest struct {
+	Code     string  `json:"code"`
+	Name     string  `json:"name"`
+	Category *string `json:"category"`
+	PaxIndex float64 `json:"paxIndex"`
+}
+
+// Limits on car classes. PAX indexes are multipliers on a run's time, in
+// practice between about 0.7 and 1.
+const (
+	MaxClassCodeLen = 10
+	MaxClassNameLen = 100
+	MaxPaxIndex     = 2
+)
+
+// NormalizeCarClass trims a class's fields and checks them, returning a
+// message describing the first problem, or "" if the class is valid.
+func NormalizeCarClass(req *CarClassRequest) string {
+	req.Code = strings.ToUpper(strings.TrimSpace(req.Code))
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Category != nil {
+		category := strings.TrimSpace(*req.Category)
+		req.Category = &category
+		if category == "" {
+			req.Category = nil
+		}
+	}
+	switch {
+	case req.Code == "" || len(req.Code) > MaxClassCodeLen:
+		return "Class code is required and must be at most 10 characters"
+	case req.Name == "" || len(req.Name) > MaxClassNameLen:
+		return "Class name is required and must be at most 100 characters"
+	case math.IsNaN(req.PaxIndex) || req.PaxIndex <= 0 || req.PaxIndex > MaxPaxIndex:
+		return "PAX index must be greater than 0 and at most 2"
+	}
+	return ""
+}
+
+type CarClassAssignRequest struct {
+	ClassID string `json:"classId"`
+}
+
 type CarModRequest struct {
 	Name     string  `json:"name"`
 	Category string  `json:"category"`
@@ -437,6 +1042,61 @@ type TrackRequest struct {
 	EventTypes  []string `json:"eventTypes"`
 }
 
+const (
+	TrackSortNewest  = "newest"
+	TrackSortName    = "name"
+	TrackSortRating  = "rating"
+	TrackSortReviews = "reviews"
+	TrackSortLaps    = "laps"
+
+	// TrackSortDistance orders by distance from TrackListParams.Near and is
+	// the default when a point is given.
+	TrackSortDistance = "distance"
+	// TrackSortRelevance orders by full-text match quality and is the
+	// default when searching.
+	TrackSortRelevance = "relevance"
+)
+
+func ValidTrackSort(s string) bool {
+	switch s {
+	case TrackSortNewest, TrackSortName, TrackSortRating, TrackSortReviews, TrackSortLaps,
+		TrackSortDistance, TrackSortRelevance:
+		return true
+	}
+	return false
+}
+
+type TrackListParams struct {
+	Search    string
+	EventType string
+	State     string
+	Country   string
+	Sort      string
+	Limit     int
+	Cursor    string
+
+	// Near, when set, adds distanceKm/distanceMi to each track and enables
+	// the distance sort. RadiusKm > 0 also drops tracks farther away.
+	Near     *GeoPoint
+	RadiusKm float64
+	// BBox restricts results to tracks inside a map viewport.
+	BBox *BoundingBox
+}
+
+type GeoPoint struct {
+	Lat float64
+	Lng float64
+}
+
+// BoundingBox is a lat/lng rectangle. MinLng > MaxLng means the box crosses
+// the antimeridian.
+type BoundingBox struct {
+	MinLat float64
+	MinLng float64
+	MaxLat float64
+	MaxLng float64
+}
+
 type TrackPatchRequest struct {
 	ImageURL    *string `json:"imageUrl"`
 	Name        *string `json:"name"`
@@ -444,6 +1104,125 @@ type TrackPatchRequest struct {
 	Location    *string `json:"location"`
 }
 
+// LapbookStats summarizes a driver's laps. Laps are compared like for like:
+// same track, event, car and conditions.
+type LapbookStats struct {
+	PersonalBests []PersonalBest `json:"personalBests"`
+	Sessions      []SessionStats `json:"sessions"`
+}
+
+type PersonalBest struct {
+	Track       TrackBrief   `json:"track"`
+	EventType   *string      `json:"eventType"`
+	Car         CarWithID    `json:"car"`
+	Conditions  string       `json:"conditions"`
+	LapRecordID string       `json:"lapRecordId"`
+	LapTime     string       `json:"lapTime"`
+	LapTimeMs   int64        `json:"lapTimeMs"`
+	AchievedAt  time.Time    `json:"achievedAt"`
+	LapCount    int          `json:"lapCount"`
+	Trend       []TrendPoint `json:"trend"`
+}
+
+// TrendPoint is one session's best lap alongside the personal best as it
+// stood after that session.
+type TrendPoint struct {
+	Date           string `json:"date"`
+	BestMs         int64  `json:"bestMs"`
+	BestLapTime    string `json:"bestLapTime"`
+	PersonalBestMs int64  `json:"personalBestMs"`
+}
+
+// SessionStats covers the laps one driver logged in a TrackSession with the
+// same conditions, or for laps outside a session, those logged at a track on
+// one day with the same event, car and conditions.
+type SessionStats struct {
+	Date        string     `json:"date"`
+	SessionID   *string    `json:"sessionId"`
+	RunGroup    *string    `json:"runGroup"`
+	Track       TrackBrief `json:"track"`
+	EventType   *string    `json:"eventType"`
+	Car         CarWithID  `json:"car"`
+	Conditions  string     `json:"conditions"`
+	LapCount    int        `json:"lapCount"`
+	BestMs      int64      `json:"bestMs"`
+	BestLapTime string     `json:"bestLapTime"`
+	AverageMs   int64      `json:"averageMs"`
+	// StdDevMs is the sample standard deviation of the session's laps, a
+	// measure of consistency. Nil with fewer than two laps.
+	StdDevMs *float64 `json:"stdDevMs"`
+	// ImprovementMs is how much faster the best lap was than the previous
+	// session's best. Negative means slower; nil for the first session.
+	ImprovementMs   *int64 `json:"improvementMs"`
+	SetPersonalBest bool   `json:"setPersonalBest"`
+}
+
+type LeaderboardParams struct {
+	EventType  string
+	Conditions string
+	CarMake    string
+	CarModel   string
+	// From and To are inclusive YYYY-MM-DD dates.
+	From  string
+	To    string
+	Limit int
+	// Pax ranks an autocross leaderboard by PAX time.
+	Pax bool
+}
+
+type LeaderboardMod struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+}
+
+type LeaderboardCar struct {
+	ID    string           `json:"id"`
+	Make  string           `json:"make"`
+	Model string           `json:"model"`
+	Year  int              `json:"year"`
+	Mods  []LeaderboardMod `json:"mods"`
+}
+
+type LeaderboardEntry struct {
+	Rank        int            `json:"rank"`
+	LapRecordID string         `json:"lapRecordId"`
+	LapTime     string         `json:"lapTime"`
+	LapTimeMs   *int64         `json:"lapTimeMs"`
+	GapMs       int64          `json:"gapMs"`
+	Gap         string         `json:"gap"`
+	Conditions  string         `json:"conditions"`
+	EventType   *string        `json:"eventType"`
+	RecordedAt  time.Time      `json:"recordedAt"`
+	Edited      bool           `json:"edited"`
+	Driver      UserBrief      `json:"driver"`
+	Car         LeaderboardCar `json:"car"`
+	Result      *LapResult     `json:"result"`
+	Pax         *PaxTime       `json:"pax"`
+}
+
+// Leaderboard rankings. Autocross runs are ranked by adjusted time, or PAX
+// time if asked for, drag passes by 1/4 mile time and drift runs by total
+// score when the leaderboard is for that event type; everything else is
+// ranked by lap time.
+const (
+	RankByLapTime         = "lapTime"
+	RankByAdjustedTime    = "adjustedTime"
+	RankByPaxTime         = "paxTime"
+	RankByQuarterMileTime = "quarterMileTime"
+	RankByScore           = "score"
+)
+
+type LeaderboardResponse struct {
+	Track    TrackBrief         `json:"track"`
+	RankedBy string             `json:"rankedBy"`
+	Entries  []LeaderboardEntry `json:"entries"`
+	Total    int                `json:"total"`
+}
+
+type ModerationRequest struct {
+	Reason *string `json:"reason"`
+}
+
 type TrackImageRequest struct {
 	URL     string  `json:"url"`
 	Caption *string `json:"caption"`
@@ -457,6 +1236,20 @@ type TrackZoneRequest struct {
 	EventType   *string `json:"eventType"`
 }
 
+// TrackSectorsRequest replaces a track's sectors with the given list, in lap
+// order. An entry with the id of an existing sector keeps that sector and
+// its splits; a name may be left out when the sector is based on a zone.
+type TrackSectorsRequest struct {
+	Sectors []TrackSectorInput `json:"sectors"`
+}
+
+type TrackSectorInput struct {
+	ID     *string     `json:"id"`
+	Name   string      `json:"name"`
+	ZoneID *string     `json:"zoneId"`
+	Line   *TimingLine `json:"line"`
+}
+
 type ZoneUpdateRequest struct {
 	Name        *string `json:"name"`
 	Description *string `json:"description"`
@@ -496,27 +1289,147 @@ type LapRecordRequest struct {
 	TrackID        string   `json:"trackId"`
 	TrackEventID   *string  `json:"trackEventId"`
 	CarID          string   `json:"carId"`
+	SessionID      *string  `json:"sessionId"`
+	LapNumber      *int     `json:"lapNumber"`
+	// Splits are the sector times in sector order, one per sector of the track.
+	Splits []string `json:"splits"`
+	// Result holds details for the lap's event type. For a drag pass the lap
+	// time may be left out and is taken from the timeslip.
+	Result *LapResult `json:"result"`
 }
 
 // ─── Admin ──────────────────────────────────────────────────────────────────────
 
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+type UserRoleResponse struct {
+	ID    string  `json:"id"`
+	Name  *string `json:"name"`
+	Email string  `json:"email"`
+	Role  string  `json:"role"`
+}
+
+// ImportedTrack is one track in a tracks data file. State is free text (a
+// US state, a county, a province...) and, like Country, may be left out.
+// Country is a two-letter ISO 3166 code.
 type ImportedTrack struct {
 	Name        string   `json:"name"`
 	Location    string   `json:"location"`
 	State       string   `json:"state"`
+	Country     string   `json:"country"`
 	Types       []string `json:"types"`
 	Latitude    float64  `json:"latitude"`
 	Longitude   float64  `json:"longitude"`
 	Description string   `json:"description"`
 }
 
+// ImportedZones are the zones of one track in the zones data file, which is
+// matched to a track by name and location.
+type ImportedZones struct {
+	TrackName     string         `json:"trackName"`
+	TrackLocation string         `json:"trackLocation"`
+	Zones         []ImportedZone `json:"zones"`
+}
+
+type ImportedZone struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	PosX        float64 `json:"posX"`
+	PosY        float64 `json:"posY"`
+}
+
+type SyncSummary struct {
+	Total   int `json:"total"`
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Failed  int `json:"failed"`
+}
+
+// SyncTracksResponse reports a sync of the tracks data file. Changes lists
+// each track that was (or, in a dry run, would be) created, updated or
+// archived, with the fields that change.
 type SyncTracksResponse struct {
-	Status  string `json:"status"`
-	Summary struct {
-		Total   int `json:"total"`
-		Created int `json:"created"`
-		Updated int `json:"updated"`
-		Failed  int `json:"failed"`
-	} `json:"summary"`
-	Errors []string `json:"errors,omitempty"`
+	Status  string            `json:"status"`
+	DryRun  bool              `json:"dryRun"`
+	Summary SyncTracksSummary `json:"summary"`
+	Changes []TrackSyncChange `json:"changes"`
+	Errors  []string          `json:"errors,omitempty"`
+}
+
+// SyncTracksSummary counts tracks in the data file, plus imported tracks
+// archived because they are no longer in it. Updated counts every track
+// already in the database, changed or not. Skipped counts tracks left alone
+// because another source imported them.
+type SyncTracksSummary struct {
+	SyncSummary
+	Unchanged int `json:"unchanged"`
+	Skipped   int `json:"skipped"`
+	Archived  int `json:"archived"`
+}
+
+// Track sync actions.
+const (
+	SyncCreate  = "create"
+	SyncUpdate  = "update"
+	SyncArchive = "archive"
+)
+
+type TrackSyncChange struct {
+	TrackID  *string                `json:"trackId"`
+	Name     string                 `json:"name"`
+	Location string                 `json:"location"`
+	Action   string                 `json:"action"`
+	Changes  map[string]FieldChange `json:"changes"`
+}
+
+// SyncOptions control a track sync. A dry run works out the changes without
+// saving them; Prune archives imported tracks missing from the data file.
+type SyncOptions struct {
+	DryRun bool
+	Prune  bool
+}
+
+// SyncRun is one track sync in the sync history. Summary is nil if the sync
+// failed before it started, and TriggeredBy is nil for command-line runs.
+type SyncRun struct {
+	ID          string             `json:"id"`
+	Source      string             `json:"source"`
+	DryRun      bool               `json:"dryRun"`
+	Prune       bool               `json:"prune"`
+	Status      string             `json:"status"`
+	Summary     *SyncTracksSummary `json:"summary"`
+	Error       *string            `json:"error"`
+	TriggeredBy *UserBrief         `json:"triggeredBy"`
+	StartedAt   time.Time          `json:"startedAt"`
+	FinishedAt  time.Time          `json:"finishedAt"`
+}
+
+// SyncZonesResponse reports an import of the zones data file. Zone counts
+// are for zones on matched tracks; a zone is skipped when its track already
+// has a zone of that name added by a user. Unmatched lists the tracks in the
+// file that aren't in the database.
+type SyncZonesResponse struct {
+	Status    string           `json:"status"`
+	Summary   SyncZonesSummary `json:"summary"`
+	Unmatched []string         `json:"unmatched,omitempty"`
+	Errors    []string         `json:"errors,omitempty"`
+}
+
+type SyncZonesSummary struct {
+	Tracks    int `json:"tracks"`
+	Unmatched int `json:"unmatched"`
+	Total     int `json:"total"`
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Skipped   int `json:"skipped"`
+	Failed    int `json:"failed"`
+}
+
+// SyncClassesResponse reports an import of the car class index table.
+type SyncClassesResponse struct {
+	Status  string      `json:"status"`
+	Summary SyncSummary `json:"summary"`
+	Errors  []string    `json:"errors,omitempty"`
 }
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/rs/xid"
+)
+
+// AccountRepo stores the links between users and their accounts with OIDC
+// providers in the "Account" table.
+type AccountRepo struct {
+	db *sql.DB
+}
+
+func NewAccountRepo(db *sql.DB) *AccountRepo {
+	return &AccountRepo{db: db}
+}
+
+// FindUserID returns the user a provider account is linked to, or "" if
+// it isn't linked.
+func (r *AccountRepo) FindUserID(provider, providerAccountID string) (string, error) {
+	var userID string
+	err := r.db.QueryRow(
+		`SELECT userId FROM "Account" WHERE provider = ? AND providerAccountId = ?`,
+		provider, providerAccountID,
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return userID, err
+}
+
+// FindProviderAccountID returns the id of the user's account with a
+// provider, or "" if they haven't linked one.
+func (r *AccountRepo) FindProviderAccountID(userID, provider string) (string, error) {
+	var id string
+	err := r.db.QueryRow(
+		`SELECT providerAccountId FROM "Account" WHERE userId = ? AND provider = ?`,
+		userID, provider,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// Save links a provider account to a user, or stores the latest tokens if
+// it is already linked to them.
+func (r *AccountRepo) Save(a models.Account) error {
+	_, err := r.db.Exec(
+		`INSERT INTO "Account" (id, userId, type, provider, providerAccountId, access_token, refresh_token, expires_at, token_type, scope, id_token)
+		 VALUES (?, ?, 'oidc', ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT (provider, providerAccountId) DO UPDATE SET
+			access_token = excluded.access_token,
+			refresh_token = COALESCE(excluded.refresh_token, refresh_token),
+			expires_at = excluded.expires_at,
+			token_type = excluded.token_type,
+			scope = excluded.scope,
+			id_token = excluded.id_token
+		 WHERE userId = excluded.userId`,
+		xid.New().String(), a.UserID, a.Provider, a.ProviderAccountID,
+		a.AccessToken, a.RefreshToken, a.ExpiresAt, a.TokenType, a.Scope, a.IDToken,
+	)
+	return err
+}
+
+// ListByUser returns the user's linked accounts. Name is left for the
+// caller to fill in from the provider's configuration.
+func (r *AccountRepo) ListByUser(userID string) ([]models.LinkedAccount, error) {
+	rows, err := r.db.Query(
+		`SELECT provider, providerAccountId FROM "Account" WHERE userId = ? ORDER BY provider`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []models.LinkedAccount{}
+	for rows.Next() {
+		var a models.LinkedAccount
+		if err := rows.Scan(&a.Provider, &a.ProviderAccountID); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+// Delete unlinks the user's account with a provider and reports whether
+// there was one.
+func (r *AccountRepo) Delete(userID, provider string) (bool, error) {
+	res, err := r.db.Exec(`DELETE FROM "Account" WHERE userId = ? AND provider = ?`, userID, provider)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
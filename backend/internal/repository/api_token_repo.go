@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/rs/xid"
+)
+
+// lastUsedInterval is how often a token's lastUsedAt is updated, so a busy
+// script doesn't write to the database on every request.
+const lastUsedInterval = time.Minute
+
+// APITokenRepo stores users' personal access tokens in the "APIToken" table.
+type APITokenRepo struct {
+	db *sql.DB
+}
+
+func NewAPITokenRepo(db *sql.DB) *APITokenRepo {
+	return &APITokenRepo{db: db}
+}
+
+// Create stores a new token for the user and returns it along with the
+// token itself, which can't be looked up again.
+func (r *APITokenRepo) Create(userID, name string, scopes []string, expiresAt *time.Time) (*models.APIToken, string, error) {
+	secret, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	token := models.APITokenPrefix + secret
+	t := &models.APIToken{
+		ID:        xid.New().String(),
+		Name:      name,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now().UTC(),
+	}
+	_, err = r.db.Exec(
+		`INSERT INTO "APIToken" (id, userId, name, tokenHash, scopes, expiresAt, createdAt) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, userID, t.Name, hashToken(token), strings.Join(scopes, " "), t.ExpiresAt, t.CreatedAt,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	return t, token, nil
+}
+
+// ListByUser returns the user's tokens, newest first, including expired ones.
+func (r *APITokenRepo) ListByUser(userID string) ([]models.APIToken, error) {
+	rows, err := r.db.Query(
+		`SELECT id, name, scopes, expiresAt, lastUsedAt, createdAt FROM "APIToken"
+		 WHERE userId = ? ORDER BY createdAt DESC, id DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		var t models.APIToken
+		var scopes string
+		if err := rows.Scan(&t.ID, &t.Name, &scopes, &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		t.Scopes = strings.Fields(scopes)
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// Delete revokes one of the user's tokens and reports whether it existed.
+func (r *APITokenRepo) Delete(userID, id string) (bool, error) {
+	res, err := r.db.Exec(`DELETE FROM "APIToken" WHERE id = ? AND userId = ?`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteAll revokes all of the user's tokens.
+func (r *APITokenRepo) DeleteAll(userID string) error {
+	_, err := r.db.Exec(`DELETE FROM "APIToken" WHERE userId = ?`, userID)
+	return err
+}
+
+// Authenticate returns who a token acts for, or nil if it is unknown or
+// expired, and records that it was used. The auth middleware calls it for
+// every request made with a token.
+func (r *APITokenRepo) Authenticate(token string) (*models.APITokenOwner, error) {
+	owner := &models.APITokenOwner{}
+	var scopes string
+	now := time.Now().UTC()
+	err := r.db.QueryRow(
+		`SELECT t.id, t.userId, u.email, t.scopes FROM "APIToken" t
+		 JOIN "User" u ON u.id = t.userId
+		 WHERE t.tokenHash = ? AND (t.expiresAt IS NULL OR t.expiresAt > ?)`,
+		hashToken(token), now,
+	).Scan(&owner.TokenID, &owner.UserID, &owner.Email, &scopes)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	owner.Scopes = strings.Fields(scopes)
+
+	_, err = r.db.Exec(
+		`UPDATE "APIToken" SET lastUsedAt = ? WHERE id = ? AND (lastUsedAt IS NULL OR lastUsedAt < ?)`,
+		now, owner.TokenID, now.Add(-lastUsedInterval),
+	)
+	return owner, err
+}
@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// ErrInvalidRefreshToken is returned by Rotate for a refresh token that is
+// unknown, expired or revoked.
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// ErrRefreshTokenReused is returned by Rotate for a refresh token that was
+// already rotated. Its whole session is revoked, since either the user or
+// whoever stole the token is holding a copy.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// AuthSessionRepo stores login sessions in the "Session" table. A session
+// (a token family) starts at login and lives on through its refresh tokens;
+// each refresh token is one row, and access tokens carry the family id.
+type AuthSessionRepo struct {
+	db *sql.DB
+}
+
+func NewAuthSessionRepo(db *sql.DB) *AuthSessionRepo {
+	return &AuthSessionRepo{db: db}
+}
+
+// Create starts a session for the user and returns its id and first refresh
+// token, which expires after ttl.
+func (r *AuthSessionRepo) Create(userID string, ttl time.Duration) (string, string, error) {
+	familyID := xid.New().String()
+	token, err := insertRefreshToken(r.db, userID, familyID, ttl, time.Now().UTC())
+	if err != nil {
+		return "", "", err
+	}
+	return familyID, token, nil
+}
+
+// Rotate exchanges a refresh token for a new one in the same session and
+// returns the session's user and id along with it.
+func (r *AuthSessionRepo) Rotate(token string, ttl time.Duration) (userID, familyID, newToken string, err error) {
+	var id string
+	var expires time.Time
+	var rotatedAt, revokedAt sql.NullTime
+	err = r.db.QueryRow(
+		`SELECT id, userId, familyId, expires, rotatedAt, revokedAt FROM "Session" WHERE sessionToken = ?`,
+		hashToken(token),
+	).Scan(&id, &userID, &familyID, &expires, &rotatedAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return "", "", "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+	now := time.Now().UTC()
+	if revokedAt.Valid || !expires.After(now) {
+		return "", "", "", ErrInvalidRefreshToken
+	}
+	if rotatedAt.Valid {
+		return "", "", "", r.reused(familyID)
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", "", "", err
+	}
+	defer tx.Rollback()
+
+	// Only one of two concurrent refreshes with the same token can win
+	res, err := tx.Exec(`UPDATE "Session" SET rotatedAt = ? WHERE id = ? AND rotatedAt IS NULL`, now, id)
+	if err != nil {
+		return "", "", "", err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		tx.Rollback()
+		return "", "", "", r.reused(familyID)
+	}
+	newToken, err = insertRefreshToken(tx, userID, familyID, ttl, now)
+	if err != nil {
+		return "", "", "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", "", "", err
+	}
+	return userID, familyID, newToken, nil
+}
+
+func (r *AuthSessionRepo) reused(familyID string) error {
+	if err := r.Revoke(familyID); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+// IsActive reports whether the user's session has not been revoked. The
+// auth middleware calls it for every request.
+func (r *AuthSessionRepo) IsActive(familyID, userID string) (bool, error) {
+	var active bool
+	err := r.db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM "Session" WHERE familyId = ? AND userId = ? AND revokedAt IS NULL)`,
+		familyID, userID,
+	).Scan(&active)
+	return active, err
+}
+
+// Revoke ends a session: its refresh tokens stop working and so do the
+// access tokens issued with them.
+func (r *AuthSessionRepo) Revoke(familyID string) error {
+	_, err := r.db.Exec(
+		`UPDATE "Session" SET revokedAt = ? WHERE familyId = ? AND revokedAt IS NULL`,
+		time.Now().UTC(), familyID,
+	)
+	return err
+}
+
+// RevokeAll ends every session of the user and returns how many were active.
+func (r *AuthSessionRepo) RevokeAll(userID string) (int, error) {
+	var count int
+	err := r.db.QueryRow(
+		`SELECT COUNT(DISTINCT familyId) FROM "Session" WHERE userId = ? AND revokedAt IS NULL`,
+		userID,
+	).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	_, err = r.db.Exec(
+		`UPDATE "Session" SET revokedAt = ? WHERE userId = ? AND revokedAt IS NULL`,
+		time.Now().UTC(), userID,
+	)
+	return count, err
+}
+
+// RevokeOthers ends every session of the user except keepFamilyID, and
+// returns how many were active.
+func (r *AuthSessionRepo) RevokeOthers(userID, keepFamilyID string) (int, error) {
+	var count int
+	err := r.db.QueryRow(
+		`SELECT COUNT(DISTINCT familyId) FROM "Session" WHERE userId = ? AND familyId != ? AND revokedAt IS NULL`,
+		userID, keepFamilyID,
+	).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	_, err = r.db.Exec(
+		`UPDATE "Session" SET revokedAt = ? WHERE userId = ? AND familyId != ? AND revokedAt IS NULL`,
+		time.Now().UTC(), userID, keepFamilyID,
+	)
+	return count, err
+}
+
+func insertRefreshToken(q querier, userID, familyID string, ttl time.Duration, now time.Time) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = q.Exec(
+		`INSERT INTO "Session" (id, sessionToken, userId, familyId, expires, createdAt) VALUES (?, ?, ?, ?, ?, ?)`,
+		xid.New().String(), hashToken(token), userID, familyID, now.Add(ttl), now,
+	)
+	return token, err
+}
+
+// randomToken returns 256 random bits, URL-safe encoded.
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken is how refresh tokens, verification tokens, OAuth states and
+// API tokens are stored, so a copy of the database doesn't hand out
+// working tokens.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"database/sql"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/laptime"
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/rs/xid"
+)
+
+type CarClassRepo struct {
+	db *sql.DB
+}
+
+func NewCarClassRepo(db *sql.DB) *CarClassRepo {
+	return &CarClassRepo{db: db}
+}
+
+const carClassColumns = `id, code, name, category, paxIndex, createdAt, updatedAt`
+
+func scanCarClass(row interface{ Scan(...interface{}) error }, cc *models.CarClass) error {
+	return row.Scan(&cc.ID, &cc.Code, &cc.Name, &cc.Category, &cc.PaxIndex, &cc.CreatedAt, &cc.UpdatedAt)
+}
+
+// List returns the classes, optionally only those in one category, ordered
+// by category and code.
+func (r *CarClassRepo) List(category string) ([]models.CarClass, error) {
+	query := `SELECT ` + carClassColumns + ` FROM "CarClass"`
+	var args []interface{}
+	if category != "" {
+		query += ` WHERE category = ? COLLATE NOCASE`
+		args = append(args, category)
+	}
+	rows, err := r.db.Query(query+` ORDER BY category, code`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	classes := []models.CarClass{}
+	for rows.Next() {
+		var cc models.CarClass
+		if err := scanCarClass(rows, &cc); err != nil {
+			return nil, err
+		}
+		classes = append(classes, cc)
+	}
+	return classes, rows.Err()
+}
+
+func (r *CarClassRepo) FindByID(id string) (*models.CarClass, error) {
+	cc := &models.CarClass{}
+	err := scanCarClass(r.db.QueryRow(`SELECT `+carClassColumns+` FROM "CarClass" WHERE id = ?`, id), cc)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cc, nil
+}
+
+// FindByCode looks a class up by code, ignoring case.
+func (r *CarClassRepo) FindByCode(code string) (*models.CarClass, error) {
+	cc := &models.CarClass{}
+	err := scanCarClass(r.db.QueryRow(`SELECT `+carClassColumns+` FROM "CarClass" WHERE code = ? COLLATE NOCASE`, code), cc)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cc, nil
+}
+
+func (r *CarClassRepo) Create(req models.CarClassRequest) (*models.CarClass, error) {
+	now := time.Now().UTC()
+	id := xid.New().String()
+	_, err := r.db.Exec(
+		`INSERT INTO "CarClass" (id, code, name, category, paxIndex, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, req.Code, req.Name, req.Category, req.PaxIndex, now, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return r.FindByID(id)
+}
+
+func (r *CarClassRepo) Update(id string, req models.CarClassRequest) (*models.CarClass, error) {
+	_, err := r.db.Exec(
+		`UPDATE "CarClass" SET code = ?, name = ?, category = ?, paxIndex = ?, updatedAt = ? WHERE id = ?`,
+		req.Code, req.Name, req.Category, req.PaxIndex, time.Now().UTC(), id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return r.FindByID(id)
+}
+
+// Upsert creates a class or updates the one with the same code, and reports
+// whether it was created.
+func (r *CarClassRepo) Upsert(req models.CarClassRequest) (bool, error) {
+	existing, err := r.FindByCode(req.Code)
+	if err != nil {
+		return false, err
+	}
+	if existing == nil {
+		_, err := r.Create(req)
+		return err == nil, err
+	}
+	_, err = r.Update(existing.ID, req)
+	return false, err
+}
+
+// Delete removes a class. Cars in it are left without a class.
+func (r *CarClassRepo) Delete(id string) error {
+	_, err := r.db.Exec(`DELETE FROM "CarClass" WHERE id = ?`, id)
+	return err
+}
+
+// classesForCars loads the classes of many cars at once, keyed by car ID.
+// Cars without a class are left out.
+func classesForCars(q querier, carIDs []string) (map[string]models.CarClassBrief, error) {
+	classes := make(map[string]models.CarClassBrief, len(carIDs))
+	if len(carIDs) == 0 {
+		return classes, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(carIDs)), ",")
+	args := make([]interface{}, len(carIDs))
+	for i, id := range carIDs {
+		args[i] = id
+	}
+
+	rows, err := q.Query(
+		`SELECT c.id, cc.id, cc.code, cc.name, cc.paxIndex
+		FROM "Car" c JOIN "CarClass" cc ON cc.id = c.classId
+		WHERE c.id IN (`+placeholders+`)`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var carID string
+		var cc models.CarClassBrief
+		if err := rows.Scan(&carID, &cc.ID, &cc.Code, &cc.Name, &cc.PaxIndex); err != nil {
+			return nil, err
+		}
+		classes[carID] = cc
+	}
+	return classes, rows.Err()
+}
+
+// paxTime works out an autocross run's PAX time from its car's current
+// class: its adjusted time, or its lap time if it has no result, times the
+// class index. It is nil for other event types, cars without a class and
+// runs without a time.
+func paxTime(eventType string, class *models.CarClassBrief, lapTimeMs *int64, result *models.LapResult) *models.PaxTime {
+	if eventType != string(models.EventAutocross) || class == nil || lapTimeMs == nil {
+		return nil
+	}
+	ms := *lapTimeMs
+	if result != nil && result.Autocross != nil {
+		if result.Autocross.AdjustedTimeMs == nil {
+			return nil
+		}
+		ms = *result.Autocross.AdjustedTimeMs
+	}
+	paxMs := int64(math.Round(float64(ms) * class.PaxIndex))
+	return &models.PaxTime{
+		Class:    class.Code,
+		PaxIndex: class.PaxIndex,
+		Time:     laptime.Format(paxMs),
+		TimeMs:   paxMs,
+	}
+}
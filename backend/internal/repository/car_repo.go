@@ -16,9 +16,28 @@ func NewCarRepo(db *sql.DB) *CarRepo {
 	return &CarRepo{db: db}
 }
 
+// carSelect selects cars along with their class, for scanCar.
+const carSelect = `SELECT c.id, c.make, c.model, c.year, c.userId, c.createdAt, c.updatedAt,
+	cc.id, cc.code, cc.name, cc.paxIndex
+	FROM "Car" c LEFT JOIN "CarClass" cc ON cc.id = c.classId`
+
+func scanCar(row interface{ Scan(...interface{}) error }, c *models.Car) error {
+	var classID, code, name sql.NullString
+	var paxIndex sql.NullFloat64
+	if err := row.Scan(&c.ID, &c.Make, &c.Model, &c.Year, &c.UserID, &c.CreatedAt, &c.UpdatedAt,
+		&classID, &code, &name, &paxIndex); err != nil {
+		return err
+	}
+	c.Class = nil
+	if classID.Valid {
+		c.Class = &models.CarClassBrief{ID: classID.String, Code: code.String, Name: name.String, PaxIndex: paxIndex.Float64}
+	}
+	return nil
+}
+
 func (r *CarRepo) FindByUserID(userID string) ([]models.Car, error) {
 	rows, err := r.db.Query(
-		`SELECT id, make, model, year, userId, createdAt, updatedAt FROM "Car" WHERE userId = ? ORDER BY createdAt DESC`,
+		carSelect+` WHERE c.userId = ? ORDER BY c.createdAt DESC`,
 		userID,
 	)
 	if err != nil {
@@ -29,7 +48,7 @@ func (r *CarRepo) FindByUserID(userID string) ([]models.Car, error) {
 	var cars []models.Car
 	for rows.Next() {
 		var c models.Car
-		if err := rows.Scan(&c.ID, &c.Make, &c.Model, &c.Year, &c.UserID, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		if err := scanCar(rows, &c); err != nil {
 			return nil, err
 		}
 		cars = append(cars, c)
@@ -55,10 +74,7 @@ func (r *CarRepo) FindByUserID(userID string) ([]models.Car, error) {
 
 func (r *CarRepo) FindByIDAndUser(id, userID string) (*models.Car, error) {
 	c := &models.Car{}
-	err := r.db.QueryRow(
-		`SELECT id, make, model, year, userId, createdAt, updatedAt FROM "Car" WHERE id = ? AND userId = ?`,
-		id, userID,
-	).Scan(&c.ID, &c.Make, &c.Model, &c.Year, &c.UserID, &c.CreatedAt, &c.UpdatedAt)
+	err := scanCar(r.db.QueryRow(carSelect+` WHERE c.id = ? AND c.userId = ?`, id, userID), c)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -105,9 +121,7 @@ func (r *CarRepo) Update(id, make, model string, year int) (*models.Car, error)
 		return nil, err
 	}
 	c := &models.Car{}
-	err = r.db.QueryRow(
-		`SELECT id, make, model, year, userId, createdAt, updatedAt FROM "Car" WHERE id = ?`, id,
-	).Scan(&c.ID, &c.Make, &c.Model, &c.Year, &c.UserID, &c.CreatedAt, &c.UpdatedAt)
+	err = scanCar(r.db.QueryRow(carSelect+` WHERE c.id = ?`, id), c)
 	if err != nil {
 		return nil, err
 	}
@@ -119,6 +133,13 @@ func (r *CarRepo) Update(id, make, model string, year int) (*models.Car, error)
 	return c, nil
 }
 
+// SetClass puts a car in a class, or takes it out of its class when classID
+// is nil.
+func (r *CarRepo) SetClass(id string, classID *string) error {
+	_, err := r.db.Exec(`UPDATE "Car" SET classId = ?, updatedAt = ? WHERE id = ?`, classID, time.Now().UTC(), id)
+	return err
+}
+
 func (r *CarRepo) Delete(id string) error {
 	_, err := r.db.Exec(`DELETE FROM "Car" WHERE id = ?`, id)
 	return err
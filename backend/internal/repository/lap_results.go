@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/joezmuda/trackside-backend/internal/laptime"
+	"github.com/joezmuda/trackside-backend/internal/models"
+)
+
+// loadResults loads the event-specific results of many lap records at once,
+// keyed by lap record ID. Laps without a result are left out.
+func loadResults(q querier, lapIDs []string) (map[string]*models.LapResult, error) {
+	results := make(map[string]*models.LapResult)
+	if len(lapIDs) == 0 {
+		return results, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(lapIDs)), ",")
+	args := make([]interface{}, len(lapIDs))
+	for i, id := range lapIDs {
+		args[i] = id
+	}
+	result := func(id string) *models.LapResult {
+		if results[id] == nil {
+			results[id] = &models.LapResult{}
+		}
+		return results[id]
+	}
+
+	rows, err := q.Query(
+		`SELECT lapRecordId, reactionMs, sixtyFootMs, threeThirtyMs, eighthMileMs, quarterMileMs, trapSpeedMph
+		FROM "DragResult" WHERE lapRecordId IN (`+placeholders+`)`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id string
+		var reaction, sixty, threeThirty, eighth, quarter sql.NullInt64
+		d := &models.DragResult{}
+		if err := rows.Scan(&id, &reaction, &sixty, &threeThirty, &eighth, &quarter, &d.TrapSpeedMph); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		d.ReactionTime = formattedMs(reaction)
+		d.SixtyFoot = formattedMs(sixty)
+		d.ThreeThirty = formattedMs(threeThirty)
+		d.EighthMile = formattedMs(eighth)
+		d.QuarterMile = formattedMs(quarter)
+		d.RedLight = reaction.Valid && reaction.Int64 < 0
+		result(id).Drag = d
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(
+		`SELECT lapRecordId, cones, dnf, offCourse, adjustedTimeMs
+		FROM "AutocrossResult" WHERE lapRecordId IN (`+placeholders+`)`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id string
+		var adjusted sql.NullInt64
+		a := &models.AutocrossResult{}
+		if err := rows.Scan(&id, &a.Cones, &a.DNF, &a.OffCourse, &adjusted); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if adjusted.Valid {
+			a.AdjustedTimeMs = &adjusted.Int64
+			a.AdjustedTime = formattedMs(adjusted)
+		}
+		result(id).Autocross = a
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(
+		`SELECT lapRecordId, category, score
+		FROM "DriftScore" WHERE lapRecordId IN (`+placeholders+`)
+		ORDER BY lapRecordId, position`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var s models.DriftScore
+		if err := rows.Scan(&id, &s.Category, &s.Score); err != nil {
+			return nil, err
+		}
+		r := result(id)
+		if r.Drift == nil {
+			r.Drift = &models.DriftResult{}
+		}
+		r.Drift.Scores = append(r.Drift.Scores, s)
+		r.Drift.Total = models.DriftTotal(r.Drift.Scores)
+	}
+	return results, rows.Err()
+}
+
+// writeResult replaces a lap's event-specific result. The result should
+// already be validated, with its derived fields filled in.
+func writeResult(q querier, lapID string, result *models.LapResult) error {
+	for _, table := range []string{"DragResult", "AutocrossResult", "DriftScore"} {
+		if _, err := q.Exec(`DELETE FROM "`+table+`" WHERE lapRecordId = ?`, lapID); err != nil {
+			return err
+		}
+	}
+	if result == nil {
+		return nil
+	}
+
+	if d := result.Drag; d != nil {
+		if _, err := q.Exec(
+			`INSERT INTO "DragResult" (lapRecordId, reactionMs, sixtyFootMs, threeThirtyMs, eighthMileMs, quarterMileMs, trapSpeedMph)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			lapID, parsedMs(d.ReactionTime), parsedMs(d.SixtyFoot), parsedMs(d.ThreeThirty),
+			parsedMs(d.EighthMile), parsedMs(d.QuarterMile), d.TrapSpeedMph,
+		); err != nil {
+			return err
+		}
+	}
+	if a := result.Autocross; a != nil {
+		if _, err := q.Exec(
+			`INSERT INTO "AutocrossResult" (lapRecordId, cones, dnf, offCourse, adjustedTimeMs) VALUES (?, ?, ?, ?, ?)`,
+			lapID, a.Cones, a.DNF, a.OffCourse, a.AdjustedTimeMs,
+		); err != nil {
+			return err
+		}
+	}
+	if d := result.Drift; d != nil {
+		for i, s := range d.Scores {
+			if _, err := q.Exec(
+				`INSERT INTO "DriftScore" (lapRecordId, position, category, score) VALUES (?, ?, ?, ?)`,
+				lapID, i+1, s.Category, s.Score,
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func formattedMs(ms sql.NullInt64) *string {
+	if !ms.Valid {
+		return nil
+	}
+	s := laptime.Format(ms.Int64)
+	return &s
+}
+
+// parsedMs reads back a time written by laptime.Format, which may be
+// negative, for storage. It is nil for an empty field.
+func parsedMs(s *string) interface{} {
+	if s == nil {
+		return nil
+	}
+	t, negative := strings.CutPrefix(*s, "-")
+	ms, err := laptime.Parse(t)
+	if err == laptime.ErrZero {
+		return int64(0)
+	}
+	if err != nil {
+		return nil
+	}
+	if negative {
+		return -ms
+	}
+	return ms
+}
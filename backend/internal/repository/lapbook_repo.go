@@ -2,10 +2,12 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/joezmuda/trackside-backend/internal/laptime"
 	"github.com/joezmuda/trackside-backend/internal/models"
 	"github.com/rs/xid"
 )
@@ -18,21 +20,22 @@ func NewLapbookRepo(db *sql.DB) *LapbookRepo {
 	return &LapbookRepo{db: db}
 }
 
-func (r *LapbookRepo) List(driverID, trackID, eventType, carID string) ([]models.LapRecordWithDetails, error) {
-	query := `SELECT lr.id, lr.lapTime, lr.conditions, lr.notes,
-		lr.tirePressureFL, lr.tirePressureFR, lr.tirePressureRL, lr.tirePressureRR,
-		lr.fuelLevel, lr.camberFL, lr.camberFR, lr.camberRL, lr.camberRR,
-		lr.casterFL, lr.casterFR, lr.toeFL, lr.toeFR, lr.toeRL, lr.toeRR,
-		lr.trackId, lr.trackEventId, lr.carId, lr.driverId, lr.createdAt, lr.updatedAt,
-		t.id, t.name, t.location,
-		c.id, c.make, c.model, c.year
-		FROM "LapRecord" lr
-		JOIN "Track" t ON lr.trackId = t.id
-		JOIN "Car" c ON lr.carId = c.id
-		WHERE lr.driverId = ?`
+// isPersonalBestExpr is true for a lap that is the driver's fastest for its
+// track, event, car and conditions. On a tie the earlier lap keeps the PB.
+const isPersonalBestExpr = `(lr.lapTimeMs IS NOT NULL AND NOT EXISTS (
+		SELECT 1 FROM "LapRecord" o
+		WHERE o.driverId = lr.driverId AND o.trackId = lr.trackId AND o.carId = lr.carId
+			AND o.trackEventId IS lr.trackEventId AND o.conditions = lr.conditions
+			AND o.lapTimeMs IS NOT NULL
+			AND (o.lapTimeMs < lr.lapTimeMs OR (o.lapTimeMs = lr.lapTimeMs AND (o.createdAt < lr.createdAt OR (o.createdAt = lr.createdAt AND o.id < lr.id))))
+	))`
+
+// lapRecordEditedExpr is true once a lap record has been changed after it was logged.
+const lapRecordEditedExpr = `EXISTS (SELECT 1 FROM "LapRecordEdit" e WHERE e.lapRecordId = lr.id)`
 
+func (r *LapbookRepo) List(driverID, trackID, eventType, carID, sessionID string) ([]models.LapRecordWithDetails, error) {
+	conditions := []string{`lr.driverId = ?`}
 	args := []interface{}{driverID}
-	conditions := []string{}
 
 	if trackID != "" {
 		conditions = append(conditions, `lr.trackId = ?`)
@@ -46,11 +49,42 @@ func (r *LapbookRepo) List(driverID, trackID, eventType, carID string) ([]models
 		conditions = append(conditions, `EXISTS (SELECT 1 FROM "TrackEvent" te WHERE te.id = lr.trackEventId AND te.eventType = ?)`)
 		args = append(args, eventType)
 	}
+	if sessionID != "" {
+		conditions = append(conditions, `lr.sessionId = ?`)
+		args = append(args, sessionID)
+	}
 
-	if len(conditions) > 0 {
-		query += " AND " + strings.Join(conditions, " AND ")
+	return r.queryRecords(conditions, args, "lr.createdAt DESC")
+}
+
+// ListForSession returns the laps of a session in lap number order.
+func (r *LapbookRepo) ListForSession(sessionID, driverID string) ([]models.LapRecordWithDetails, error) {
+	return r.queryRecords([]string{`lr.sessionId = ?`, `lr.driverId = ?`}, []interface{}{sessionID, driverID}, "lr.lapNumber, lr.createdAt")
+}
+
+// FindWithDetails returns one of a driver's lap records, or nil if the
+// driver has no record with that id.
+func (r *LapbookRepo) FindWithDetails(id, driverID string) (*models.LapRecordWithDetails, error) {
+	records, err := r.queryRecords([]string{`lr.id = ?`, `lr.driverId = ?`}, []interface{}{id, driverID}, "lr.id")
+	if err != nil || len(records) == 0 {
+		return nil, err
 	}
-	query += " ORDER BY lr.createdAt DESC"
+	return &records[0], nil
+}
+
+func (r *LapbookRepo) queryRecords(conditions []string, args []interface{}, orderBy string) ([]models.LapRecordWithDetails, error) {
+	query := `SELECT lr.id, lr.lapTime, lr.lapTimeMs, ` + isPersonalBestExpr + `, ` + lapRecordEditedExpr + `, lr.conditions, lr.notes,
+		lr.tirePressureFL, lr.tirePressureFR, lr.tirePressureRL, lr.tirePressureRR,
+		lr.fuelLevel, lr.camberFL, lr.camberFR, lr.camberRL, lr.camberRR,
+		lr.casterFL, lr.casterFR, lr.toeFL, lr.toeFR, lr.toeRL, lr.toeRR,
+		lr.trackId, lr.trackEventId, lr.carId, lr.sessionId, lr.lapNumber, lr.driverId, lr.createdAt, lr.updatedAt,
+		t.id, t.name, t.location,
+		c.id, c.make, c.model, c.year
+		FROM "LapRecord" lr
+		JOIN "Track" t ON lr.trackId = t.id
+		JOIN "Car" c ON lr.carId = c.id
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY ` + orderBy
 
 	rows, err := r.db.Query(query, args...)
 	if err != nil {
@@ -62,11 +96,11 @@ func (r *LapbookRepo) List(driverID, trackID, eventType, carID string) ([]models
 	for rows.Next() {
 		var lr models.LapRecordWithDetails
 		if err := rows.Scan(
-			&lr.ID, &lr.LapTime, &lr.Conditions, &lr.Notes,
+			&lr.ID, &lr.LapTime, &lr.LapTimeMs, &lr.IsPersonalBest, &lr.Edited, &lr.Conditions, &lr.Notes,
 			&lr.TirePressureFL, &lr.TirePressureFR, &lr.TirePressureRL, &lr.TirePressureRR,
 			&lr.FuelLevel, &lr.CamberFL, &lr.CamberFR, &lr.CamberRL, &lr.CamberRR,
 			&lr.CasterFL, &lr.CasterFR, &lr.ToeFL, &lr.ToeFR, &lr.ToeRL, &lr.ToeRR,
-			&lr.TrackID, &lr.TrackEventID, &lr.CarID, &lr.DriverID, &lr.CreatedAt, &lr.UpdatedAt,
+			&lr.TrackID, &lr.TrackEventID, &lr.CarID, &lr.SessionID, &lr.LapNumber, &lr.DriverID, &lr.CreatedAt, &lr.UpdatedAt,
 			&lr.Track.ID, &lr.Track.Name, &lr.Track.Location,
 			&lr.Car.ID, &lr.Car.Make, &lr.Car.Model, &lr.Car.Year,
 		); err != nil {
@@ -92,62 +126,126 @@ func (r *LapbookRepo) List(driverID, trackID, eventType, carID string) ([]models
 		}
 	}
 
+	if err := r.fillSplits(records); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(records))
+	for i := range records {
+		ids[i] = records[i].ID
+	}
+	results, err := loadResults(r.db, ids)
+	if err != nil {
+		return nil, err
+	}
+	carIDs := make([]string, len(records))
+	for i := range records {
+		carIDs[i] = records[i].CarID
+	}
+	classes, err := classesForCars(r.db, carIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range records {
+		rec := &records[i]
+		rec.Result = results[rec.ID]
+		if class, ok := classes[rec.CarID]; ok && rec.TrackEvent != nil {
+			rec.Pax = paxTime(rec.TrackEvent.EventType, &class, rec.LapTimeMs, rec.Result)
+		}
+	}
+
 	if records == nil {
 		records = []models.LapRecordWithDetails{}
 	}
 	return records, nil
 }
 
-func (r *LapbookRepo) Create(req models.LapRecordRequest, driverID string) (*models.LapRecordWithDetails, error) {
-	now := time.Now().UTC()
+// fillSplits loads the sector splits of the given records in one query.
+func (r *LapbookRepo) fillSplits(records []models.LapRecordWithDetails) error {
+	if len(records) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(records))
+	args := make([]interface{}, len(records))
+	index := make(map[string]int, len(records))
+	for i := range records {
+		placeholders[i] = "?"
+		args[i] = records[i].ID
+		index[records[i].ID] = i
+		records[i].Splits = []models.LapSplit{}
+	}
+
+	rows, err := r.db.Query(
+		`SELECT ls.lapRecordId, s.id, s.position, s.name, ls.timeMs
+		FROM "LapSplit" ls
+		JOIN "TrackSector" s ON s.id = ls.sectorId
+		WHERE ls.lapRecordId IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY s.position`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var lapID string
+		var split models.LapSplit
+		if err := rows.Scan(&lapID, &split.SectorID, &split.Position, &split.Name, &split.TimeMs); err != nil {
+			return err
+		}
+		split.Time = laptime.Format(split.TimeMs)
+		rec := &records[index[lapID]]
+		rec.Splits = append(rec.Splits, split)
+	}
+	return rows.Err()
+}
+
+// Create stores a lap record with its sector splits, if any. req.LapTime
+// should already be in canonical form matching lapTimeMs, which is nil for
+// a run without a time, and splitsMs must have one time per sector of the
+// track.
+func (r *LapbookRepo) Create(req models.LapRecordRequest, lapTimeMs *int64, splitsMs []int64, driverID string) (*models.LapRecordWithDetails, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	id, err := insertLapRecord(tx, req, lapTimeMs, splitsMs, driverID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return r.FindWithDetails(id, driverID)
+}
+
+// insertLapRecord inserts a lap record with its splits and result and
+// returns its id.
+func insertLapRecord(q querier, req models.LapRecordRequest, lapTimeMs *int64, splitsMs []int64, driverID string, now time.Time) (string, error) {
 	id := xid.New().String()
-	_, err := r.db.Exec(
-		`INSERT INTO "LapRecord" (id, lapTime, conditions, notes,
+	_, err := q.Exec(
+		`INSERT INTO "LapRecord" (id, lapTime, lapTimeMs, conditions, notes,
 			tirePressureFL, tirePressureFR, tirePressureRL, tirePressureRR,
 			fuelLevel, camberFL, camberFR, camberRL, camberRR,
 			casterFL, casterFR, toeFL, toeFR, toeRL, toeRR,
-			trackId, trackEventId, carId, driverId, createdAt, updatedAt)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		id, req.LapTime, req.Conditions, req.Notes,
+			trackId, trackEventId, carId, sessionId, lapNumber, driverId, createdAt, updatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, req.LapTime, lapTimeMs, req.Conditions, req.Notes,
 		req.TirePressureFL, req.TirePressureFR, req.TirePressureRL, req.TirePressureRR,
 		req.FuelLevel, req.CamberFL, req.CamberFR, req.CamberRL, req.CamberRR,
 		req.CasterFL, req.CasterFR, req.ToeFL, req.ToeFR, req.ToeRL, req.ToeRR,
-		req.TrackID, req.TrackEventID, req.CarID, driverID, now, now,
+		req.TrackID, req.TrackEventID, req.CarID, req.SessionID, req.LapNumber, driverID, now, now,
 	)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-
-	lr := &models.LapRecordWithDetails{
-		ID: id, LapTime: req.LapTime, Conditions: req.Conditions, Notes: req.Notes,
-		TirePressureFL: req.TirePressureFL, TirePressureFR: req.TirePressureFR,
-		TirePressureRL: req.TirePressureRL, TirePressureRR: req.TirePressureRR,
-		FuelLevel: req.FuelLevel,
-		CamberFL: req.CamberFL, CamberFR: req.CamberFR, CamberRL: req.CamberRL, CamberRR: req.CamberRR,
-		CasterFL: req.CasterFL, CasterFR: req.CasterFR,
-		ToeFL: req.ToeFL, ToeFR: req.ToeFR, ToeRL: req.ToeRL, ToeRR: req.ToeRR,
-		TrackID: req.TrackID, TrackEventID: req.TrackEventID, CarID: req.CarID,
-		DriverID: driverID, CreatedAt: now, UpdatedAt: now,
+	if err := writeSplits(q, id, req.TrackID, splitsMs); err != nil {
+		return "", err
 	}
-
-	r.db.QueryRow(`SELECT id, name, location FROM "Track" WHERE id = ?`, req.TrackID).Scan(
-		&lr.Track.ID, &lr.Track.Name, &lr.Track.Location,
-	)
-	r.db.QueryRow(`SELECT id, make, model, year FROM "Car" WHERE id = ?`, req.CarID).Scan(
-		&lr.Car.ID, &lr.Car.Make, &lr.Car.Model, &lr.Car.Year,
-	)
-
-	if req.TrackEventID != nil {
-		te := &models.TrackEvent{}
-		err := r.db.QueryRow(
-			`SELECT id, eventType, trackId FROM "TrackEvent" WHERE id = ?`, *req.TrackEventID,
-		).Scan(&te.ID, &te.EventType, &te.TrackID)
-		if err == nil {
-			lr.TrackEvent = te
-		}
-	}
-
-	return lr, nil
+	return id, writeResult(q, id, req.Result)
 }
 
 func (r *LapbookRepo) FindByIDAndDriver(id, driverID string) (*models.LapRecord, error) {
@@ -164,6 +262,302 @@ func (r *LapbookRepo) FindByIDAndDriver(id, driverID string) (*models.LapRecord,
 	return lr, nil
 }
 
+// GetEditable loads the editable fields of one of a driver's lap records,
+// so a partial update can be applied on top of them. It returns nil if the
+// driver has no record with that id.
+func (r *LapbookRepo) GetEditable(id, driverID string) (*models.LapRecordRequest, error) {
+	return getEditable(r.db, id, driverID)
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func getEditable(q querier, id, driverID string) (*models.LapRecordRequest, error) {
+	req := &models.LapRecordRequest{}
+	cols := lapRecordColumns(req)
+	names := make([]string, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i, col := range cols {
+		names[i] = col.name
+		dest[i] = col.ptr
+	}
+	err := q.QueryRow(
+		`SELECT `+strings.Join(names, ", ")+` FROM "LapRecord" WHERE id = ? AND driverId = ?`, id, driverID,
+	).Scan(dest...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Splits only count when there is one for every sector of the track
+	var sectors int
+	if err := q.QueryRow(`SELECT COUNT(*) FROM "TrackSector" WHERE trackId = ?`, req.TrackID).Scan(&sectors); err != nil {
+		return nil, err
+	}
+	rows, err := q.Query(
+		`SELECT ls.timeMs FROM "LapSplit" ls
+		JOIN "TrackSector" s ON s.id = ls.sectorId
+		WHERE ls.lapRecordId = ? ORDER BY s.position`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var splits []string
+	for rows.Next() {
+		var ms int64
+		if err := rows.Scan(&ms); err != nil {
+			return nil, err
+		}
+		splits = append(splits, laptime.Format(ms))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if sectors > 0 && len(splits) == sectors {
+		req.Splits = splits
+	}
+	rows.Close()
+
+	results, err := loadResults(q, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	req.Result = results[id]
+	return req, nil
+}
+
+// writeSplits replaces a lap's sector splits. splitsMs is either empty or
+// has one time per sector of the track, in sector order.
+func writeSplits(q querier, lapID, trackID string, splitsMs []int64) error {
+	if _, err := q.Exec(`DELETE FROM "LapSplit" WHERE lapRecordId = ?`, lapID); err != nil {
+		return err
+	}
+	if len(splitsMs) == 0 {
+		return nil
+	}
+
+	rows, err := q.Query(`SELECT id FROM "TrackSector" WHERE trackId = ? ORDER BY position`, trackID)
+	if err != nil {
+		return err
+	}
+	var sectorIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		sectorIDs = append(sectorIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(sectorIDs) != len(splitsMs) {
+		return fmt.Errorf("got %d splits for %d sectors", len(splitsMs), len(sectorIDs))
+	}
+
+	for i, ms := range splitsMs {
+		if _, err := q.Exec(
+			`INSERT INTO "LapSplit" (lapRecordId, sectorId, timeMs) VALUES (?, ?, ?)`, lapID, sectorIDs[i], ms,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lapRecordColumn pairs a LapRecord column with the request field that
+// holds its value. Column names double as the keys in the edit history.
+type lapRecordColumn struct {
+	name string
+	ptr  interface{}
+}
+
+func lapRecordColumns(req *models.LapRecordRequest) []lapRecordColumn {
+	return []lapRecordColumn{
+		{"lapTime", &req.LapTime}, {"conditions", &req.Conditions}, {"notes", &req.Notes},
+		{"tirePressureFL", &req.TirePressureFL}, {"tirePressureFR", &req.TirePressureFR},
+		{"tirePressureRL", &req.TirePressureRL}, {"tirePressureRR", &req.TirePressureRR},
+		{"fuelLevel", &req.FuelLevel},
+		{"camberFL", &req.CamberFL}, {"camberFR", &req.CamberFR}, {"camberRL", &req.CamberRL}, {"camberRR", &req.CamberRR},
+		{"casterFL", &req.CasterFL}, {"casterFR", &req.CasterFR},
+		{"toeFL", &req.ToeFL}, {"toeFR", &req.ToeFR}, {"toeRL", &req.ToeRL}, {"toeRR", &req.ToeRR},
+		{"trackId", &req.TrackID}, {"trackEventId", &req.TrackEventID}, {"carId", &req.CarID},
+		{"sessionId", &req.SessionID}, {"lapNumber", &req.LapNumber},
+	}
+}
+
+// columnValue dereferences a request field so values can be compared and
+// stored in the edit history, with nil for an empty optional field.
+func columnValue(ptr interface{}) interface{} {
+	switch v := ptr.(type) {
+	case *string:
+		return *v
+	case **string:
+		if *v == nil {
+			return nil
+		}
+		return **v
+	case **float64:
+		if *v == nil {
+			return nil
+		}
+		return **v
+	case **int:
+		if *v == nil {
+			return nil
+		}
+		return **v
+	}
+	return nil
+}
+
+// Update replaces the editable fields, splits and result of a lap record
+// and records which fields changed in its edit history. Nothing is written
+// when no field changed. req.LapTime, req.Splits and req.Result should
+// already be in canonical form matching lapTimeMs and splitsMs.
+func (r *LapbookRepo) Update(id, driverID string, req models.LapRecordRequest, lapTimeMs *int64, splitsMs []int64) (*models.LapRecordWithDetails, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	current, err := getEditable(tx, id, driverID)
+	if err != nil || current == nil {
+		return nil, err
+	}
+
+	oldCols := lapRecordColumns(current)
+	newCols := lapRecordColumns(&req)
+	changes := make(map[string]models.FieldChange)
+	sets := make([]string, 0, len(newCols)+2)
+	args := make([]interface{}, 0, len(newCols)+3)
+	for i, col := range newCols {
+		from, to := columnValue(oldCols[i].ptr), columnValue(col.ptr)
+		if from != to {
+			changes[col.name] = models.FieldChange{From: from, To: to}
+		}
+		sets = append(sets, col.name+" = ?")
+		args = append(args, to)
+	}
+	splitsChanged := strings.Join(current.Splits, ",") != strings.Join(req.Splits, ",")
+	if splitsChanged {
+		changes["splits"] = models.FieldChange{From: current.Splits, To: req.Splits}
+	}
+	oldResult, err := json.Marshal(current.Result)
+	if err != nil {
+		return nil, err
+	}
+	newResult, err := json.Marshal(req.Result)
+	if err != nil {
+		return nil, err
+	}
+	if string(oldResult) != string(newResult) {
+		changes["result"] = models.FieldChange{From: current.Result, To: req.Result}
+	}
+
+	if len(changes) > 0 {
+		now := time.Now().UTC()
+		sets = append(sets, "lapTimeMs = ?", "updatedAt = ?")
+		args = append(args, lapTimeMs, now, id)
+		if _, err := tx.Exec(`UPDATE "LapRecord" SET `+strings.Join(sets, ", ")+` WHERE id = ?`, args...); err != nil {
+			return nil, err
+		}
+		// Splits are positional, so a lap moved to another track needs them rewritten
+		if _, moved := changes["trackId"]; splitsChanged || moved {
+			if err := writeSplits(tx, id, req.TrackID, splitsMs); err != nil {
+				return nil, err
+			}
+		}
+		if _, ok := changes["result"]; ok {
+			if err := writeResult(tx, id, req.Result); err != nil {
+				return nil, err
+			}
+		}
+
+		changesJSON, err := json.Marshal(changes)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO "LapRecordEdit" (id, lapRecordId, editedById, changes, createdAt) VALUES (?, ?, ?, ?, ?)`,
+			xid.New().String(), id, driverID, string(changesJSON), now,
+		); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return r.FindWithDetails(id, driverID)
+}
+
+// History lists the edits made to a lap record, oldest first.
+func (r *LapbookRepo) History(id string) ([]models.LapRecordEdit, error) {
+	rows, err := r.db.Query(
+		`SELECT e.id, e.lapRecordId, e.editedById, e.changes, e.createdAt, u.id, u.name
+		FROM "LapRecordEdit" e
+		JOIN "User" u ON e.editedById = u.id
+		WHERE e.lapRecordId = ?
+		ORDER BY CAST(e.createdAt AS TEXT) ASC, e.id ASC`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []models.LapRecordEdit
+	for rows.Next() {
+		var e models.LapRecordEdit
+		var changes string
+		if err := rows.Scan(&e.ID, &e.LapRecordID, &e.EditedByID, &changes, &e.CreatedAt,
+			&e.EditedBy.ID, &e.EditedBy.Name); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(changes), &e.Changes); err != nil {
+			return nil, fmt.Errorf("lap record edit %s: %w", e.ID, err)
+		}
+		history = append(history, e)
+	}
+	if history == nil {
+		history = []models.LapRecordEdit{}
+	}
+	return history, rows.Err()
+}
+
+// NextLapNumber returns the lap number after the highest one in a session,
+// ignoring the lap record excludeID.
+func (r *LapbookRepo) NextLapNumber(sessionID, excludeID string) (int, error) {
+	var next int
+	err := r.db.QueryRow(
+		`SELECT COALESCE(MAX(lapNumber), 0) + 1 FROM "LapRecord" WHERE sessionId = ? AND id != ?`, sessionID, excludeID,
+	).Scan(&next)
+	return next, err
+}
+
+// LapNumberTaken reports whether another lap record in the session already
+// has the given lap number.
+func (r *LapbookRepo) LapNumberTaken(sessionID string, lapNumber int, excludeID string) (bool, error) {
+	var taken bool
+	err := r.db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM "LapRecord" WHERE sessionId = ? AND lapNumber = ? AND id != ?)`,
+		sessionID, lapNumber, excludeID,
+	).Scan(&taken)
+	return taken, err
+}
+
 func (r *LapbookRepo) Delete(id string) error {
 	_, err := r.db.Exec(`DELETE FROM "LapRecord" WHERE id = ?`, id)
 	return err
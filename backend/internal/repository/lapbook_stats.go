@@ -0,0 +1,387 @@
+package repository
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/laptime"
+	"github.com/joezmuda/trackside-backend/internal/models"
+)
+
+// statsLap is one lap as loaded for Stats.
+type statsLap struct {
+	id         string
+	lapTimeMs  int64
+	conditions string
+	createdAt  time.Time
+	eventID    *string
+	eventType  *string
+	sessionID  *string
+	// sessionDate and runGroup are set when the lap belongs to a session
+	sessionDate *string
+	runGroup    *string
+	track       models.TrackBrief
+	car         models.CarWithID
+}
+
+// comboKey identifies laps that are comparable with each other.
+func (l *statsLap) comboKey() string {
+	event := ""
+	if l.eventID != nil {
+		event = *l.eventID
+	}
+	return l.track.ID + "|" + event + "|" + l.car.ID + "|" + l.conditions
+}
+
+// date is the session date for a lap in a session, else the UTC day it was logged.
+func (l *statsLap) date() string {
+	if l.sessionDate != nil {
+		return *l.sessionDate
+	}
+	return l.createdAt.UTC().Format(time.DateOnly)
+}
+
+// groupKey identifies the session a lap counts towards.
+func (l *statsLap) groupKey() string {
+	if l.sessionID != nil {
+		return "session:" + *l.sessionID
+	}
+	return "day:" + l.date()
+}
+
+// Stats computes personal bests, trends and per-session figures for a
+// driver's laps. Laps attached to a session are grouped by that session;
+// other laps of one combination logged on the same UTC day count as one
+// session.
+func (r *LapbookRepo) Stats(driverID, trackID, eventType, carID, conditions string) (*models.LapbookStats, error) {
+	where := []string{`lr.driverId = ?`, `lr.lapTimeMs IS NOT NULL`}
+	args := []interface{}{driverID}
+	if trackID != "" {
+		where = append(where, `lr.trackId = ?`)
+		args = append(args, trackID)
+	}
+	if carID != "" {
+		where = append(where, `lr.carId = ?`)
+		args = append(args, carID)
+	}
+	if eventType != "" {
+		where = append(where, `te.eventType = ?`)
+		args = append(args, eventType)
+	}
+	if conditions != "" {
+		where = append(where, `lr.conditions = ?`)
+		args = append(args, conditions)
+	}
+
+	rows, err := r.db.Query(
+		`SELECT lr.id, lr.lapTimeMs, lr.conditions, lr.createdAt, lr.trackEventId, te.eventType,
+			lr.sessionId, ts.date, ts.runGroup,
+			t.id, t.name, t.location, c.id, c.make, c.model, c.year
+		FROM "LapRecord" lr
+		JOIN "Track" t ON lr.trackId = t.id
+		JOIN "Car" c ON lr.carId = c.id
+		LEFT JOIN "TrackEvent" te ON te.id = lr.trackEventId
+		LEFT JOIN "TrackSession" ts ON ts.id = lr.sessionId
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY lr.createdAt, lr.id`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var laps []statsLap
+	for rows.Next() {
+		var l statsLap
+		if err := rows.Scan(
+			&l.id, &l.lapTimeMs, &l.conditions, &l.createdAt, &l.eventID, &l.eventType,
+			&l.sessionID, &l.sessionDate, &l.runGroup,
+			&l.track.ID, &l.track.Name, &l.track.Location,
+			&l.car.ID, &l.car.Make, &l.car.Model, &l.car.Year,
+		); err != nil {
+			return nil, err
+		}
+		laps = append(laps, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return computeLapbookStats(laps), nil
+}
+
+// computeLapbookStats expects laps in chronological order.
+func computeLapbookStats(laps []statsLap) *models.LapbookStats {
+	stats := &models.LapbookStats{
+		PersonalBests: []models.PersonalBest{},
+		Sessions:      []models.SessionStats{},
+	}
+
+	// Group into combinations, then into sessions within each combination
+	var comboOrder []string
+	combos := map[string][][]statsLap{}
+	groupIndex := map[string]int{}
+	for _, l := range laps {
+		key := l.comboKey()
+		days, ok := combos[key]
+		if !ok {
+			comboOrder = append(comboOrder, key)
+		}
+		group := key + "|" + l.groupKey()
+		if i, ok := groupIndex[group]; ok {
+			days[i] = append(days[i], l)
+		} else {
+			groupIndex[group] = len(days)
+			days = append(days, []statsLap{l})
+		}
+		combos[key] = days
+	}
+
+	for _, key := range comboOrder {
+		days := combos[key]
+		first := days[0][0]
+		pb := models.PersonalBest{
+			Track:      first.track,
+			EventType:  first.eventType,
+			Car:        first.car,
+			Conditions: first.conditions,
+			Trend:      []models.TrendPoint{},
+		}
+
+		var prevBest int64
+		for i, day := range days {
+			session := sessionStats(day)
+			if i > 0 {
+				improvement := prevBest - session.BestMs
+				session.ImprovementMs = &improvement
+			}
+			if i == 0 || session.BestMs < pb.LapTimeMs {
+				best := fastestLap(day)
+				pb.LapRecordID = best.id
+				pb.LapTimeMs = best.lapTimeMs
+				pb.AchievedAt = best.createdAt
+				session.SetPersonalBest = true
+			}
+			pb.LapCount += len(day)
+			pb.Trend = append(pb.Trend, models.TrendPoint{
+				Date:           session.Date,
+				BestMs:         session.BestMs,
+				BestLapTime:    session.BestLapTime,
+				PersonalBestMs: pb.LapTimeMs,
+			})
+			prevBest = session.BestMs
+			stats.Sessions = append(stats.Sessions, session)
+		}
+		pb.LapTime = laptime.Format(pb.LapTimeMs)
+		stats.PersonalBests = append(stats.PersonalBests, pb)
+	}
+
+	sort.SliceStable(stats.PersonalBests, func(i, j int) bool {
+		a, b := stats.PersonalBests[i], stats.PersonalBests[j]
+		if a.Track.Name != b.Track.Name {
+			return a.Track.Name < b.Track.Name
+		}
+		return a.LapTimeMs < b.LapTimeMs
+	})
+	// Most recent sessions first
+	sort.SliceStable(stats.Sessions, func(i, j int) bool {
+		return stats.Sessions[i].Date > stats.Sessions[j].Date
+	})
+	return stats
+}
+
+func fastestLap(laps []statsLap) statsLap {
+	best := laps[0]
+	for _, l := range laps[1:] {
+		if l.lapTimeMs < best.lapTimeMs {
+			best = l
+		}
+	}
+	return best
+}
+
+func sessionStats(laps []statsLap) models.SessionStats {
+	first := laps[0]
+	best := fastestLap(laps)
+
+	times := make([]int64, len(laps))
+	for i, l := range laps {
+		times[i] = l.lapTimeMs
+	}
+	average, stdDev := lapSpread(times)
+
+	s := models.SessionStats{
+		Date:        first.date(),
+		SessionID:   first.sessionID,
+		RunGroup:    first.runGroup,
+		Track:       first.track,
+		EventType:   first.eventType,
+		Car:         first.car,
+		Conditions:  first.conditions,
+		LapCount:    len(laps),
+		BestMs:      best.lapTimeMs,
+		BestLapTime: laptime.Format(best.lapTimeMs),
+		AverageMs:   average,
+		StdDevMs:    stdDev,
+	}
+	return s
+}
+
+// lapSpread returns the rounded mean of a non-empty set of lap times and
+// their sample standard deviation to 0.1ms, which is nil for a single lap.
+func lapSpread(times []int64) (int64, *float64) {
+	var sum float64
+	for _, t := range times {
+		sum += float64(t)
+	}
+	mean := sum / float64(len(times))
+	if len(times) < 2 {
+		return int64(math.Round(mean)), nil
+	}
+
+	var sq float64
+	for _, t := range times {
+		d := float64(t) - mean
+		sq += d * d
+	}
+	stdDev := math.Round(math.Sqrt(sq/float64(len(times)-1))*10) / 10
+	return int64(math.Round(mean)), &stdDev
+}
+
+// summarizeSession summarises the lap times of a TrackSession.
+func summarizeSession(times []int64) models.SessionSummary {
+	summary := models.SessionSummary{LapCount: len(times)}
+	if len(times) == 0 {
+		return summary
+	}
+
+	best := times[0]
+	for _, t := range times[1:] {
+		if t < best {
+			best = t
+		}
+	}
+	bestLapTime := laptime.Format(best)
+	average, stdDev := lapSpread(times)
+	summary.BestMs = &best
+	summary.BestLapTime = &bestLapTime
+	summary.AverageMs = &average
+	summary.StdDevMs = stdDev
+	return summary
+}
+
+// TheoreticalBest adds up a driver's fastest split in each of a track's
+// sectors and compares the result with their personal best, the fastest lap
+// that has a split for every sector. Laps can be limited to one car, event
+// type or conditions. On equal splits the earlier lap counts.
+func (r *LapbookRepo) TheoreticalBest(driverID string, track models.TrackBrief, sectors []models.TrackSector, carID, eventType, conditions string) (*models.TheoreticalBest, error) {
+	where := []string{`lr.driverId = ?`, `lr.trackId = ?`, `lr.lapTimeMs IS NOT NULL`}
+	args := []interface{}{driverID, track.ID}
+	if carID != "" {
+		where = append(where, `lr.carId = ?`)
+		args = append(args, carID)
+	}
+	if eventType != "" {
+		where = append(where, `te.eventType = ?`)
+		args = append(args, eventType)
+	}
+	if conditions != "" {
+		where = append(where, `lr.conditions = ?`)
+		args = append(args, conditions)
+	}
+
+	rows, err := r.db.Query(
+		`SELECT lr.id, lr.lapTime, lr.lapTimeMs, ls.sectorId, ls.timeMs
+		FROM "LapSplit" ls
+		JOIN "LapRecord" lr ON lr.id = ls.lapRecordId
+		LEFT JOIN "TrackEvent" te ON te.id = lr.trackEventId
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY lr.createdAt, lr.id`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type splitLap struct {
+		ref    models.LapTimeRef
+		splits map[string]int64
+	}
+	var laps []*splitLap
+	byID := map[string]*splitLap{}
+	for rows.Next() {
+		var ref models.LapTimeRef
+		var sectorID string
+		var ms int64
+		if err := rows.Scan(&ref.LapRecordID, &ref.LapTime, &ref.LapTimeMs, &sectorID, &ms); err != nil {
+			return nil, err
+		}
+		lap, ok := byID[ref.LapRecordID]
+		if !ok {
+			lap = &splitLap{ref: ref, splits: map[string]int64{}}
+			byID[ref.LapRecordID] = lap
+			laps = append(laps, lap)
+		}
+		lap.splits[sectorID] = ms
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &models.TheoreticalBest{Track: track, Sectors: make([]models.SectorBest, len(sectors))}
+	var pb *splitLap
+	for _, lap := range laps {
+		if len(lap.splits) == len(sectors) && (pb == nil || lap.ref.LapTimeMs < pb.ref.LapTimeMs) {
+			pb = lap
+		}
+	}
+	if pb != nil {
+		ref := pb.ref
+		result.PersonalBest = &ref
+	}
+
+	var total, worstLoss int64
+	complete := len(sectors) > 0
+	for i, sector := range sectors {
+		sb := models.SectorBest{SectorID: sector.ID, Position: sector.Position, Name: sector.Name}
+		for _, lap := range laps {
+			ms, ok := lap.splits[sector.ID]
+			if ok && (sb.BestMs == nil || ms < *sb.BestMs) {
+				best, lapID := ms, lap.ref.LapRecordID
+				sb.BestMs, sb.LapRecordID = &best, &lapID
+			}
+		}
+		if sb.BestMs == nil {
+			complete = false
+		} else {
+			best := laptime.Format(*sb.BestMs)
+			sb.Best = &best
+			total += *sb.BestMs
+		}
+		if pb != nil && sb.BestMs != nil {
+			pbMs := pb.splits[sector.ID]
+			loss := pbMs - *sb.BestMs
+			sb.PersonalBestMs, sb.LossMs = &pbMs, &loss
+			if loss > worstLoss {
+				worstLoss = loss
+				id := sector.ID
+				result.WeakestSectorID = &id
+			}
+		}
+		result.Sectors[i] = sb
+	}
+
+	if complete {
+		formatted := laptime.Format(total)
+		result.TheoreticalBestMs, result.TheoreticalBest = &total, &formatted
+		if pb != nil {
+			gain := pb.ref.LapTimeMs - total
+			result.GainMs = &gain
+		}
+	}
+	return result, nil
+}
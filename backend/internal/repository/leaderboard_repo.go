@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"database/sql"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/laptime"
+	"github.com/joezmuda/trackside-backend/internal/models"
+)
+
+type LeaderboardRepo struct {
+	db *sql.DB
+}
+
+func NewLeaderboardRepo(db *sql.DB) *LeaderboardRepo {
+	return &LeaderboardRepo{db: db}
+}
+
+// adjustedTimeExpr is an autocross run's time with cone penalties. A run
+// without a result counts as clean; runs that did not finish or went off
+// course have no adjusted time.
+const adjustedTimeExpr = `CASE WHEN NOT EXISTS (SELECT 1 FROM "AutocrossResult" ar WHERE ar.lapRecordId = lr.id) THEN lr.lapTimeMs
+			ELSE (SELECT ar.adjustedTimeMs FROM "AutocrossResult" ar WHERE ar.lapRecordId = lr.id) END`
+
+// rankExprs are the values laps are ranked by for each ranking, with the
+// sort direction. Laps without a value are not ranked: the times are null
+// for laps without a parsed time, and PAX times for cars without a class.
+// Drag passes are ranked by their 1/4 mile time, so eighth-mile passes,
+// passes without a timeslip and red lights are left out. Drift runs are
+// ranked by their total score and need one to be ranked, but not a time.
+var rankExprs = map[string]struct{ value, dir string }{
+	models.RankByLapTime:         {`lr.lapTimeMs`, "ASC"},
+	models.RankByAdjustedTime:    {adjustedTimeExpr, "ASC"},
+	models.RankByPaxTime:         {`ROUND((` + adjustedTimeExpr + `) * (SELECT cc.paxIndex FROM "CarClass" cc WHERE cc.id = c.classId))`, "ASC"},
+	models.RankByQuarterMileTime: {`(SELECT dr.quarterMileMs FROM "DragResult" dr WHERE dr.lapRecordId = lr.id AND COALESCE(dr.reactionMs, 0) >= 0)`, "ASC"},
+	models.RankByScore:           {`(SELECT SUM(ds.score) FROM "DriftScore" ds WHERE ds.lapRecordId = lr.id)`, "DESC"},
+}
+
+// RankingFor returns how a leaderboard for the given event type is ranked.
+// pax only applies to autocross.
+func RankingFor(eventType string, pax bool) string {
+	switch models.EventType(eventType) {
+	case models.EventAutocross:
+		if pax {
+			return models.RankByPaxTime
+		}
+		return models.RankByAdjustedTime
+	case models.EventDrag:
+		return models.RankByQuarterMileTime
+	case models.EventDrift:
+		return models.RankByScore
+	}
+	return models.RankByLapTime
+}
+
+// ForTrack ranks each driver's best lap at a track under the given filters,
+// by lap time or the event type's own ranking (see RankingFor). Drivers who
+// opted out and laps without a value to rank by are left out. Equal values
+// share a rank, with the earlier lap listed first. Laps that were changed
+// after being logged are flagged as edited.
+func (r *LeaderboardRepo) ForTrack(trackID string, params models.LeaderboardParams) ([]models.LeaderboardEntry, int, error) {
+	rank := rankExprs[RankingFor(params.EventType, params.Pax)]
+	where := []string{`lr.trackId = ?`, `u.leaderboardOptOut = false`, rank.value + ` IS NOT NULL`}
+	args := []interface{}{trackID}
+
+	if params.EventType != "" {
+		where = append(where, `te.eventType = ?`)
+		args = append(args, params.EventType)
+	}
+	if params.Conditions != "" {
+		where = append(where, `lr.conditions = ?`)
+		args = append(args, params.Conditions)
+	}
+	if params.CarMake != "" {
+		where = append(where, `c.make = ? COLLATE NOCASE`)
+		args = append(args, params.CarMake)
+	}
+	if params.CarModel != "" {
+		where = append(where, `c.model = ? COLLATE NOCASE`)
+		args = append(args, params.CarModel)
+	}
+	// createdAt is compared as text; both stored formats start with the date
+	if params.From != "" {
+		where = append(where, `CAST(lr.createdAt AS TEXT) >= ?`)
+		args = append(args, params.From)
+	}
+	if params.To != "" {
+		day, err := time.Parse(time.DateOnly, params.To)
+		if err != nil {
+			return nil, 0, err
+		}
+		where = append(where, `CAST(lr.createdAt AS TEXT) < ?`)
+		args = append(args, day.AddDate(0, 0, 1).Format(time.DateOnly))
+	}
+	args = append(args, params.Limit)
+
+	rows, err := r.db.Query(
+		`WITH best AS (
+			SELECT lr.id, lr.lapTime, lr.lapTimeMs, lr.conditions, lr.createdAt,
+				lr.driverId, lr.carId, te.eventType, `+lapRecordEditedExpr+` AS edited,
+				`+rank.value+` AS rankValue,
+				ROW_NUMBER() OVER (PARTITION BY lr.driverId ORDER BY `+rank.value+` `+rank.dir+`, lr.createdAt, lr.id) AS rn
+			FROM "LapRecord" lr
+			JOIN "User" u ON u.id = lr.driverId
+			JOIN "Car" c ON c.id = lr.carId
+			LEFT JOIN "TrackEvent" te ON te.id = lr.trackEventId
+			WHERE `+strings.Join(where, " AND ")+`
+		)
+		SELECT b.id, b.lapTime, b.lapTimeMs, b.conditions, b.eventType, b.createdAt, b.edited, b.rankValue,
+			u.id, u.name, c.id, c.make, c.model, c.year,
+			COUNT(*) OVER ()
+		FROM best b
+		JOIN "User" u ON u.id = b.driverId
+		JOIN "Car" c ON c.id = b.carId
+		WHERE b.rn = 1
+		ORDER BY b.rankValue `+rank.dir+`, b.createdAt, b.id
+		LIMIT ?`,
+		args...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var entries []models.LeaderboardEntry
+	var values []float64
+	total := 0
+	for rows.Next() {
+		var e models.LeaderboardEntry
+		var value float64
+		if err := rows.Scan(
+			&e.LapRecordID, &e.LapTime, &e.LapTimeMs, &e.Conditions, &e.EventType, &e.RecordedAt, &e.Edited, &value,
+			&e.Driver.ID, &e.Driver.Name, &e.Car.ID, &e.Car.Make, &e.Car.Model, &e.Car.Year,
+			&total,
+		); err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, e)
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	rows.Close()
+
+	// Gaps are in the ranked value: time behind the leader, or points for scores
+	for i := range entries {
+		e := &entries[i]
+		if rank.dir == "DESC" {
+			e.Gap = "-" + strconv.FormatFloat(math.Round((values[0]-values[i])*100)/100, 'f', -1, 64)
+		} else {
+			e.GapMs = int64(values[i] - values[0])
+			e.Gap = "+" + laptime.Format(e.GapMs)
+		}
+		e.Rank = i + 1
+		if i > 0 && values[i] == values[i-1] {
+			e.Rank = entries[i-1].Rank
+		}
+	}
+
+	// Fetch mods AFTER closing rows to avoid SQLite deadlock
+	carIDs := make([]string, len(entries))
+	for i := range entries {
+		carIDs[i] = entries[i].Car.ID
+	}
+	mods, err := r.modsForCars(carIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	lapIDs := make([]string, len(entries))
+	for i := range entries {
+		lapIDs[i] = entries[i].LapRecordID
+	}
+	results, err := loadResults(r.db, lapIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	classes, err := classesForCars(r.db, carIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range entries {
+		entries[i].Result = results[entries[i].LapRecordID]
+		if class, ok := classes[entries[i].Car.ID]; ok && entries[i].EventType != nil {
+			entries[i].Pax = paxTime(*entries[i].EventType, &class, entries[i].LapTimeMs, entries[i].Result)
+		}
+		entries[i].Car.Mods = mods[entries[i].Car.ID]
+		if entries[i].Car.Mods == nil {
+			entries[i].Car.Mods = []models.LeaderboardMod{}
+		}
+	}
+
+	if entries == nil {
+		entries = []models.LeaderboardEntry{}
+	}
+	return entries, total, nil
+}
+
+// modsForCars loads the mods of many cars at once, keyed by car ID.
+func (r *LeaderboardRepo) modsForCars(carIDs []string) (map[string][]models.LeaderboardMod, error) {
+	mods := make(map[string][]models.LeaderboardMod, len(carIDs))
+	if len(carIDs) == 0 {
+		return mods, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(carIDs)), ",")
+	args := make([]interface{}, len(carIDs))
+	for i, id := range carIDs {
+		args[i] = id
+	}
+
+	rows, err := r.db.Query(
+		`SELECT carId, name, category FROM "CarMod" WHERE carId IN (`+placeholders+`) ORDER BY category, name`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var carID string
+		var m models.LeaderboardMod
+		if err := rows.Scan(&carID, &m.Name, &m.Category); err != nil {
+			return nil, err
+		}
+		mods[carID] = append(mods[carID], m)
+	}
+	return mods, rows.Err()
+}
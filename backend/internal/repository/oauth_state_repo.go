@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/models"
+)
+
+// ErrInvalidOAuthState is returned by Consume for a state that is unknown,
+// expired or already used.
+var ErrInvalidOAuthState = errors.New("invalid or expired state")
+
+// OAuthStateRepo keeps sign-ins with OIDC providers in the "OAuthState"
+// table while the user is away at the provider.
+type OAuthStateRepo struct {
+	db *sql.DB
+}
+
+func NewOAuthStateRepo(db *sql.DB) *OAuthStateRepo {
+	return &OAuthStateRepo{db: db}
+}
+
+// Create stores a sign-in that expires after ttl and returns the state
+// parameter that identifies it. Sign-ins that were never finished are
+// cleared out along the way.
+func (r *OAuthStateRepo) Create(s models.OAuthState, ttl time.Duration) (string, error) {
+	state, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	if _, err := r.db.Exec(`DELETE FROM "OAuthState" WHERE expires <= ?`, now); err != nil {
+		return "", err
+	}
+	_, err = r.db.Exec(
+		`INSERT INTO "OAuthState" (state, provider, codeVerifier, nonce, userId, expires) VALUES (?, ?, ?, ?, ?, ?)`,
+		hashToken(state), s.Provider, s.CodeVerifier, s.Nonce, s.UserID, now.Add(ttl),
+	)
+	return state, err
+}
+
+// Consume uses up a state and returns its sign-in. A state works once.
+func (r *OAuthStateRepo) Consume(state string) (*models.OAuthState, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var s models.OAuthState
+	var expires time.Time
+	err = tx.QueryRow(
+		`SELECT provider, codeVerifier, nonce, userId, expires FROM "OAuthState" WHERE state = ?`, hashToken(state),
+	).Scan(&s.Provider, &s.CodeVerifier, &s.Nonce, &s.UserID, &expires)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidOAuthState
+	}
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.Exec(`DELETE FROM "OAuthState" WHERE state = ?`, hashToken(state))
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return nil, ErrInvalidOAuthState
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if !expires.After(time.Now().UTC()) {
+		return nil, ErrInvalidOAuthState
+	}
+	return &s, nil
+}
@@ -0,0 +1,321 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/laptime"
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/rs/xid"
+)
+
+type SessionRepo struct {
+	db *sql.DB
+}
+
+func NewSessionRepo(db *sql.DB) *SessionRepo {
+	return &SessionRepo{db: db}
+}
+
+const sessionSelect = `SELECT s.id, s.date, s.runGroup, s.ambientTempC, s.trackTempC, s.setup, s.notes,
+		s.trackId, s.trackEventId, s.carId, s.driverId, s.createdAt, s.updatedAt,
+		t.id, t.name, t.location, te.id, te.eventType,
+		c.id, c.make, c.model, c.year
+	FROM "TrackSession" s
+	JOIN "Track" t ON s.trackId = t.id
+	JOIN "Car" c ON s.carId = c.id
+	LEFT JOIN "TrackEvent" te ON te.id = s.trackEventId`
+
+// List returns a driver's sessions, most recent first, optionally filtered
+// by track, car or date (YYYY-MM-DD).
+func (r *SessionRepo) List(driverID, trackID, carID, date string) ([]models.TrackSessionWithDetails, error) {
+	where := []string{`s.driverId = ?`}
+	args := []interface{}{driverID}
+	if trackID != "" {
+		where = append(where, `s.trackId = ?`)
+		args = append(args, trackID)
+	}
+	if carID != "" {
+		where = append(where, `s.carId = ?`)
+		args = append(args, carID)
+	}
+	if date != "" {
+		where = append(where, `s.date = ?`)
+		args = append(args, date)
+	}
+	return r.query(where, args)
+}
+
+// FindWithDetails returns one of a driver's sessions, or nil if the driver
+// has no session with that id.
+func (r *SessionRepo) FindWithDetails(id, driverID string) (*models.TrackSessionWithDetails, error) {
+	sessions, err := r.query([]string{`s.id = ?`, `s.driverId = ?`}, []interface{}{id, driverID})
+	if err != nil || len(sessions) == 0 {
+		return nil, err
+	}
+	return &sessions[0], nil
+}
+
+func (r *SessionRepo) query(where []string, args []interface{}) ([]models.TrackSessionWithDetails, error) {
+	rows, err := r.db.Query(
+		sessionSelect+` WHERE `+strings.Join(where, " AND ")+` ORDER BY s.date DESC, s.createdAt DESC`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.TrackSessionWithDetails
+	for rows.Next() {
+		var s models.TrackSessionWithDetails
+		var setup, eventID, eventType sql.NullString
+		if err := rows.Scan(
+			&s.ID, &s.Date, &s.RunGroup, &s.AmbientTempC, &s.TrackTempC, &setup, &s.Notes,
+			&s.TrackID, &s.TrackEventID, &s.CarID, &s.DriverID, &s.CreatedAt, &s.UpdatedAt,
+			&s.Track.ID, &s.Track.Name, &s.Track.Location, &eventID, &eventType,
+			&s.Car.ID, &s.Car.Make, &s.Car.Model, &s.Car.Year,
+		); err != nil {
+			return nil, err
+		}
+		if setup.Valid {
+			s.Setup = json.RawMessage(setup.String)
+		}
+		if eventID.Valid {
+			s.TrackEvent = &models.TrackEvent{ID: eventID.String, EventType: eventType.String, TrackID: s.TrackID}
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Fetch lap times AFTER closing rows to avoid SQLite deadlock
+	if err := r.fillSummaries(sessions); err != nil {
+		return nil, err
+	}
+
+	if sessions == nil {
+		sessions = []models.TrackSessionWithDetails{}
+	}
+	return sessions, nil
+}
+
+// fillSummaries loads the lap times of the given sessions in one query and
+// summarises each session.
+func (r *SessionRepo) fillSummaries(sessions []models.TrackSessionWithDetails) error {
+	if len(sessions) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(sessions))
+	args := make([]interface{}, len(sessions))
+	for i := range sessions {
+		placeholders[i] = "?"
+		args[i] = sessions[i].ID
+	}
+
+	rows, err := r.db.Query(
+		`SELECT sessionId, lapTimeMs FROM "LapRecord"
+		WHERE lapTimeMs IS NOT NULL AND sessionId IN (`+strings.Join(placeholders, ", ")+`)`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	times := make(map[string][]int64)
+	for rows.Next() {
+		var sessionID string
+		var ms int64
+		if err := rows.Scan(&sessionID, &ms); err != nil {
+			return err
+		}
+		times[sessionID] = append(times[sessionID], ms)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	theoretical, err := r.theoreticalBests(placeholders, args)
+	if err != nil {
+		return err
+	}
+
+	for i := range sessions {
+		sessions[i].Summary = summarizeSession(times[sessions[i].ID])
+		if ms, ok := theoretical[sessions[i].ID]; ok {
+			formatted := laptime.Format(ms)
+			sessions[i].Summary.TheoreticalBestMs = &ms
+			sessions[i].Summary.TheoreticalBest = &formatted
+		}
+	}
+	return nil
+}
+
+// theoreticalBests adds up the fastest split per sector within each of the
+// given sessions. Sessions without a split for every sector of their track
+// are left out.
+func (r *SessionRepo) theoreticalBests(placeholders []string, sessionIDs []interface{}) (map[string]int64, error) {
+	rows, err := r.db.Query(
+		`SELECT b.sessionId, SUM(b.bestMs), COUNT(*),
+			(SELECT COUNT(*) FROM "TrackSector" s WHERE s.trackId = ts.trackId)
+		FROM (
+			SELECT lr.sessionId, ls.sectorId, MIN(ls.timeMs) AS bestMs
+			FROM "LapSplit" ls
+			JOIN "LapRecord" lr ON lr.id = ls.lapRecordId
+			WHERE lr.sessionId IN (`+strings.Join(placeholders, ", ")+`)
+			GROUP BY lr.sessionId, ls.sectorId
+		) b
+		JOIN "TrackSession" ts ON ts.id = b.sessionId
+		GROUP BY b.sessionId`,
+		sessionIDs...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := map[string]int64{}
+	for rows.Next() {
+		var sessionID string
+		var total int64
+		var covered, sectors int
+		if err := rows.Scan(&sessionID, &total, &covered, &sectors); err != nil {
+			return nil, err
+		}
+		if sectors > 0 && covered == sectors {
+			result[sessionID] = total
+		}
+	}
+	return result, rows.Err()
+}
+
+// FindByIDAndDriver returns a session without its details, or nil if the
+// driver has no session with that id.
+func (r *SessionRepo) FindByIDAndDriver(id, driverID string) (*models.TrackSession, error) {
+	s := &models.TrackSession{}
+	var setup sql.NullString
+	err := r.db.QueryRow(
+		`SELECT id, date, runGroup, ambientTempC, trackTempC, setup, notes,
+			trackId, trackEventId, carId, driverId, createdAt, updatedAt
+		FROM "TrackSession" WHERE id = ? AND driverId = ?`,
+		id, driverID,
+	).Scan(&s.ID, &s.Date, &s.RunGroup, &s.AmbientTempC, &s.TrackTempC, &setup, &s.Notes,
+		&s.TrackID, &s.TrackEventID, &s.CarID, &s.DriverID, &s.CreatedAt, &s.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if setup.Valid {
+		s.Setup = json.RawMessage(setup.String)
+	}
+	return s, nil
+}
+
+func (r *SessionRepo) Create(req models.TrackSessionRequest, driverID string) (*models.TrackSessionWithDetails, error) {
+	id, err := insertSession(r.db, req, driverID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	return r.FindWithDetails(id, driverID)
+}
+
+// Import creates a session together with its laps, all or nothing. The laps
+// take the session's track, car and event.
+func (r *SessionRepo) Import(req models.TrackSessionRequest, conditions string, laps []models.ImportedLap, driverID string) (*models.TrackSessionWithDetails, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	id, err := insertSession(tx, req, driverID, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, lap := range laps {
+		lapNumber := lap.LapNumber
+		lapReq := models.LapRecordRequest{
+			LapTime:      lap.LapTime,
+			Conditions:   conditions,
+			TrackID:      req.TrackID,
+			TrackEventID: req.TrackEventID,
+			CarID:        req.CarID,
+			SessionID:    &id,
+			LapNumber:    &lapNumber,
+		}
+		if _, err := insertLapRecord(tx, lapReq, &lap.LapTimeMs, lap.SplitsMs, driverID, now); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.FindWithDetails(id, driverID)
+}
+
+func insertSession(q querier, req models.TrackSessionRequest, driverID string, now time.Time) (string, error) {
+	id := xid.New().String()
+	_, err := q.Exec(
+		`INSERT INTO "TrackSession" (id, date, runGroup, ambientTempC, trackTempC, setup, notes,
+			trackId, trackEventId, carId, driverId, createdAt, updatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, req.Date, req.RunGroup, req.AmbientTempC, req.TrackTempC, setupValue(req.Setup), req.Notes,
+		req.TrackID, req.TrackEventID, req.CarID, driverID, now, now,
+	)
+	return id, err
+}
+
+func (r *SessionRepo) Update(id, driverID string, req models.TrackSessionRequest) (*models.TrackSessionWithDetails, error) {
+	_, err := r.db.Exec(
+		`UPDATE "TrackSession" SET date = ?, runGroup = ?, ambientTempC = ?, trackTempC = ?, setup = ?, notes = ?,
+			trackId = ?, trackEventId = ?, carId = ?, updatedAt = ?
+		WHERE id = ?`,
+		req.Date, req.RunGroup, req.AmbientTempC, req.TrackTempC, setupValue(req.Setup), req.Notes,
+		req.TrackID, req.TrackEventID, req.CarID, time.Now().UTC(), id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return r.FindWithDetails(id, driverID)
+}
+
+// LapCount counts the lap records attached to a session.
+func (r *SessionRepo) LapCount(id string) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM "LapRecord" WHERE sessionId = ?`, id).Scan(&count)
+	return count, err
+}
+
+// Delete removes a session. Its laps are kept but no longer belong to a session.
+func (r *SessionRepo) Delete(id string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE "LapRecord" SET sessionId = NULL, lapNumber = NULL WHERE sessionId = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM "TrackSession" WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setupValue stores an empty or null setup as NULL.
+func setupValue(setup json.RawMessage) interface{} {
+	if len(setup) == 0 || string(setup) == "null" {
+		return nil
+	}
+	return string(setup)
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/rs/xid"
+)
+
+type SyncRunRepo struct {
+	db *sql.DB
+}
+
+func NewSyncRunRepo(db *sql.DB) *SyncRunRepo {
+	return &SyncRunRepo{db: db}
+}
+
+// Create records a finished sync in the history, filling in its ID.
+// triggeredByID is nil for command-line runs.
+func (r *SyncRunRepo) Create(run *models.SyncRun, triggeredByID *string) error {
+	var summary interface{}
+	if run.Summary != nil {
+		data, err := json.Marshal(run.Summary)
+		if err != nil {
+			return err
+		}
+		summary = string(data)
+	}
+
+	run.ID = xid.New().String()
+	_, err := r.db.Exec(
+		`INSERT INTO "SyncRun" (id, source, dryRun, prune, status, summary, error, triggeredById, startedAt, finishedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.ID, run.Source, run.DryRun, run.Prune, run.Status, summary, run.Error, triggeredByID,
+		run.StartedAt.UTC(), run.FinishedAt.UTC(),
+	)
+	return err
+}
+
+// List returns the most recent syncs, newest first.
+func (r *SyncRunRepo) List(limit int) ([]models.SyncRun, error) {
+	rows, err := r.db.Query(
+		`SELECT sr.id, sr.source, sr.dryRun, sr.prune, sr.status, sr.summary, sr.error, sr.startedAt, sr.finishedAt,
+			u.id, u.name
+		FROM "SyncRun" sr
+		LEFT JOIN "User" u ON u.id = sr.triggeredById
+		ORDER BY sr.startedAt DESC, sr.id DESC
+		LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []models.SyncRun{}
+	for rows.Next() {
+		var run models.SyncRun
+		var summary, userID, userName sql.NullString
+		if err := rows.Scan(&run.ID, &run.Source, &run.DryRun, &run.Prune, &run.Status, &summary, &run.Error,
+			&run.StartedAt, &run.FinishedAt, &userID, &userName); err != nil {
+			return nil, err
+		}
+		if summary.Valid {
+			run.Summary = &models.SyncTracksSummary{}
+			if err := json.Unmarshal([]byte(summary.String), run.Summary); err != nil {
+				return nil, err
+			}
+		}
+		if userID.Valid {
+			run.TriggeredBy = &models.UserBrief{ID: userID.String}
+			if userName.Valid {
+				run.TriggeredBy.Name = &userName.String
+			}
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
@@ -2,11 +2,18 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/joezmuda/trackside-backend/internal/geo"
 	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/joezmuda/trackside-backend/internal/search"
 	"github.com/rs/xid"
 )
 
@@ -18,108 +25,474 @@ func NewTrackRepo(db *sql.DB) *TrackRepo {
 	return &TrackRepo{db: db}
 }
 
-func (r *TrackRepo) List(search, eventType, state string) ([]models.TrackListItem, error) {
-	query := `SELECT t.id, t.name, t.location, t.state, t.description, t.imageUrl, t.latitude, t.longitude,
-		t.status, t.isImported, t.uploadedById, t.createdAt, t.updatedAt,
-		u.id, u.name
-		FROM "Track" t
-		JOIN "User" u ON t.uploadedById = u.id
-		WHERE t.status = 'APPROVED'`
+// ErrInvalidCursor is returned by List when the cursor was not produced by a
+// previous List call with the same sort.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// trackSorts maps the public sort names to their ORDER BY expression and
+// direction. Every sort is tie-broken on t.id so keyset pagination is stable.
+// The distance and relevance sorts depend on the request and are built in List.
+// createdAt is compared as stored text; casting stops the driver from turning
+// the cursor value into a time.Time with a different string format.
+var trackSorts = map[string]struct {
+	expr string
+	desc bool
+}{
+	models.TrackSortNewest:  {`CAST(t.createdAt AS TEXT)`, true},
+	models.TrackSortName:    {`t.name COLLATE NOCASE`, false},
+	models.TrackSortRating:  {`COALESCE(rv.avgRating, 0)`, true},
+	models.TrackSortReviews: {`COALESCE(rv.reviewCount, 0)`, true},
+	models.TrackSortLaps:    {`COALESCE(lr.lapCount, 0)`, true},
+}
+
+type trackCursor struct {
+	Sort  string      `json:"s"`
+	Value interface{} `json:"v"`
+	ID    string      `json:"id"`
+}
+
+// List returns one page of approved tracks matching the filters, plus the
+// total number of matches and a cursor for the next page.
+func (r *TrackRepo) List(params models.TrackListParams) (*models.TrackListResponse, error) {
+	where := []string{`t.status = 'APPROVED'`}
 	args := []interface{}{}
 
-	if search != "" {
-		query += ` AND (t.name LIKE ? OR t.location LIKE ?)`
-		like := "%" + search + "%"
-		args = append(args, like, like)
+	// Joins come before WHERE in the query, so their args go first
+	var joins, snippetExpr string
+	if params.Search != "" {
+		match, err := r.searchMatchExpr(params.Search)
+		if err != nil {
+			return nil, err
+		}
+		if match == "" {
+			return &models.TrackListResponse{Tracks: []models.TrackListItem{}}, nil
+		}
+		joins = trackSearchJoin
+		snippetExpr = `s.snippet`
+		args = append(args, match)
+	}
+
+	var distExpr string
+	if params.Near != nil {
+		// The point is inlined rather than bound because the expression is
+		// repeated in the select list, cursor condition and ORDER BY.
+		distExpr = fmt.Sprintf(`distance_km(t.latitude, t.longitude, %s, %s)`,
+			strconv.FormatFloat(params.Near.Lat, 'f', -1, 64),
+			strconv.FormatFloat(params.Near.Lng, 'f', -1, 64))
+		where = append(where, `t.latitude IS NOT NULL AND t.longitude IS NOT NULL`)
+
+		if params.RadiusKm > 0 {
+			// Narrow by latitude first so the distance is only computed for
+			// tracks in the right band.
+			span := geo.LatitudeSpan(params.RadiusKm)
+			where = append(where, `t.latitude BETWEEN ? AND ?`, distExpr+` <= ?`)
+			args = append(args, params.Near.Lat-span, params.Near.Lat+span, params.RadiusKm)
+		}
 	}
 
-	if eventType != "" {
-		query += ` AND EXISTS (SELECT 1 FROM "TrackEvent" te WHERE te.trackId = t.id AND te.eventType = ?)`
-		args = append(args, eventType)
+	sort, ok := trackSorts[params.Sort]
+	switch params.Sort {
+	case models.TrackSortDistance:
+		if distExpr == "" {
+			return nil, errors.New("distance sort requires a point")
+		}
+		sort.expr, ok = distExpr, true
+	case models.TrackSortRelevance:
+		if joins == "" {
+			return nil, errors.New("relevance sort requires a search")
+		}
+		sort.expr, sort.desc, ok = `s.score`, true, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown sort %q", params.Sort)
 	}
 
-	if state != "" {
-		query += ` AND t.state = ?`
-		args = append(args, strings.ToUpper(state))
+	if box := params.BBox; box != nil {
+		where = append(where, `t.latitude BETWEEN ? AND ?`)
+		args = append(args, box.MinLat, box.MaxLat)
+		if box.MinLng <= box.MaxLng {
+			where = append(where, `t.longitude BETWEEN ? AND ?`)
+		} else {
+			where = append(where, `(t.longitude >= ? OR t.longitude <= ?)`)
+		}
+		args = append(args, box.MinLng, box.MaxLng)
 	}
 
-	query += ` ORDER BY t.createdAt DESC`
+	if params.EventType != "" {
+		where = append(where, `EXISTS (SELECT 1 FROM "TrackEvent" te WHERE te.trackId = t.id AND te.eventType = ?)`)
+		args = append(args, params.EventType)
+	}
 
-	rows, err := r.db.Query(query, args...)
+	if params.State != "" {
+		where = append(where, `t.state = ? COLLATE NOCASE`)
+		args = append(args, params.State)
+	}
+
+	if params.Country != "" {
+		where = append(where, `t.country = ?`)
+		args = append(args, strings.ToUpper(params.Country))
+	}
+
+	resp := &models.TrackListResponse{}
+	if err := r.db.QueryRow(
+		`SELECT COUNT(*) FROM "Track" t `+joins+` WHERE `+strings.Join(where, " AND "), args...,
+	).Scan(&resp.Total); err != nil {
+		return nil, err
+	}
+
+	if params.Cursor != "" {
+		cur, err := decodeTrackCursor(params.Cursor)
+		if err != nil || cur.Sort != params.Sort {
+			return nil, ErrInvalidCursor
+		}
+		op := ">"
+		if sort.desc {
+			op = "<"
+		}
+		where = append(where, fmt.Sprintf(`(%[1]s %[2]s ? OR (%[1]s = ? AND t.id %[2]s ?))`, sort.expr, op))
+		args = append(args, cur.Value, cur.Value, cur.ID)
+	}
+
+	dir := "ASC"
+	if sort.desc {
+		dir = "DESC"
+	}
+	orderBy := fmt.Sprintf(`%s %s, t.id %s`, sort.expr, dir, dir)
+
+	// Fetch one extra row to know whether another page exists
+	tracks, keys, err := r.queryTracks(trackQuery{
+		joins:    joins,
+		where:    strings.Join(where, " AND "),
+		args:     args,
+		orderBy:  orderBy,
+		sortExpr: sort.expr,
+		snippet:  snippetExpr,
+		limit:    params.Limit + 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tracks) > params.Limit {
+		tracks = tracks[:params.Limit]
+		last := tracks[len(tracks)-1]
+		next, err := encodeTrackCursor(trackCursor{Sort: params.Sort, Value: keys[len(tracks)-1], ID: last.ID})
+		if err != nil {
+			return nil, err
+		}
+		resp.NextCursor = &next
+	}
+
+	if params.Near != nil {
+		setDistances(tracks, *params.Near)
+	}
+	resp.Tracks = tracks
+	return resp, nil
+}
+
+// setDistances fills in each track's distance from p, rounded to 0.1.
+func setDistances(tracks []models.TrackListItem, p models.GeoPoint) {
+	for i := range tracks {
+		t := &tracks[i]
+		if t.Latitude == nil || t.Longitude == nil {
+			continue
+		}
+		km := geo.DistanceKm(p.Lat, p.Lng, *t.Latitude, *t.Longitude)
+		roundedKm := math.Round(km*10) / 10
+		roundedMi := math.Round(geo.KmToMiles(km)*10) / 10
+		t.DistanceKm = &roundedKm
+		t.DistanceMi = &roundedMi
+	}
+}
+
+// trackSearchJoin joins full-text matches for one MATCH argument as s, with
+// a relevance score (higher is better) and a highlighted snippet of the
+// best-matching column. bm25() weights are per column: trackId, name,
+// location, state, description.
+const trackSearchJoin = `JOIN (
+		SELECT trackId,
+			-bm25("TrackSearch", 0, 10, 4, 2, 1) AS score,
+			snippet("TrackSearch", -1, char(2), char(3), '…', 12) AS snippet
+		FROM "TrackSearch" WHERE "TrackSearch" MATCH ?
+	) s ON s.trackId = t.id`
+
+// searchMatchExpr turns user input into a MATCH expression, using the
+// indexed vocabulary to also match likely misspellings.
+func (r *TrackRepo) searchMatchExpr(q string) (string, error) {
+	return search.MatchExpr(q, trackSearchVocab{r.db})
+}
+
+// trackSearchVocab reads the "TrackSearch" vocabulary. Both lookups are
+// bounded in SQL, so the whole vocabulary is never loaded.
+type trackSearchVocab struct {
+	db *sql.DB
+}
+
+func (v trackSearchVocab) HasPrefix(prefix string) (bool, error) {
+	var found bool
+	err := v.db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM "TrackSearchTerms" WHERE term >= ? AND term < ?)`,
+		prefix, prefix+"\U0010FFFF",
+	).Scan(&found)
+	return found, err
+}
+
+func (v trackSearchVocab) TermsOfLength(minLen, maxLen int) ([]string, error) {
+	rows, err := v.db.Query(
+		`SELECT term FROM "TrackSearchTerms" WHERE length(term) BETWEEN ? AND ?`, minLen, maxLen,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var terms []string
+	for rows.Next() {
+		var term string
+		if err := rows.Scan(&term); err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	return terms, rows.Err()
+}
+
+// Suggest returns approved tracks matching a partial query for autocomplete,
+// best match first.
+func (r *TrackRepo) Suggest(q string, limit int) ([]models.TrackSuggestion, error) {
+	suggestions := []models.TrackSuggestion{}
+	match, err := r.searchMatchExpr(q)
+	if err != nil || match == "" {
+		return suggestions, err
+	}
+
+	rows, err := r.db.Query(
+		`SELECT t.id, t.name, t.location, t.state, s.highlight
+		FROM "Track" t
+		JOIN (
+			SELECT trackId,
+				-bm25("TrackSearch", 0, 10, 4, 2, 1) AS score,
+				highlight("TrackSearch", 1, char(2), char(3)) AS highlight
+			FROM "TrackSearch" WHERE "TrackSearch" MATCH ?
+		) s ON s.trackId = t.id
+		WHERE t.status = 'APPROVED'
+		ORDER BY s.score DESC, t.name COLLATE NOCASE
+		LIMIT ?`,
+		match, limit,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	for rows.Next() {
+		var sg models.TrackSuggestion
+		if err := rows.Scan(&sg.ID, &sg.Name, &sg.Location, &sg.State, &sg.Highlight); err != nil {
+			return nil, err
+		}
+		sg.Highlight = search.HighlightHTML(sg.Highlight)
+		suggestions = append(suggestions, sg)
+	}
+	return suggestions, rows.Err()
+}
+
+func encodeTrackCursor(c trackCursor) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeTrackCursor(s string) (*trackCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c trackCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	if c.ID == "" || c.Value == nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// ListByUploader returns every track a user submitted, whatever its status,
+// along with the reason given for its latest moderation decision.
+func (r *TrackRepo) ListByUploader(userID string) ([]models.TrackListItem, error) {
+	tracks, err := r.listWhere(`t.uploadedById = ?`, []interface{}{userID}, `t.createdAt DESC`)
+	if err != nil {
+		return nil, err
+	}
+	return tracks, r.fillStatusReasons(tracks)
+}
+
+// ListByStatus returns one page of tracks in the given moderation status,
+// oldest first so the review queue is worked in submission order.
+func (r *TrackRepo) ListByStatus(status string, limit, offset int) ([]models.TrackListItem, error) {
+	tracks, _, err := r.queryTracks(trackQuery{
+		where: `t.status = ?`, args: []interface{}{status},
+		orderBy: `t.createdAt ASC, t.id ASC`, sortExpr: `t.id`, limit: limit, offset: offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tracks, r.fillStatusReasons(tracks)
+}
+
+func (r *TrackRepo) listWhere(where string, args []interface{}, orderBy string) ([]models.TrackListItem, error) {
+	tracks, _, err := r.queryTracks(trackQuery{where: where, args: args, orderBy: orderBy, sortExpr: `t.id`, limit: -1})
+	return tracks, err
+}
+
+// trackQuery describes a queryTracks call. args holds the arguments for
+// joins followed by those for where, in placeholder order.
+type trackQuery struct {
+	joins    string
+	where    string
+	args     []interface{}
+	orderBy  string
+	sortExpr string
+	snippet  string // selected into TrackListItem.Snippet when set
+	limit    int    // negative means no limit
+	offset   int    // rows to skip; needs a limit
+}
+
+// queryTracks loads list items with their counts and average rating joined in
+// as grouped aggregates, then fetches events for the whole page in one query.
+// It also returns the value of sortExpr for each row, for building cursors.
+func (r *TrackRepo) queryTracks(q trackQuery) ([]models.TrackListItem, []interface{}, error) {
+	snippet := q.snippet
+	if snippet == "" {
+		snippet = `NULL`
+	}
+	query := `SELECT t.id, t.name, t.location, t.state, t.country, t.description, t.imageUrl, t.latitude, t.longitude,
+		t.status, t.isImported, t.source, t.uploadedById, t.createdAt, t.updatedAt,
+		u.id, u.name,
+		COALESCE(rv.reviewCount, 0), COALESCE(rv.avgRating, 0),
+		COALESCE(z.zoneCount, 0), COALESCE(lr.lapCount, 0),
+		` + snippet + `, ` + q.sortExpr + `
+		FROM "Track" t
+		JOIN "User" u ON t.uploadedById = u.id
+		LEFT JOIN (SELECT trackId, COUNT(*) AS reviewCount, AVG(rating) AS avgRating FROM "TrackReview" GROUP BY trackId) rv ON rv.trackId = t.id
+		LEFT JOIN (SELECT trackId, COUNT(*) AS zoneCount FROM "TrackZone" GROUP BY trackId) z ON z.trackId = t.id
+		LEFT JOIN (SELECT trackId, COUNT(*) AS lapCount FROM "LapRecord" GROUP BY trackId) lr ON lr.trackId = t.id
+		` + q.joins + `
+		WHERE ` + q.where + `
+		ORDER BY ` + q.orderBy
+	args := q.args
+	if q.limit >= 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(append([]interface{}{}, args...), q.limit, q.offset)
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
 	var tracks []models.TrackListItem
+	var keys []interface{}
 	for rows.Next() {
 		var t models.TrackListItem
+		var snippet sql.NullString
+		var key interface{}
 		if err := rows.Scan(
-			&t.ID, &t.Name, &t.Location, &t.State, &t.Description, &t.ImageURL,
-			&t.Latitude, &t.Longitude, &t.Status, &t.IsImported, &t.UploadedByID,
+			&t.ID, &t.Name, &t.Location, &t.State, &t.Country, &t.Description, &t.ImageURL,
+			&t.Latitude, &t.Longitude, &t.Status, &t.IsImported, &t.Source, &t.UploadedByID,
 			&t.CreatedAt, &t.UpdatedAt,
 			&t.UploadedBy.ID, &t.UploadedBy.Name,
+			&t.Count.Reviews, &t.AvgRating, &t.Count.Zones, &t.Count.LapRecords,
+			&snippet, &key,
 		); err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		if snippet.Valid {
+			highlighted := search.HighlightHTML(snippet.String)
+			t.Snippet = &highlighted
+		}
+		if b, ok := key.([]byte); ok {
+			key = string(b)
 		}
 		tracks = append(tracks, t)
+		keys = append(keys, key)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	rows.Close()
 
-	// Fetch nested data AFTER closing the rows cursor to avoid SQLite deadlock
+	// Fetch events AFTER closing the rows cursor to avoid SQLite deadlock
+	ids := make([]string, len(tracks))
 	for i := range tracks {
-		events, err := r.GetEvents(tracks[i].ID)
-		if err != nil {
-			return nil, err
+		ids[i] = tracks[i].ID
+	}
+	events, err := r.getEventsForTracks(ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range tracks {
+		tracks[i].Events = events[tracks[i].ID]
+		if tracks[i].Events == nil {
+			tracks[i].Events = []models.TrackEvent{}
 		}
-		tracks[i].Events = events
+	}
 
-		r.db.QueryRow(`SELECT COUNT(*) FROM "TrackReview" WHERE trackId = ?`, tracks[i].ID).Scan(&tracks[i].Count.Reviews)
-		r.db.QueryRow(`SELECT COUNT(*) FROM "TrackZone" WHERE trackId = ?`, tracks[i].ID).Scan(&tracks[i].Count.Zones)
-		r.db.QueryRow(`SELECT COUNT(*) FROM "LapRecord" WHERE trackId = ?`, tracks[i].ID).Scan(&tracks[i].Count.LapRecords)
+	if tracks == nil {
+		tracks = []models.TrackListItem{}
 	}
+	return tracks, keys, nil
+}
 
-	// Get avg ratings in bulk
-	ratingRows, err := r.db.Query(`SELECT trackId, AVG(rating) FROM "TrackReview" GROUP BY trackId`)
-	if err != nil {
-		return nil, err
+// getEventsForTracks loads the events of many tracks at once, keyed by track ID.
+func (r *TrackRepo) getEventsForTracks(trackIDs []string) (map[string][]models.TrackEvent, error) {
+	events := make(map[string][]models.TrackEvent, len(trackIDs))
+	if len(trackIDs) == 0 {
+		return events, nil
 	}
-	defer ratingRows.Close()
 
-	ratingMap := make(map[string]float64)
-	for ratingRows.Next() {
-		var trackID string
-		var avg float64
-		ratingRows.Scan(&trackID, &avg)
-		ratingMap[trackID] = avg
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(trackIDs)), ",")
+	args := make([]interface{}, len(trackIDs))
+	for i, id := range trackIDs {
+		args[i] = id
 	}
 
-	for i := range tracks {
-		tracks[i].AvgRating = ratingMap[tracks[i].ID]
+	rows, err := r.db.Query(`SELECT id, eventType, trackId FROM "TrackEvent" WHERE trackId IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	if tracks == nil {
-		tracks = []models.TrackListItem{}
+	for rows.Next() {
+		var e models.TrackEvent
+		if err := rows.Scan(&e.ID, &e.EventType, &e.TrackID); err != nil {
+			return nil, err
+		}
+		events[e.TrackID] = append(events[e.TrackID], e)
 	}
-	return tracks, nil
+	return events, rows.Err()
 }
 
 func (r *TrackRepo) FindByID(id string) (*models.Track, error) {
 	t := &models.Track{}
+	var line [4]sql.NullFloat64
 	err := r.db.QueryRow(
-		`SELECT id, name, location, state, description, imageUrl, latitude, longitude, status, isImported, uploadedById, createdAt, updatedAt FROM "Track" WHERE id = ?`,
+		`SELECT id, name, location, state, country, description, imageUrl, latitude, longitude, status, isImported, source, uploadedById, createdAt, updatedAt,
+			startLineLat1, startLineLng1, startLineLat2, startLineLng2 FROM "Track" WHERE id = ?`,
 		id,
-	).Scan(&t.ID, &t.Name, &t.Location, &t.State, &t.Description, &t.ImageURL,
-		&t.Latitude, &t.Longitude, &t.Status, &t.IsImported, &t.UploadedByID, &t.CreatedAt, &t.UpdatedAt)
+	).Scan(&t.ID, &t.Name, &t.Location, &t.State, &t.Country, &t.Description, &t.ImageURL,
+		&t.Latitude, &t.Longitude, &t.Status, &t.IsImported, &t.Source, &t.UploadedByID, &t.CreatedAt, &t.UpdatedAt,
+		&line[0], &line[1], &line[2], &line[3])
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
+	t.StartFinishLine = scannedLine(line)
 	return t, nil
 }
 
@@ -130,10 +503,11 @@ func (r *TrackRepo) GetDetail(id string, eventTypeFilter string) (*models.TrackD
 	}
 
 	detail := &models.TrackDetail{
-		ID: t.ID, Name: t.Name, Location: t.Location, State: t.State,
+		ID: t.ID, Name: t.Name, Location: t.Location, State: t.State, Country: t.Country,
 		Description: t.Description, ImageURL: t.ImageURL, Latitude: t.Latitude,
-		Longitude: t.Longitude, Status: t.Status, IsImported: t.IsImported,
+		Longitude: t.Longitude, Status: t.Status, IsImported: t.IsImported, Source: t.Source,
 		UploadedByID: t.UploadedByID, CreatedAt: t.CreatedAt, UpdatedAt: t.UpdatedAt,
+		StartFinishLine: t.StartFinishLine,
 	}
 
 	// Uploader with experience
@@ -179,7 +553,7 @@ func (r *TrackRepo) GetDetail(id string, eventTypeFilter string) (*models.TrackD
 	return detail, nil
 }
 
-func (r *TrackRepo) Create(req models.TrackRequest, userID string) (*models.TrackListItem, error) {
+func (r *TrackRepo) Create(req models.TrackRequest, userID, status string) (*models.TrackListItem, error) {
 	now := time.Now().UTC()
 	id := xid.New().String()
 
@@ -190,8 +564,8 @@ func (r *TrackRepo) Create(req models.TrackRequest, userID string) (*models.Trac
 	defer tx.Rollback()
 
 	_, err = tx.Exec(
-		`INSERT INTO "Track" (id, name, location, description, imageUrl, uploadedById, status, isImported, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, 'APPROVED', false, ?, ?)`,
-		id, req.Name, req.Location, req.Description, req.ImageURL, userID, now, now,
+		`INSERT INTO "Track" (id, name, location, description, imageUrl, uploadedById, status, isImported, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, false, ?, ?)`,
+		id, req.Name, req.Location, req.Description, req.ImageURL, userID, status, now, now,
 	)
 	if err != nil {
 		return nil, err
@@ -215,7 +589,7 @@ func (r *TrackRepo) Create(req models.TrackRequest, userID string) (*models.Trac
 	result := &models.TrackListItem{
 		ID: id, Name: req.Name, Location: req.Location,
 		Description: req.Description, ImageURL: req.ImageURL,
-		Status: "APPROVED", IsImported: false, UploadedByID: userID,
+		Status: status, IsImported: false, UploadedByID: userID,
 		CreatedAt: now, UpdatedAt: now,
 	}
 
@@ -228,7 +602,38 @@ func (r *TrackRepo) Create(req models.TrackRequest, userID string) (*models.Trac
 }
 
 func (r *TrackRepo) Update(id string, req models.TrackPatchRequest) (*models.Track, error) {
+	if err := updateTrack(r.db, id, req, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	return r.FindByID(id)
+}
+
+// UpdateForReview applies an edit and sends the track back to the review
+// queue in one transaction, recording why in its status history, so an edit
+// that fails to save leaves the track as it was.
+func (r *TrackRepo) UpdateForReview(id string, req models.TrackPatchRequest, reason, changedByID string) (*models.Track, error) {
 	now := time.Now().UTC()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := setTrackStatus(tx, id, string(models.TrackStatusPending), &reason, changedByID, now); err != nil {
+		return nil, err
+	}
+	if err := updateTrack(tx, id, req, now); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.FindByID(id)
+}
+
+// updateTrack writes the fields set in req.
+func updateTrack(q querier, id string, req models.TrackPatchRequest, now time.Time) error {
 	sets := []string{"updatedAt = ?"}
 	args := []interface{}{now}
 
@@ -251,11 +656,8 @@ func (r *TrackRepo) Update(id string, req models.TrackPatchRequest) (*models.Tra
 
 	args = append(args, id)
 	query := fmt.Sprintf(`UPDATE "Track" SET %s WHERE id = ?`, strings.Join(sets, ", "))
-	_, err := r.db.Exec(query, args...)
-	if err != nil {
-		return nil, err
-	}
-	return r.FindByID(id)
+	_, err := q.Exec(query, args...)
+	return err
 }
 
 func (r *TrackRepo) GetEvents(trackID string) ([]models.TrackEvent, error) {
@@ -281,31 +683,62 @@ func (r *TrackRepo) GetEvents(trackID string) ([]models.TrackEvent, error) {
 
 func (r *TrackRepo) FindByNameAndLocation(name, location string) (*models.Track, error) {
 	t := &models.Track{}
+	var line [4]sql.NullFloat64
 	err := r.db.QueryRow(
-		`SELECT id, name, location, state, description, imageUrl, latitude, longitude, status, isImported, uploadedById, createdAt, updatedAt FROM "Track" WHERE name = ? AND location = ?`,
+		`SELECT id, name, location, state, country, description, imageUrl, latitude, longitude, status, isImported, source, uploadedById, createdAt, updatedAt,
+			startLineLat1, startLineLng1, startLineLat2, startLineLng2 FROM "Track" WHERE name = ? AND location = ?`,
 		name, location,
-	).Scan(&t.ID, &t.Name, &t.Location, &t.State, &t.Description, &t.ImageURL,
-		&t.Latitude, &t.Longitude, &t.Status, &t.IsImported, &t.UploadedByID, &t.CreatedAt, &t.UpdatedAt)
+	).Scan(&t.ID, &t.Name, &t.Location, &t.State, &t.Country, &t.Description, &t.ImageURL,
+		&t.Latitude, &t.Longitude, &t.Status, &t.IsImported, &t.Source, &t.UploadedByID, &t.CreatedAt, &t.UpdatedAt,
+		&line[0], &line[1], &line[2], &line[3])
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
+	t.StartFinishLine = scannedLine(line)
 	return t, nil
 }
 
-func (r *TrackRepo) UpsertImported(data models.ImportedTrack, systemUserID string) error {
+// FindApprovedByName returns the approved tracks whose name matches,
+// ignoring case. Several tracks can share a name in different places.
+func (r *TrackRepo) FindApprovedByName(name string) ([]models.TrackBrief, error) {
+	rows, err := r.db.Query(
+		`SELECT id, name, location FROM "Track" WHERE name = ? COLLATE NOCASE AND status = 'APPROVED'`,
+		name,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracks := []models.TrackBrief{}
+	for rows.Next() {
+		var t models.TrackBrief
+		if err := rows.Scan(&t.ID, &t.Name, &t.Location); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, rows.Err()
+}
+
+// UpsertImported creates an imported track from a data file, or updates the
+// track with the same name and location. source names the data file's
+// dataset. An empty state or country is stored as null.
+func (r *TrackRepo) UpsertImported(data models.ImportedTrack, source, systemUserID string) error {
 	existing, err := r.FindByNameAndLocation(data.Name, data.Location)
 	if err != nil {
 		return err
 	}
 	now := time.Now().UTC()
+	state, country := nullIfEmpty(data.State), nullIfEmpty(data.Country)
 
 	if existing != nil {
 		_, err = r.db.Exec(
-			`UPDATE "Track" SET description = ?, latitude = ?, longitude = ?, state = ?, isImported = true, updatedAt = ? WHERE id = ?`,
-			data.Description, data.Latitude, data.Longitude, data.State, now, existing.ID,
+			`UPDATE "Track" SET description = ?, latitude = ?, longitude = ?, state = ?, country = ?, source = ?, isImported = true, updatedAt = ? WHERE id = ?`,
+			data.Description, data.Latitude, data.Longitude, state, country, source, now, existing.ID,
 		)
 		return err
 	}
@@ -318,8 +751,8 @@ func (r *TrackRepo) UpsertImported(data models.ImportedTrack, systemUserID strin
 	defer tx.Rollback()
 
 	_, err = tx.Exec(
-		`INSERT INTO "Track" (id, name, location, state, description, latitude, longitude, isImported, status, uploadedById, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, true, 'APPROVED', ?, ?, ?)`,
-		id, data.Name, data.Location, data.State, data.Description, data.Latitude, data.Longitude, systemUserID, now, now,
+		`INSERT INTO "Track" (id, name, location, state, country, description, latitude, longitude, isImported, source, status, uploadedById, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, true, ?, 'APPROVED', ?, ?, ?)`,
+		id, data.Name, data.Location, state, country, data.Description, data.Latitude, data.Longitude, source, systemUserID, now, now,
 	)
 	if err != nil {
 		return err
@@ -338,6 +771,37 @@ func (r *TrackRepo) UpsertImported(data models.ImportedTrack, systemUserID strin
 	return tx.Commit()
 }
 
+// nullIfEmpty stores an empty string as null.
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// ListImported returns the approved tracks imported from source that are
+// owned by the given user (the system user), by name.
+func (r *TrackRepo) ListImported(ownerID, source string) ([]models.TrackBrief, error) {
+	rows, err := r.db.Query(
+		`SELECT id, name, location FROM "Track" WHERE isImported = true AND uploadedById = ? AND source = ? AND status = ? ORDER BY name, location`,
+		ownerID, source, string(models.TrackStatusApproved),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracks := []models.TrackBrief{}
+	for rows.Next() {
+		var t models.TrackBrief
+		if err := rows.Scan(&t.ID, &t.Name, &t.Location); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, rows.Err()
+}
+
 // ─── Track Images ───────────────────────────────────────────────────────────────
 
 func (r *TrackRepo) GetImages(trackID string) ([]models.TrackImageWithUploader, error) {
@@ -410,3 +874,135 @@ func (r *TrackRepo) Exists(id string) (bool, error) {
 	err := r.db.QueryRow(`SELECT COUNT(*) FROM "Track" WHERE id = ?`, id).Scan(&count)
 	return count > 0, err
 }
+
+// IsVisible reports whether a track exists and may be seen by the given user.
+// Approved tracks are public; pending and rejected ones are only visible to
+// their uploader and to moderators.
+func (r *TrackRepo) IsVisible(id, userID string, canModerate bool) (bool, error) {
+	if canModerate {
+		return r.Exists(id)
+	}
+	var count int
+	err := r.db.QueryRow(
+		`SELECT COUNT(*) FROM "Track" WHERE id = ? AND (status = 'APPROVED' OR uploadedById = ?)`, id, userID,
+	).Scan(&count)
+	return count > 0, err
+}
+
+// ─── Moderation ─────────────────────────────────────────────────────────────────
+
+// SetStatus moves a track to a new moderation status and records the change
+// in its status history.
+func (r *TrackRepo) SetStatus(id, status string, reason *string, changedByID string) (*models.Track, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := setTrackStatus(tx, id, status, reason, changedByID, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.FindByID(id)
+}
+
+// setTrackStatus moves a track to status and adds the change to its status
+// history.
+func setTrackStatus(q querier, id, status string, reason *string, changedByID string, now time.Time) error {
+	var from string
+	if err := q.QueryRow(`SELECT status FROM "Track" WHERE id = ?`, id).Scan(&from); err != nil {
+		return err
+	}
+
+	_, err := q.Exec(`UPDATE "Track" SET status = ?, updatedAt = ? WHERE id = ?`, status, now, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(
+		`INSERT INTO "TrackStatusChange" (id, trackId, fromStatus, toStatus, reason, changedById, createdAt) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		xid.New().String(), id, from, status, reason, changedByID, now,
+	)
+	return err
+}
+
+func (r *TrackRepo) GetStatusHistory(trackID string) ([]models.TrackStatusChange, error) {
+	rows, err := r.db.Query(
+		`SELECT sc.id, sc.trackId, sc.fromStatus, sc.toStatus, sc.reason, sc.changedById, sc.createdAt,
+			u.id, u.name
+		FROM "TrackStatusChange" sc
+		JOIN "User" u ON sc.changedById = u.id
+		WHERE sc.trackId = ?
+		ORDER BY sc.createdAt ASC`,
+		trackID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []models.TrackStatusChange
+	for rows.Next() {
+		var sc models.TrackStatusChange
+		if err := rows.Scan(&sc.ID, &sc.TrackID, &sc.FromStatus, &sc.ToStatus, &sc.Reason, &sc.ChangedByID, &sc.CreatedAt,
+			&sc.ChangedBy.ID, &sc.ChangedBy.Name); err != nil {
+			return nil, err
+		}
+		history = append(history, sc)
+	}
+	if history == nil {
+		history = []models.TrackStatusChange{}
+	}
+	return history, rows.Err()
+}
+
+// fillStatusReasons sets each track's StatusReason to the reason given for
+// its latest status change, loading them all in one query.
+func (r *TrackRepo) fillStatusReasons(tracks []models.TrackListItem) error {
+	if len(tracks) == 0 {
+		return nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(tracks)), ",")
+	args := make([]interface{}, len(tracks))
+	for i := range tracks {
+		args[i] = tracks[i].ID
+	}
+
+	// With MAX(), SQLite takes the bare reason column from the latest row
+	rows, err := r.db.Query(
+		`SELECT trackId, reason, MAX(createdAt) FROM "TrackStatusChange"
+		WHERE trackId IN (`+placeholders+`)
+		GROUP BY trackId`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	reasons := make(map[string]string, len(tracks))
+	for rows.Next() {
+		var trackID string
+		var reason sql.NullString
+		var latest interface{}
+		if err := rows.Scan(&trackID, &reason, &latest); err != nil {
+			return err
+		}
+		if reason.Valid {
+			reasons[trackID] = reason.String
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range tracks {
+		if reason, ok := reasons[tracks[i].ID]; ok {
+			tracks[i].StatusReason = &reason
+		}
+	}
+	return nil
+}
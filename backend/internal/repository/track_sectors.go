@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/rs/xid"
+)
+
+// GetSectors returns a track's timing sectors in lap order.
+func (r *TrackRepo) GetSectors(trackID string) ([]models.TrackSector, error) {
+	rows, err := r.db.Query(
+		`SELECT id, position, name, trackId, zoneId, lineLat1, lineLng1, lineLat2, lineLng2, createdAt
+		FROM "TrackSector" WHERE trackId = ? ORDER BY position`,
+		trackID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sectors []models.TrackSector
+	for rows.Next() {
+		var s models.TrackSector
+		var line [4]sql.NullFloat64
+		if err := rows.Scan(&s.ID, &s.Position, &s.Name, &s.TrackID, &s.ZoneID,
+			&line[0], &line[1], &line[2], &line[3], &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		s.Line = scannedLine(line)
+		sectors = append(sectors, s)
+	}
+	if sectors == nil {
+		sectors = []models.TrackSector{}
+	}
+	return sectors, rows.Err()
+}
+
+// SetSectors replaces a track's sectors with the given list, numbering them
+// in order. Inputs with the id of one of the track's sectors update that
+// sector; sectors left out are deleted together with their splits. Names
+// must already be filled in.
+func (r *TrackRepo) SetSectors(trackID string, sectors []models.TrackSectorInput) ([]models.TrackSector, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	keep := map[string]bool{}
+	for _, s := range sectors {
+		if s.ID != nil {
+			keep[*s.ID] = true
+		}
+	}
+
+	rows, err := tx.Query(`SELECT id FROM "TrackSector" WHERE trackId = ?`, trackID)
+	if err != nil {
+		return nil, err
+	}
+	var remove []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !keep[id] {
+			remove = append(remove, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range remove {
+		if _, err := tx.Exec(`DELETE FROM "TrackSector" WHERE id = ?`, id); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now().UTC()
+	for i, s := range sectors {
+		line := lineValues(s.Line)
+		if s.ID != nil {
+			_, err = tx.Exec(
+				`UPDATE "TrackSector" SET position = ?, name = ?, zoneId = ?,
+					lineLat1 = ?, lineLng1 = ?, lineLat2 = ?, lineLng2 = ?
+				WHERE id = ? AND trackId = ?`,
+				i+1, s.Name, s.ZoneID, line[0], line[1], line[2], line[3], *s.ID, trackID,
+			)
+		} else {
+			_, err = tx.Exec(
+				`INSERT INTO "TrackSector" (id, position, name, trackId, zoneId, lineLat1, lineLng1, lineLat2, lineLng2, createdAt)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				xid.New().String(), i+1, s.Name, trackID, s.ZoneID, line[0], line[1], line[2], line[3], now,
+			)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.GetSectors(trackID)
+}
+
+// SetStartFinishLine sets or, with a nil line, clears the line that starts
+// and ends laps at a track.
+func (r *TrackRepo) SetStartFinishLine(trackID string, line *models.TimingLine) (*models.Track, error) {
+	v := lineValues(line)
+	_, err := r.db.Exec(
+		`UPDATE "Track" SET startLineLat1 = ?, startLineLng1 = ?, startLineLat2 = ?, startLineLng2 = ?, updatedAt = ?
+		WHERE id = ?`,
+		v[0], v[1], v[2], v[3], time.Now().UTC(), trackID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return r.FindByID(trackID)
+}
+
+// scannedLine builds a timing line from its four stored coordinates, or
+// returns nil if they are not set.
+func scannedLine(v [4]sql.NullFloat64) *models.TimingLine {
+	for _, f := range v {
+		if !f.Valid {
+			return nil
+		}
+	}
+	return &models.TimingLine{Lat1: v[0].Float64, Lng1: v[1].Float64, Lat2: v[2].Float64, Lng2: v[3].Float64}
+}
+
+// lineValues returns a timing line's coordinates for storing, all NULL for
+// a nil line.
+func lineValues(line *models.TimingLine) [4]interface{} {
+	if line == nil {
+		return [4]interface{}{}
+	}
+	return [4]interface{}{line.Lat1, line.Lng1, line.Lat2, line.Lng2}
+}
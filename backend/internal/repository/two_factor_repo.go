@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/rs/xid"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets at a time.
+const recoveryCodeCount = 10
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactorRepo stores users' TOTP secrets in "TwoFactor" and their
+// recovery codes in "RecoveryCode".
+type TwoFactorRepo struct {
+	db *sql.DB
+}
+
+func NewTwoFactorRepo(db *sql.DB) *TwoFactorRepo {
+	return &TwoFactorRepo{db: db}
+}
+
+// Get returns the user's TOTP setup, or nil if they haven't started one.
+func (r *TwoFactorRepo) Get(userID string) (*models.TwoFactor, error) {
+	tf := &models.TwoFactor{}
+	err := r.db.QueryRow(
+		`SELECT secret, enabledAt, lastStep, failedAttempts, lockedUntil FROM "TwoFactor" WHERE userId = ?`,
+		userID,
+	).Scan(&tf.Secret, &tf.EnabledAt, &tf.LastStep, &tf.FailedAttempts, &tf.LockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tf, nil
+}
+
+// IsEnabled reports whether the user has two-factor authentication on.
+func (r *TwoFactorRepo) IsEnabled(userID string) (bool, error) {
+	var enabled bool
+	err := r.db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM "TwoFactor" WHERE userId = ? AND enabledAt IS NOT NULL)`,
+		userID,
+	).Scan(&enabled)
+	return enabled, err
+}
+
+// Start stores a new secret for the user to confirm, replacing any setup
+// they didn't finish. It must not be called once two-factor is enabled.
+func (r *TwoFactorRepo) Start(userID, secret string) error {
+	_, err := r.db.Exec(
+		`INSERT INTO "TwoFactor" (userId, secret, createdAt) VALUES (?, ?, ?)
+		 ON CONFLICT (userId) DO UPDATE SET secret = excluded.secret, lastStep = 0, createdAt = excluded.createdAt
+		 WHERE enabledAt IS NULL`,
+		userID, secret, time.Now().UTC(),
+	)
+	return err
+}
+
+// Enable turns two-factor authentication on once the user has confirmed a
+// code, and returns their first recovery codes.
+func (r *TwoFactorRepo) Enable(userID string) ([]string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`UPDATE "TwoFactor" SET enabledAt = ?, failedAttempts = 0, lockedUntil = NULL WHERE userId = ?`,
+		time.Now().UTC(), userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// Disable turns two-factor authentication off and drops the recovery codes.
+func (r *TwoFactorRepo) Disable(userID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM "TwoFactor" WHERE userId = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM "RecoveryCode" WHERE userId = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseStep records that a code from step was accepted. It reports false if
+// a code from that step or a later one was already used.
+func (r *TwoFactorRepo) UseStep(userID string, step int64) (bool, error) {
+	res, err := r.db.Exec(
+		`UPDATE "TwoFactor" SET lastStep = ?, failedAttempts = 0 WHERE userId = ? AND lastStep < ?`,
+		step, userID, step,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UseRecoveryCode uses up one of the user's recovery codes and reports
+// whether it was valid.
+func (r *TwoFactorRepo) UseRecoveryCode(userID, code string) (bool, error) {
+	res, err := r.db.Exec(
+		`UPDATE "RecoveryCode" SET usedAt = ? WHERE userId = ? AND codeHash = ? AND usedAt IS NULL`,
+		time.Now().UTC(), userID, hashToken(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	_, err = r.db.Exec(`UPDATE "TwoFactor" SET failedAttempts = 0 WHERE userId = ?`, userID)
+	return true, err
+}
+
+// RecordFailure counts a wrong code. After maxAttempts in a row, codes are
+// refused until lockFor has passed.
+func (r *TwoFactorRepo) RecordFailure(userID string, maxAttempts int, lockFor time.Duration) error {
+	_, err := r.db.Exec(
+		`UPDATE "TwoFactor" SET
+			lockedUntil = CASE WHEN failedAttempts + 1 >= ? THEN ? ELSE lockedUntil END,
+			failedAttempts = CASE WHEN failedAttempts + 1 >= ? THEN 0 ELSE failedAttempts + 1 END
+		 WHERE userId = ?`,
+		maxAttempts, time.Now().UTC().Add(lockFor), maxAttempts, userID,
+	)
+	return err
+}
+
+// ReplaceRecoveryCodes gives the user a new set of recovery codes; the old
+// ones stop working.
+func (r *TwoFactorRepo) ReplaceRecoveryCodes(userID string) ([]string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// RecoveryCodesLeft returns how many of the user's recovery codes are unused.
+func (r *TwoFactorRepo) RecoveryCodesLeft(userID string) (int, error) {
+	var count int
+	err := r.db.QueryRow(
+		`SELECT COUNT(*) FROM "RecoveryCode" WHERE userId = ? AND usedAt IS NULL`, userID,
+	).Scan(&count)
+	return count, err
+}
+
+func replaceRecoveryCodes(q querier, userID string) ([]string, error) {
+	if _, err := q.Exec(`DELETE FROM "RecoveryCode" WHERE userId = ?`, userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = q.Exec(
+			`INSERT INTO "RecoveryCode" (id, userId, codeHash) VALUES (?, ?, ?)`,
+			xid.New().String(), userID, hashToken(normalizeRecoveryCode(code)),
+		)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// newRecoveryCode returns a random code like "k3x9q-mw2zt".
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(recoveryCodeEncoding.EncodeToString(buf))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode lets users type a recovery code in either case and
+// with or without the dash.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -20,9 +20,9 @@ func NewUserRepo(db *sql.DB) *UserRepo {
 func (r *UserRepo) FindByEmail(email string) (*models.User, error) {
 	u := &models.User{}
 	err := r.db.QueryRow(
-		`SELECT id, name, email, passwordHash, image, experience, createdAt, updatedAt FROM "User" WHERE email = ?`,
+		`SELECT id, name, email, passwordHash, image, experience, role, leaderboardOptOut, emailVerified, createdAt, updatedAt FROM "User" WHERE email = ?`,
 		email,
-	).Scan(&u.ID, &u.Name, &u.Email, &u.PasswordHash, &u.Image, &u.Experience, &u.CreatedAt, &u.UpdatedAt)
+	).Scan(&u.ID, &u.Name, &u.Email, &u.PasswordHash, &u.Image, &u.Experience, &u.Role, &u.LeaderboardOptOut, &u.EmailVerified, &u.CreatedAt, &u.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -35,9 +35,9 @@ func (r *UserRepo) FindByEmail(email string) (*models.User, error) {
 func (r *UserRepo) FindByID(id string) (*models.User, error) {
 	u := &models.User{}
 	err := r.db.QueryRow(
-		`SELECT id, name, email, passwordHash, image, experience, createdAt, updatedAt FROM "User" WHERE id = ?`,
+		`SELECT id, name, email, passwordHash, image, experience, role, leaderboardOptOut, emailVerified, createdAt, updatedAt FROM "User" WHERE id = ?`,
 		id,
-	).Scan(&u.ID, &u.Name, &u.Email, &u.PasswordHash, &u.Image, &u.Experience, &u.CreatedAt, &u.UpdatedAt)
+	).Scan(&u.ID, &u.Name, &u.Email, &u.PasswordHash, &u.Image, &u.Experience, &u.Role, &u.LeaderboardOptOut, &u.EmailVerified, &u.CreatedAt, &u.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -66,16 +66,19 @@ func (r *UserRepo) Create(name, email, password string) (*models.User, error) {
 		Name:      &name,
 		Email:     email,
 		Experience: "BEGINNER",
+		Role:      string(models.RoleUser),
 		CreatedAt: now,
 		UpdatedAt: now,
 	}, nil
 }
 
-func (r *UserRepo) UpdateProfile(id, name, experience string) (*models.User, error) {
+// UpdateProfile sets the user's name and experience, and their leaderboard
+// opt-out when leaderboardOptOut is non-nil.
+func (r *UserRepo) UpdateProfile(id, name, experience string, leaderboardOptOut *bool) (*models.User, error) {
 	now := time.Now().UTC()
 	_, err := r.db.Exec(
-		`UPDATE "User" SET name = ?, experience = ?, updatedAt = ? WHERE id = ?`,
-		name, experience, now, id,
+		`UPDATE "User" SET name = ?, experience = ?, leaderboardOptOut = COALESCE(?, leaderboardOptOut), updatedAt = ? WHERE id = ?`,
+		name, experience, leaderboardOptOut, now, id,
 	)
 	if err != nil {
 		return nil, err
@@ -94,8 +97,12 @@ func (r *UserRepo) GetProfile(id string) (*models.ProfileResponse, error) {
 		Name:       u.Name,
 		Email:      u.Email,
 		Experience: u.Experience,
+		Role:       u.Role,
 		Image:      u.Image,
 		CreatedAt:  u.CreatedAt,
+
+		LeaderboardOptOut: u.LeaderboardOptOut,
+		EmailVerified:     u.EmailVerified,
 	}
 
 	// Get cars with mods
@@ -115,8 +122,86 @@ func (r *UserRepo) GetProfile(id string) (*models.ProfileResponse, error) {
 	return profile, nil
 }
 
+// MarkEmailVerified records that the user has shown they own their email
+// address. It keeps the time of the first verification.
+func (r *UserRepo) MarkEmailVerified(id string) error {
+	now := time.Now().UTC()
+	_, err := r.db.Exec(
+		`UPDATE "User" SET emailVerified = COALESCE(emailVerified, ?), updatedAt = ? WHERE id = ?`,
+		now, now, id,
+	)
+	return err
+}
+
+// IsEmailVerified reports whether the user has verified their email address.
+func (r *UserRepo) IsEmailVerified(id string) (bool, error) {
+	var verified bool
+	err := r.db.QueryRow(`SELECT emailVerified IS NOT NULL FROM "User" WHERE id = ?`, id).Scan(&verified)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return verified, err
+}
+
+func (r *UserRepo) SetPassword(id, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(
+		`UPDATE "User" SET passwordHash = ?, updatedAt = ? WHERE id = ?`,
+		string(hash), time.Now().UTC(), id,
+	)
+	return err
+}
+
+// CreateFromProvider creates a user who signed up through an OIDC provider.
+// They have no password, and the provider has verified their email.
+func (r *UserRepo) CreateFromProvider(name, email string, image *string) (*models.User, error) {
+	now := time.Now().UTC()
+	id := xid.New().String()
+	_, err := r.db.Exec(
+		`INSERT INTO "User" (id, name, email, emailVerified, image, experience, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, name, email, now, image, "BEGINNER", now, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return r.FindByID(id)
+}
+
+// RemovePassword leaves the user able to sign in only through a provider
+// or by resetting their password.
+func (r *UserRepo) RemovePassword(id string) error {
+	_, err := r.db.Exec(
+		`UPDATE "User" SET passwordHash = NULL, updatedAt = ? WHERE id = ?`,
+		time.Now().UTC(), id,
+	)
+	return err
+}
+
+// FindSystem returns the system user that owns imported tracks, or nil if
+// nothing has been imported yet. An account someone registered with the
+// system address before it was reserved has a password or a linked
+// provider account, and is not taken for it.
+func (r *UserRepo) FindSystem() (*models.User, error) {
+	u := &models.User{}
+	err := r.db.QueryRow(
+		`SELECT id, name, email, passwordHash, image, experience, role, leaderboardOptOut, emailVerified, createdAt, updatedAt FROM "User" u
+		 WHERE email = ? AND passwordHash IS NULL AND NOT EXISTS (SELECT 1 FROM "Account" a WHERE a.userId = u.id)`,
+		models.SystemEmail,
+	).Scan(&u.ID, &u.Name, &u.Email, &u.PasswordHash, &u.Image, &u.Experience, &u.Role, &u.LeaderboardOptOut, &u.EmailVerified, &u.CreatedAt, &u.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
 func (r *UserRepo) FindOrCreateSystem() (*models.User, error) {
-	u, err := r.FindByEmail("system@trackside.local")
+	u, err := r.FindSystem()
 	if err != nil {
 		return nil, err
 	}
@@ -127,8 +212,8 @@ func (r *UserRepo) FindOrCreateSystem() (*models.User, error) {
 	now := time.Now().UTC()
 	id := xid.New().String()
 	_, err = r.db.Exec(
-		`INSERT INTO "User" (id, name, email, emailVerified, experience, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		id, "Trackside System", "system@trackside.local", now, "BEGINNER", now, now,
+		`INSERT INTO "User" (id, name, email, emailVerified, experience, role, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, "Trackside System", models.SystemEmail, now, "BEGINNER", string(models.RoleAdmin), now, now,
 	)
 	if err != nil {
 		return nil, err
@@ -137,9 +222,19 @@ func (r *UserRepo) FindOrCreateSystem() (*models.User, error) {
 	return &models.User{
 		ID:        id,
 		Name:      &name,
-		Email:     "system@trackside.local",
+		Email:     models.SystemEmail,
 		Experience: "BEGINNER",
+		Role:      string(models.RoleAdmin),
 		CreatedAt: now,
 		UpdatedAt: now,
 	}, nil
 }
+
+func (r *UserRepo) SetRole(id, role string) (*models.User, error) {
+	now := time.Now().UTC()
+	_, err := r.db.Exec(`UPDATE "User" SET role = ?, updatedAt = ? WHERE id = ?`, role, now, id)
+	if err != nil {
+		return nil, err
+	}
+	return r.FindByID(id)
+}
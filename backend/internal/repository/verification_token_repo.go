@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Verification token purposes. A token's identifier in the
+// "VerificationToken" table is its purpose and user ID, e.g.
+// "verify-email:<userId>".
+const (
+	TokenVerifyEmail   = "verify-email"
+	TokenResetPassword = "reset-password"
+)
+
+// ErrInvalidToken is returned by Consume for a verification token that is
+// unknown, expired, already used or meant for something else.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+type VerificationTokenRepo struct {
+	db *sql.DB
+}
+
+func NewVerificationTokenRepo(db *sql.DB) *VerificationTokenRepo {
+	return &VerificationTokenRepo{db: db}
+}
+
+// Create issues a token for the user that expires after ttl, replacing any
+// earlier token for the same purpose so only the latest email's link works.
+func (r *VerificationTokenRepo) Create(purpose, userID string, ttl time.Duration) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	identifier := purpose + ":" + userID
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM "VerificationToken" WHERE identifier = ?`, identifier); err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO "VerificationToken" (identifier, token, expires) VALUES (?, ?, ?)`,
+		identifier, hashToken(token), time.Now().UTC().Add(ttl),
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// Consume uses up a token and returns the ID of the user it was issued to.
+// A token works once, even if it turns out to be expired or for another
+// purpose.
+func (r *VerificationTokenRepo) Consume(purpose, token string) (string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var identifier string
+	var expires time.Time
+	err = tx.QueryRow(
+		`SELECT identifier, expires FROM "VerificationToken" WHERE token = ?`, hashToken(token),
+	).Scan(&identifier, &expires)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidToken
+	}
+	if err != nil {
+		return "", err
+	}
+	res, err := tx.Exec(`DELETE FROM "VerificationToken" WHERE token = ?`, hashToken(token))
+	if err != nil {
+		return "", err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return "", ErrInvalidToken
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	userID, ok := strings.CutPrefix(identifier, purpose+":")
+	if !ok || !expires.After(time.Now().UTC()) {
+		return "", ErrInvalidToken
+	}
+	return userID, nil
+}
+
+// DeleteForUser drops the user's outstanding tokens for a purpose.
+func (r *VerificationTokenRepo) DeleteForUser(purpose, userID string) error {
+	_, err := r.db.Exec(`DELETE FROM "VerificationToken" WHERE identifier = ?`, purpose+":"+userID)
+	return err
+}
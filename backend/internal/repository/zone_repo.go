@@ -17,7 +17,7 @@ func NewZoneRepo(db *sql.DB) *ZoneRepo {
 }
 
 func (r *ZoneRepo) GetZonesWithTips(trackID string, eventTypeFilter string) ([]models.TrackZoneWithTips, error) {
-	query := `SELECT id, name, description, posX, posY, trackId, eventType, createdAt FROM "TrackZone" WHERE trackId = ?`
+	query := `SELECT id, name, description, posX, posY, trackId, eventType, isImported, createdAt FROM "TrackZone" WHERE trackId = ?`
 	args := []interface{}{trackID}
 
 	if eventTypeFilter != "" {
@@ -34,7 +34,7 @@ func (r *ZoneRepo) GetZonesWithTips(trackID string, eventTypeFilter string) ([]m
 	var zones []models.TrackZoneWithTips
 	for rows.Next() {
 		var z models.TrackZoneWithTips
-		if err := rows.Scan(&z.ID, &z.Name, &z.Description, &z.PosX, &z.PosY, &z.TrackID, &z.EventType, &z.CreatedAt); err != nil {
+		if err := rows.Scan(&z.ID, &z.Name, &z.Description, &z.PosX, &z.PosY, &z.TrackID, &z.EventType, &z.IsImported, &z.CreatedAt); err != nil {
 			return nil, err
 		}
 		zones = append(zones, z)
@@ -108,9 +108,9 @@ func (r *ZoneRepo) Create(name string, description *string, posX, posY float64,
 func (r *ZoneRepo) FindByID(zoneID string) (*models.TrackZone, error) {
 	z := &models.TrackZone{}
 	err := r.db.QueryRow(
-		`SELECT id, name, description, posX, posY, trackId, eventType, createdAt FROM "TrackZone" WHERE id = ?`,
+		`SELECT id, name, description, posX, posY, trackId, eventType, isImported, createdAt FROM "TrackZone" WHERE id = ?`,
 		zoneID,
-	).Scan(&z.ID, &z.Name, &z.Description, &z.PosX, &z.PosY, &z.TrackID, &z.EventType, &z.CreatedAt)
+	).Scan(&z.ID, &z.Name, &z.Description, &z.PosX, &z.PosY, &z.TrackID, &z.EventType, &z.IsImported, &z.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -120,6 +120,53 @@ func (r *ZoneRepo) FindByID(zoneID string) (*models.TrackZone, error) {
 	return z, nil
 }
 
+// FindByTrackAndName returns a track's zone with the given name, ignoring
+// case, preferring an imported zone if there are several.
+func (r *ZoneRepo) FindByTrackAndName(trackID, name string) (*models.TrackZone, error) {
+	z := &models.TrackZone{}
+	err := r.db.QueryRow(
+		`SELECT id, name, description, posX, posY, trackId, eventType, isImported, createdAt FROM "TrackZone"
+		WHERE trackId = ? AND name = ? COLLATE NOCASE
+		ORDER BY isImported DESC, createdAt
+		LIMIT 1`,
+		trackID, name,
+	).Scan(&z.ID, &z.Name, &z.Description, &z.PosX, &z.PosY, &z.TrackID, &z.EventType, &z.IsImported, &z.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// UpsertImported creates an imported zone on a track, or updates the
+// track's imported zone of the same name. Zones added by users are never
+// changed.
+func (r *ZoneRepo) UpsertImported(trackID string, data models.ImportedZone) error {
+	var existingID string
+	err := r.db.QueryRow(
+		`SELECT id FROM "TrackZone" WHERE trackId = ? AND name = ? COLLATE NOCASE AND isImported = true ORDER BY createdAt LIMIT 1`,
+		trackID, data.Name,
+	).Scan(&existingID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if existingID != "" {
+		_, err = r.db.Exec(
+			`UPDATE "TrackZone" SET name = ?, description = ?, posX = ?, posY = ? WHERE id = ?`,
+			data.Name, data.Description, data.PosX, data.PosY, existingID,
+		)
+		return err
+	}
+	_, err = r.db.Exec(
+		`INSERT INTO "TrackZone" (id, name, description, posX, posY, trackId, isImported, createdAt) VALUES (?, ?, ?, ?, ?, ?, true, ?)`,
+		xid.New().String(), data.Name, data.Description, data.PosX, data.PosY, trackID, time.Now().UTC(),
+	)
+	return err
+}
+
 func (r *ZoneRepo) Update(zoneID string, name *string, description *string) (*models.TrackZoneWithTips, error) {
 	if name != nil {
 		r.db.Exec(`UPDATE "TrackZone" SET name = ? WHERE id = ?`, *name, zoneID)
@@ -140,7 +187,7 @@ func (r *ZoneRepo) Update(zoneID string, name *string, description *string) (*mo
 
 	return &models.TrackZoneWithTips{
 		ID: z.ID, Name: z.Name, Description: z.Description, PosX: z.PosX, PosY: z.PosY,
-		TrackID: z.TrackID, EventType: z.EventType, CreatedAt: z.CreatedAt,
+		TrackID: z.TrackID, EventType: z.EventType, IsImported: z.IsImported, CreatedAt: z.CreatedAt,
 		Tips: tips,
 	}, nil
 }
@@ -172,9 +219,9 @@ func (r *ZoneRepo) CreateTip(content string, conditions *string, zoneID, authorI
 func (r *ZoneRepo) FindZoneForTrack(zoneID, trackID string) (*models.TrackZone, error) {
 	z := &models.TrackZone{}
 	err := r.db.QueryRow(
-		`SELECT id, name, description, posX, posY, trackId, eventType, createdAt FROM "TrackZone" WHERE id = ? AND trackId = ?`,
+		`SELECT id, name, description, posX, posY, trackId, eventType, isImported, createdAt FROM "TrackZone" WHERE id = ? AND trackId = ?`,
 		zoneID, trackID,
-	).Scan(&z.ID, &z.Name, &z.Description, &z.PosX, &z.PosY, &z.TrackID, &z.EventType, &z.CreatedAt)
+	).Scan(&z.ID, &z.Name, &z.Description, &z.PosX, &z.PosY, &z.TrackID, &z.EventType, &z.IsImported, &z.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -5,7 +5,10 @@ import (
 
 	"github.com/joezmuda/trackside-backend/internal/config"
 	"github.com/joezmuda/trackside-backend/internal/handlers"
+	"github.com/joezmuda/trackside-backend/internal/mailer"
 	mw "github.com/joezmuda/trackside-backend/internal/middleware"
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/joezmuda/trackside-backend/internal/oidc"
 	"github.com/joezmuda/trackside-backend/internal/repository"
 	"github.com/labstack/echo/v4"
 	echomw "github.com/labstack/echo/v4/middleware"
@@ -24,39 +27,94 @@ func Setup(e *echo.Echo, db *sql.DB, cfg *config.Config) {
 	zoneRepo := repository.NewZoneRepo(db)
 	reviewRepo := repository.NewReviewRepo(db)
 	lapbookRepo := repository.NewLapbookRepo(db)
+	sessionRepo := repository.NewSessionRepo(db)
+	leaderboardRepo := repository.NewLeaderboardRepo(db)
+	classRepo := repository.NewCarClassRepo(db)
+	syncRunRepo := repository.NewSyncRunRepo(db)
+	authSessionRepo := repository.NewAuthSessionRepo(db)
+	verificationTokenRepo := repository.NewVerificationTokenRepo(db)
+	accountRepo := repository.NewAccountRepo(db)
+	oauthStateRepo := repository.NewOAuthStateRepo(db)
+	twoFactorRepo := repository.NewTwoFactorRepo(db)
+	apiTokenRepo := repository.NewAPITokenRepo(db)
+
+	// Sign-in providers
+	providers := make([]*oidc.Provider, 0, len(cfg.OIDCProviders))
+	for _, p := range cfg.OIDCProviders {
+		providers = append(providers, oidc.NewProvider(p))
+	}
 
 	// Handlers
-	authHandler := handlers.NewAuthHandler(userRepo, cfg.JWTSecret)
+	authHandler := handlers.NewAuthHandler(userRepo, authSessionRepo, verificationTokenRepo, twoFactorRepo, apiTokenRepo, mailer.New(cfg.MailDir), cfg.AppURL, cfg.JWTSecret)
+	twoFactorHandler := handlers.NewTwoFactorHandler(authHandler, userRepo, twoFactorRepo, cfg.TwoFactorRole)
+	oidcHandler := handlers.NewOIDCHandler(authHandler, userRepo, accountRepo, oauthStateRepo, providers)
 	carHandler := handlers.NewCarHandler(carRepo)
 	carModHandler := handlers.NewCarModHandler(carRepo)
+	carClassHandler := handlers.NewCarClassHandler(classRepo, carRepo, cfg.DataDir)
 	trackHandler := handlers.NewTrackHandler(trackRepo)
 	trackImageHandler := handlers.NewTrackImageHandler(trackRepo)
 	trackReviewHandler := handlers.NewTrackReviewHandler(trackRepo, reviewRepo)
 	trackZoneHandler := handlers.NewTrackZoneHandler(trackRepo, zoneRepo)
+	trackSectorHandler := handlers.NewTrackSectorHandler(trackRepo, zoneRepo)
 	zoneTipHandler := handlers.NewZoneTipHandler(zoneRepo)
-	lapbookHandler := handlers.NewLapbookHandler(lapbookRepo, carRepo, trackRepo)
+	lapbookHandler := handlers.NewLapbookHandler(lapbookRepo, sessionRepo, carRepo, trackRepo)
+	sessionHandler := handlers.NewSessionHandler(sessionRepo, lapbookRepo, carRepo, trackRepo)
+	leaderboardHandler := handlers.NewLeaderboardHandler(leaderboardRepo, trackRepo)
 	profileHandler := handlers.NewProfileHandler(userRepo)
+	apiTokenHandler := handlers.NewAPITokenHandler(apiTokenRepo)
 	uploadHandler := handlers.NewUploadHandler(cfg.UploadDir)
-	adminHandler := handlers.NewAdminHandler(trackRepo, userRepo, cfg.DataDir)
+	adminHandler := handlers.NewAdminHandler(trackRepo, userRepo, zoneRepo, syncRunRepo, cfg.DataDir)
+	moderationHandler := handlers.NewModerationHandler(trackRepo)
 
 	// Auth middleware
-	authMW := mw.AuthMiddleware(cfg.JWTSecret)
+	authMW := mw.AuthMiddleware(cfg.JWTSecret, authSessionRepo, apiTokenRepo)
+	optionalAuthMW := mw.OptionalAuthMiddleware(cfg.JWTSecret, authSessionRepo, apiTokenRepo)
+	verifiedMW := mw.RequireVerifiedEmail(userRepo)
+	twoFactorMW := mw.RequireTwoFactor(twoFactorRepo, cfg.TwoFactorRole)
 
 	// ─── Public routes ──────────────────────────────────────────────────────────
-	api := e.Group("/api")
+	// Moderators who need two-factor authentication only act as one once it is on
+	api := e.Group("/api", mw.TwoFactorPolicy(twoFactorRepo, cfg.TwoFactorRole))
 
 	// Auth
 	api.POST("/register", authHandler.Register)
 	api.POST("/auth/login", authHandler.Login)
-
-	// Public track endpoints
+	api.POST("/auth/refresh", authHandler.Refresh)
+	api.POST("/auth/verify-email", authHandler.VerifyEmail)
+	api.POST("/auth/forgot-password", authHandler.ForgotPassword)
+	api.POST("/auth/reset-password", authHandler.ResetPassword)
+	api.POST("/auth/2fa/verify", twoFactorHandler.Verify)
+	api.GET("/auth/oidc/providers", oidcHandler.Providers)
+	api.POST("/auth/oidc/:provider/start", oidcHandler.Start)
+	api.POST("/auth/oidc/:provider/callback", oidcHandler.Callback, optionalAuthMW)
+
+	// Public track endpoints (unapproved tracks are only shown to their uploader)
 	api.GET("/tracks", trackHandler.List)
-	api.GET("/tracks/:id", trackHandler.GetByID)
-	api.GET("/tracks/:id/images", trackImageHandler.List)
+	api.GET("/tracks/autocomplete", trackHandler.Autocomplete)
+	api.GET("/tracks/:id", trackHandler.GetByID, optionalAuthMW)
+	api.GET("/tracks/:id/images", trackImageHandler.List, optionalAuthMW)
+	api.GET("/tracks/:id/leaderboard", leaderboardHandler.Get, optionalAuthMW)
+	api.GET("/tracks/:id/sectors", trackSectorHandler.List, optionalAuthMW)
+
+	// Car classes
+	api.GET("/classes", carClassHandler.List)
 
 	// ─── Protected routes ───────────────────────────────────────────────────────
+	// API tokens only work for the cars, lapbook, sessions and tracks routes
 	auth := api.Group("", authMW)
 
+	// Auth (protected)
+	auth.POST("/auth/logout", authHandler.Logout)
+	auth.POST("/auth/logout-all", authHandler.LogoutAll)
+	auth.POST("/auth/resend-verification", authHandler.ResendVerification)
+
+	// Two-factor authentication
+	auth.GET("/auth/2fa", twoFactorHandler.Status)
+	auth.POST("/auth/2fa/setup", twoFactorHandler.Setup)
+	auth.POST("/auth/2fa/enable", twoFactorHandler.Enable)
+	auth.POST("/auth/2fa/disable", twoFactorHandler.Disable)
+	auth.POST("/auth/2fa/recovery-codes", twoFactorHandler.RecoveryCodes)
+
 	// Cars
 	auth.GET("/cars", carHandler.List)
 	auth.POST("/cars", carHandler.Create)
@@ -67,39 +125,86 @@ func Setup(e *echo.Echo, db *sql.DB, cfg *config.Config) {
 	auth.POST("/cars/:id/mods", carModHandler.Create)
 	auth.DELETE("/cars/:id/mods/:modId", carModHandler.Delete)
 
+	// Car classes
+	auth.PUT("/cars/:id/class", carClassHandler.Assign)
+	auth.DELETE("/cars/:id/class", carClassHandler.Clear)
+	auth.GET("/cars/:id/class-suggestion", carClassHandler.Suggest)
+
 	// Tracks (protected)
-	auth.POST("/tracks", trackHandler.Create)
+	auth.POST("/tracks", trackHandler.Create, verifiedMW)
 	auth.PATCH("/tracks/:id", trackHandler.Update)
+	auth.GET("/tracks/mine", trackHandler.Mine)
+	auth.GET("/tracks/:id/status-history", trackHandler.StatusHistory)
 
 	// Track images (protected)
 	auth.POST("/tracks/:id/images", trackImageHandler.Create)
 	auth.DELETE("/tracks/:id/images", trackImageHandler.Delete)
 
 	// Track reviews
-	auth.POST("/tracks/:id/reviews", trackReviewHandler.Create)
+	auth.POST("/tracks/:id/reviews", trackReviewHandler.Create, verifiedMW)
 
 	// Track zones
 	auth.POST("/tracks/:id/zones", trackZoneHandler.Create)
 	auth.PATCH("/tracks/:id/zones/:zoneId", trackZoneHandler.Update)
 	auth.DELETE("/tracks/:id/zones/:zoneId", trackZoneHandler.Delete)
 
+	// Track sectors
+	auth.PUT("/tracks/:id/sectors", trackSectorHandler.Replace)
+	auth.PUT("/tracks/:id/start-finish", trackSectorHandler.SetStartFinish)
+	auth.DELETE("/tracks/:id/start-finish", trackSectorHandler.ClearStartFinish)
+
 	// Zone tips
-	auth.POST("/tracks/:id/zones/:zoneId/tips", zoneTipHandler.Create)
+	auth.POST("/tracks/:id/zones/:zoneId/tips", zoneTipHandler.Create, verifiedMW)
 
 	// Lapbook
 	auth.GET("/lapbook", lapbookHandler.List)
+	auth.GET("/lapbook/stats", lapbookHandler.Stats)
+	auth.GET("/lapbook/theoretical-best", lapbookHandler.TheoreticalBest)
 	auth.POST("/lapbook", lapbookHandler.Create)
+	auth.POST("/lapbook/import", sessionHandler.Import)
+	auth.POST("/lapbook/import/gps", sessionHandler.ImportGPS)
+	auth.PUT("/lapbook/:id", lapbookHandler.Update)
+	auth.PATCH("/lapbook/:id", lapbookHandler.Patch)
+	auth.GET("/lapbook/:id/history", lapbookHandler.History)
 	auth.DELETE("/lapbook/:id", lapbookHandler.Delete)
 
+	// Track sessions
+	auth.GET("/sessions", sessionHandler.List)
+	auth.POST("/sessions", sessionHandler.Create)
+	auth.GET("/sessions/:id", sessionHandler.Get)
+	auth.PUT("/sessions/:id", sessionHandler.Update)
+	auth.DELETE("/sessions/:id", sessionHandler.Delete)
+
 	// Profile
 	auth.GET("/profile", profileHandler.Get)
 	auth.PUT("/profile", profileHandler.Update)
+	auth.GET("/profile/accounts", oidcHandler.Accounts)
+	auth.POST("/profile/accounts/:provider", oidcHandler.Link)
+	auth.DELETE("/profile/accounts/:provider", oidcHandler.Unlink)
+	auth.GET("/profile/tokens", apiTokenHandler.List)
+	auth.POST("/profile/tokens", apiTokenHandler.Create)
+	auth.DELETE("/profile/tokens/:id", apiTokenHandler.Delete)
 
 	// Upload
 	auth.POST("/upload", uploadHandler.Upload)
 
+	// Moderation
+	moderation := auth.Group("/moderation", mw.RequireRole(models.RoleModerator), twoFactorMW)
+	moderation.GET("/tracks", moderationHandler.Queue)
+	moderation.POST("/tracks/:id/approve", moderationHandler.Approve)
+	moderation.POST("/tracks/:id/reject", moderationHandler.Reject)
+
 	// Admin
-	auth.POST("/admin/sync-tracks", adminHandler.SyncTracks)
+	admin := auth.Group("/admin", mw.RequireRole(models.RoleAdmin), twoFactorMW)
+	admin.POST("/sync-tracks", adminHandler.SyncTracks)
+	admin.GET("/sync-runs", adminHandler.SyncRuns)
+	admin.POST("/sync-zones", adminHandler.SyncZones)
+	admin.POST("/sync-classes", carClassHandler.Sync)
+	admin.POST("/classes", carClassHandler.Create)
+	admin.PUT("/classes/:id", carClassHandler.Update)
+	admin.DELETE("/classes/:id", carClassHandler.Delete)
+	admin.PUT("/users/:id/role", adminHandler.GrantRole)
+	admin.DELETE("/users/:id/role", adminHandler.RevokeRole)
 
 	// ─── Static file serving ────────────────────────────────────────────────────
 	e.GET("/uploads/*", handlers.ServeUploads(cfg.UploadDir))
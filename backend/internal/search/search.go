@@ -0,0 +1,159 @@
+// Package search turns user search input into FTS5 MATCH expressions and
+// highlights results. It backs the "TrackSearch" full-text index.
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Markers passed to highlight() and snippet(). They are control characters so they
+// can't collide with user text, and are turned into <mark> tags after the
+// rest of the snippet has been HTML-escaped.
+const (
+	MarkStart = "\x02"
+	MarkEnd   = "\x03"
+)
+
+// Tokenize lowercases s and splits it into letter/digit runs, matching how
+// the unicode61 tokenizer splits indexed text closely enough for building queries.
+func Tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Vocabulary looks up terms in the full-text index, for matching
+// misspelled words.
+type Vocabulary interface {
+	// HasPrefix reports whether any indexed term starts with prefix.
+	HasPrefix(prefix string) (bool, error)
+	// TermsOfLength returns the indexed terms of minLen to maxLen characters.
+	TermsOfLength(minLen, maxLen int) ([]string, error)
+}
+
+// MatchExpr builds an FTS5 MATCH expression requiring every token of q.
+// Each token matches as a prefix so partial words work while typing. A
+// token that isn't the start of any indexed term is also allowed to match
+// vocabulary terms a small edit distance away, so "seka" finds "seca".
+//
+// It returns "" if q contains no searchable tokens.
+func MatchExpr(q string, vocab Vocabulary) (string, error) {
+	tokens := Tokenize(q)
+	if len(tokens) == 0 {
+		return "", nil
+	}
+
+	parts := make([]string, 0, len(tokens))
+	for _, tok := range tokens {
+		alts := []string{`"` + tok + `"*`}
+		found, err := vocab.HasPrefix(tok)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			fixes, err := corrections(tok, vocab)
+			if err != nil {
+				return "", err
+			}
+			for _, fix := range fixes {
+				alts = append(alts, `"`+fix+`"`)
+			}
+		}
+		if len(alts) == 1 {
+			parts = append(parts, alts[0])
+		} else {
+			parts = append(parts, "("+strings.Join(alts, " OR ")+")")
+		}
+	}
+	// FTS5 only allows implicit AND between plain phrases
+	return strings.Join(parts, " AND "), nil
+}
+
+// maxCorrections caps how many alternatives one misspelled token expands to.
+const maxCorrections = 5
+
+// corrections returns vocabulary terms within the allowed edit distance of
+// tok. Short tokens are never corrected since almost everything is close to
+// them. Only terms whose length is within that distance are looked up.
+func corrections(tok string, vocab Vocabulary) ([]string, error) {
+	n := len([]rune(tok))
+	maxDist := 0
+	switch {
+	case n >= 8:
+		maxDist = 2
+	case n >= 4:
+		maxDist = 1
+	}
+	if maxDist == 0 {
+		return nil, nil
+	}
+
+	terms, err := vocab.TermsOfLength(n-maxDist, n+maxDist)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, term := range terms {
+		if !isWord(term) {
+			continue
+		}
+		if editDistance(tok, term) <= maxDist {
+			out = append(out, term)
+			if len(out) == maxCorrections {
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// isWord reports whether term is safe to place unquoted in a MATCH expression.
+func isWord(term string) bool {
+	if term == "" {
+		return false
+	}
+	for _, r := range term {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// editDistance is the optimal string alignment distance between a and b:
+// insertions, deletions, substitutions and adjacent transpositions each cost 1.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+// HighlightHTML HTML-escapes a snippet produced with MarkStart/MarkEnd and
+// wraps the matched terms in <mark> tags.
+func HighlightHTML(snippet string) string {
+	s := html.EscapeString(snippet)
+	s = strings.ReplaceAll(s, MarkStart, "<mark>")
+	return strings.ReplaceAll(s, MarkEnd, "</mark>")
+}
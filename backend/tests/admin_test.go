@@ -0,0 +1,329 @@
+package tests
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/importer"
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/joezmuda/trackside-backend/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTracksJSON = `{"tracks":[
+	{"name":"Barber Motorsports Park","location":"Birmingham, AL","state":"AL","types":["roadcourse"],"latitude":33.5317,"longitude":-86.6194,"description":"2.38 mile road course"},
+	{"name":"Atmore Dragway","location":"Atmore, AL","state":"AL","types":["drag"],"latitude":31.0257,"longitude":-87.4919,"description":"Quarter mile drag strip"}
+]}`
+
+// writeTracksFile writes a tracks data file into a fresh directory and returns it.
+func writeTracksFile(t *testing.T, contents string) string {
+	t.Helper()
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, importer.TracksFile), []byte(contents), 0644)
+	require.NoError(t, err)
+	return dir
+}
+
+func TestSyncTracks_CreatesThenUpdates(t *testing.T) {
+	app := setupTestApp(t)
+	dataDir := writeTracksFile(t, testTracksJSON)
+
+	resp, err := importer.SyncTracks(app.trackRepo, app.userRepo, dataDir, models.SyncOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, resp.Summary.Total)
+	assert.Equal(t, 2, resp.Summary.Created)
+	assert.Equal(t, 0, resp.Summary.Failed)
+
+	resp, err = importer.SyncTracks(app.trackRepo, app.userRepo, dataDir, models.SyncOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, resp.Summary.Created)
+	assert.Equal(t, 2, resp.Summary.Updated)
+
+	rec := app.doRequest(http.MethodGet, "/api/tracks?search=Barber", "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	result := parseTrackList(t, rec)
+	require.Len(t, result, 1)
+	assert.Equal(t, true, result[0]["isImported"])
+}
+
+func TestSyncTracks_MissingFile(t *testing.T) {
+	app := setupTestApp(t)
+
+	_, err := importer.SyncTracks(app.trackRepo, app.userRepo, t.TempDir(), models.SyncOptions{})
+	assert.Error(t, err)
+}
+
+func TestSyncTracks_InvalidJSON(t *testing.T) {
+	app := setupTestApp(t)
+	dataDir := writeTracksFile(t, `{"tracks":`)
+
+	_, err := importer.SyncTracks(app.trackRepo, app.userRepo, dataDir, models.SyncOptions{})
+	assert.Error(t, err)
+}
+
+func TestSyncTracks_EndpointRequiresAdmin(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUser(t, "User", uniqueEmail("driver"), "password123")
+	// An "admin"-looking email no longer grants anything
+	_, adminEmailToken := app.createTestUser(t, "Sneaky", uniqueEmail("admin"), "password123")
+	_, modToken := app.createTestUserWithRole(t, "Mod", uniqueEmail("mod"), "MODERATOR")
+
+	for _, tok := range []string{token, adminEmailToken, modToken} {
+		rec := app.doRequest(http.MethodPost, "/api/admin/sync-tracks", "", tok)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	}
+}
+
+func TestSyncTracks_EndpointAsAdmin(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUserWithRole(t, "Admin", uniqueEmail("ops"), "ADMIN")
+
+	// The test app's DATA_DIR has no tracks file, so getting past the role
+	// check shows up as a sync failure rather than a 403.
+	rec := app.doRequest(http.MethodPost, "/api/admin/sync-tracks", "", token)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "Sync failed", parseJSON(t, rec)["error"])
+}
+
+func TestSyncTracks_DryRunDiff(t *testing.T) {
+	app := setupTestApp(t)
+	dataDir := writeTracksFile(t, testTracksJSON)
+
+	// A dry run reports what would be created without saving anything
+	resp, err := importer.SyncTracks(app.trackRepo, app.userRepo, dataDir, models.SyncOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.True(t, resp.DryRun)
+	assert.Equal(t, 2, resp.Summary.Created)
+	require.Len(t, resp.Changes, 2)
+	assert.Equal(t, models.SyncCreate, resp.Changes[0].Action)
+	assert.Equal(t, []string{"ROADCOURSE"}, resp.Changes[0].Changes["eventTypes"].To)
+	track, err := app.trackRepo.FindByNameAndLocation("Barber Motorsports Park", "Birmingham, AL")
+	require.NoError(t, err)
+	assert.Nil(t, track)
+
+	_, err = importer.SyncTracks(app.trackRepo, app.userRepo, dataDir, models.SyncOptions{})
+	require.NoError(t, err)
+
+	// Only changed fields of changed tracks are listed
+	changed := strings.Replace(testTracksJSON, `"description":"2.38 mile road course"`, `"description":"2.38 mile, 17 turn road course"`, 1)
+	dataDir = writeTracksFile(t, changed)
+	resp, err = importer.SyncTracks(app.trackRepo, app.userRepo, dataDir, models.SyncOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, resp.Summary.Updated)
+	assert.Equal(t, 1, resp.Summary.Unchanged)
+	require.Len(t, resp.Changes, 1)
+	change := resp.Changes[0]
+	assert.Equal(t, models.SyncUpdate, change.Action)
+	assert.Equal(t, "Barber Motorsports Park", change.Name)
+	require.Len(t, change.Changes, 1)
+	assert.Equal(t, "2.38 mile, 17 turn road course", change.Changes["description"].To)
+
+	track, err = app.trackRepo.FindByNameAndLocation("Barber Motorsports Park", "Birmingham, AL")
+	require.NoError(t, err)
+	assert.Equal(t, "2.38 mile road course", *track.Description)
+}
+
+func TestSyncTracks_Prune(t *testing.T) {
+	app := setupTestApp(t)
+	_, err := importer.SyncTracks(app.trackRepo, app.userRepo, writeTracksFile(t, testTracksJSON), models.SyncOptions{})
+	require.NoError(t, err)
+	_, token := app.createTestUser(t, "User", uniqueEmail("driver"), "password123")
+	userTrack := app.createTestTrack(t, token)
+
+	onlyBarber := `{"tracks":[{"name":"Barber Motorsports Park","location":"Birmingham, AL","state":"AL","types":["roadcourse"],"latitude":33.5317,"longitude":-86.6194,"description":"2.38 mile road course"}]}`
+	dataDir := writeTracksFile(t, onlyBarber)
+
+	// Without prune, tracks dropped from the file are left alone
+	resp, err := importer.SyncTracks(app.trackRepo, app.userRepo, dataDir, models.SyncOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, resp.Summary.Archived)
+
+	resp, err = importer.SyncTracks(app.trackRepo, app.userRepo, dataDir, models.SyncOptions{Prune: true, DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, resp.Summary.Archived)
+	atmore, err := app.trackRepo.FindByNameAndLocation("Atmore Dragway", "Atmore, AL")
+	require.NoError(t, err)
+	assert.Equal(t, "APPROVED", atmore.Status)
+
+	resp, err = importer.SyncTracks(app.trackRepo, app.userRepo, dataDir, models.SyncOptions{Prune: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, resp.Summary.Archived)
+	require.Len(t, resp.Changes, 1)
+	assert.Equal(t, models.SyncArchive, resp.Changes[0].Action)
+	assert.Equal(t, "Atmore Dragway", resp.Changes[0].Name)
+
+	// Archived tracks are hidden; tracks submitted by users are never pruned
+	rec := app.doRequest(http.MethodGet, "/api/tracks/"+atmore.ID, "", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+userTrack, "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// A track put back in the file is restored
+	resp, err = importer.SyncTracks(app.trackRepo, app.userRepo, writeTracksFile(t, testTracksJSON), models.SyncOptions{Prune: true})
+	require.NoError(t, err)
+	assert.Equal(t, 0, resp.Summary.Archived)
+	require.Len(t, resp.Changes, 1)
+	assert.Equal(t, "ARCHIVED", resp.Changes[0].Changes["status"].From)
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+atmore.ID, "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestSyncTracks_History(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUser(t, "User", uniqueEmail("driver"), "password123")
+	_, adminToken := app.createTestUserWithRole(t, "Admin", uniqueEmail("ops"), "ADMIN")
+
+	rec := app.doRequest(http.MethodPost, "/api/admin/sync-tracks?dryRun=perhaps", "", adminToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// A run from the command line has no user
+	runRepo := repository.NewSyncRunRepo(app.db)
+	opts := models.SyncOptions{DryRun: true}
+	started := time.Now()
+	resp, err := importer.SyncTracks(app.trackRepo, app.userRepo, writeTracksFile(t, testTracksJSON), opts)
+	require.NoError(t, runRepo.Create(importer.TrackSyncRun(importer.TracksFile, opts, started, resp, err), nil))
+
+	// The test app's DATA_DIR has no tracks file, so this run fails
+	rec = app.doRequest(http.MethodPost, "/api/admin/sync-tracks?prune=true", "", adminToken)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	rec = app.doRequest(http.MethodGet, "/api/admin/sync-runs", "", token)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/admin/sync-runs", "", adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	runs := parseJSONArray(t, rec)
+	require.Len(t, runs, 2)
+
+	assert.Equal(t, "failed", runs[0]["status"])
+	assert.Equal(t, true, runs[0]["prune"])
+	assert.NotEmpty(t, runs[0]["error"])
+	assert.Nil(t, runs[0]["summary"])
+	assert.Equal(t, "Admin", runs[0]["triggeredBy"].(map[string]interface{})["name"])
+
+	assert.Equal(t, "success", runs[1]["status"])
+	assert.Equal(t, true, runs[1]["dryRun"])
+	assert.Equal(t, "usa-tracks.json", runs[1]["source"])
+	assert.Equal(t, float64(2), runs[1]["summary"].(map[string]interface{})["created"])
+	assert.Nil(t, runs[1]["triggeredBy"])
+
+	rec = app.doRequest(http.MethodGet, "/api/admin/sync-runs?limit=1", "", adminToken)
+	require.Len(t, parseJSONArray(t, rec), 1)
+}
+
+const testZonesJSON = `{"zones":[
+	{"trackName":"Barber Motorsports Park","trackLocation":"Birmingham, AL","zones":[
+		{"name":"Turn 1","description":"Downhill left","posX":37,"posY":12},
+		{"name":"Turn 2","description":"Turn 2","posX":62,"posY":12},
+		{"name":"Turn 3","posX":150,"posY":12}
+	]},
+	{"trackName":"Atmore Dragway","trackLocation":"Atmore, AL","zones":[
+		{"name":"The Strip","description":"Drag racing straight","posX":50,"posY":50}
+	]},
+	{"trackName":"Nowhere Raceway","trackLocation":"Nowhere, AL","zones":[
+		{"name":"Turn 1","posX":10,"posY":10}
+	]}
+]}`
+
+func TestSyncZones(t *testing.T) {
+	app := setupTestApp(t)
+	zoneRepo := repository.NewZoneRepo(app.db)
+	dataDir := writeTracksFile(t, testTracksJSON)
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, importer.ZonesFile), []byte(testZonesJSON), 0644))
+	_, err := importer.SyncTracks(app.trackRepo, app.userRepo, dataDir, models.SyncOptions{})
+	require.NoError(t, err)
+	barber, err := app.trackRepo.FindByNameAndLocation("Barber Motorsports Park", "Birmingham, AL")
+	require.NoError(t, err)
+
+	// A zone a user already added is left alone rather than duplicated
+	_, token := app.createTestUser(t, "User", uniqueEmail("zones"), "password123")
+	rec := app.doRequest(http.MethodPost, "/api/tracks/"+barber.ID+"/zones", `{"name":"turn 2","posX":60,"posY":15}`, token)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Equal(t, false, parseJSON(t, rec)["isImported"])
+
+	resp, err := importer.SyncZones(app.trackRepo, zoneRepo, dataDir)
+	require.NoError(t, err)
+	assert.Equal(t, 3, resp.Summary.Tracks)
+	assert.Equal(t, 1, resp.Summary.Unmatched)
+	assert.Equal(t, []string{"Nowhere Raceway (Nowhere, AL)"}, resp.Unmatched)
+	assert.Equal(t, 4, resp.Summary.Total)
+	assert.Equal(t, 2, resp.Summary.Created)
+	assert.Equal(t, 1, resp.Summary.Skipped)
+	assert.Equal(t, 1, resp.Summary.Failed)
+	require.Len(t, resp.Errors, 1)
+
+	// Syncing again updates the imported zones in place
+	resp, err = importer.SyncZones(app.trackRepo, zoneRepo, dataDir)
+	require.NoError(t, err)
+	assert.Equal(t, 0, resp.Summary.Created)
+	assert.Equal(t, 2, resp.Summary.Updated)
+	assert.Equal(t, 1, resp.Summary.Skipped)
+
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+barber.ID, "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	zones := parseJSON(t, rec)["zones"].([]interface{})
+	require.Len(t, zones, 2)
+	imported := map[string]bool{}
+	for _, z := range zones {
+		zone := z.(map[string]interface{})
+		imported[zone["name"].(string)] = zone["isImported"].(bool)
+	}
+	assert.Equal(t, map[string]bool{"Turn 1": true, "turn 2": false}, imported)
+
+	_, err = importer.SyncZones(app.trackRepo, zoneRepo, t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestSyncZones_Endpoint(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUser(t, "User", uniqueEmail("driver"), "password123")
+	_, adminToken := app.createTestUserWithRole(t, "Admin", uniqueEmail("ops"), "ADMIN")
+
+	rec := app.doRequest(http.MethodPost, "/api/admin/sync-zones", "", token)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// The test app's DATA_DIR has no zones file
+	rec = app.doRequest(http.MethodPost, "/api/admin/sync-zones", "", adminToken)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "Sync failed", parseJSON(t, rec)["error"])
+}
+
+func TestAdminRoles_GrantAndRevoke(t *testing.T) {
+	app := setupTestApp(t)
+	_, adminToken := app.createTestUserWithRole(t, "Admin", uniqueEmail("ops"), "ADMIN")
+	userID, _ := app.createTestUser(t, "Driver", uniqueEmail("driver"), "password123")
+
+	rec := app.doRequest(http.MethodPut, "/api/admin/users/"+userID+"/role", `{"role":"MODERATOR"}`, adminToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "MODERATOR", parseJSON(t, rec)["role"])
+
+	user, err := app.userRepo.FindByID(userID)
+	require.NoError(t, err)
+	assert.Equal(t, "MODERATOR", user.Role)
+
+	rec = app.doRequest(http.MethodDelete, "/api/admin/users/"+userID+"/role", "", adminToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "USER", parseJSON(t, rec)["role"])
+}
+
+func TestAdminRoles_Validation(t *testing.T) {
+	app := setupTestApp(t)
+	adminID, adminToken := app.createTestUserWithRole(t, "Admin", uniqueEmail("ops"), "ADMIN")
+	userID, userToken := app.createTestUser(t, "Driver", uniqueEmail("driver"), "password123")
+
+	rec := app.doRequest(http.MethodPut, "/api/admin/users/"+userID+"/role", `{"role":"SUPERUSER"}`, adminToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = app.doRequest(http.MethodPut, "/api/admin/users/nonexistent/role", `{"role":"ADMIN"}`, adminToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = app.doRequest(http.MethodDelete, "/api/admin/users/"+adminID+"/role", "", adminToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = app.doRequest(http.MethodPut, "/api/admin/users/"+userID+"/role", `{"role":"ADMIN"}`, userToken)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createAPIToken creates an API token through the API and returns the
+// response.
+func (app *testApp) createAPIToken(t *testing.T, token, body string) map[string]interface{} {
+	t.Helper()
+	rec := app.doRequest(http.MethodPost, "/api/profile/tokens", body, token)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	return parseJSON(t, rec)
+}
+
+func TestAPITokens_CreateListRevoke(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUser(t, "User", uniqueEmail("pat"), "password123")
+	_, otherToken := app.createTestUser(t, "Other", uniqueEmail("pat"), "password123")
+
+	created := app.createAPIToken(t, token, `{"name":"Pit laptop","scopes":["lapbook:write","cars:read","lapbook:write"],"expiresInDays":30}`)
+	apiToken := created["token"].(string)
+	assert.True(t, strings.HasPrefix(apiToken, "tsk_"))
+	assert.Equal(t, "Pit laptop", created["name"])
+	assert.Equal(t, []interface{}{"cars:read", "lapbook:write"}, created["scopes"])
+	assert.NotNil(t, created["expiresAt"])
+	assert.Nil(t, created["lastUsedAt"])
+	id := created["id"].(string)
+
+	// The token is stored hashed
+	var stored string
+	require.NoError(t, app.db.QueryRow(`SELECT tokenHash FROM "APIToken" WHERE id = ?`, id).Scan(&stored))
+	assert.NotContains(t, stored, apiToken)
+
+	rec := app.doRequest(http.MethodGet, "/api/lapbook", "", apiToken)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = app.doRequest(http.MethodGet, "/api/profile/tokens", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	tokens := parseJSONArray(t, rec)
+	require.Len(t, tokens, 1)
+	assert.Equal(t, id, tokens[0]["id"])
+	assert.NotNil(t, tokens[0]["lastUsedAt"])
+	assert.NotContains(t, tokens[0], "token")
+
+	// Other users can't see or revoke it
+	rec = app.doRequest(http.MethodGet, "/api/profile/tokens", "", otherToken)
+	assert.Empty(t, parseJSONArray(t, rec))
+	rec = app.doRequest(http.MethodDelete, "/api/profile/tokens/"+id, "", otherToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = app.doRequest(http.MethodDelete, "/api/profile/tokens/"+id, "", token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/lapbook", "", apiToken)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = app.doRequest(http.MethodDelete, "/api/profile/tokens/"+id, "", token)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAPITokens_Scopes(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUserWithRole(t, "Mod", uniqueEmail("pat"), "MODERATOR")
+	carID := app.createTestCar(t, "Mazda", "MX-5", 2019, userID)
+	trackID := app.createTestTrack(t, token)
+	pendingID := app.createPendingTrack(t, token, `{"name":"Pending","location":"Somewhere, CA","eventTypes":["ROADCOURSE"]}`)
+
+	apiToken := app.createAPIToken(t, token, `{"name":"Logger","scopes":["lapbook:write","cars:read","tracks:read"]}`)["token"].(string)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"write includes read", http.MethodGet, "/api/lapbook", "", http.StatusOK},
+		{"write", http.MethodPost, "/api/lapbook", `{"lapTime":"1:42.5","conditions":"DRY","trackId":"` + trackID + `","carId":"` + carID + `"}`, http.StatusCreated},
+		{"read", http.MethodGet, "/api/cars", "", http.StatusOK},
+		{"read only", http.MethodPost, "/api/cars", `{"make":"Honda","model":"S2000","year":2004}`, http.StatusForbidden},
+		{"no scope", http.MethodGet, "/api/sessions", "", http.StatusForbidden},
+		{"own pending track", http.MethodGet, "/api/tracks/" + pendingID, "", http.StatusOK},
+		{"profile", http.MethodGet, "/api/profile", "", http.StatusForbidden},
+		{"new tokens", http.MethodPost, "/api/profile/tokens", `{"name":"More","scopes":["cars:write"]}`, http.StatusForbidden},
+		{"logout", http.MethodPost, "/api/auth/logout", "", http.StatusForbidden},
+		{"moderation", http.MethodGet, "/api/moderation/tracks", "", http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := app.doRequest(tc.method, tc.path, tc.body, apiToken)
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+		})
+	}
+
+	// Tokens act as a regular user, even for a moderator
+	_, otherToken := app.createTestUser(t, "Other", uniqueEmail("pat"), "password123")
+	othersPending := app.createPendingTrack(t, otherToken, `{"name":"Other Pending","location":"Elsewhere, CA","eventTypes":["ROADCOURSE"]}`)
+	rec := app.doRequest(http.MethodGet, "/api/tracks/"+othersPending, "", token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+othersPending, "", apiToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAPITokens_Validation(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUser(t, "User", uniqueEmail("pat"), "password123")
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"missing name", `{"name":"  ","scopes":["cars:read"]}`},
+		{"long name", `{"name":"` + strings.Repeat("x", 101) + `","scopes":["cars:read"]}`},
+		{"no scopes", `{"name":"Script","scopes":[]}`},
+		{"unknown resource", `{"name":"Script","scopes":["admin:write"]}`},
+		{"unknown access", `{"name":"Script","scopes":["cars:delete"]}`},
+		{"zero days", `{"name":"Script","scopes":["cars:read"],"expiresInDays":0}`},
+		{"too many days", `{"name":"Script","scopes":["cars:read"],"expiresInDays":366}`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := app.doRequest(http.MethodPost, "/api/profile/tokens", tc.body, token)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+		})
+	}
+
+	// Without expiresInDays the token doesn't expire
+	created := app.createAPIToken(t, token, `{"name":"Script","scopes":["cars:read"]}`)
+	assert.Nil(t, created["expiresAt"])
+}
+
+func TestAPITokens_ExpiredAndRevokedOnPasswordReset(t *testing.T) {
+	app := setupTestApp(t)
+	email := uniqueEmail("pat")
+	_, token := app.createTestUser(t, "User", email, "password123")
+
+	expiring := app.createAPIToken(t, token, `{"name":"Old","scopes":["cars:read"],"expiresInDays":1}`)
+	_, err := app.db.Exec(`UPDATE "APIToken" SET expiresAt = datetime('now', '-1 minute') WHERE id = ?`, expiring["id"])
+	require.NoError(t, err)
+	rec := app.doRequest(http.MethodGet, "/api/cars", "", expiring["token"].(string))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	apiToken := app.createAPIToken(t, token, `{"name":"Current","scopes":["cars:read"]}`)["token"].(string)
+	rec = app.doRequest(http.MethodGet, "/api/cars", "", apiToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = app.doRequest(http.MethodPost, "/api/auth/forgot-password", `{"email":"`+email+`"}`, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := `{"token":"` + app.emailToken(t, email) + `","password":"newpassword1","confirmPassword":"newpassword1"}`
+	rec = app.doRequest(http.MethodPost, "/api/auth/reset-password", body, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = app.doRequest(http.MethodGet, "/api/cars", "", apiToken)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
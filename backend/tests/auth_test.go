@@ -2,8 +2,13 @@ package tests
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	mw "github.com/joezmuda/trackside-backend/internal/middleware"
+	"github.com/joezmuda/trackside-backend/internal/repository"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -86,6 +91,7 @@ func TestLogin_Success(t *testing.T) {
 	user := result["user"].(map[string]interface{})
 	assert.Equal(t, email, user["email"])
 	assert.Equal(t, "Login User", user["name"])
+	assert.Equal(t, "USER", user["role"])
 }
 
 func TestLogin_WrongPassword(t *testing.T) {
@@ -125,3 +131,144 @@ func TestProtectedRoute_InvalidToken(t *testing.T) {
 	rec := app.doRequest(http.MethodGet, "/api/cars", "", "invalid-token")
 	assert.Equal(t, http.StatusUnauthorized, rec.Code)
 }
+
+// login logs in through the API and returns the response body.
+func (app *testApp) login(t *testing.T, email, password string) map[string]interface{} {
+	t.Helper()
+	rec := app.doRequest(http.MethodPost, "/api/auth/login", `{"email":"`+email+`","password":"`+password+`"}`, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	return parseJSON(t, rec)
+}
+
+func (app *testApp) refresh(refreshToken string) *httptest.ResponseRecorder {
+	return app.doRequest(http.MethodPost, "/api/auth/refresh", `{"refreshToken":"`+refreshToken+`"}`, "")
+}
+
+func TestRefreshToken_Rotation(t *testing.T) {
+	app := setupTestApp(t)
+	email := uniqueEmail("refresh")
+	userID, _ := app.createTestUser(t, "Refresh User", email, "password123")
+
+	first := app.login(t, email, "password123")
+	assert.Equal(t, float64(900), first["expiresIn"])
+	require.NotEmpty(t, first["refreshToken"])
+	rec := app.doRequest(http.MethodGet, "/api/profile", "", first["token"].(string))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = app.refresh("")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = app.refresh("not-a-token")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// A refresh issues a new pair, picking up role changes
+	_, err := app.userRepo.SetRole(userID, "MODERATOR")
+	require.NoError(t, err)
+	rec = app.refresh(first["refreshToken"].(string))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	second := parseJSON(t, rec)
+	assert.NotEqual(t, first["refreshToken"], second["refreshToken"])
+	assert.Equal(t, "MODERATOR", second["user"].(map[string]interface{})["role"])
+	rec = app.doRequest(http.MethodGet, "/api/moderation/tracks", "", second["token"].(string))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Reusing a rotated refresh token revokes the whole session
+	rec = app.refresh(first["refreshToken"].(string))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = app.refresh(second["refreshToken"].(string))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/profile", "", second["token"].(string))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/profile", "", first["token"].(string))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Logging in again starts a fresh session
+	third := app.login(t, email, "password123")
+	rec = app.doRequest(http.MethodGet, "/api/profile", "", third["token"].(string))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestLogout(t *testing.T) {
+	app := setupTestApp(t)
+	email := uniqueEmail("logout")
+	_, helperToken := app.createTestUser(t, "Logout User", email, "password123")
+	phone := app.login(t, email, "password123")
+	laptop := app.login(t, email, "password123")
+
+	rec := app.doRequest(http.MethodPost, "/api/auth/logout", "", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Logging out ends only that session
+	rec = app.doRequest(http.MethodPost, "/api/auth/logout", "", phone["token"].(string))
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/profile", "", phone["token"].(string))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = app.refresh(phone["refreshToken"].(string))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/profile", "", laptop["token"].(string))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Logging out everywhere ends the rest, including the test helper's
+	tablet := app.login(t, email, "password123")
+	rec = app.doRequest(http.MethodPost, "/api/auth/logout-all", "", laptop["token"].(string))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, float64(3), parseJSON(t, rec)["sessions"])
+	rec = app.doRequest(http.MethodGet, "/api/profile", "", helperToken)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	for _, s := range []map[string]interface{}{laptop, tablet} {
+		rec = app.doRequest(http.MethodGet, "/api/profile", "", s["token"].(string))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		rec = app.refresh(s["refreshToken"].(string))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestRevokeOthers_CountsSessions(t *testing.T) {
+	app := setupTestApp(t)
+	userID, _ := app.createTestUser(t, "User", uniqueEmail("others"), "password123")
+	sessions := repository.NewAuthSessionRepo(app.db)
+
+	keep, _, err := sessions.Create(userID, time.Hour)
+	require.NoError(t, err)
+	_, token, err := sessions.Create(userID, time.Hour)
+	require.NoError(t, err)
+	// A rotated session has several refresh tokens but is still one session
+	for i := 0; i < 2; i++ {
+		_, _, token, err = sessions.Rotate(token, time.Hour)
+		require.NoError(t, err)
+	}
+
+	// The other two are the rotated one and the test helper's
+	count, err := sessions.RevokeOthers(userID, keep)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	active, err := sessions.IsActive(keep, userID)
+	require.NoError(t, err)
+	assert.True(t, active)
+
+	count, err = sessions.RevokeOthers(userID, keep)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestProtectedRoute_TokenWithoutSession(t *testing.T) {
+	app := setupTestApp(t)
+	userID, _ := app.createTestUser(t, "User", uniqueEmail("nosession"), "password123")
+
+	claims := &mw.JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Role: "USER",
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTSecret))
+	require.NoError(t, err)
+	rec := app.doRequest(http.MethodGet, "/api/cars", "", token)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	claims.SessionID = "unknown-session"
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTSecret))
+	require.NoError(t, err)
+	rec = app.doRequest(http.MethodGet, "/api/cars", "", token)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joezmuda/trackside-backend/internal/importer"
+	"github.com/joezmuda/trackside-backend/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createClass creates a car class as an admin and returns its ID.
+func (app *testApp) createClass(t *testing.T, adminToken, body string) string {
+	t.Helper()
+	rec := app.doRequest(http.MethodPost, "/api/admin/classes", body, adminToken)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	return parseJSON(t, rec)["id"].(string)
+}
+
+func TestCarClasses_AdminCRUD(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUser(t, "User", uniqueEmail("cls"), "password123")
+	_, admin := app.createTestUserWithRole(t, "Admin", uniqueEmail("cls"), "ADMIN")
+
+	rec := app.doRequest(http.MethodPost, "/api/admin/classes", `{"code":"SS","name":"Super Street","paxIndex":0.83}`, token)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	id := app.createClass(t, admin, `{"code":" ss ","name":"Super Street","category":"Street","paxIndex":0.83}`)
+	app.createClass(t, admin, `{"code":"STR","name":"Street Touring Roadster","category":"Street Touring","paxIndex":0.827}`)
+
+	for _, body := range []string{
+		`{"code":"","name":"Nameless","paxIndex":0.8}`,
+		`{"code":"TOOLONGCODE1","name":"Long","paxIndex":0.8}`,
+		`{"code":"XP","name":"","paxIndex":0.8}`,
+		`{"code":"XP","name":"X Prepared","paxIndex":0}`,
+		`{"code":"XP","name":"X Prepared","paxIndex":2.5}`,
+	} {
+		rec = app.doRequest(http.MethodPost, "/api/admin/classes", body, admin)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+	rec = app.doRequest(http.MethodPost, "/api/admin/classes", `{"code":"str","name":"Duplicate","paxIndex":0.8}`, admin)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	// Classes are public, and can be filtered by category
+	rec = app.doRequest(http.MethodGet, "/api/classes", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	classes := parseJSONArray(t, rec)
+	require.Len(t, classes, 2)
+	assert.Equal(t, "SS", classes[0]["code"])
+	rec = app.doRequest(http.MethodGet, "/api/classes?category=street%20touring", "", "")
+	require.Len(t, parseJSONArray(t, rec), 1)
+
+	rec = app.doRequest(http.MethodPut, "/api/admin/classes/"+id, `{"code":"SS","name":"Super Street","category":"Street","paxIndex":0.831}`, admin)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, 0.831, parseJSON(t, rec)["paxIndex"])
+	rec = app.doRequest(http.MethodPut, "/api/admin/classes/"+id, `{"code":"STR","name":"Clash","paxIndex":0.8}`, admin)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = app.doRequest(http.MethodDelete, "/api/admin/classes/"+id, "", admin)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodDelete, "/api/admin/classes/"+id, "", admin)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestSyncClasses(t *testing.T) {
+	app := setupTestApp(t)
+	classRepo := repository.NewCarClassRepo(app.db)
+	dir := t.TempDir()
+	writeClasses := func(contents string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, importer.ClassesFile), []byte(contents), 0644))
+	}
+
+	writeClasses("Class,Name,Category,PAX\nSS,Super Street,Street,0.830\nSTR,Street Touring Roadster,Street Touring,0.827\n\nXP,X Prepared,,abc\n,No Code,,0.9\n")
+	resp, err := importer.SyncClasses(classRepo, dir)
+	require.NoError(t, err)
+	assert.Equal(t, 4, resp.Summary.Total)
+	assert.Equal(t, 2, resp.Summary.Created)
+	assert.Equal(t, 2, resp.Summary.Failed)
+	require.Len(t, resp.Errors, 2)
+	assert.Contains(t, resp.Errors[0], "line 5")
+
+	// Classes are matched by code, so a new table updates them in place
+	writeClasses("code,name,index\nss,Super Street,0.835\n")
+	resp, err = importer.SyncClasses(classRepo, dir)
+	require.NoError(t, err)
+	assert.Equal(t, 0, resp.Summary.Created)
+	assert.Equal(t, 1, resp.Summary.Updated)
+	class, err := classRepo.FindByCode("SS")
+	require.NoError(t, err)
+	assert.Equal(t, 0.835, class.PaxIndex)
+
+	writeClasses("code,name\nSS,Super Street\n")
+	_, err = importer.SyncClasses(classRepo, dir)
+	assert.Error(t, err)
+	writeClasses("code,name,index\nS\"S,Super Street,0.835\n")
+	_, err = importer.SyncClasses(classRepo, dir)
+	assert.ErrorContains(t, err, "invalid class index file")
+	_, err = importer.SyncClasses(classRepo, t.TempDir())
+	assert.Error(t, err)
+
+	// The test app's DATA_DIR has no class file
+	_, admin := app.createTestUserWithRole(t, "Admin", uniqueEmail("cls"), "ADMIN")
+	rec := app.doRequest(http.MethodPost, "/api/admin/sync-classes", "", admin)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "Sync failed", parseJSON(t, rec)["error"])
+}
+
+func TestCarClasses_AssignAndSuggest(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("cls"), "password123")
+	otherID, _ := app.createTestUser(t, "Other", uniqueEmail("cls"), "password123")
+	_, admin := app.createTestUserWithRole(t, "Admin", uniqueEmail("cls"), "ADMIN")
+	carID := app.createTestCar(t, "Mazda", "MX-5", 2019, userID)
+	otherCar := app.createTestCar(t, "Honda", "Civic", 2020, otherID)
+	ssID := app.createClass(t, admin, `{"code":"SS","name":"Super Street","category":"Street","paxIndex":0.83}`)
+	app.createClass(t, admin, `{"code":"STR","name":"Street Touring Roadster","category":"Street Touring","paxIndex":0.827}`)
+	app.createClass(t, admin, `{"code":"SSP","name":"Super Street Prepared","category":"Street Prepared","paxIndex":0.853}`)
+
+	// A stock car belongs in a street class
+	rec := app.doRequest(http.MethodGet, "/api/cars/"+carID+"/class-suggestion", "", token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	suggestion := parseJSON(t, rec)
+	assert.Equal(t, "Street", suggestion["category"])
+	assert.Empty(t, suggestion["reasons"])
+	require.Len(t, suggestion["classes"], 1)
+
+	for _, mod := range []string{
+		`{"name":"Coilovers","category":"SUSPENSION"}`,
+		`{"name":"Cat-back","category":"EXHAUST"}`,
+		`{"name":"Floor mats","category":"INTERIOR"}`,
+	} {
+		rec = app.doRequest(http.MethodPost, "/api/cars/"+carID+"/mods", mod, token)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	rec = app.doRequest(http.MethodGet, "/api/cars/"+carID+"/class-suggestion", "", token)
+	suggestion = parseJSON(t, rec)
+	assert.Equal(t, "Street Touring", suggestion["category"])
+	assert.Equal(t, []interface{}{"Coilovers (suspension)", "Cat-back (exhaust)"}, suggestion["reasons"])
+	classes := suggestion["classes"].([]interface{})
+	require.Len(t, classes, 1)
+	assert.Equal(t, "STR", classes[0].(map[string]interface{})["code"])
+
+	rec = app.doRequest(http.MethodPost, "/api/cars/"+carID+"/mods", `{"name":"Turbo kit","category":"ENGINE"}`, token)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/cars/"+carID+"/class-suggestion", "", token)
+	suggestion = parseJSON(t, rec)
+	assert.Equal(t, "Street Prepared", suggestion["category"])
+	assert.Equal(t, []interface{}{"Turbo kit (engine)"}, suggestion["reasons"])
+
+	// Assigning a class
+	rec = app.doRequest(http.MethodPut, "/api/cars/"+carID+"/class", `{"classId":"`+ssID+`"}`, token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	class := parseJSON(t, rec)["class"].(map[string]interface{})
+	assert.Equal(t, "SS", class["code"])
+	assert.Equal(t, 0.83, class["paxIndex"])
+
+	rec = app.doRequest(http.MethodGet, "/api/cars", "", token)
+	cars := parseJSONArray(t, rec)
+	require.Len(t, cars, 1)
+	assert.Equal(t, "SS", cars[0]["class"].(map[string]interface{})["code"])
+
+	rec = app.doRequest(http.MethodPut, "/api/cars/"+carID+"/class", `{"classId":"nope"}`, token)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = app.doRequest(http.MethodPut, "/api/cars/"+otherCar+"/class", `{"classId":"`+ssID+`"}`, token)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/cars/"+otherCar+"/class-suggestion", "", token)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = app.doRequest(http.MethodDelete, "/api/cars/"+carID+"/class", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Nil(t, parseJSON(t, rec)["class"])
+
+	// Deleting a class leaves its cars without one
+	rec = app.doRequest(http.MethodPut, "/api/cars/"+carID+"/class", `{"classId":"`+ssID+`"}`, token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodDelete, "/api/admin/classes/"+ssID, "", admin)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/cars", "", token)
+	assert.Nil(t, parseJSONArray(t, rec)[0]["class"])
+}
+
+func TestCarClasses_PaxTimes(t *testing.T) {
+	app := setupTestApp(t)
+	aliceID, alice := app.createTestUser(t, "Alice", uniqueEmail("pax"), "password123")
+	bobID, bob := app.createTestUser(t, "Bob", uniqueEmail("pax"), "password123")
+	carolID, carol := app.createTestUser(t, "Carol", uniqueEmail("pax"), "password123")
+	_, admin := app.createTestUserWithRole(t, "Admin", uniqueEmail("pax"), "ADMIN")
+	aliceCar := app.createTestCar(t, "Mazda", "MX-5", 2019, aliceID)
+	bobCar := app.createTestCar(t, "Chevrolet", "Corvette", 2020, bobID)
+	carolCar := app.createTestCar(t, "Honda", "Civic", 2020, carolID)
+	slow := app.createClass(t, admin, `{"code":"HS","name":"H Street","paxIndex":0.8}`)
+	fast := app.createClass(t, admin, `{"code":"SS","name":"Super Street","paxIndex":0.9}`)
+	rec := app.doRequest(http.MethodPut, "/api/cars/"+aliceCar+"/class", `{"classId":"`+slow+`"}`, alice)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = app.doRequest(http.MethodPut, "/api/cars/"+bobCar+"/class", `{"classId":"`+fast+`"}`, bob)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	trackID, events := app.createMultiEventTrack(t, alice)
+
+	// PAX time is the adjusted time times the class index
+	lap := app.postLapResult(t, alice, trackID, aliceCar, events["AUTOCROSS"], "55.000", `{"autocross":{"cones":1}}`)
+	pax := lap["pax"].(map[string]interface{})
+	assert.Equal(t, "HS", pax["class"])
+	assert.Equal(t, "45.600", pax["time"])
+	assert.Equal(t, float64(45600), pax["timeMs"])
+	app.postLapResult(t, bob, trackID, bobCar, events["AUTOCROSS"], "50.000", `{"autocross":{"cones":0}}`)
+	app.logLap(t, carol, trackID, carolCar, "49.000", "DRY", events["AUTOCROSS"])
+
+	// Only autocross runs get a PAX time
+	lap = app.postLapResult(t, alice, trackID, aliceCar, events["DRAG"], "", `{"drag":{"quarterMile":"13.100"}}`)
+	assert.Nil(t, lap["pax"])
+	lap = app.postLapResult(t, alice, trackID, aliceCar, events["AUTOCROSS"], "40.000", `{"autocross":{"cones":0,"dnf":true}}`)
+	assert.Nil(t, lap["pax"])
+
+	board := "/api/tracks/" + trackID + "/leaderboard?eventType=AUTOCROSS"
+	rec = app.doRequest(http.MethodGet, board, "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, []string{"Carol", "Bob", "Alice"}, leaderboardNames(t, rec))
+
+	// Ranked by PAX time, cars without a class drop out
+	rec = app.doRequest(http.MethodGet, board+"&pax=true", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	result := parseJSON(t, rec)
+	assert.Equal(t, "paxTime", result["rankedBy"])
+	assert.Equal(t, []string{"Bob", "Alice"}, leaderboardNames(t, rec))
+	entries := result["entries"].([]interface{})
+	first, second := entries[0].(map[string]interface{}), entries[1].(map[string]interface{})
+	assert.Equal(t, "45.000", first["pax"].(map[string]interface{})["time"])
+	assert.Equal(t, "45.600", second["pax"].(map[string]interface{})["time"])
+	assert.Equal(t, "+0.600", second["gap"])
+
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+trackID+"/leaderboard?eventType=DRAG&pax=true", "", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = app.doRequest(http.MethodGet, board+"&pax=maybe", "", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
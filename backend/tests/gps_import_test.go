@@ -0,0 +1,245 @@
+package tests
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The test loop is a 200 m circle driven anticlockwise at 6°/s, logged at
+// 1 Hz: one lap takes 60 s. The start/finish line crosses it at its east
+// point and the line ending sector 1 at its west point.
+const (
+	loopLat     = 36.5843
+	loopLng     = -121.7535
+	loopRadius  = 200.0
+	loopDegSec  = 6.0
+	metresDeg   = 6371000 * math.Pi / 180
+	loopStartAt = "2026-06-06T17:00:00Z"
+)
+
+type tracePoint struct {
+	lat, lng float64
+	at       time.Time
+}
+
+// loopPoint returns the point on the loop r metres from its centre at the
+// given angle, measured anticlockwise from east.
+func loopPoint(r, deg float64) (float64, float64) {
+	rad := deg * math.Pi / 180
+	return loopLat + r*math.Sin(rad)/metresDeg,
+		loopLng + r*math.Cos(rad)/(metresDeg*math.Cos(loopLat*math.Pi/180))
+}
+
+// loopTrace drives the loop for the given number of seconds, starting just
+// before the start/finish line. Seconds for which skip returns true are not
+// logged.
+func loopTrace(seconds int, skip func(int) bool) []tracePoint {
+	start, _ := time.Parse(time.RFC3339, loopStartAt)
+	var points []tracePoint
+	for s := 0; s <= seconds; s++ {
+		if skip != nil && skip(s) {
+			continue
+		}
+		lat, lng := loopPoint(loopRadius, -10+loopDegSec*float64(s))
+		points = append(points, tracePoint{lat, lng, start.Add(time.Duration(s) * time.Second)})
+	}
+	return points
+}
+
+func gpxTrace(points []tracePoint) string {
+	var b strings.Builder
+	b.WriteString("<?xml version=\"1.0\"?>\n<gpx version=\"1.1\" creator=\"test\"><trk><trkseg>\n")
+	for _, p := range points {
+		fmt.Fprintf(&b, "<trkpt lat=\"%.7f\" lon=\"%.7f\"><time>%s</time></trkpt>\n", p.lat, p.lng, p.at.Format(time.RFC3339))
+	}
+	b.WriteString("</trkseg></trk></gpx>\n")
+	return b.String()
+}
+
+func nmeaTrace(points []tracePoint) string {
+	var b strings.Builder
+	for _, p := range points {
+		lat, lng := math.Abs(p.lat), math.Abs(p.lng)
+		body := fmt.Sprintf("GPRMC,%s,A,%02d%09.6f,N,%03d%09.6f,W,40.6,0.0,%s,,",
+			p.at.Format("150405.00"),
+			int(lat), (lat-math.Floor(lat))*60,
+			int(lng), (lng-math.Floor(lng))*60,
+			p.at.Format("020106"))
+		var sum byte
+		for i := 0; i < len(body); i++ {
+			sum ^= body[i]
+		}
+		fmt.Fprintf(&b, "$%s*%02X\r\n", body, sum)
+	}
+	return b.String()
+}
+
+// timingLine is a 100 m line across the loop at the given angle.
+func timingLine(deg float64) string {
+	lat1, lng1 := loopPoint(loopRadius-50, deg)
+	lat2, lng2 := loopPoint(loopRadius+50, deg)
+	return fmt.Sprintf(`{"lat1":%.7f,"lng1":%.7f,"lat2":%.7f,"lng2":%.7f}`, lat1, lng1, lat2, lng2)
+}
+
+// createLoopTrack creates an approved track at the loop with a start/finish
+// line and two sectors.
+func (app *testApp) createLoopTrack(t *testing.T, token string) string {
+	t.Helper()
+	trackID := app.createTestTrack(t, token)
+	_, err := app.db.Exec(`UPDATE "Track" SET latitude = ?, longitude = ? WHERE id = ?`, loopLat, loopLng, trackID)
+	require.NoError(t, err)
+	rec := app.doRequest(http.MethodPut, "/api/tracks/"+trackID+"/start-finish", timingLine(0), token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	app.defineSectors(t, token, trackID, `{"sectors":[{"name":"S1","line":`+timingLine(180)+`},{"name":"S2"}]}`)
+	return trackID
+}
+
+func (app *testApp) importGPS(t *testing.T, token, filename, data string, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	return app.uploadFile(t, "/api/lapbook/import/gps", token, filename, data, fields)
+}
+
+func TestTimingLines(t *testing.T) {
+	app := setupTestApp(t)
+	_, owner := app.createTestUser(t, "Owner", uniqueEmail("gps"), "password123")
+	_, other := app.createTestUser(t, "Other", uniqueEmail("gps"), "password123")
+	trackID := app.createTestTrack(t, owner)
+	path := "/api/tracks/" + trackID + "/start-finish"
+
+	rec := app.doRequest(http.MethodGet, "/api/tracks/"+trackID, "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Nil(t, parseJSON(t, rec)["startFinishLine"])
+
+	rec = app.doRequest(http.MethodPut, path, timingLine(0), owner)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	line := parseJSON(t, rec)["startFinishLine"].(map[string]interface{})
+	assert.InDelta(t, loopLat, line["lat1"], 1e-9)
+
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+trackID, "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotNil(t, parseJSON(t, rec)["startFinishLine"])
+
+	app.defineSectors(t, owner, trackID, `{"sectors":[{"name":"S1","line":`+timingLine(180)+`},{"name":"S2"}]}`)
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+trackID+"/sectors", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	sectors := parseJSONArray(t, rec)
+	require.Len(t, sectors, 2)
+	assert.NotNil(t, sectors[0]["line"])
+	assert.Nil(t, sectors[1]["line"])
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		status int
+	}{
+		{"not the uploader", http.MethodPut, path, other, timingLine(0), http.StatusForbidden},
+		{"delete as another user", http.MethodDelete, path, other, "", http.StatusForbidden},
+		{"unknown track", http.MethodPut, "/api/tracks/nope/start-finish", owner, timingLine(0), http.StatusNotFound},
+		{"latitude out of range", http.MethodPut, path, owner, `{"lat1":91,"lng1":0,"lat2":90,"lng2":0}`, http.StatusBadRequest},
+		{"same point twice", http.MethodPut, path, owner, `{"lat1":36.5,"lng1":-121.7,"lat2":36.5,"lng2":-121.7}`, http.StatusBadRequest},
+		{"too long", http.MethodPut, path, owner, `{"lat1":36.5,"lng1":-121.7,"lat2":36.6,"lng2":-121.7}`, http.StatusBadRequest},
+		{"bad sector line", http.MethodPut, "/api/tracks/" + trackID + "/sectors", owner,
+			`{"sectors":[{"name":"S1","line":{"lat1":36.5,"lng1":-121.7,"lat2":36.5,"lng2":-121.7}}]}`, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := app.doRequest(tc.method, tc.path, tc.body, tc.token)
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+		})
+	}
+
+	rec = app.doRequest(http.MethodDelete, path, "", owner)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Nil(t, parseJSON(t, rec)["startFinishLine"])
+}
+
+func TestGPSImport_GPX(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("gps"), "password123")
+	carID := app.createTestCar(t, "BMW", "M3", 2020, userID)
+	trackID := app.createLoopTrack(t, token)
+	gpx := gpxTrace(loopTrace(185, nil))
+
+	rec := app.importGPS(t, token, "session.gpx", gpx, map[string]string{"carId": carID, "dryRun": "true"})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	result := parseJSON(t, rec)
+	assert.Equal(t, "gpx", result["format"])
+	assert.Equal(t, "gps", result["trackMatch"])
+	assert.Equal(t, trackID, result["track"].(map[string]interface{})["id"])
+	assert.Equal(t, "2026-06-06", result["date"])
+	assert.Empty(t, result["errors"])
+	assert.Nil(t, result["session"])
+
+	laps := result["laps"].([]interface{})
+	require.Len(t, laps, 3)
+	for i, l := range laps {
+		lap := l.(map[string]interface{})
+		assert.Equal(t, float64(i+1), lap["lapNumber"])
+		assert.InDelta(t, 60000, lap["lapTimeMs"], 2)
+		splits := lap["splitsMs"].([]interface{})
+		require.Len(t, splits, 2)
+		assert.InDelta(t, 30000, splits[0], 2)
+		assert.InDelta(t, 30000, splits[1], 2)
+	}
+
+	rec = app.importGPS(t, token, "session.gpx", gpx, map[string]string{"carId": carID, "runGroup": "Blue"})
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	session := parseJSON(t, rec)["session"].(map[string]interface{})
+	assert.Equal(t, "Blue", session["runGroup"])
+	assert.Equal(t, float64(3), session["summary"].(map[string]interface{})["lapCount"])
+
+	rec = app.doRequest(http.MethodGet, "/api/sessions/"+session["id"].(string), "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	lap := parseJSON(t, rec)["laps"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "1:00.000", lap["lapTime"])
+	assert.Len(t, lap["splits"], 2)
+}
+
+func TestGPSImport_NMEA(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("gps"), "password123")
+	carID := app.createTestCar(t, "BMW", "M3", 2020, userID)
+	trackID := app.createLoopTrack(t, token)
+
+	// The logger drops out for 9 s during the second lap
+	points := loopTrace(185, func(s int) bool { return s > 80 && s < 90 })
+	nmea := "$GPRMC,garbage*00\r\n" + nmeaTrace(points)
+	rec := app.importGPS(t, token, "session.nmea", nmea, map[string]string{"carId": carID, "trackId": trackID})
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	result := parseJSON(t, rec)
+	assert.Equal(t, "nmea", result["format"])
+	assert.Equal(t, "2026-06-06", result["date"])
+
+	laps := result["laps"].([]interface{})
+	require.Len(t, laps, 2)
+	assert.Equal(t, float64(1), laps[0].(map[string]interface{})["lapNumber"])
+	assert.Equal(t, float64(3), laps[1].(map[string]interface{})["lapNumber"])
+	assert.InDelta(t, 60000, laps[1].(map[string]interface{})["lapTimeMs"], 5)
+	errors := result["errors"].([]interface{})
+	require.Len(t, errors, 1)
+	assert.Contains(t, errors[0].(map[string]interface{})["message"], "lap 2: GPS trace has a 10s gap")
+
+	// A track without a start/finish line can't be used
+	plainTrack := app.createPendingTrack(t, token, `{"name":"Plain Track","location":"Nowhere, NV","eventTypes":["ROADCOURSE"]}`)
+	app.approveTrack(t, plainTrack)
+	rec = app.importGPS(t, token, "session.nmea", nmea, map[string]string{"carId": carID, "trackId": plainTrack})
+	assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+
+	rec = app.importGPS(t, token, "laps.csv", "Lap,Lap time\n1,1:40.000\n", map[string]string{"carId": carID, "trackId": trackID})
+	assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+
+	// A trace that never crosses the line twice has nothing to save
+	rec = app.importGPS(t, token, "short.gpx", gpxTrace(loopTrace(30, nil)), map[string]string{"carId": carID, "trackId": trackID})
+	assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+}
@@ -34,8 +34,9 @@ type testApp struct {
 }
 
 // setupTestApp creates an in-memory SQLite database, runs migrations,
-// wires up all routes, and returns a ready-to-use testApp.
-func setupTestApp(t *testing.T) *testApp {
+// wires up all routes, and returns a ready-to-use testApp. Options can
+// change the config before the routes are set up.
+func setupTestApp(t *testing.T, options ...func(*config.Config)) *testApp {
 	t.Helper()
 
 	// Use a temp file for each test to avoid :memory: connection-pool issues
@@ -56,6 +57,11 @@ func setupTestApp(t *testing.T) *testApp {
 		UploadDir:   t.TempDir(),
 		CORSOrigins: []string{"http://localhost:3000"},
 		DataDir:     ".",
+		AppURL:      "http://localhost:3000",
+		MailDir:     t.TempDir(),
+	}
+	for _, option := range options {
+		option(cfg)
 	}
 
 	e := echo.New()
@@ -72,14 +78,25 @@ func setupTestApp(t *testing.T) *testApp {
 }
 
 // generateToken creates a valid JWT for the given user ID and email.
-func generateToken(userID, email string) string {
+func (app *testApp) generateToken(t *testing.T, userID, email string) string {
+	return app.generateTokenWithRole(t, userID, email, "USER")
+}
+
+// generateTokenWithRole starts a login session for the user and creates a
+// valid JWT for it carrying the given role.
+func (app *testApp) generateTokenWithRole(t *testing.T, userID, email, role string) string {
+	t.Helper()
+	sessionID, _, err := repository.NewAuthSessionRepo(app.db).Create(userID, time.Hour)
+	require.NoError(t, err)
 	claims := &mw.JWTClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   userID,
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
-		Email: email,
+		Email:     email,
+		Role:      role,
+		SessionID: sessionID,
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenStr, _ := token.SignedString([]byte(testJWTSecret))
@@ -109,15 +126,28 @@ func (app *testApp) doRequest(method, path, body string, token string) *httptest
 	return rec
 }
 
-// createTestUser creates a user in the database and returns (userID, token).
+// createTestUser creates a user with a verified email address in the
+// database and returns (userID, token).
 func (app *testApp) createTestUser(t *testing.T, name, email, password string) (string, string) {
 	t.Helper()
 	user, err := app.userRepo.Create(name, email, password)
 	require.NoError(t, err)
-	token := generateToken(user.ID, user.Email)
+	require.NoError(t, app.userRepo.MarkEmailVerified(user.ID))
+	token := app.generateToken(t, user.ID, user.Email)
 	return user.ID, token
 }
 
+// createTestUserWithRole creates a user holding role and returns (userID, token).
+func (app *testApp) createTestUserWithRole(t *testing.T, name, email, role string) (string, string) {
+	t.Helper()
+	user, err := app.userRepo.Create(name, email, "password123")
+	require.NoError(t, err)
+	require.NoError(t, app.userRepo.MarkEmailVerified(user.ID))
+	_, err = app.userRepo.SetRole(user.ID, role)
+	require.NoError(t, err)
+	return user.ID, app.generateTokenWithRole(t, user.ID, user.Email, role)
+}
+
 // createTestCar creates a car for a user and returns the car ID.
 func (app *testApp) createTestCar(t *testing.T, make, model string, year int, userID string) string {
 	t.Helper()
@@ -126,10 +156,32 @@ func (app *testApp) createTestCar(t *testing.T, make, model string, year int, us
 	return car.ID
 }
 
-// createTestTrack creates a track via the API and returns the track ID.
+// createTestTrack creates a track via the API, approves it so it is publicly
+// visible, and returns the track ID.
 func (app *testApp) createTestTrack(t *testing.T, token string) string {
 	t.Helper()
-	body := `{"name":"Test Track","location":"Test City, CA","eventTypes":["ROADCOURSE"]}`
+	id := app.createPendingTrack(t, token, `{"name":"Test Track","location":"Test City, CA","eventTypes":["ROADCOURSE"]}`)
+	app.approveTrack(t, id)
+	return id
+}
+
+// logLap records a lap through the API and returns its ID.
+func (app *testApp) logLap(t *testing.T, token, trackID, carID, lapTime, conditions, trackEventID string) string {
+	t.Helper()
+	body := `{"lapTime":"` + lapTime + `","conditions":"` + conditions + `","trackId":"` + trackID + `","carId":"` + carID + `"`
+	if trackEventID != "" {
+		body += `,"trackEventId":"` + trackEventID + `"`
+	}
+	body += `}`
+	rec := app.doRequest(http.MethodPost, "/api/lapbook", body, token)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	return parseJSON(t, rec)["id"].(string)
+}
+
+// createPendingTrack submits a track via the API and returns its ID without
+// approving it.
+func (app *testApp) createPendingTrack(t *testing.T, token, body string) string {
+	t.Helper()
 	rec := app.doRequest(http.MethodPost, "/api/tracks", body, token)
 	require.Equal(t, http.StatusCreated, rec.Code, "create track: %s", rec.Body.String())
 
@@ -139,6 +191,13 @@ func (app *testApp) createTestTrack(t *testing.T, token string) string {
 	return result["id"].(string)
 }
 
+// approveTrack marks a track APPROVED directly in the database.
+func (app *testApp) approveTrack(t *testing.T, trackID string) {
+	t.Helper()
+	_, err := app.db.Exec(`UPDATE "Track" SET status = 'APPROVED' WHERE id = ?`, trackID)
+	require.NoError(t, err)
+}
+
 // parseJSON unmarshals the response body into a map.
 func parseJSON(t *testing.T, rec *httptest.ResponseRecorder) map[string]interface{} {
 	t.Helper()
@@ -157,6 +216,17 @@ func parseJSONArray(t *testing.T, rec *httptest.ResponseRecorder) []map[string]i
 	return result
 }
 
+// parseTrackList unmarshals a GET /api/tracks page and returns its tracks.
+func parseTrackList(t *testing.T, rec *httptest.ResponseRecorder) []map[string]interface{} {
+	t.Helper()
+	var result struct {
+		Tracks []map[string]interface{} `json:"tracks"`
+	}
+	err := json.Unmarshal(rec.Body.Bytes(), &result)
+	require.NoError(t, err, "failed to parse track list: %s", rec.Body.String())
+	return result.Tracks
+}
+
 // uniqueEmail generates a unique email for test isolation.
 func uniqueEmail(prefix string) string {
 	return fmt.Sprintf("%s-%d@test.com", prefix, time.Now().UnixNano())
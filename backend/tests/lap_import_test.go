@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// importLaps posts a data-logger export to the lap import endpoint.
+func (app *testApp) importLaps(t *testing.T, token, csv string, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	return app.uploadFile(t, "/api/lapbook/import", token, "laps.csv", csv, fields)
+}
+
+// uploadFile posts a multipart form with the given fields and, unless data
+// is empty, a file field.
+func (app *testApp) uploadFile(t *testing.T, path, token, filename, data string, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		require.NoError(t, writer.WriteField(k, v))
+	}
+	if data != "" {
+		part, err := writer.CreateFormFile("file", filename)
+		require.NoError(t, err)
+		part.Write([]byte(data))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, path, &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", authHeader(token))
+	rec := httptest.NewRecorder()
+	app.e.ServeHTTP(rec, req)
+	return rec
+}
+
+const raceChronoExport = `This file is created using RaceChrono v8.1
+Session title,Saturday practice
+Track name,Test Track
+Created,2026-05-02 09:30
+
+Lap #,Lap time,Sector 1,Sector 2
+1,1:45.120,50.100,55.020
+2,1:42.500,49.000,53.500
+3,1:4x.000,49.000,55.000
+4,1:43.000,49.000,50.000
+Best,1:42.500,49.000,53.500
+`
+
+func TestLapImport_DryRun(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("imp"), "password123")
+	carID := app.createTestCar(t, "BMW", "M3", 2020, userID)
+	trackID := app.createTestTrack(t, token)
+	app.defineSectors(t, token, trackID, `{"sectors":[{"name":"S1"},{"name":"S2"}]}`)
+
+	rec := app.importLaps(t, token, raceChronoExport, map[string]string{"carId": carID, "dryRun": "true"})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	result := parseJSON(t, rec)
+	assert.Equal(t, true, result["dryRun"])
+	assert.Equal(t, "racechrono", result["format"])
+	assert.Equal(t, "2026-05-02", result["date"])
+	assert.Equal(t, trackID, result["track"].(map[string]interface{})["id"])
+	assert.Equal(t, "name", result["trackMatch"])
+	assert.Nil(t, result["session"])
+
+	laps := result["laps"].([]interface{})
+	require.Len(t, laps, 2)
+	first := laps[0].(map[string]interface{})
+	assert.Equal(t, float64(7), first["row"])
+	assert.Equal(t, "1:45.120", first["lapTime"])
+	assert.Equal(t, []interface{}{"50.100", "55.020"}, first["splits"])
+
+	errors := result["errors"].([]interface{})
+	require.Len(t, errors, 2)
+	assert.Equal(t, float64(9), errors[0].(map[string]interface{})["row"])
+	assert.Contains(t, errors[0].(map[string]interface{})["message"], "invalid lap time")
+	assert.Contains(t, errors[1].(map[string]interface{})["message"], "sector times add up to")
+
+	// Nothing was saved
+	rec = app.doRequest(http.MethodGet, "/api/lapbook", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, parseJSONArray(t, rec))
+}
+
+func TestLapImport_Create(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("imp"), "password123")
+	carID := app.createTestCar(t, "BMW", "M3", 2020, userID)
+	app.createTestTrack(t, token)
+	trackID := app.createPendingTrack(t, token, `{"name":"WeatherTech Raceway Laguna Seca","location":"Monterey, CA","eventTypes":["ROADCOURSE"]}`)
+	app.approveTrack(t, trackID)
+	_, err := app.db.Exec(`UPDATE "Track" SET latitude = 36.5843, longitude = -121.7535 WHERE id = ?`, trackID)
+	require.NoError(t, err)
+	app.defineSectors(t, token, trackID, `{"sectors":[{"name":"S1"},{"name":"S2"},{"name":"S3"}]}`)
+
+	// AiM export with the session's coordinates but a name we don't know
+	export := `"Format","AiM CSV File"
+"Venue","Mazda Raceway"
+"Date","05/03/2026"
+"Latitude","36.5850"
+"Longitude","-121.7540"
+
+"Lap","Time","S1","S2"
+"1","1:40.000","45.000","55.000"
+"2","1:39.500","44.500","55.000"
+`
+	rec := app.importLaps(t, token, export, map[string]string{"carId": carID, "runGroup": "Red"})
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	result := parseJSON(t, rec)
+	assert.Equal(t, "aim", result["format"])
+	assert.Equal(t, "gps", result["trackMatch"])
+	assert.Equal(t, trackID, result["track"].(map[string]interface{})["id"])
+	require.Len(t, result["warnings"], 1)
+	assert.Contains(t, result["warnings"].([]interface{})[0], "splits were not imported")
+
+	session := result["session"].(map[string]interface{})
+	assert.Equal(t, "2026-05-03", session["date"])
+	assert.Equal(t, "Red", session["runGroup"])
+	summary := session["summary"].(map[string]interface{})
+	assert.Equal(t, float64(2), summary["lapCount"])
+	assert.Equal(t, float64(99500), summary["bestMs"])
+
+	rec = app.doRequest(http.MethodGet, "/api/sessions/"+session["id"].(string), "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	laps := parseJSON(t, rec)["laps"].([]interface{})
+	require.Len(t, laps, 2)
+	assert.Equal(t, float64(2), laps[1].(map[string]interface{})["lapNumber"])
+	assert.Equal(t, "DRY", laps[1].(map[string]interface{})["conditions"])
+	assert.Empty(t, laps[1].(map[string]interface{})["splits"])
+
+	// With a matching sector count the splits come along
+	export = "Track: Laguna Seca\nDate: 2026-05-04\nLap,Lap Time (s),Split 1,Split 2,Split 3\n1,98.9,30.1,35.5,33.3\n"
+	rec = app.importLaps(t, token, export, map[string]string{"carId": carID, "conditions": "WET"})
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	result = parseJSON(t, rec)
+	assert.Equal(t, "name", result["trackMatch"])
+	assert.Equal(t, trackID, result["track"].(map[string]interface{})["id"])
+	rec = app.doRequest(http.MethodGet, "/api/sessions/"+result["session"].(map[string]interface{})["id"].(string), "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	lap := parseJSON(t, rec)["laps"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "1:38.900", lap["lapTime"])
+	assert.Equal(t, "WET", lap["conditions"])
+	assert.Len(t, lap["splits"], 3)
+}
+
+func TestLapImport_Errors(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("imp"), "password123")
+	otherID, _ := app.createTestUser(t, "Other", uniqueEmail("imp"), "password123")
+	carID := app.createTestCar(t, "BMW", "M3", 2020, userID)
+	otherCarID := app.createTestCar(t, "Honda", "Civic", 2018, otherID)
+	trackID := app.createTestTrack(t, token)
+	unknownTrack := "Track name,Nowhere Raceway\nLap,Lap time\n1,1:40.000\n"
+
+	cases := []struct {
+		name   string
+		csv    string
+		fields map[string]string
+		status int
+	}{
+		{"no file", "", map[string]string{"carId": carID}, http.StatusBadRequest},
+		{"no car", raceChronoExport, map[string]string{}, http.StatusBadRequest},
+		{"another driver's car", raceChronoExport, map[string]string{"carId": otherCarID}, http.StatusNotFound},
+		{"no lap table", "Name,Value\nfoo,bar\n", map[string]string{"carId": carID}, http.StatusBadRequest},
+		{"no matching track", unknownTrack, map[string]string{"carId": carID}, http.StatusBadRequest},
+		{"unknown track id", unknownTrack, map[string]string{"carId": carID, "trackId": "nope"}, http.StatusNotFound},
+		{"no valid laps", "Lap,Lap time\n1,soon\n", map[string]string{"carId": carID, "trackId": trackID}, http.StatusBadRequest},
+		{"bad date", unknownTrack, map[string]string{"carId": carID, "trackId": trackID, "date": "May 2"}, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := app.importLaps(t, token, tc.csv, tc.fields)
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+		})
+	}
+
+	// A dry run previews even without a track
+	rec := app.importLaps(t, token, unknownTrack, map[string]string{"carId": carID, "dryRun": "true"})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	result := parseJSON(t, rec)
+	assert.Nil(t, result["track"])
+	assert.Len(t, result["laps"], 1)
+	assert.NotEmpty(t, result["warnings"])
+
+	// An explicit track wins over the file
+	rec = app.importLaps(t, token, unknownTrack, map[string]string{"carId": carID, "trackId": trackID})
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Equal(t, "id", parseJSON(t, rec)["trackMatch"])
+}
@@ -0,0 +1,233 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createMultiEventTrack creates an approved track with drag, autocross and
+// drift events and returns its ID and event IDs by type.
+func (app *testApp) createMultiEventTrack(t *testing.T, token string) (string, map[string]string) {
+	t.Helper()
+	trackID := app.createPendingTrack(t, token, `{"name":"Event Park","location":"Somewhere, CA","eventTypes":["DRAG","AUTOCROSS","DRIFT","ROADCOURSE"]}`)
+	app.approveTrack(t, trackID)
+	events, err := app.trackRepo.GetEvents(trackID)
+	require.NoError(t, err)
+	eventIDs := map[string]string{}
+	for _, e := range events {
+		eventIDs[e.EventType] = e.ID
+	}
+	return trackID, eventIDs
+}
+
+// postLapResult logs a lap with an event-specific result and returns the created record.
+func (app *testApp) postLapResult(t *testing.T, token, trackID, carID, eventID, lapTime, result string) map[string]interface{} {
+	t.Helper()
+	body := `{"conditions":"DRY","trackId":"` + trackID + `","carId":"` + carID + `","trackEventId":"` + eventID + `","result":` + result
+	if lapTime != "" {
+		body += `,"lapTime":"` + lapTime + `"`
+	}
+	rec := app.doRequest(http.MethodPost, "/api/lapbook", body+"}", token)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	return parseJSON(t, rec)
+}
+
+func TestLapResults_Create(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("res"), "password123")
+	carID := app.createTestCar(t, "Ford", "Mustang", 2021, userID)
+	trackID, events := app.createMultiEventTrack(t, token)
+
+	// A drag pass takes its elapsed time from the 1/4 mile time
+	lap := app.postLapResult(t, token, trackID, carID, events["DRAG"], "",
+		`{"drag":{"reactionTime":"-0.02","sixtyFoot":"1.8","threeThirty":"5.1","eighthMile":"7.9","quarterMile":"12.345","trapSpeedMph":112.4}}`)
+	assert.Equal(t, "12.345", lap["lapTime"])
+	drag := lap["result"].(map[string]interface{})["drag"].(map[string]interface{})
+	assert.Equal(t, "-0.020", drag["reactionTime"])
+	assert.Equal(t, "1.800", drag["sixtyFoot"])
+	assert.Equal(t, 112.4, drag["trapSpeedMph"])
+	assert.Equal(t, true, drag["redLight"])
+
+	lap = app.postLapResult(t, token, trackID, carID, events["AUTOCROSS"], "55.100", `{"autocross":{"cones":2}}`)
+	ax := lap["result"].(map[string]interface{})["autocross"].(map[string]interface{})
+	assert.Equal(t, "59.100", ax["adjustedTime"])
+	assert.Equal(t, float64(59100), ax["adjustedTimeMs"])
+
+	lap = app.postLapResult(t, token, trackID, carID, events["AUTOCROSS"], "50.000", `{"autocross":{"cones":0,"offCourse":true}}`)
+	ax = lap["result"].(map[string]interface{})["autocross"].(map[string]interface{})
+	assert.Nil(t, ax["adjustedTime"])
+
+	lap = app.postLapResult(t, token, trackID, carID, events["DRIFT"], "40.000",
+		`{"drift":{"scores":[{"category":" Line ","score":30.1},{"category":"Angle","score":28.2},{"category":"Style","score":25}]}}`)
+	drift := lap["result"].(map[string]interface{})["drift"].(map[string]interface{})
+	assert.Equal(t, 83.3, drift["total"])
+	scores := drift["scores"].([]interface{})
+	require.Len(t, scores, 3)
+	assert.Equal(t, "Line", scores[0].(map[string]interface{})["category"])
+
+	// The result comes back in the lapbook, and laps without one have none
+	app.logLap(t, token, trackID, carID, "1:30.000", "DRY", "")
+	rec := app.doRequest(http.MethodGet, "/api/lapbook?eventType=DRIFT", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	laps := parseJSONArray(t, rec)
+	require.Len(t, laps, 1)
+	assert.NotNil(t, laps[0]["result"].(map[string]interface{})["drift"])
+	rec = app.doRequest(http.MethodGet, "/api/lapbook?eventType=ROADCOURSE", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, parseJSONArray(t, rec))
+
+	ids := `"conditions":"DRY","trackId":"` + trackID + `","carId":"` + carID + `"`
+	cases := []struct {
+		name string
+		body string
+	}{
+		{"result without an event", `{"lapTime":"50.000",` + ids + `,"result":{"autocross":{"cones":1}}}`},
+		{"wrong event type", `{"lapTime":"50.000",` + ids + `,"trackEventId":"` + events["DRIFT"] + `","result":{"autocross":{"cones":1}}}`},
+		{"two kinds", `{"lapTime":"50.000",` + ids + `,"trackEventId":"` + events["AUTOCROSS"] + `","result":{"autocross":{},"drift":{"scores":[{"category":"Line","score":1}]}}}`},
+		{"negative cones", `{"lapTime":"50.000",` + ids + `,"trackEventId":"` + events["AUTOCROSS"] + `","result":{"autocross":{"cones":-1}}}`},
+		{"empty timeslip", `{"lapTime":"12.000",` + ids + `,"trackEventId":"` + events["DRAG"] + `","result":{"drag":{}}}`},
+		{"increments out of order", `{` + ids + `,"trackEventId":"` + events["DRAG"] + `","result":{"drag":{"sixtyFoot":"5.0","threeThirty":"4.0","quarterMile":"12.0"}}}`},
+		{"quarter mile differs from ET", `{"lapTime":"12.500",` + ids + `,"trackEventId":"` + events["DRAG"] + `","result":{"drag":{"quarterMile":"12.000"}}}`},
+		{"trap speed", `{"lapTime":"12.000",` + ids + `,"trackEventId":"` + events["DRAG"] + `","result":{"drag":{"trapSpeedMph":0}}}`},
+		{"no time at all", `{` + ids + `,"trackEventId":"` + events["DRAG"] + `","result":{"drag":{"trapSpeedMph":110}}}`},
+		{"no drift scores", `{"lapTime":"40.000",` + ids + `,"trackEventId":"` + events["DRIFT"] + `","result":{"drift":{"scores":[]}}}`},
+		{"drift score too high", `{"lapTime":"40.000",` + ids + `,"trackEventId":"` + events["DRIFT"] + `","result":{"drift":{"scores":[{"category":"Line","score":101}]}}}`},
+		{"repeated category", `{"lapTime":"40.000",` + ids + `,"trackEventId":"` + events["DRIFT"] + `","result":{"drift":{"scores":[{"category":"Line","score":1},{"category":"line","score":2}]}}}`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := app.doRequest(http.MethodPost, "/api/lapbook", tc.body, token)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+		})
+	}
+}
+
+func TestLapResults_Update(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("res"), "password123")
+	carID := app.createTestCar(t, "Mazda", "MX-5", 2019, userID)
+	trackID, events := app.createMultiEventTrack(t, token)
+
+	lap := app.postLapResult(t, token, trackID, carID, events["AUTOCROSS"], "50.000", `{"autocross":{"cones":1}}`)
+	path := "/api/lapbook/" + lap["id"].(string)
+
+	// A faster raw time moves the adjusted time with it
+	rec := app.doRequest(http.MethodPatch, path, `{"lapTime":"49.000"}`, token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	ax := parseJSON(t, rec)["result"].(map[string]interface{})["autocross"].(map[string]interface{})
+	assert.Equal(t, "51.000", ax["adjustedTime"])
+
+	rec = app.doRequest(http.MethodPatch, path, `{"result":{"autocross":{"cones":0,"dnf":true}}}`, token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	ax = parseJSON(t, rec)["result"].(map[string]interface{})["autocross"].(map[string]interface{})
+	assert.Equal(t, true, ax["dnf"])
+	assert.Nil(t, ax["adjustedTimeMs"])
+
+	// Moving the lap to another event needs the result cleared
+	rec = app.doRequest(http.MethodPatch, path, `{"trackEventId":"`+events["ROADCOURSE"]+`"}`, token)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+	rec = app.doRequest(http.MethodPatch, path, `{"trackEventId":"`+events["ROADCOURSE"]+`","result":null}`, token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Nil(t, parseJSON(t, rec)["result"])
+
+	rec = app.doRequest(http.MethodGet, path+"/history", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	history := parseJSONArray(t, rec)
+	require.Len(t, history, 3)
+	assert.Contains(t, history[0]["changes"], "result")
+	assert.Contains(t, history[1]["changes"], "result")
+	assert.Nil(t, history[2]["changes"].(map[string]interface{})["result"].(map[string]interface{})["to"])
+}
+
+func TestLapResults_Leaderboards(t *testing.T) {
+	app := setupTestApp(t)
+	aliceID, alice := app.createTestUser(t, "Alice", uniqueEmail("res"), "password123")
+	bobID, bob := app.createTestUser(t, "Bob", uniqueEmail("res"), "password123")
+	carolID, carol := app.createTestUser(t, "Carol", uniqueEmail("res"), "password123")
+	aliceCar := app.createTestCar(t, "Mazda", "MX-5", 2019, aliceID)
+	bobCar := app.createTestCar(t, "Honda", "S2000", 2008, bobID)
+	carolCar := app.createTestCar(t, "Toyota", "GR86", 2023, carolID)
+	trackID, events := app.createMultiEventTrack(t, alice)
+	board := "/api/tracks/" + trackID + "/leaderboard?eventType="
+
+	// Autocross: best adjusted time, with DNFs left out and clean runs counting as is
+	app.postLapResult(t, alice, trackID, aliceCar, events["AUTOCROSS"], "50.000", `{"autocross":{"cones":2}}`)
+	app.postLapResult(t, alice, trackID, aliceCar, events["AUTOCROSS"], "52.000", `{"autocross":{"cones":0}}`)
+	app.postLapResult(t, bob, trackID, bobCar, events["AUTOCROSS"], "51.000", `{"autocross":{"cones":0}}`)
+	app.postLapResult(t, bob, trackID, bobCar, events["AUTOCROSS"], "45.000", `{"autocross":{"cones":0,"dnf":true}}`)
+	app.logLap(t, carol, trackID, carolCar, "53.500", "DRY", events["AUTOCROSS"])
+
+	rec := app.doRequest(http.MethodGet, board+"AUTOCROSS", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	result := parseJSON(t, rec)
+	assert.Equal(t, "adjustedTime", result["rankedBy"])
+	assert.Equal(t, []string{"Bob", "Alice", "Carol"}, leaderboardNames(t, rec))
+	entries := result["entries"].([]interface{})
+	alicesRun := entries[1].(map[string]interface{})
+	assert.Equal(t, "52.000", alicesRun["lapTime"])
+	assert.Equal(t, "+1.000", alicesRun["gap"])
+	assert.Nil(t, entries[2].(map[string]interface{})["result"])
+
+	// Drag: lowest 1/4 mile time, leaving out eighth-mile passes and red lights
+	app.postLapResult(t, alice, trackID, aliceCar, events["DRAG"], "", `{"drag":{"quarterMile":"13.100","trapSpeedMph":104}}`)
+	app.postLapResult(t, bob, trackID, bobCar, events["DRAG"], "", `{"drag":{"quarterMile":"12.900","trapSpeedMph":108}}`)
+	app.postLapResult(t, alice, trackID, aliceCar, events["DRAG"], "", `{"drag":{"reactionTime":"-0.010","quarterMile":"12.500"}}`)
+	app.postLapResult(t, carol, trackID, carolCar, events["DRAG"], "", `{"drag":{"eighthMile":"8.200"}}`)
+	rec = app.doRequest(http.MethodGet, board+"DRAG", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "quarterMileTime", parseJSON(t, rec)["rankedBy"])
+	assert.Equal(t, []string{"Bob", "Alice"}, leaderboardNames(t, rec))
+	assert.Equal(t, "13.100", parseJSON(t, rec)["entries"].([]interface{})[1].(map[string]interface{})["lapTime"])
+
+	// Drift: highest total score, regardless of time; unscored runs aren't ranked
+	app.postLapResult(t, alice, trackID, aliceCar, events["DRIFT"], "40.000", `{"drift":{"scores":[{"category":"Line","score":30},{"category":"Angle","score":30}]}}`)
+	app.postLapResult(t, bob, trackID, bobCar, events["DRIFT"], "35.000", `{"drift":{"scores":[{"category":"Line","score":25},{"category":"Angle","score":32.5}]}}`)
+	app.logLap(t, carol, trackID, carolCar, "30.000", "DRY", events["DRIFT"])
+	rec = app.doRequest(http.MethodGet, board+"DRIFT", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	result = parseJSON(t, rec)
+	assert.Equal(t, "score", result["rankedBy"])
+	assert.Equal(t, []string{"Alice", "Bob"}, leaderboardNames(t, rec))
+	entries = result["entries"].([]interface{})
+	assert.Equal(t, "-2.5", entries[1].(map[string]interface{})["gap"])
+	assert.Equal(t, float64(57.5), entries[1].(map[string]interface{})["result"].(map[string]interface{})["drift"].(map[string]interface{})["total"])
+}
+
+func TestLapResults_DriftWithoutLapTime(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "Alice", uniqueEmail("res"), "password123")
+	carID := app.createTestCar(t, "Nissan", "240SX", 1995, userID)
+	trackID, events := app.createMultiEventTrack(t, token)
+
+	// Judged runs don't need a time
+	lap := app.postLapResult(t, token, trackID, carID, events["DRIFT"], "", `{"drift":{"scores":[{"category":"Line","score":30},{"category":"Angle","score":28}]}}`)
+	assert.Equal(t, "", lap["lapTime"])
+	assert.Nil(t, lap["lapTimeMs"])
+
+	rec := app.doRequest(http.MethodPatch, "/api/lapbook/"+lap["id"].(string), `{"notes":"Second run"}`, token)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+trackID+"/leaderboard?eventType=DRIFT", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	entries := parseJSON(t, rec)["entries"].([]interface{})
+	require.Len(t, entries, 1)
+	entry := entries[0].(map[string]interface{})
+	assert.Equal(t, lap["id"], entry["lapRecordId"])
+	assert.Nil(t, entry["lapTimeMs"])
+	assert.Equal(t, "DRIFT", entry["eventType"])
+
+	// Timed events still need one
+	ids := `"conditions":"DRY","trackId":"` + trackID + `","carId":"` + carID + `"`
+	for _, body := range []string{
+		`{` + ids + `}`,
+		`{` + ids + `,"trackEventId":"` + events["ROADCOURSE"] + `"}`,
+		`{` + ids + `,"trackEventId":"` + events["AUTOCROSS"] + `","result":{"autocross":{"cones":1}}}`,
+	} {
+		rec = app.doRequest(http.MethodPost, "/api/lapbook", body, token)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+		assert.Equal(t, "Lap time is required", parseJSON(t, rec)["error"])
+	}
+}
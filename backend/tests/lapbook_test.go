@@ -29,7 +29,8 @@ func TestLapbook_Create(t *testing.T) {
 	assert.Equal(t, http.StatusCreated, rec.Code)
 
 	result := parseJSON(t, rec)
-	assert.Equal(t, "1:42.5", result["lapTime"])
+	assert.Equal(t, "1:42.500", result["lapTime"])
+	assert.Equal(t, float64(102500), result["lapTimeMs"])
 	assert.Equal(t, "DRY", result["conditions"])
 }
 
@@ -47,6 +48,7 @@ func TestLapbook_CreateValidation(t *testing.T) {
 		{"invalid conditions", `{"lapTime":"1:30","conditions":"SNOW","trackId":"` + trackID + `","carId":"` + carID + `"}`},
 		{"missing track", `{"lapTime":"1:30","conditions":"DRY","trackId":"","carId":"` + carID + `"}`},
 		{"missing car", `{"lapTime":"1:30","conditions":"DRY","trackId":"` + trackID + `","carId":""}`},
+		{"unparseable lap time", `{"lapTime":"fast","conditions":"DRY","trackId":"` + trackID + `","carId":"` + carID + `"}`},
 	}
 
 	for _, tc := range tests {
@@ -57,6 +59,56 @@ func TestLapbook_CreateValidation(t *testing.T) {
 	}
 }
 
+func TestLapbook_LapTimeFormats(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("lap"), "password123")
+	carID := app.createTestCar(t, "BMW", "M3", 2020, userID)
+	trackID := app.createTestTrack(t, token)
+
+	valid := []struct {
+		in   string
+		want string
+		ms   float64
+	}{
+		{"1:32.4", "1:32.400", 92400},
+		{"92.4", "1:32.400", 92400},
+		{" 12.345 ", "12.345", 12345},
+		{"1:05", "1:05.000", 65000},
+		{"1:02:03.456", "1:02:03.456", 3723456},
+		{"75:00.1", "1:15:00.100", 4500100},
+	}
+	for _, tc := range valid {
+		t.Run(tc.in, func(t *testing.T) {
+			body := `{"lapTime":"` + tc.in + `","conditions":"DRY","trackId":"` + trackID + `","carId":"` + carID + `"}`
+			rec := app.doRequest(http.MethodPost, "/api/lapbook", body, token)
+			require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+			result := parseJSON(t, rec)
+			assert.Equal(t, tc.want, result["lapTime"])
+			assert.Equal(t, tc.ms, result["lapTimeMs"])
+		})
+	}
+
+	invalid := map[string]string{
+		"fast":         "use the format",
+		"1:5.2":        "use the format",
+		"1:60.000":     "00 to 59",
+		"1:30.1234":    "3 decimal places",
+		"1:30.":        "use the format",
+		"-1:30":        "use the format",
+		"0:00.000":     "greater than zero",
+		"24:00:00.000": "under 24 hours",
+		"1:02:03:04":   "use the format",
+	}
+	for in, msg := range invalid {
+		t.Run(in, func(t *testing.T) {
+			body := `{"lapTime":"` + in + `","conditions":"DRY","trackId":"` + trackID + `","carId":"` + carID + `"}`
+			rec := app.doRequest(http.MethodPost, "/api/lapbook", body, token)
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, parseJSON(t, rec)["error"], msg)
+		})
+	}
+}
+
 func TestLapbook_CreateNotOwnedCar(t *testing.T) {
 	app := setupTestApp(t)
 	userID1, _ := app.createTestUser(t, "User1", uniqueEmail("lap1"), "password123")
@@ -94,7 +146,7 @@ func TestLapbook_ListAfterCreate(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rec.Code)
 	result := parseJSONArray(t, rec)
 	assert.Len(t, result, 1)
-	assert.Equal(t, "1:42.5", result[0]["lapTime"])
+	assert.Equal(t, "1:42.500", result[0]["lapTime"])
 	// Should include nested track and car
 	assert.NotNil(t, result[0]["track"])
 	assert.NotNil(t, result[0]["car"])
@@ -137,6 +189,118 @@ func TestLapbook_DeleteNotOwned(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, rec.Code)
 }
 
+func TestLapbook_Update(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("lap"), "password123")
+	carID := app.createTestCar(t, "BMW", "M3", 2020, userID)
+	otherCarID := app.createTestCar(t, "Mazda", "MX-5", 2019, userID)
+	trackID := app.createTestTrack(t, token)
+
+	body := `{"lapTime":"1:42.5","conditions":"DRY","notes":"first","fuelLevel":50,"trackId":"` + trackID + `","carId":"` + carID + `"}`
+	rec := app.doRequest(http.MethodPost, "/api/lapbook", body, token)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	created := parseJSON(t, rec)
+	recordID := created["id"].(string)
+
+	// PUT replaces every field, so the omitted notes and fuel level are cleared
+	body = `{"lapTime":"1:41.9","conditions":"WET","trackId":"` + trackID + `","carId":"` + otherCarID + `"}`
+	rec = app.doRequest(http.MethodPut, "/api/lapbook/"+recordID, body, token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	result := parseJSON(t, rec)
+	assert.Equal(t, "1:41.900", result["lapTime"])
+	assert.Equal(t, float64(101900), result["lapTimeMs"])
+	assert.Equal(t, "WET", result["conditions"])
+	assert.Equal(t, otherCarID, result["carId"])
+	assert.Equal(t, "Mazda", result["car"].(map[string]interface{})["make"])
+	assert.Nil(t, result["notes"])
+	assert.Nil(t, result["fuelLevel"])
+	assert.Equal(t, true, result["edited"])
+	assert.Equal(t, created["createdAt"], result["createdAt"])
+	assert.NotEqual(t, created["updatedAt"], result["updatedAt"])
+
+	// PATCH keeps omitted fields and null clears an optional one
+	rec = app.doRequest(http.MethodPatch, "/api/lapbook/"+recordID, `{"notes":"damp line","camberFL":-2.5}`, token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	result = parseJSON(t, rec)
+	assert.Equal(t, "1:41.900", result["lapTime"])
+	assert.Equal(t, "WET", result["conditions"])
+	assert.Equal(t, "damp line", result["notes"])
+	assert.Equal(t, -2.5, result["camberFL"])
+
+	rec = app.doRequest(http.MethodPatch, "/api/lapbook/"+recordID, `{"notes":null}`, token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	result = parseJSON(t, rec)
+	assert.Nil(t, result["notes"])
+	assert.Equal(t, -2.5, result["camberFL"])
+
+	// A patch that changes nothing is not recorded
+	rec = app.doRequest(http.MethodPatch, "/api/lapbook/"+recordID, `{"lapTime":"101.9"}`, token)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = app.doRequest(http.MethodGet, "/api/lapbook/"+recordID+"/history", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	history := parseJSONArray(t, rec)
+	require.Len(t, history, 3)
+
+	changes := history[0]["changes"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"from": "1:42.500", "to": "1:41.900"}, changes["lapTime"])
+	assert.Equal(t, map[string]interface{}{"from": "DRY", "to": "WET"}, changes["conditions"])
+	assert.Equal(t, map[string]interface{}{"from": carID, "to": otherCarID}, changes["carId"])
+	assert.Equal(t, map[string]interface{}{"from": "first", "to": nil}, changes["notes"])
+	assert.Equal(t, map[string]interface{}{"from": float64(50), "to": nil}, changes["fuelLevel"])
+	assert.NotContains(t, changes, "trackId")
+	assert.Equal(t, "User", history[0]["editedBy"].(map[string]interface{})["name"])
+
+	assert.Len(t, history[1]["changes"], 2)
+	assert.Equal(t, map[string]interface{}{"from": "damp line", "to": nil}, history[2]["changes"].(map[string]interface{})["notes"])
+}
+
+func TestLapbook_UpdateValidation(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("lap"), "password123")
+	otherID, otherToken := app.createTestUser(t, "Other", uniqueEmail("lap"), "password123")
+	carID := app.createTestCar(t, "BMW", "M3", 2020, userID)
+	otherCarID := app.createTestCar(t, "Honda", "Civic", 2018, otherID)
+	trackID := app.createTestTrack(t, token)
+	otherTrackID := app.createPendingTrack(t, token, `{"name":"Other Track","location":"Elsewhere, CA","eventTypes":["AUTOCROSS"]}`)
+	app.approveTrack(t, otherTrackID)
+	pendingTrackID := app.createPendingTrack(t, otherToken, `{"name":"Hidden Track","location":"Nowhere, NV","eventTypes":["ROADCOURSE"]}`)
+	otherEvents, err := app.trackRepo.GetEvents(otherTrackID)
+	require.NoError(t, err)
+
+	recordID := app.logLap(t, token, trackID, carID, "1:42.500", "DRY", "")
+
+	cases := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{"bad lap time", http.MethodPatch, `{"lapTime":"fast"}`, http.StatusBadRequest},
+		{"bad conditions", http.MethodPatch, `{"conditions":"SNOW"}`, http.StatusBadRequest},
+		{"another driver's car", http.MethodPatch, `{"carId":"` + otherCarID + `"}`, http.StatusNotFound},
+		{"hidden track", http.MethodPatch, `{"trackId":"` + pendingTrackID + `"}`, http.StatusNotFound},
+		{"event from another track", http.MethodPatch, `{"trackEventId":"` + otherEvents[0].ID + `"}`, http.StatusBadRequest},
+		{"put without required fields", http.MethodPut, `{"lapTime":"1:40.000"}`, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := app.doRequest(tc.method, "/api/lapbook/"+recordID, tc.body, token)
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+		})
+	}
+
+	// Another driver can neither edit the record nor see its history
+	rec := app.doRequest(http.MethodPatch, "/api/lapbook/"+recordID, `{"lapTime":"1:00.000"}`, otherToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/lapbook/"+recordID+"/history", "", otherToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = app.doRequest(http.MethodGet, "/api/lapbook/"+recordID+"/history", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, parseJSONArray(t, rec))
+}
+
 func TestLapbook_WithTelemetry(t *testing.T) {
 	app := setupTestApp(t)
 	userID, token := app.createTestUser(t, "User", uniqueEmail("lap"), "password123")
@@ -163,3 +327,98 @@ func TestLapbook_WithTelemetry(t *testing.T) {
 	assert.Equal(t, 75.0, result["fuelLevel"])
 	assert.Equal(t, "Best lap of the day", result["notes"])
 }
+
+// setLapDate moves a lap record to the given day, for stats that group by day.
+func (app *testApp) setLapDate(t *testing.T, lapID, date string) {
+	t.Helper()
+	_, err := app.db.Exec(`UPDATE "LapRecord" SET createdAt = ? WHERE id = ?`, date+"T12:00:00Z", lapID)
+	require.NoError(t, err)
+}
+
+func TestLapbook_PersonalBestFlag(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("lap"), "password123")
+	carID := app.createTestCar(t, "BMW", "M3", 2020, userID)
+	trackID := app.createTestTrack(t, token)
+
+	slow := app.logLap(t, token, trackID, carID, "1:45.000", "DRY", "")
+	fast := app.logLap(t, token, trackID, carID, "1:42.000", "DRY", "")
+	wet := app.logLap(t, token, trackID, carID, "1:55.000", "WET", "")
+
+	rec := app.doRequest(http.MethodGet, "/api/lapbook", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	flags := map[string]interface{}{}
+	for _, r := range parseJSONArray(t, rec) {
+		flags[r["id"].(string)] = r["isPersonalBest"]
+	}
+	assert.Equal(t, false, flags[slow])
+	assert.Equal(t, true, flags[fast])
+	// Conditions are compared separately
+	assert.Equal(t, true, flags[wet])
+
+	body := `{"lapTime":"1:41.000","conditions":"DRY","trackId":"` + trackID + `","carId":"` + carID + `"}`
+	rec = app.doRequest(http.MethodPost, "/api/lapbook", body, token)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, true, parseJSON(t, rec)["isPersonalBest"])
+}
+
+func TestLapbook_Stats(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("lap"), "password123")
+	carID := app.createTestCar(t, "BMW", "M3", 2020, userID)
+	trackID := app.createTestTrack(t, token)
+
+	// Day one: 1:45.000, 1:44.000, 1:46.000
+	for _, lt := range []string{"1:45.000", "1:44.000", "1:46.000"} {
+		app.setLapDate(t, app.logLap(t, token, trackID, carID, lt, "DRY", ""), "2024-05-01")
+	}
+	// Day two: slower
+	app.setLapDate(t, app.logLap(t, token, trackID, carID, "1:44.500", "DRY", ""), "2024-06-01")
+	// Day three: new PB
+	pbLap := app.logLap(t, token, trackID, carID, "1:42.250", "DRY", "")
+	app.setLapDate(t, pbLap, "2024-07-01")
+	app.setLapDate(t, app.logLap(t, token, trackID, carID, "1:43.250", "DRY", ""), "2024-07-01")
+
+	rec := app.doRequest(http.MethodGet, "/api/lapbook/stats", "", token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	stats := parseJSON(t, rec)
+
+	pbs := stats["personalBests"].([]interface{})
+	require.Len(t, pbs, 1)
+	pb := pbs[0].(map[string]interface{})
+	assert.Equal(t, pbLap, pb["lapRecordId"])
+	assert.Equal(t, "1:42.250", pb["lapTime"])
+	assert.Equal(t, float64(6), pb["lapCount"])
+	trend := pb["trend"].([]interface{})
+	require.Len(t, trend, 3)
+	assert.Equal(t, float64(104000), trend[1].(map[string]interface{})["personalBestMs"])
+	assert.Equal(t, float64(102250), trend[2].(map[string]interface{})["personalBestMs"])
+
+	sessions := stats["sessions"].([]interface{})
+	require.Len(t, sessions, 3)
+	latest := sessions[0].(map[string]interface{})
+	assert.Equal(t, "2024-07-01", latest["date"])
+	assert.Equal(t, float64(2250), latest["improvementMs"])
+	assert.Equal(t, true, latest["setPersonalBest"])
+	assert.Equal(t, float64(102750), latest["averageMs"])
+	assert.InDelta(t, 707.1, latest["stdDevMs"], 0.1)
+
+	middle := sessions[1].(map[string]interface{})
+	assert.Equal(t, float64(-500), middle["improvementMs"])
+	assert.Equal(t, false, middle["setPersonalBest"])
+	assert.Nil(t, middle["stdDevMs"])
+
+	first := sessions[2].(map[string]interface{})
+	assert.Nil(t, first["improvementMs"])
+	assert.Equal(t, float64(3), first["lapCount"])
+	assert.Equal(t, float64(105000), first["averageMs"])
+	assert.Equal(t, float64(1000), first["stdDevMs"])
+
+	// Filters narrow the laps considered
+	rec = app.doRequest(http.MethodGet, "/api/lapbook/stats?conditions=WET", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, parseJSON(t, rec)["personalBests"])
+
+	rec = app.doRequest(http.MethodGet, "/api/lapbook/stats?conditions=SNOW", "", token)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
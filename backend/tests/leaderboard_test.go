@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// leaderboardNames returns the driver names of a leaderboard response in order.
+func leaderboardNames(t *testing.T, rec *httptest.ResponseRecorder) []string {
+	t.Helper()
+	names := []string{}
+	for _, e := range parseJSON(t, rec)["entries"].([]interface{}) {
+		driver := e.(map[string]interface{})["driver"].(map[string]interface{})
+		names = append(names, driver["name"].(string))
+	}
+	return names
+}
+
+func TestLeaderboard_BestLapPerDriver(t *testing.T) {
+	app := setupTestApp(t)
+	aliceID, alice := app.createTestUser(t, "Alice", uniqueEmail("lb"), "password123")
+	bobID, bob := app.createTestUser(t, "Bob", uniqueEmail("lb"), "password123")
+	trackID := app.createTestTrack(t, alice)
+
+	aliceCar := app.createTestCar(t, "Mazda", "MX-5", 2019, aliceID)
+	bobCar := app.createTestCar(t, "BMW", "M3", 2020, bobID)
+	rec := app.doRequest(http.MethodPost, "/api/cars/"+bobCar+"/mods", `{"name":"Coilovers","category":"SUSPENSION"}`, bob)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	app.logLap(t, alice, trackID, aliceCar, "1:45.000", "DRY", "")
+	best := app.logLap(t, alice, trackID, aliceCar, "1:42.100", "DRY", "")
+	app.logLap(t, bob, trackID, bobCar, "1:40.600", "DRY", "")
+	app.logLap(t, bob, trackID, bobCar, "1:50.000", "WET", "")
+
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+trackID+"/leaderboard", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	result := parseJSON(t, rec)
+	assert.Equal(t, float64(2), result["total"])
+	entries := result["entries"].([]interface{})
+	require.Len(t, entries, 2)
+
+	leader := entries[0].(map[string]interface{})
+	assert.Equal(t, float64(1), leader["rank"])
+	assert.Equal(t, "Bob", leader["driver"].(map[string]interface{})["name"])
+	assert.Equal(t, float64(0), leader["gapMs"])
+	car := leader["car"].(map[string]interface{})
+	assert.Equal(t, "BMW", car["make"])
+	mods := car["mods"].([]interface{})
+	require.Len(t, mods, 1)
+	assert.Equal(t, "Coilovers", mods[0].(map[string]interface{})["name"])
+
+	second := entries[1].(map[string]interface{})
+	assert.Equal(t, float64(2), second["rank"])
+	assert.Equal(t, best, second["lapRecordId"])
+	assert.Equal(t, "1:42.100", second["lapTime"])
+	assert.Equal(t, float64(1500), second["gapMs"])
+	assert.Equal(t, "+1.500", second["gap"])
+	assert.Empty(t, second["car"].(map[string]interface{})["mods"])
+}
+
+func TestLeaderboard_Filters(t *testing.T) {
+	app := setupTestApp(t)
+	aliceID, alice := app.createTestUser(t, "Alice", uniqueEmail("lb"), "password123")
+	bobID, bob := app.createTestUser(t, "Bob", uniqueEmail("lb"), "password123")
+	trackID := app.createPendingTrack(t, alice, `{"name":"Dual Track","location":"Somewhere, CA","eventTypes":["ROADCOURSE","AUTOCROSS"]}`)
+	app.approveTrack(t, trackID)
+
+	events, err := app.trackRepo.GetEvents(trackID)
+	require.NoError(t, err)
+	eventIDs := map[string]string{}
+	for _, e := range events {
+		eventIDs[e.EventType] = e.ID
+	}
+
+	aliceCar := app.createTestCar(t, "Mazda", "MX-5", 2019, aliceID)
+	bobCar := app.createTestCar(t, "BMW", "M3", 2020, bobID)
+	app.logLap(t, alice, trackID, aliceCar, "58.000", "WET", eventIDs[string(models.EventAutocross)])
+	app.logLap(t, bob, trackID, bobCar, "1:40.000", "DRY", eventIDs[string(models.EventRoadcourse)])
+
+	cases := map[string][]string{
+		"?eventType=AUTOCROSS":                               {"Alice"},
+		"?eventType=ROADCOURSE":                              {"Bob"},
+		"?eventType=AUTOCROSS&conditions=WET":                {"Alice"},
+		"?eventType=ROADCOURSE&conditions=WET":               {},
+		"?eventType=ROADCOURSE&carMake=bmw&carModel=m3":      {"Bob"},
+		"?eventType=ROADCOURSE&carMake=Porsche":              {},
+		"?eventType=AUTOCROSS&from=2000-01-01&to=2999-12-31": {"Alice"},
+		"?eventType=ROADCOURSE&to=2000-01-01":                {},
+	}
+	for query, want := range cases {
+		rec := app.doRequest(http.MethodGet, "/api/tracks/"+trackID+"/leaderboard"+query, "", "")
+		require.Equal(t, http.StatusOK, rec.Code, query)
+		assert.ElementsMatch(t, want, leaderboardNames(t, rec), query)
+	}
+
+	// Times from different event types aren't ranked together
+	rec := app.doRequest(http.MethodGet, "/api/tracks/"+trackID+"/leaderboard?conditions=WET", "", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestLeaderboard_OptOut(t *testing.T) {
+	app := setupTestApp(t)
+	aliceID, alice := app.createTestUser(t, "Alice", uniqueEmail("lb"), "password123")
+	bobID, bob := app.createTestUser(t, "Bob", uniqueEmail("lb"), "password123")
+	trackID := app.createTestTrack(t, alice)
+	app.logLap(t, alice, trackID, app.createTestCar(t, "Mazda", "MX-5", 2019, aliceID), "1:40.000", "DRY", "")
+	app.logLap(t, bob, trackID, app.createTestCar(t, "BMW", "M3", 2020, bobID), "1:41.000", "DRY", "")
+
+	rec := app.doRequest(http.MethodPut, "/api/profile", `{"name":"Alice","experience":"BEGINNER","leaderboardOptOut":true}`, alice)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, true, parseJSON(t, rec)["leaderboardOptOut"])
+
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+trackID+"/leaderboard", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"Bob"}, leaderboardNames(t, rec))
+
+	// Updating the profile without the field leaves the opt-out alone
+	rec = app.doRequest(http.MethodPut, "/api/profile", `{"name":"Alice B","experience":"BEGINNER"}`, alice)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/profile", "", alice)
+	assert.Equal(t, true, parseJSON(t, rec)["leaderboardOptOut"])
+}
+
+func TestLeaderboard_Validation(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUser(t, "User", uniqueEmail("lb"), "password123")
+	trackID := app.createTestTrack(t, token)
+
+	for _, query := range []string{
+		"?eventType=RALLY",
+		"?conditions=SNOW",
+		"?from=yesterday",
+		"?from=2024-05-02&to=2024-05-01",
+		"?limit=0",
+	} {
+		rec := app.doRequest(http.MethodGet, "/api/tracks/"+trackID+"/leaderboard"+query, "", "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+
+	rec := app.doRequest(http.MethodGet, "/api/tracks/nonexistent/leaderboard", "", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	pending := app.createPendingTrack(t, token, `{"name":"Pending","location":"Nowhere","eventTypes":["DRAG"]}`)
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+pending+"/leaderboard", "", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+pending+"/leaderboard", "", token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestLeaderboard_EditedFlag(t *testing.T) {
+	app := setupTestApp(t)
+	aliceID, alice := app.createTestUser(t, "Alice", uniqueEmail("lb"), "password123")
+	trackID := app.createTestTrack(t, alice)
+	carID := app.createTestCar(t, "Mazda", "MX-5", 2019, aliceID)
+	lapID := app.logLap(t, alice, trackID, carID, "1:45.000", "DRY", "")
+
+	entry := func() map[string]interface{} {
+		rec := app.doRequest(http.MethodGet, "/api/tracks/"+trackID+"/leaderboard", "", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		entries := parseJSON(t, rec)["entries"].([]interface{})
+		require.Len(t, entries, 1)
+		return entries[0].(map[string]interface{})
+	}
+	assert.Equal(t, false, entry()["edited"])
+
+	rec := app.doRequest(http.MethodPatch, "/api/lapbook/"+lapID, `{"lapTime":"1:39.000"}`, alice)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	e := entry()
+	assert.Equal(t, "1:39.000", e["lapTime"])
+	assert.Equal(t, true, e["edited"])
+}
@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joezmuda/trackside-backend/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openLegacyDB creates a database from the pre-versioning schema fixture,
+// mimicking a production database created before schema_migrations existed.
+func openLegacyDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.Connect(filepath.Join(t.TempDir(), "legacy.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	fixture, err := os.ReadFile(filepath.Join("testdata", "legacy_schema.sql"))
+	require.NoError(t, err)
+	_, err = db.Exec(string(fixture))
+	require.NoError(t, err)
+	return db
+}
+
+func latestVersion(t *testing.T) int {
+	t.Helper()
+	migrations, err := database.LoadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	return migrations[len(migrations)-1].Version
+}
+
+func TestMigrations_LegacyDatabaseToHead(t *testing.T) {
+	db := openLegacyDB(t)
+
+	require.NoError(t, database.Migrate(db))
+
+	statuses, err := database.GetMigrationStatus(db)
+	require.NoError(t, err)
+	for _, s := range statuses {
+		assert.True(t, s.Applied, "migration %d not applied", s.Version)
+	}
+
+	var version int
+	require.NoError(t, db.QueryRow(`SELECT MAX(version) FROM "schema_migrations"`).Scan(&version))
+	assert.Equal(t, latestVersion(t), version)
+
+	// Existing rows survive the upgrade
+	var lapTime string
+	require.NoError(t, db.QueryRow(`SELECT lapTime FROM "LapRecord" WHERE id = 'legacy-lap'`).Scan(&lapTime))
+	assert.Equal(t, "1:38.412", lapTime)
+}
+
+func TestMigrations_BackfillsLapTimeMs(t *testing.T) {
+	db := openLegacyDB(t)
+	require.NoError(t, database.MigrateTo(db, 5))
+
+	_, err := db.Exec(`INSERT INTO "LapRecord" (id, lapTime, conditions, trackId, carId, driverId, createdAt, updatedAt) VALUES
+		('short-lap', '92.4', 'DRY', 'legacy-track', 'legacy-car', 'legacy-user', '2024-05-02 12:00:00', '2024-05-02 12:00:00'),
+		('bad-lap', 'fast', 'DRY', 'legacy-track', 'legacy-car', 'legacy-user', '2024-05-02 12:00:00', '2024-05-02 12:00:00')`)
+	require.NoError(t, err)
+
+	require.NoError(t, database.Migrate(db))
+
+	texts := map[string]string{}
+	millis := map[string]sql.NullInt64{}
+	rows, err := db.Query(`SELECT id, lapTime, lapTimeMs FROM "LapRecord"`)
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var id, text string
+		var ms sql.NullInt64
+		require.NoError(t, rows.Scan(&id, &text, &ms))
+		texts[id], millis[id] = text, ms
+	}
+
+	assert.Equal(t, "1:38.412", texts["legacy-lap"])
+	assert.Equal(t, int64(98412), millis["legacy-lap"].Int64)
+	assert.Equal(t, "1:32.400", texts["short-lap"])
+	assert.Equal(t, int64(92400), millis["short-lap"].Int64)
+
+	// Unparseable times are left alone rather than failing the migration
+	assert.Equal(t, "fast", texts["bad-lap"])
+	assert.False(t, millis["bad-lap"].Valid)
+}
+
+func TestMigrations_OnlyPromotesTheSystemUser(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		passwordHash interface{}
+		role         string
+	}{
+		{"system user", nil, "ADMIN"},
+		{"registered with the address", "hash", "USER"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := openLegacyDB(t)
+			require.NoError(t, database.MigrateTo(db, 1))
+			_, err := db.Exec(`INSERT INTO "User" (id, name, email, passwordHash, experience, createdAt, updatedAt)
+				VALUES ('system', 'Trackside System', 'system@trackside.local', ?, 'BEGINNER', '2024-05-01 12:00:00', '2024-05-01 12:00:00')`, tc.passwordHash)
+			require.NoError(t, err)
+
+			require.NoError(t, database.Migrate(db))
+			var role string
+			require.NoError(t, db.QueryRow(`SELECT role FROM "User" WHERE id = 'system'`).Scan(&role))
+			assert.Equal(t, tc.role, role)
+		})
+	}
+}
+
+func TestMigrations_Idempotent(t *testing.T) {
+	db := openLegacyDB(t)
+
+	require.NoError(t, database.Migrate(db))
+	require.NoError(t, database.Migrate(db))
+
+	var count int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM "schema_migrations"`).Scan(&count))
+	assert.Equal(t, latestVersion(t), count)
+}
+
+func TestMigrations_UpToVersion(t *testing.T) {
+	db := openLegacyDB(t)
+
+	require.NoError(t, database.MigrateTo(db, 1))
+	statuses, err := database.GetMigrationStatus(db)
+	require.NoError(t, err)
+	for _, s := range statuses {
+		assert.Equal(t, s.Version <= 1, s.Applied, "migration %d", s.Version)
+	}
+
+	require.NoError(t, database.Migrate(db))
+	assert.Error(t, database.MigrateTo(db, 9999), "unknown version")
+}
+
+func TestMigrations_ForwardOnly(t *testing.T) {
+	db := openLegacyDB(t)
+	require.NoError(t, database.Migrate(db))
+
+	if latestVersion(t) > 1 {
+		assert.Error(t, database.MigrateTo(db, 1))
+	}
+}
+
+func TestMigrations_ChecksumMismatch(t *testing.T) {
+	db := openLegacyDB(t)
+	require.NoError(t, database.Migrate(db))
+
+	_, err := db.Exec(`UPDATE "schema_migrations" SET checksum = 'tampered' WHERE version = 1`)
+	require.NoError(t, err)
+
+	assert.Error(t, database.Migrate(db))
+	_, err = database.GetMigrationStatus(db)
+	assert.Error(t, err)
+}
@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joezmuda/trackside-backend/internal/config"
+	"github.com/rs/xid"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	mockOIDCClientID     = "trackside"
+	mockOIDCClientSecret = "mock-client-secret"
+)
+
+// mockOIDCUser is who signs in at the mock provider.
+type mockOIDCUser struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// mockOIDC is a local OpenID Connect provider. Its authorization endpoint
+// signs in whoever the test says without asking, and its token endpoint
+// checks the client's credentials, redirect URI and PKCE verifier like a
+// real provider would.
+type mockOIDC struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	user  mockOIDCUser
+	codes map[string]mockOIDCGrant
+	// nonce, when set, replaces the nonce in ID tokens.
+	nonce string
+}
+
+type mockOIDCGrant struct {
+	user        mockOIDCUser
+	redirectURI string
+	challenge   string
+	nonce       string
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	m := &mockOIDC{key: key, codes: map[string]mockOIDCGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("GET /authorize", m.authorizeEndpoint)
+	mux.HandleFunc("POST /token", m.tokenEndpoint)
+	mux.HandleFunc("GET /jwks", m.jwks)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// provider returns the config for signing in with the mock provider.
+func (m *mockOIDC) provider(id, name string) config.OIDCProvider {
+	return config.OIDCProvider{
+		ID:           id,
+		Name:         name,
+		Issuer:       m.server.URL,
+		ClientID:     mockOIDCClientID,
+		ClientSecret: mockOIDCClientSecret,
+		RedirectURL:  "http://localhost:3000/auth/callback/" + id,
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+}
+
+// replayNonce makes ID tokens carry nonce instead of the one the client
+// asked for, or the right one again if nonce is empty.
+func (m *mockOIDC) replayNonce(nonce string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nonce = nonce
+}
+
+// authorize follows an authorization URL as user, and returns the code and
+// state the provider sends them back to Trackside with.
+func (m *mockOIDC) authorize(t *testing.T, authURL string, user mockOIDCUser) (string, string) {
+	t.Helper()
+	m.mu.Lock()
+	m.user = user
+	m.mu.Unlock()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func (m *mockOIDC) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                m.server.URL,
+		"authorization_endpoint":                m.server.URL + "/authorize",
+		"token_endpoint":                        m.server.URL + "/token",
+		"jwks_uri":                              m.server.URL + "/jwks",
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic"},
+	})
+}
+
+func (m *mockOIDC) authorizeEndpoint(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != mockOIDCClientID ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" ||
+		!strings.Contains(q.Get("scope"), "openid") {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	code := xid.New().String()
+	m.mu.Lock()
+	m.codes[code] = mockOIDCGrant{
+		user:        m.user,
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+	}
+	m.mu.Unlock()
+
+	redirect := q.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+func (m *mockOIDC) tokenEndpoint(w http.ResponseWriter, r *http.Request) {
+	invalid := func(e string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": e})
+	}
+	if id, secret, ok := r.BasicAuth(); !ok || id != mockOIDCClientID || secret != mockOIDCClientSecret {
+		invalid("invalid_client")
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		invalid("invalid_request")
+		return
+	}
+
+	m.mu.Lock()
+	grant, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	nonce := grant.nonce
+	if m.nonce != "" {
+		nonce = m.nonce
+	}
+	m.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || grant.redirectURI != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		invalid("invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            m.server.URL,
+		"aud":            mockOIDCClientID,
+		"sub":            grant.user.Subject,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          nonce,
+		"email":          grant.user.Email,
+		"email_verified": grant.user.EmailVerified,
+		"name":           grant.user.Name,
+	})
+	idToken.Header["kid"] = "mock-key"
+	signed, err := idToken.SignedString(m.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "mock-access-" + xid.New().String(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"scope":        "openid email profile",
+		"id_token":     signed,
+	})
+}
+
+func (m *mockOIDC) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "mock-key",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}},
+	})
+}
@@ -0,0 +1,241 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const pendingTrackBody = `{"name":"Pending Park","location":"Nowhere, NV","eventTypes":["AUTOCROSS"]}`
+
+func TestModeration_NewTrackIsPending(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUser(t, "User", uniqueEmail("mod"), "password123")
+
+	rec := app.doRequest(http.MethodPost, "/api/tracks", pendingTrackBody, token)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "PENDING", parseJSON(t, rec)["status"])
+
+	// Not in the public list
+	rec = app.doRequest(http.MethodGet, "/api/tracks", "", "")
+	assert.Empty(t, parseTrackList(t, rec))
+}
+
+func TestModeration_ModeratorTrackIsApproved(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUserWithRole(t, "Mod", uniqueEmail("mod"), "MODERATOR")
+
+	rec := app.doRequest(http.MethodPost, "/api/tracks", pendingTrackBody, token)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "APPROVED", parseJSON(t, rec)["status"])
+}
+
+func TestModeration_PendingVisibleOnlyToUploader(t *testing.T) {
+	app := setupTestApp(t)
+	_, ownerToken := app.createTestUser(t, "Owner", uniqueEmail("owner"), "password123")
+	_, otherToken := app.createTestUser(t, "Other", uniqueEmail("other"), "password123")
+	_, modToken := app.createTestUserWithRole(t, "Mod", uniqueEmail("mod"), "MODERATOR")
+	trackID := app.createPendingTrack(t, ownerToken, pendingTrackBody)
+
+	rec := app.doRequest(http.MethodGet, "/api/tracks/"+trackID, "", ownerToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+trackID, "", modToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+trackID, "", otherToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+trackID, "", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Others can't attach data to it either
+	rec = app.doRequest(http.MethodPost, "/api/tracks/"+trackID+"/reviews", `{"rating":5,"conditions":"DRY"}`, otherToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+trackID+"/images", "", otherToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestModeration_QueueRequiresModerator(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUser(t, "User", uniqueEmail("user"), "password123")
+
+	rec := app.doRequest(http.MethodGet, "/api/moderation/tracks", "", token)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestModeration_ApproveFlow(t *testing.T) {
+	app := setupTestApp(t)
+	_, ownerToken := app.createTestUser(t, "Owner", uniqueEmail("owner"), "password123")
+	_, modToken := app.createTestUserWithRole(t, "Mod", uniqueEmail("mod"), "MODERATOR")
+	trackID := app.createPendingTrack(t, ownerToken, pendingTrackBody)
+
+	rec := app.doRequest(http.MethodGet, "/api/moderation/tracks", "", modToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	queue := parseJSONArray(t, rec)
+	require.Len(t, queue, 1)
+	assert.Equal(t, trackID, queue[0]["id"])
+
+	rec = app.doRequest(http.MethodPost, "/api/moderation/tracks/"+trackID+"/approve", `{"reason":"Verified with the venue"}`, modToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "APPROVED", parseJSON(t, rec)["status"])
+
+	// Approving twice is a conflict
+	rec = app.doRequest(http.MethodPost, "/api/moderation/tracks/"+trackID+"/approve", `{}`, modToken)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	// Now public, and gone from the queue
+	rec = app.doRequest(http.MethodGet, "/api/tracks", "", "")
+	assert.Len(t, parseTrackList(t, rec), 1)
+	rec = app.doRequest(http.MethodGet, "/api/moderation/tracks", "", modToken)
+	assert.Empty(t, parseJSONArray(t, rec))
+}
+
+func TestModeration_QueuePagination(t *testing.T) {
+	app := setupTestApp(t)
+	_, ownerToken := app.createTestUser(t, "Owner", uniqueEmail("owner"), "password123")
+	_, modToken := app.createTestUserWithRole(t, "Mod", uniqueEmail("mod"), "MODERATOR")
+	first := app.createPendingTrack(t, ownerToken, `{"name":"First","location":"A, CA","eventTypes":["AUTOCROSS"]}`)
+	second := app.createPendingTrack(t, ownerToken, `{"name":"Second","location":"B, CA","eventTypes":["AUTOCROSS"]}`)
+	third := app.createPendingTrack(t, ownerToken, `{"name":"Third","location":"C, CA","eventTypes":["AUTOCROSS"]}`)
+
+	rec := app.doRequest(http.MethodGet, "/api/moderation/tracks?limit=2", "", modToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	page := parseJSONArray(t, rec)
+	require.Len(t, page, 2)
+	assert.Equal(t, first, page[0]["id"])
+	assert.Equal(t, second, page[1]["id"])
+
+	rec = app.doRequest(http.MethodGet, "/api/moderation/tracks?limit=2&offset=2", "", modToken)
+	page = parseJSONArray(t, rec)
+	require.Len(t, page, 1)
+	assert.Equal(t, third, page[0]["id"])
+
+	for _, q := range []string{"limit=0", "limit=101", "limit=x", "offset=-1"} {
+		rec = app.doRequest(http.MethodGet, "/api/moderation/tracks?"+q, "", modToken)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, q)
+	}
+
+	// Each track shows the reason for its latest decision
+	rec = app.doRequest(http.MethodPost, "/api/moderation/tracks/"+first+"/reject", `{"reason":"Wrong location"}`, modToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodPatch, "/api/tracks/"+first, `{"location":"Right, CA"}`, ownerToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodPost, "/api/moderation/tracks/"+first+"/reject", `{"reason":"Still a duplicate"}`, modToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodPost, "/api/moderation/tracks/"+second+"/reject", `{"reason":"Not a venue"}`, modToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = app.doRequest(http.MethodGet, "/api/moderation/tracks?status=REJECTED", "", modToken)
+	rejected := parseJSONArray(t, rec)
+	require.Len(t, rejected, 2)
+	assert.Equal(t, "Still a duplicate", rejected[0]["statusReason"])
+	assert.Equal(t, "Not a venue", rejected[1]["statusReason"])
+}
+
+func TestModeration_RejectFlow(t *testing.T) {
+	app := setupTestApp(t)
+	_, ownerToken := app.createTestUser(t, "Owner", uniqueEmail("owner"), "password123")
+	_, modToken := app.createTestUserWithRole(t, "Mod", uniqueEmail("mod"), "MODERATOR")
+	trackID := app.createPendingTrack(t, ownerToken, pendingTrackBody)
+
+	// Reason is required
+	rec := app.doRequest(http.MethodPost, "/api/moderation/tracks/"+trackID+"/reject", `{"reason":"  "}`, modToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = app.doRequest(http.MethodPost, "/api/moderation/tracks/"+trackID+"/reject", `{"reason":"Duplicate of an existing track"}`, modToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "REJECTED", parseJSON(t, rec)["status"])
+
+	// The submitter sees the outcome and reason
+	rec = app.doRequest(http.MethodGet, "/api/tracks/mine", "", ownerToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	mine := parseJSONArray(t, rec)
+	require.Len(t, mine, 1)
+	assert.Equal(t, "REJECTED", mine[0]["status"])
+	assert.Equal(t, "Duplicate of an existing track", mine[0]["statusReason"])
+
+	// Editing a rejected track resubmits it
+	rec = app.doRequest(http.MethodPatch, "/api/tracks/"+trackID, `{"description":"Fixed"}`, ownerToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "PENDING", parseJSON(t, rec)["status"])
+
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+trackID+"/status-history", "", ownerToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	history := parseJSONArray(t, rec)
+	require.Len(t, history, 2)
+	assert.Equal(t, "PENDING", history[0]["fromStatus"])
+	assert.Equal(t, "REJECTED", history[0]["toStatus"])
+	assert.Equal(t, "REJECTED", history[1]["fromStatus"])
+	assert.Equal(t, "PENDING", history[1]["toStatus"])
+}
+
+func TestModeration_EditApprovedTrackNeedsReview(t *testing.T) {
+	app := setupTestApp(t)
+	_, ownerToken := app.createTestUser(t, "Owner", uniqueEmail("owner"), "password123")
+	_, modToken := app.createTestUserWithRole(t, "Mod", uniqueEmail("mod"), "MODERATOR")
+	trackID := app.createTestTrack(t, ownerToken)
+
+	rec := app.doRequest(http.MethodPatch, "/api/tracks/"+trackID, `{"name":"Totally Legit Raceway"}`, ownerToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "PENDING", parseJSON(t, rec)["status"])
+
+	// Hidden from the public until a moderator approves the edit
+	rec = app.doRequest(http.MethodGet, "/api/tracks", "", "")
+	assert.Empty(t, parseTrackList(t, rec))
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+trackID, "", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+trackID+"/status-history", "", ownerToken)
+	history := parseJSONArray(t, rec)
+	require.Len(t, history, 1)
+	assert.Equal(t, "APPROVED", history[0]["fromStatus"])
+	assert.Equal(t, "PENDING", history[0]["toStatus"])
+	assert.Equal(t, "Edited after approval", history[0]["reason"])
+
+	rec = app.doRequest(http.MethodPost, "/api/moderation/tracks/"+trackID+"/approve", `{}`, modToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/tracks", "", "")
+	tracks := parseTrackList(t, rec)
+	require.Len(t, tracks, 1)
+	assert.Equal(t, "Totally Legit Raceway", tracks[0]["name"])
+}
+
+func TestModeration_FailedEditKeepsApproval(t *testing.T) {
+	app := setupTestApp(t)
+	_, ownerToken := app.createTestUser(t, "Owner", uniqueEmail("owner"), "password123")
+	trackID := app.createTestTrack(t, ownerToken)
+
+	// Make the field update fail after the status change has been written
+	_, err := app.db.Exec(`CREATE TRIGGER fail_track_edit BEFORE UPDATE OF name ON "Track"
+		WHEN new.name = 'Broken Raceway' BEGIN SELECT RAISE(ABORT, 'edit failed'); END`)
+	require.NoError(t, err)
+
+	rec := app.doRequest(http.MethodPatch, "/api/tracks/"+trackID, `{"name":"Broken Raceway"}`, ownerToken)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+trackID, "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "APPROVED", parseJSON(t, rec)["status"])
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+trackID+"/status-history", "", ownerToken)
+	assert.Empty(t, parseJSONArray(t, rec))
+}
+
+func TestModeration_ModeratorEditStaysApproved(t *testing.T) {
+	app := setupTestApp(t)
+	_, modToken := app.createTestUserWithRole(t, "Mod", uniqueEmail("mod"), "MODERATOR")
+	trackID := app.createTestTrack(t, modToken)
+
+	rec := app.doRequest(http.MethodPatch, "/api/tracks/"+trackID, `{"description":"Updated"}`, modToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "APPROVED", parseJSON(t, rec)["status"])
+}
+
+func TestModeration_StatusHistoryForbiddenToOthers(t *testing.T) {
+	app := setupTestApp(t)
+	_, ownerToken := app.createTestUser(t, "Owner", uniqueEmail("owner"), "password123")
+	_, otherToken := app.createTestUser(t, "Other", uniqueEmail("other"), "password123")
+	trackID := app.createTestTrack(t, ownerToken)
+
+	rec := app.doRequest(http.MethodGet, "/api/tracks/"+trackID+"/status-history", "", otherToken)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
@@ -0,0 +1,253 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/joezmuda/trackside-backend/internal/config"
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupOIDCApp returns a test app that can sign in with a mock provider
+// called "mock".
+func setupOIDCApp(t *testing.T) (*testApp, *mockOIDC) {
+	t.Helper()
+	mock := newMockOIDC(t)
+	app := setupTestApp(t, func(cfg *config.Config) {
+		cfg.OIDCProviders = []config.OIDCProvider{mock.provider("mock", "Mock ID")}
+	})
+	return app, mock
+}
+
+// oidcStart starts signing in (or linking, with a token) at path and
+// returns the provider's authorization URL.
+func (app *testApp) oidcStart(t *testing.T, path, token string) string {
+	t.Helper()
+	rec := app.doRequest(http.MethodPost, path, "", token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	body := parseJSON(t, rec)
+	authURL, err := url.Parse(body["url"].(string))
+	require.NoError(t, err)
+	require.Equal(t, body["state"], authURL.Query().Get("state"))
+	return body["url"].(string)
+}
+
+func (app *testApp) oidcCallback(provider, code, state, token string) *httptest.ResponseRecorder {
+	return app.doRequest(http.MethodPost, "/api/auth/oidc/"+provider+"/callback",
+		`{"code":"`+code+`","state":"`+state+`"}`, token)
+}
+
+// oidcLogin signs in with the mock provider as user.
+func (app *testApp) oidcLogin(t *testing.T, mock *mockOIDC, user mockOIDCUser) *httptest.ResponseRecorder {
+	t.Helper()
+	code, state := mock.authorize(t, app.oidcStart(t, "/api/auth/oidc/mock/start", ""), user)
+	return app.oidcCallback("mock", code, state, "")
+}
+
+func TestOIDCLogin_NewUser(t *testing.T) {
+	app, mock := setupOIDCApp(t)
+
+	rec := app.doRequest(http.MethodGet, "/api/auth/oidc/providers", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []map[string]interface{}{{"id": "mock", "name": "Mock ID"}}, parseJSONArray(t, rec))
+	rec = app.doRequest(http.MethodPost, "/api/auth/oidc/nope/start", "", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	authURL := app.oidcStart(t, "/api/auth/oidc/mock/start", "")
+	q, _ := url.Parse(authURL)
+	assert.Equal(t, "S256", q.Query().Get("code_challenge_method"))
+	assert.Equal(t, "http://localhost:3000/auth/callback/mock", q.Query().Get("redirect_uri"))
+
+	driver := mockOIDCUser{Subject: "driver-1", Email: uniqueEmail("oidc"), EmailVerified: true, Name: "Oidc Driver"}
+	code, state := mock.authorize(t, authURL, driver)
+	rec = app.oidcCallback("mock", code, state, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	login := parseJSON(t, rec)
+	assert.NotEmpty(t, login["refreshToken"])
+	user := login["user"].(map[string]interface{})
+	assert.Equal(t, driver.Email, user["email"])
+	assert.Equal(t, true, user["emailVerified"])
+
+	rec = app.doRequest(http.MethodGet, "/api/profile", "", login["token"].(string))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "Oidc Driver", parseJSON(t, rec)["name"])
+
+	// The state works once
+	rec = app.oidcCallback("mock", code, state, "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Signing in again finds the same user, who has no password
+	rec = app.oidcLogin(t, mock, driver)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, user["id"], parseJSON(t, rec)["user"].(map[string]interface{})["id"])
+	rec = app.doRequest(http.MethodPost, "/api/auth/login", `{"email":"`+driver.Email+`","password":"password123"}`, "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = app.doRequest(http.MethodPost, "/api/auth/forgot-password", `{"email":"`+driver.Email+`"}`, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, app.emails(t, driver.Email), 1)
+
+	var accounts int
+	require.NoError(t, app.db.QueryRow(`SELECT COUNT(*) FROM "Account" WHERE userId = ? AND access_token IS NOT NULL`, user["id"]).Scan(&accounts))
+	assert.Equal(t, 1, accounts)
+}
+
+func TestOIDCLogin_LinksByVerifiedEmail(t *testing.T) {
+	app, mock := setupOIDCApp(t)
+	email := uniqueEmail("existing")
+	userID, _ := app.createTestUser(t, "Existing", email, "password123")
+
+	rec := app.oidcLogin(t, mock, mockOIDCUser{Subject: "existing-1", Email: email, EmailVerified: true})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, userID, parseJSON(t, rec)["user"].(map[string]interface{})["id"])
+	app.login(t, email, "password123")
+
+	// An email the provider hasn't verified doesn't link or create anything
+	other := uniqueEmail("unverified")
+	app.createTestUser(t, "Other", other, "password123")
+	rec = app.oidcLogin(t, mock, mockOIDCUser{Subject: "unverified-1", Email: other, EmailVerified: false})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = app.oidcLogin(t, mock, mockOIDCUser{Subject: "unverified-2", Email: uniqueEmail("nobody"), EmailVerified: false})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	var accounts int
+	require.NoError(t, app.db.QueryRow(`SELECT COUNT(*) FROM "Account"`).Scan(&accounts))
+	assert.Equal(t, 1, accounts)
+
+	// Whoever registered an email without verifying it loses the account to
+	// the provider's verified owner
+	squatted := uniqueEmail("squatted")
+	rec = app.doRequest(http.MethodPost, "/api/register",
+		`{"name":"Squatter","email":"`+squatted+`","password":"password123","confirmPassword":"password123"}`, "")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	squatterID := parseJSON(t, rec)["id"]
+	squatter := app.login(t, squatted, "password123")
+
+	rec = app.oidcLogin(t, mock, mockOIDCUser{Subject: "owner-1", Email: squatted, EmailVerified: true})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	owner := parseJSON(t, rec)
+	assert.Equal(t, squatterID, owner["user"].(map[string]interface{})["id"])
+	assert.Equal(t, true, owner["user"].(map[string]interface{})["emailVerified"])
+	rec = app.doRequest(http.MethodGet, "/api/profile", "", squatter["token"].(string))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = app.doRequest(http.MethodPost, "/api/auth/login", `{"email":"`+squatted+`","password":"password123"}`, "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestOIDCLogin_RejectsTamperedSignIns(t *testing.T) {
+	mock, other := newMockOIDC(t), newMockOIDC(t)
+	app := setupTestApp(t, func(cfg *config.Config) {
+		cfg.OIDCProviders = []config.OIDCProvider{mock.provider("mock", "Mock ID"), other.provider("other", "Other ID")}
+	})
+	driver := mockOIDCUser{Subject: "driver-1", Email: uniqueEmail("tamper"), EmailVerified: true}
+
+	// A state is only good for the provider it was issued for
+	code, state := mock.authorize(t, app.oidcStart(t, "/api/auth/oidc/mock/start", ""), driver)
+	rec := app.oidcCallback("other", code, state, "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// A code can only be redeemed with the verifier of the sign-in that
+	// asked for it
+	code, _ = mock.authorize(t, app.oidcStart(t, "/api/auth/oidc/mock/start", ""), driver)
+	_, state = mock.authorize(t, app.oidcStart(t, "/api/auth/oidc/mock/start", ""), driver)
+	rec = app.oidcCallback("mock", code, state, "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// An ID token for another sign-in is refused
+	mock.replayNonce("replayed")
+	rec = app.oidcLogin(t, mock, driver)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	mock.replayNonce("")
+
+	// Sign-ins expire
+	code, state = mock.authorize(t, app.oidcStart(t, "/api/auth/oidc/mock/start", ""), driver)
+	_, err := app.db.Exec(`UPDATE "OAuthState" SET expires = datetime('now', '-1 minute')`)
+	require.NoError(t, err)
+	rec = app.oidcCallback("mock", code, state, "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = app.oidcLogin(t, mock, driver)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+}
+
+func TestLinkedAccounts(t *testing.T) {
+	app, mock := setupOIDCApp(t)
+	userID, token := app.createTestUser(t, "Linker", uniqueEmail("linker"), "password123")
+	_, otherToken := app.createTestUser(t, "Other", uniqueEmail("other"), "password123")
+	// The provider account's email doesn't have to match when linking
+	account := mockOIDCUser{Subject: "linked-1", Email: uniqueEmail("elsewhere"), EmailVerified: false}
+
+	// Linking has to be finished by the user who started it
+	rec := app.doRequest(http.MethodPost, "/api/profile/accounts/mock", "", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	code, state := mock.authorize(t, app.oidcStart(t, "/api/profile/accounts/mock", token), account)
+	rec = app.oidcCallback("mock", code, state, "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	code, state = mock.authorize(t, app.oidcStart(t, "/api/profile/accounts/mock", token), account)
+	rec = app.oidcCallback("mock", code, state, otherToken)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	code, state = mock.authorize(t, app.oidcStart(t, "/api/profile/accounts/mock", token), account)
+	rec = app.oidcCallback("mock", code, state, token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = app.doRequest(http.MethodGet, "/api/profile/accounts", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []map[string]interface{}{{"provider": "mock", "name": "Mock ID", "providerAccountId": "linked-1"}}, parseJSONArray(t, rec))
+
+	rec = app.oidcLogin(t, mock, account)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, userID, parseJSON(t, rec)["user"].(map[string]interface{})["id"])
+
+	// A provider account belongs to one user, who has one per provider
+	code, state = mock.authorize(t, app.oidcStart(t, "/api/profile/accounts/mock", otherToken), account)
+	rec = app.oidcCallback("mock", code, state, otherToken)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	code, state = mock.authorize(t, app.oidcStart(t, "/api/profile/accounts/mock", token), mockOIDCUser{Subject: "linked-2"})
+	rec = app.oidcCallback("mock", code, state, token)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = app.doRequest(http.MethodDelete, "/api/profile/accounts/mock", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/profile/accounts", "", token)
+	assert.Empty(t, parseJSONArray(t, rec))
+	rec = app.doRequest(http.MethodDelete, "/api/profile/accounts/mock", "", token)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Users without a password can't unlink their only way in
+	rec = app.oidcLogin(t, mock, mockOIDCUser{Subject: "only-1", Email: uniqueEmail("only"), EmailVerified: true})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = app.doRequest(http.MethodDelete, "/api/profile/accounts/mock", "", parseJSON(t, rec)["token"].(string))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestSystemEmailIsReserved(t *testing.T) {
+	app, mock := setupOIDCApp(t)
+
+	rec := app.doRequest(http.MethodPost, "/api/register",
+		`{"name":"Sneaky","email":"System@Trackside.local","password":"password123","confirmPassword":"password123"}`, "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// A provider vouching for the address doesn't get the system account
+	system, err := app.userRepo.FindOrCreateSystem()
+	require.NoError(t, err)
+	rec = app.oidcLogin(t, mock, mockOIDCUser{Subject: "system-1", Email: models.SystemEmail, EmailVerified: true})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	var accounts int
+	require.NoError(t, app.db.QueryRow(`SELECT COUNT(*) FROM "Account" WHERE userId = ?`, system.ID).Scan(&accounts))
+	assert.Equal(t, 0, accounts)
+	found, err := app.userRepo.FindSystem()
+	require.NoError(t, err)
+	assert.Equal(t, system.ID, found.ID)
+
+	// An account registered with the address before it was reserved isn't
+	// taken for the system user
+	other := setupTestApp(t)
+	_, err = other.db.Exec(`INSERT INTO "User" (id, name, email, passwordHash, experience, createdAt, updatedAt)
+		VALUES ('squatter', 'Squatter', ?, 'hash', 'BEGINNER', datetime('now'), datetime('now'))`, models.SystemEmail)
+	require.NoError(t, err)
+	found, err = other.userRepo.FindSystem()
+	require.NoError(t, err)
+	assert.Nil(t, found)
+}
@@ -0,0 +1,169 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// defineSectors sets a track's sectors through the API and returns their IDs in order.
+func (app *testApp) defineSectors(t *testing.T, token, trackID, body string) []string {
+	t.Helper()
+	rec := app.doRequest(http.MethodPut, "/api/tracks/"+trackID+"/sectors", body, token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var ids []string
+	for _, s := range parseJSONArray(t, rec) {
+		ids = append(ids, s["id"].(string))
+	}
+	return ids
+}
+
+func TestSectors_Define(t *testing.T) {
+	app := setupTestApp(t)
+	_, owner := app.createTestUser(t, "Owner", uniqueEmail("sec"), "password123")
+	_, other := app.createTestUser(t, "Other", uniqueEmail("sec"), "password123")
+	trackID := app.createTestTrack(t, owner)
+	otherTrackID := app.createPendingTrack(t, owner, `{"name":"Other Track","location":"Elsewhere, CA","eventTypes":["ROADCOURSE"]}`)
+
+	rec := app.doRequest(http.MethodPost, "/api/tracks/"+trackID+"/zones", `{"name":"Turn 1","posX":10,"posY":20}`, owner)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	zoneID := parseJSON(t, rec)["id"].(string)
+	rec = app.doRequest(http.MethodPost, "/api/tracks/"+otherTrackID+"/zones", `{"name":"Hairpin","posX":10,"posY":20}`, owner)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	foreignZoneID := parseJSON(t, rec)["id"].(string)
+
+	ids := app.defineSectors(t, owner, trackID, `{"sectors":[{"zoneId":"`+zoneID+`"},{"name":"Back straight"},{"name":"Final corners"}]}`)
+	require.Len(t, ids, 3)
+
+	rec = app.doRequest(http.MethodGet, "/api/tracks/"+trackID+"/sectors", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	sectors := parseJSONArray(t, rec)
+	require.Len(t, sectors, 3)
+	assert.Equal(t, "Turn 1", sectors[0]["name"])
+	assert.Equal(t, zoneID, sectors[0]["zoneId"])
+	assert.Equal(t, float64(1), sectors[0]["position"])
+	assert.Equal(t, "Final corners", sectors[2]["name"])
+
+	// Reordering keeps sectors by id; the one left out is removed
+	ids2 := app.defineSectors(t, owner, trackID, `{"sectors":[{"id":"`+ids[2]+`","name":"Final corners"},{"id":"`+ids[0]+`","name":"T1"}]}`)
+	assert.Equal(t, []string{ids[2], ids[0]}, ids2)
+
+	cases := []struct {
+		name   string
+		token  string
+		body   string
+		status int
+	}{
+		{"not the uploader", other, `{"sectors":[{"name":"S1"}]}`, http.StatusForbidden},
+		{"zone from another track", owner, `{"sectors":[{"zoneId":"` + foreignZoneID + `"}]}`, http.StatusBadRequest},
+		{"no name or zone", owner, `{"sectors":[{"name":"  "}]}`, http.StatusBadRequest},
+		{"unknown id", owner, `{"sectors":[{"id":"nope","name":"S1"}]}`, http.StatusBadRequest},
+		{"repeated id", owner, `{"sectors":[{"id":"` + ids[0] + `","name":"A"},{"id":"` + ids[0] + `","name":"B"}]}`, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := app.doRequest(http.MethodPut, "/api/tracks/"+trackID+"/sectors", tc.body, tc.token)
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+		})
+	}
+}
+
+func TestSectors_Splits(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("sec"), "password123")
+	carID := app.createTestCar(t, "BMW", "M3", 2020, userID)
+	trackID := app.createTestTrack(t, token)
+	ids := `"trackId":"` + trackID + `","carId":"` + carID + `"`
+
+	// No sectors yet
+	rec := app.doRequest(http.MethodPost, "/api/lapbook", `{"lapTime":"1:42.000","conditions":"DRY","splits":["30","40","32"],`+ids+`}`, token)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	sectorIDs := app.defineSectors(t, token, trackID, `{"sectors":[{"name":"S1"},{"name":"S2"},{"name":"S3"}]}`)
+
+	invalid := []string{
+		`["30","72"]`,         // wrong count
+		`["30","40","35"]`,    // adds up to 1:45
+		`["30","forty","32"]`, // unparseable
+	}
+	for _, splits := range invalid {
+		rec = app.doRequest(http.MethodPost, "/api/lapbook", `{"lapTime":"1:42.000","conditions":"DRY","splits":`+splits+`,`+ids+`}`, token)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, splits)
+	}
+
+	rec = app.doRequest(http.MethodPost, "/api/lapbook", `{"lapTime":"1:42.000","conditions":"DRY","splits":["30","40","32.005"],`+ids+`}`, token)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	lapA := parseJSON(t, rec)
+	splits := lapA["splits"].([]interface{})
+	require.Len(t, splits, 3)
+	assert.Equal(t, "30.000", splits[0].(map[string]interface{})["time"])
+	assert.Equal(t, "S2", splits[1].(map[string]interface{})["name"])
+	assert.Equal(t, sectorIDs[2], splits[2].(map[string]interface{})["sectorId"])
+
+	rec = app.doRequest(http.MethodPost, "/api/lapbook", `{"lapTime":"1:43.000","conditions":"DRY","splits":["29.5","41","32.5"],`+ids+`}`, token)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	lapB := parseJSON(t, rec)
+
+	rec = app.doRequest(http.MethodGet, "/api/lapbook/theoretical-best?trackId="+trackID, "", token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	result := parseJSON(t, rec)
+	assert.Equal(t, float64(101505), result["theoreticalBestMs"])
+	assert.Equal(t, "1:41.505", result["theoreticalBest"])
+	assert.Equal(t, lapA["id"], result["personalBest"].(map[string]interface{})["lapRecordId"])
+	assert.Equal(t, float64(495), result["gainMs"])
+	assert.Equal(t, sectorIDs[0], result["weakestSectorId"])
+	sectors := result["sectors"].([]interface{})
+	s1 := sectors[0].(map[string]interface{})
+	assert.Equal(t, float64(29500), s1["bestMs"])
+	assert.Equal(t, lapB["id"], s1["lapRecordId"])
+	assert.Equal(t, float64(30000), s1["personalBestMs"])
+	assert.Equal(t, float64(500), s1["lossMs"])
+	assert.Equal(t, float64(0), sectors[1].(map[string]interface{})["lossMs"])
+
+	// Changing splits is part of the edit history
+	rec = app.doRequest(http.MethodPatch, "/api/lapbook/"+lapB["id"].(string), `{"splits":["29.5","41.1","32.4"]}`, token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = app.doRequest(http.MethodGet, "/api/lapbook/"+lapB["id"].(string)+"/history", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	history := parseJSONArray(t, rec)
+	require.Len(t, history, 1)
+	assert.Equal(t, map[string]interface{}{
+		"from": []interface{}{"29.500", "41.000", "32.500"},
+		"to":   []interface{}{"29.500", "41.100", "32.400"},
+	}, history[0]["changes"].(map[string]interface{})["splits"])
+
+	// Removing a sector drops its splits
+	app.defineSectors(t, token, trackID, `{"sectors":[{"id":"`+sectorIDs[0]+`","name":"S1"},{"id":"`+sectorIDs[1]+`","name":"S2"}]}`)
+	rec = app.doRequest(http.MethodGet, "/api/lapbook", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	for _, lap := range parseJSONArray(t, rec) {
+		assert.Len(t, lap["splits"], 2)
+	}
+}
+
+func TestSectors_SessionTheoreticalBest(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("sec"), "password123")
+	carID := app.createTestCar(t, "BMW", "M3", 2020, userID)
+	trackID := app.createTestTrack(t, token)
+	app.defineSectors(t, token, trackID, `{"sectors":[{"name":"S1"},{"name":"S2"}]}`)
+	sessionID := app.createSession(t, token, `{"date":"2026-05-02","trackId":"`+trackID+`","carId":"`+carID+`"}`)
+
+	for _, body := range []string{
+		`{"lapTime":"1:00.000","splits":["28","32"]`,
+		`{"lapTime":"1:01.000","splits":["27.5","33.5"]`,
+		`{"lapTime":"1:05.000"`,
+	} {
+		rec := app.doRequest(http.MethodPost, "/api/lapbook", body+`,"conditions":"DRY","sessionId":"`+sessionID+`"}`, token)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	rec := app.doRequest(http.MethodGet, "/api/sessions/"+sessionID, "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	summary := parseJSON(t, rec)["summary"].(map[string]interface{})
+	assert.Equal(t, float64(3), summary["lapCount"])
+	assert.Equal(t, float64(59500), summary["theoreticalBestMs"])
+	assert.Equal(t, "59.500", summary["theoreticalBest"])
+}
@@ -0,0 +1,200 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createSession creates a track session via the API and returns its ID.
+func (app *testApp) createSession(t *testing.T, token, body string) string {
+	t.Helper()
+	rec := app.doRequest(http.MethodPost, "/api/sessions", body, token)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	return parseJSON(t, rec)["id"].(string)
+}
+
+func TestSessions_CreateAndGet(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("sess"), "password123")
+	carID := app.createTestCar(t, "BMW", "M3", 2020, userID)
+	trackID := app.createTestTrack(t, token)
+
+	body := `{"date":"2026-05-02","runGroup":" Blue ","ambientTempC":18.5,"trackTempC":31,
+		"setup":{"tirePressureFL": 32.5, "springs": "stiff"},"trackId":"` + trackID + `","carId":"` + carID + `"}`
+	rec := app.doRequest(http.MethodPost, "/api/sessions", body, token)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	created := parseJSON(t, rec)
+	sessionID := created["id"].(string)
+	assert.Equal(t, "2026-05-02", created["date"])
+	assert.Equal(t, "Blue", created["runGroup"])
+	assert.Equal(t, 18.5, created["ambientTempC"])
+	assert.Equal(t, map[string]interface{}{"tirePressureFL": 32.5, "springs": "stiff"}, created["setup"])
+	assert.Equal(t, "BMW", created["car"].(map[string]interface{})["make"])
+	summary := created["summary"].(map[string]interface{})
+	assert.Equal(t, float64(0), summary["lapCount"])
+	assert.Nil(t, summary["bestMs"])
+
+	// Laps take the session's track and car and are numbered in order
+	for _, lapTime := range []string{"1:45.000", "1:42.000", "1:43.500"} {
+		rec = app.doRequest(http.MethodPost, "/api/lapbook", `{"lapTime":"`+lapTime+`","conditions":"DRY","sessionId":"`+sessionID+`"}`, token)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		lap := parseJSON(t, rec)
+		assert.Equal(t, trackID, lap["trackId"])
+		assert.Equal(t, carID, lap["carId"])
+	}
+	rec = app.doRequest(http.MethodPost, "/api/lapbook", `{"lapTime":"1:50.000","conditions":"DRY","sessionId":"`+sessionID+`","lapNumber":2}`, token)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = app.doRequest(http.MethodGet, "/api/sessions/"+sessionID, "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	result := parseJSON(t, rec)
+	laps := result["laps"].([]interface{})
+	require.Len(t, laps, 3)
+	for i, lap := range laps {
+		assert.Equal(t, float64(i+1), lap.(map[string]interface{})["lapNumber"])
+	}
+	assert.Equal(t, "1:45.000", laps[0].(map[string]interface{})["lapTime"])
+
+	summary = result["summary"].(map[string]interface{})
+	assert.Equal(t, float64(3), summary["lapCount"])
+	assert.Equal(t, float64(102000), summary["bestMs"])
+	assert.Equal(t, "1:42.000", summary["bestLapTime"])
+	assert.Equal(t, float64(103500), summary["averageMs"])
+	assert.Equal(t, float64(1500), summary["stdDevMs"])
+	assert.Nil(t, summary["theoreticalBestMs"])
+
+	rec = app.doRequest(http.MethodGet, "/api/lapbook?sessionId="+sessionID, "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, parseJSONArray(t, rec), 3)
+
+	rec = app.doRequest(http.MethodGet, "/api/sessions?date=2026-05-02", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	sessions := parseJSONArray(t, rec)
+	require.Len(t, sessions, 1)
+	assert.Nil(t, sessions[0]["laps"])
+	assert.Equal(t, float64(3), sessions[0]["summary"].(map[string]interface{})["lapCount"])
+
+	rec = app.doRequest(http.MethodGet, "/api/sessions?date=2026-05-03", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, parseJSONArray(t, rec))
+}
+
+func TestSessions_Validation(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("sess"), "password123")
+	otherID, otherToken := app.createTestUser(t, "Other", uniqueEmail("sess"), "password123")
+	carID := app.createTestCar(t, "BMW", "M3", 2020, userID)
+	otherCarID := app.createTestCar(t, "Honda", "Civic", 2018, otherID)
+	trackID := app.createTestTrack(t, token)
+	ids := `"trackId":"` + trackID + `","carId":"` + carID + `"`
+
+	cases := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"missing date", `{` + ids + `}`, http.StatusBadRequest},
+		{"bad date", `{"date":"02/05/2026",` + ids + `}`, http.StatusBadRequest},
+		{"ambient too hot", `{"date":"2026-05-02","ambientTempC":75,` + ids + `}`, http.StatusBadRequest},
+		{"setup not an object", `{"date":"2026-05-02","setup":[1,2],` + ids + `}`, http.StatusBadRequest},
+		{"missing car", `{"date":"2026-05-02","trackId":"` + trackID + `"}`, http.StatusBadRequest},
+		{"another driver's car", `{"date":"2026-05-02","trackId":"` + trackID + `","carId":"` + otherCarID + `"}`, http.StatusNotFound},
+		{"unknown track", `{"date":"2026-05-02","trackId":"nope","carId":"` + carID + `"}`, http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := app.doRequest(http.MethodPost, "/api/sessions", tc.body, token)
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+		})
+	}
+
+	sessionID := app.createSession(t, token, `{"date":"2026-05-02",`+ids+`}`)
+
+	// Sessions are private to their driver
+	rec := app.doRequest(http.MethodGet, "/api/sessions/"+sessionID, "", otherToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	otherTrack := app.createPendingTrack(t, otherToken, `{"name":"Other Track","location":"Elsewhere, CA","eventTypes":["ROADCOURSE"]}`)
+	app.approveTrack(t, otherTrack)
+	rec = app.doRequest(http.MethodPost, "/api/lapbook", `{"lapTime":"1:40.000","conditions":"DRY","sessionId":"`+sessionID+`","trackId":"`+otherTrack+`","carId":"`+otherCarID+`"}`, otherToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// A lap must match its session
+	rec = app.doRequest(http.MethodPost, "/api/lapbook", `{"lapTime":"1:40.000","conditions":"DRY","sessionId":"`+sessionID+`","trackId":"`+otherTrack+`"}`, token)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = app.doRequest(http.MethodPost, "/api/lapbook", `{"lapTime":"1:40.000","conditions":"DRY","sessionId":"`+sessionID+`","lapNumber":0}`, token)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestSessions_UpdateAndDelete(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("sess"), "password123")
+	carID := app.createTestCar(t, "BMW", "M3", 2020, userID)
+	otherCarID := app.createTestCar(t, "Mazda", "MX-5", 2019, userID)
+	trackID := app.createTestTrack(t, token)
+	sessionID := app.createSession(t, token, `{"date":"2026-05-02","runGroup":"Blue","trackId":"`+trackID+`","carId":"`+carID+`"}`)
+
+	rec := app.doRequest(http.MethodPut, "/api/sessions/"+sessionID, `{"date":"2026-05-02","runGroup":"Red","trackTempC":40,"trackId":"`+trackID+`","carId":"`+otherCarID+`"}`, token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	result := parseJSON(t, rec)
+	assert.Equal(t, "Red", result["runGroup"])
+	assert.Equal(t, float64(40), result["trackTempC"])
+	assert.Equal(t, otherCarID, result["carId"])
+
+	lapID := app.logLap(t, token, trackID, otherCarID, "1:40.000", "DRY", "")
+	rec = app.doRequest(http.MethodPatch, "/api/lapbook/"+lapID, `{"sessionId":"`+sessionID+`"}`, token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, float64(1), parseJSON(t, rec)["lapNumber"])
+
+	// Once it has laps, the session's car is fixed
+	rec = app.doRequest(http.MethodPut, "/api/sessions/"+sessionID, `{"date":"2026-05-02","trackId":"`+trackID+`","carId":"`+carID+`"}`, token)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	// Deleting the session keeps its laps
+	rec = app.doRequest(http.MethodDelete, "/api/sessions/"+sessionID, "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/sessions/"+sessionID, "", token)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = app.doRequest(http.MethodGet, "/api/lapbook", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	laps := parseJSONArray(t, rec)
+	require.Len(t, laps, 1)
+	assert.Nil(t, laps[0]["sessionId"])
+	assert.Nil(t, laps[0]["lapNumber"])
+}
+
+func TestSessions_Stats(t *testing.T) {
+	app := setupTestApp(t)
+	userID, token := app.createTestUser(t, "User", uniqueEmail("sess"), "password123")
+	carID := app.createTestCar(t, "BMW", "M3", 2020, userID)
+	trackID := app.createTestTrack(t, token)
+	ids := `"trackId":"` + trackID + `","carId":"` + carID + `"`
+	morning := app.createSession(t, token, `{"date":"2026-05-02","runGroup":"Morning",`+ids+`}`)
+	afternoon := app.createSession(t, token, `{"date":"2026-05-02","runGroup":"Afternoon",`+ids+`}`)
+
+	for _, lap := range []struct{ session, time string }{
+		{morning, "1:45.000"}, {morning, "1:44.000"}, {afternoon, "1:42.000"},
+	} {
+		rec := app.doRequest(http.MethodPost, "/api/lapbook", `{"lapTime":"`+lap.time+`","conditions":"DRY","sessionId":"`+lap.session+`"}`, token)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	// Two sessions on one day are reported separately
+	rec := app.doRequest(http.MethodGet, "/api/lapbook/stats", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	sessions := parseJSON(t, rec)["sessions"].([]interface{})
+	require.Len(t, sessions, 2)
+	byGroup := map[string]map[string]interface{}{}
+	for _, s := range sessions {
+		s := s.(map[string]interface{})
+		assert.Equal(t, "2026-05-02", s["date"])
+		byGroup[s["runGroup"].(string)] = s
+	}
+	assert.Equal(t, float64(2), byGroup["Morning"]["lapCount"])
+	assert.Equal(t, morning, byGroup["Morning"]["sessionId"])
+	assert.Equal(t, float64(102000), byGroup["Afternoon"]["bestMs"])
+	assert.Equal(t, float64(2000), byGroup["Afternoon"]["improvementMs"])
+}
@@ -0,0 +1,193 @@
+-- Schema and sample rows as written by the pre-versioning database.Migrate,
+-- used to check that existing databases migrate cleanly up to head.
+CREATE TABLE IF NOT EXISTS "User" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "name" TEXT,
+    "email" TEXT NOT NULL,
+    "emailVerified" DATETIME,
+    "passwordHash" TEXT,
+    "image" TEXT,
+    "experience" TEXT NOT NULL DEFAULT 'BEGINNER',
+    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updatedAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS "Account" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "userId" TEXT NOT NULL,
+    "type" TEXT NOT NULL,
+    "provider" TEXT NOT NULL,
+    "providerAccountId" TEXT NOT NULL,
+    "refresh_token" TEXT,
+    "access_token" TEXT,
+    "expires_at" INTEGER,
+    "token_type" TEXT,
+    "scope" TEXT,
+    "id_token" TEXT,
+    "session_state" TEXT,
+    CONSTRAINT "Account_userId_fkey" FOREIGN KEY ("userId") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS "Session" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "sessionToken" TEXT NOT NULL,
+    "userId" TEXT NOT NULL,
+    "expires" DATETIME NOT NULL,
+    CONSTRAINT "Session_userId_fkey" FOREIGN KEY ("userId") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS "VerificationToken" (
+    "identifier" TEXT NOT NULL,
+    "token" TEXT NOT NULL,
+    "expires" DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "Car" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "make" TEXT NOT NULL,
+    "model" TEXT NOT NULL,
+    "year" INTEGER NOT NULL,
+    "userId" TEXT NOT NULL,
+    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updatedAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT "Car_userId_fkey" FOREIGN KEY ("userId") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS "CarMod" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "name" TEXT NOT NULL,
+    "category" TEXT NOT NULL,
+    "notes" TEXT,
+    "carId" TEXT NOT NULL,
+    CONSTRAINT "CarMod_carId_fkey" FOREIGN KEY ("carId") REFERENCES "Car" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS "Track" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "name" TEXT NOT NULL,
+    "location" TEXT NOT NULL,
+    "state" TEXT,
+    "description" TEXT,
+    "imageUrl" TEXT,
+    "latitude" REAL,
+    "longitude" REAL,
+    "status" TEXT NOT NULL DEFAULT 'APPROVED',
+    "isImported" BOOLEAN NOT NULL DEFAULT false,
+    "uploadedById" TEXT NOT NULL,
+    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updatedAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT "Track_uploadedById_fkey" FOREIGN KEY ("uploadedById") REFERENCES "User" ("id") ON DELETE RESTRICT ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS "TrackImage" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "url" TEXT NOT NULL,
+    "caption" TEXT,
+    "trackId" TEXT NOT NULL,
+    "uploadedById" TEXT NOT NULL,
+    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT "TrackImage_trackId_fkey" FOREIGN KEY ("trackId") REFERENCES "Track" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT "TrackImage_uploadedById_fkey" FOREIGN KEY ("uploadedById") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS "TrackEvent" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "eventType" TEXT NOT NULL,
+    "trackId" TEXT NOT NULL,
+    CONSTRAINT "TrackEvent_trackId_fkey" FOREIGN KEY ("trackId") REFERENCES "Track" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS "TrackZone" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "name" TEXT NOT NULL,
+    "description" TEXT,
+    "posX" REAL NOT NULL,
+    "posY" REAL NOT NULL,
+    "trackId" TEXT NOT NULL,
+    "eventType" TEXT,
+    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT "TrackZone_trackId_fkey" FOREIGN KEY ("trackId") REFERENCES "Track" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS "ZoneTip" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "content" TEXT NOT NULL,
+    "conditions" TEXT,
+    "zoneId" TEXT NOT NULL,
+    "authorId" TEXT NOT NULL,
+    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updatedAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT "ZoneTip_zoneId_fkey" FOREIGN KEY ("zoneId") REFERENCES "TrackZone" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT "ZoneTip_authorId_fkey" FOREIGN KEY ("authorId") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS "TrackReview" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "rating" INTEGER NOT NULL,
+    "content" TEXT,
+    "conditions" TEXT NOT NULL,
+    "trackId" TEXT NOT NULL,
+    "trackEventId" TEXT,
+    "authorId" TEXT NOT NULL,
+    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updatedAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT "TrackReview_trackId_fkey" FOREIGN KEY ("trackId") REFERENCES "Track" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT "TrackReview_trackEventId_fkey" FOREIGN KEY ("trackEventId") REFERENCES "TrackEvent" ("id") ON DELETE SET NULL ON UPDATE CASCADE,
+    CONSTRAINT "TrackReview_authorId_fkey" FOREIGN KEY ("authorId") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS "LapRecord" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "lapTime" TEXT NOT NULL,
+    "conditions" TEXT NOT NULL,
+    "notes" TEXT,
+    "tirePressureFL" REAL,
+    "tirePressureFR" REAL,
+    "tirePressureRL" REAL,
+    "tirePressureRR" REAL,
+    "fuelLevel" REAL,
+    "camberFL" REAL,
+    "camberFR" REAL,
+    "camberRL" REAL,
+    "camberRR" REAL,
+    "casterFL" REAL,
+    "casterFR" REAL,
+    "toeFL" REAL,
+    "toeFR" REAL,
+    "toeRL" REAL,
+    "toeRR" REAL,
+    "trackId" TEXT NOT NULL,
+    "trackEventId" TEXT,
+    "carId" TEXT NOT NULL,
+    "driverId" TEXT NOT NULL,
+    "createdAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updatedAt" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT "LapRecord_trackId_fkey" FOREIGN KEY ("trackId") REFERENCES "Track" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT "LapRecord_trackEventId_fkey" FOREIGN KEY ("trackEventId") REFERENCES "TrackEvent" ("id") ON DELETE SET NULL ON UPDATE CASCADE,
+    CONSTRAINT "LapRecord_carId_fkey" FOREIGN KEY ("carId") REFERENCES "Car" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT "LapRecord_driverId_fkey" FOREIGN KEY ("driverId") REFERENCES "User" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- Indexes
+CREATE UNIQUE INDEX IF NOT EXISTS "User_email_key" ON "User"("email");
+CREATE UNIQUE INDEX IF NOT EXISTS "Account_provider_providerAccountId_key" ON "Account"("provider", "providerAccountId");
+CREATE UNIQUE INDEX IF NOT EXISTS "Session_sessionToken_key" ON "Session"("sessionToken");
+CREATE UNIQUE INDEX IF NOT EXISTS "VerificationToken_token_key" ON "VerificationToken"("token");
+CREATE UNIQUE INDEX IF NOT EXISTS "VerificationToken_identifier_token_key" ON "VerificationToken"("identifier", "token");
+CREATE UNIQUE INDEX IF NOT EXISTS "Track_name_location_key" ON "Track"("name", "location");
+CREATE UNIQUE INDEX IF NOT EXISTS "TrackEvent_trackId_eventType_key" ON "TrackEvent"("trackId", "eventType");
+
+-- Sample data
+INSERT INTO "User" (id, name, email, passwordHash, experience, createdAt, updatedAt)
+VALUES ('legacy-user', 'Legacy Driver', 'legacy@test.com', NULL, 'ADVANCED', '2024-05-01 12:00:00', '2024-05-01 12:00:00');
+
+INSERT INTO "Car" (id, make, model, year, userId, createdAt, updatedAt)
+VALUES ('legacy-car', 'Mazda', 'MX-5', 2019, 'legacy-user', '2024-05-01 12:00:00', '2024-05-01 12:00:00');
+
+INSERT INTO "Track" (id, name, location, state, latitude, longitude, status, isImported, uploadedById, createdAt, updatedAt)
+VALUES ('legacy-track', 'Road Atlanta', 'Braselton, GA', 'GA', 34.1469, -83.8153, 'APPROVED', false, 'legacy-user', '2024-05-01 12:00:00', '2024-05-01 12:00:00');
+
+INSERT INTO "TrackEvent" (id, eventType, trackId) VALUES ('legacy-event', 'ROADCOURSE', 'legacy-track');
+
+INSERT INTO "LapRecord" (id, lapTime, conditions, trackId, trackEventId, carId, driverId, createdAt, updatedAt)
+VALUES ('legacy-lap', '1:38.412', 'DRY', 'legacy-track', 'legacy-event', 'legacy-car', 'legacy-user', '2024-05-01 12:00:00', '2024-05-01 12:00:00');
@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/joezmuda/trackside-backend/internal/importer"
+	"github.com/joezmuda/trackside-backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const ontarioTracksCSV = `Track Name,City,Province,Types,Lat,Lng,Notes
+Canadian Tire Motorsport Park,"Bowmanville, ON",Ontario,roadcourse,44.0514,-78.6756,Mosport
+Toronto Motorsports Park,"Cayuga, ON",Ontario,drag|roadcourse,42.9531,-79.8681,
+Shannonville Motorsport Park,"Shannonville, ON",Ontario,roadcourse,not-a-number,-77.2306,
+Grand Bend Motorplex,"Grand Bend, ON",Ontario,sprint,43.2890,-81.7350,
+`
+
+const ontarioTracksGeoJSON = `{"type":"FeatureCollection","features":[
+	{"type":"Feature","geometry":{"type":"Point","coordinates":[-78.6756,44.0514]},
+	 "properties":{"name":"Canadian Tire Motorsport Park","location":"Bowmanville, ON","state":"Ontario","types":["roadcourse"],"description":"Mosport"}},
+	{"type":"Feature","geometry":{"type":"LineString","coordinates":[[-79.8681,42.9531],[-79.8, 42.9]]},
+	 "properties":{"name":"Toronto Motorsports Park","location":"Cayuga, ON","types":["drag"]}}
+]}`
+
+func TestParseTracks_Formats(t *testing.T) {
+	tracks, rowErrors, err := importer.ParseTracks("ontario.csv", []byte(ontarioTracksCSV))
+	require.NoError(t, err)
+	require.Len(t, tracks, 2)
+	assert.Equal(t, models.ImportedTrack{
+		Name: "Canadian Tire Motorsport Park", Location: "Bowmanville, ON", State: "Ontario",
+		Types: []string{"ROADCOURSE"}, Latitude: 44.0514, Longitude: -78.6756, Description: "Mosport",
+	}, tracks[0])
+	assert.Equal(t, []string{"DRAG", "ROADCOURSE"}, tracks[1].Types)
+	assert.Equal(t, []string{
+		"line 4: latitude must be a number",
+		`line 5: unknown event type "SPRINT"`,
+	}, rowErrors)
+
+	// GeoJSON coordinates are longitude first
+	tracks, rowErrors, err = importer.ParseTracks("ontario.geojson", []byte(ontarioTracksGeoJSON))
+	require.NoError(t, err)
+	require.Len(t, tracks, 1)
+	assert.Equal(t, 44.0514, tracks[0].Latitude)
+	assert.Equal(t, -78.6756, tracks[0].Longitude)
+	assert.Equal(t, []string{"feature 2: geometry must be a Point"}, rowErrors)
+
+	// A bare JSON array works too, and the format is sniffed without an extension
+	tracks, rowErrors, err = importer.ParseTracks("upload", []byte(`[
+		{"name":"Rockingham","location":"Corby","country":"gb","types":["roadcourse"],"latitude":52.5147,"longitude":-0.6583},
+		{"name":"Rockingham","location":"Corby","country":"GB","types":["roadcourse"],"latitude":52.5147,"longitude":-0.6583},
+		{"name":"Anglesey","location":"Ty Croes","country":"Wales","types":["roadcourse"],"latitude":53.19,"longitude":-4.5},
+		{"name":"Knockhill","location":"Dunfermline","types":"roadcourse","latitude":56.13,"longitude":-3.51},
+		{"name":"Oulton Park","location":"Little Budworth","types":["roadcourse"],"latitude":53.18},
+		{"name":"Snetterton","location":"Norfolk","types":["roadcourse"],"latitude":152.5,"longitude":0.95}
+	]`))
+	require.NoError(t, err)
+	require.Len(t, tracks, 1)
+	assert.Equal(t, "GB", tracks[0].Country)
+	assert.Equal(t, "", tracks[0].State)
+	assert.Equal(t, []string{
+		"track 4: types must be a list",
+		"track 2: duplicate of track 1",
+		"track 3: country must be a two-letter code",
+		"track 5: longitude is required",
+		"track 6: latitude must be between -90 and 90",
+	}, rowErrors)
+
+	// Problems with the file as a whole are errors
+	_, _, err = importer.ParseTracks("tracks.json", []byte(`{"type":"Feature"}`))
+	assert.Error(t, err)
+	_, _, err = importer.ParseTracks("tracks.json", []byte(`{"circuits":[]}`))
+	assert.Error(t, err)
+	_, _, err = importer.ParseTracks("tracks.csv", []byte("name,location\nA,B\n"))
+	assert.Error(t, err)
+}
+
+func TestSyncTracks_Upload(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUser(t, "User", uniqueEmail("driver"), "password123")
+	_, adminToken := app.createTestUserWithRole(t, "Admin", uniqueEmail("ops"), "ADMIN")
+
+	rec := app.uploadFile(t, "/api/admin/sync-tracks", token, "ontario.csv", ontarioTracksCSV, nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = app.uploadFile(t, "/api/admin/sync-tracks", adminToken, "ontario.csv", ontarioTracksCSV, map[string]string{"source": "Ontario Clubs!"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = app.uploadFile(t, "/api/admin/sync-tracks", adminToken, "ontario.csv", ontarioTracksCSV, map[string]string{"country": "Canada"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	// A file named like the bundled one needs its own source, so pruning it
+	// can't archive the bundled tracks
+	rec = app.uploadFile(t, "/api/admin/sync-tracks", adminToken, "USA-Tracks.json", ontarioTracksCSV, map[string]string{"prune": "true"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, parseJSON(t, rec)["error"], "source is required")
+
+	// A file that can't be read at all is the upload's fault
+	rec = app.uploadFile(t, "/api/admin/sync-tracks", adminToken, "broken.csv", "name,location,latitude,longitude\nA\"B,C,1,2\n", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, parseJSON(t, rec)["error"], "invalid CSV tracks data file")
+
+	// Invalid rows are reported and the rest imported
+	rec = app.uploadFile(t, "/api/admin/sync-tracks", adminToken, "ontario.csv", ontarioTracksCSV,
+		map[string]string{"source": "ontario-clubs", "country": "ca", "dryRun": "true"})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	body := parseJSON(t, rec)
+	assert.Equal(t, true, body["dryRun"])
+	summary := body["summary"].(map[string]interface{})
+	assert.Equal(t, float64(4), summary["total"])
+	assert.Equal(t, float64(2), summary["created"])
+	assert.Equal(t, float64(2), summary["failed"])
+	assert.Len(t, body["errors"], 2)
+	change := body["changes"].([]interface{})[0].(map[string]interface{})["changes"].(map[string]interface{})
+	assert.Equal(t, "ontario-clubs", change["source"].(map[string]interface{})["to"])
+	assert.Equal(t, "CA", change["country"].(map[string]interface{})["to"])
+
+	rec = app.uploadFile(t, "/api/admin/sync-tracks", adminToken, "ontario.csv", ontarioTracksCSV,
+		map[string]string{"source": "ontario-clubs", "country": "CA"})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// State is free text, matched without regard to case
+	rec = app.doRequest(http.MethodGet, "/api/tracks?country=ca&state=ontario", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	tracks := parseTrackList(t, rec)
+	require.Len(t, tracks, 2)
+	assert.Equal(t, "CA", tracks[0]["country"])
+	assert.Equal(t, "Ontario", tracks[0]["state"])
+	assert.Equal(t, "ontario-clubs", tracks[0]["source"])
+	rec = app.doRequest(http.MethodGet, "/api/tracks?country=US", "", "")
+	assert.Empty(t, parseTrackList(t, rec))
+
+	rec = app.doRequest(http.MethodGet, "/api/admin/sync-runs", "", adminToken)
+	runs := parseJSONArray(t, rec)
+	require.Len(t, runs, 3)
+	assert.Equal(t, "ontario-clubs", runs[0]["source"])
+	assert.Equal(t, "failed", runs[2]["status"])
+}
+
+func TestSyncTracks_SourcesCoexist(t *testing.T) {
+	app := setupTestApp(t)
+	_, err := importer.SyncTracks(app.trackRepo, app.userRepo, writeTracksFile(t, testTracksJSON), models.SyncOptions{})
+	require.NoError(t, err)
+	barber, err := app.trackRepo.FindByNameAndLocation("Barber Motorsports Park", "Birmingham, AL")
+	require.NoError(t, err)
+	assert.Equal(t, importer.TracksFile, *barber.Source)
+	assert.Equal(t, "US", *barber.Country)
+	assert.Equal(t, "AL", *barber.State)
+
+	// A partner club's list that repeats a bundled track leaves it alone, and
+	// pruning it doesn't touch the bundled tracks
+	club := importer.TrackFile{Source: "alabama-club", Name: "club.json", Data: []byte(`{"tracks":[
+		{"name":"Barber Motorsports Park","location":"Birmingham, AL","types":["autocross"],"latitude":33.5,"longitude":-86.6},
+		{"name":"Talladega Lot","location":"Talladega, AL","types":["autocross"],"latitude":33.56,"longitude":-86.07}
+	]}`)}
+	resp, err := importer.SyncTrackFile(app.trackRepo, app.userRepo, club, models.SyncOptions{Prune: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, resp.Summary.Created)
+	assert.Equal(t, 1, resp.Summary.Skipped)
+	assert.Equal(t, 0, resp.Summary.Archived)
+	assert.Equal(t, []string{"Barber Motorsports Park: already imported from usa-tracks.json"}, resp.Errors)
+	talladega, err := app.trackRepo.FindByNameAndLocation("Talladega Lot", "Talladega, AL")
+	require.NoError(t, err)
+	assert.Nil(t, talladega.State)
+	assert.Nil(t, talladega.Country)
+
+	// Pruning works per source, and not at all if rows couldn't be read
+	club.Data = []byte(`{"tracks":[{"name":"Barber Motorsports Park","location":"Birmingham, AL","types":["autocross"],"latitude":33.5}]}`)
+	resp, err = importer.SyncTrackFile(app.trackRepo, app.userRepo, club, models.SyncOptions{Prune: true})
+	require.NoError(t, err)
+	assert.Equal(t, 0, resp.Summary.Archived)
+	assert.Contains(t, resp.Errors, "not pruning: 1 rows could not be read")
+
+	club.Data = []byte(`{"tracks":[]}`)
+	resp, err = importer.SyncTrackFile(app.trackRepo, app.userRepo, club, models.SyncOptions{Prune: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, resp.Summary.Archived)
+	require.Len(t, resp.Changes, 1)
+	assert.Equal(t, "Talladega Lot", resp.Changes[0].Name)
+	barber, err = app.trackRepo.FindByNameAndLocation("Barber Motorsports Park", "Birmingham, AL")
+	require.NoError(t, err)
+	assert.Equal(t, "APPROVED", barber.Status)
+}
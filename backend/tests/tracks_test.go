@@ -1,6 +1,8 @@
 package tests
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"testing"
 
@@ -12,7 +14,7 @@ func TestTracks_ListEmpty(t *testing.T) {
 	app := setupTestApp(t)
 	rec := app.doRequest(http.MethodGet, "/api/tracks", "", "")
 	assert.Equal(t, http.StatusOK, rec.Code)
-	result := parseJSONArray(t, rec)
+	result := parseTrackList(t, rec)
 	assert.Empty(t, result)
 }
 
@@ -117,20 +119,292 @@ func TestTracks_ListWithFilter(t *testing.T) {
 	app := setupTestApp(t)
 	_, token := app.createTestUser(t, "User", uniqueEmail("tracks"), "password123")
 
-	// Create a track
-	body := `{"name":"Sebring International","location":"Sebring, FL","eventTypes":["ROADCOURSE"]}`
-	rec := app.doRequest(http.MethodPost, "/api/tracks", body, token)
-	require.Equal(t, http.StatusCreated, rec.Code)
+	// Create and approve a track
+	trackID := app.createPendingTrack(t, token, `{"name":"Sebring International","location":"Sebring, FL","eventTypes":["ROADCOURSE"]}`)
+	app.approveTrack(t, trackID)
 
 	// Search by name
-	rec = app.doRequest(http.MethodGet, "/api/tracks?search=Sebring", "", "")
+	rec := app.doRequest(http.MethodGet, "/api/tracks?search=Sebring", "", "")
 	assert.Equal(t, http.StatusOK, rec.Code)
-	results := parseJSONArray(t, rec)
+	results := parseTrackList(t, rec)
 	assert.Len(t, results, 1)
 
 	// Search with no match
 	rec = app.doRequest(http.MethodGet, "/api/tracks?search=Nonexistent", "", "")
 	assert.Equal(t, http.StatusOK, rec.Code)
-	results = parseJSONArray(t, rec)
+	results = parseTrackList(t, rec)
 	assert.Empty(t, results)
 }
+
+// createApprovedTracks creates n approved tracks named "Track 00".."Track NN".
+func (app *testApp) createApprovedTracks(t *testing.T, token string, n int) []string {
+	t.Helper()
+	ids := make([]string, n)
+	for i := 0; i < n; i++ {
+		body := fmt.Sprintf(`{"name":"Track %02d","location":"City %02d, CA","eventTypes":["ROADCOURSE"]}`, i, i)
+		ids[i] = app.createPendingTrack(t, token, body)
+		app.approveTrack(t, ids[i])
+	}
+	return ids
+}
+
+// collectTrackPages walks every page of GET /api/tracks for a sort and
+// returns the track names in order along with the number of pages.
+func (app *testApp) collectTrackPages(t *testing.T, sort string, limit, total int) ([]string, int) {
+	t.Helper()
+	seen := map[string]bool{}
+	var names []string
+	cursor := ""
+	pages := 0
+	for {
+		path := fmt.Sprintf("/api/tracks?sort=%s&limit=%d", sort, limit)
+		if cursor != "" {
+			path += "&cursor=" + cursor
+		}
+		rec := app.doRequest(http.MethodGet, path, "", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		page := parseJSON(t, rec)
+		assert.Equal(t, float64(total), page["total"])
+
+		for _, tr := range page["tracks"].([]interface{}) {
+			item := tr.(map[string]interface{})
+			assert.False(t, seen[item["id"].(string)], "track returned twice")
+			seen[item["id"].(string)] = true
+			names = append(names, item["name"].(string))
+		}
+		pages++
+
+		if page["nextCursor"] == nil {
+			return names, pages
+		}
+		cursor = page["nextCursor"].(string)
+		require.Less(t, pages, 10)
+	}
+}
+
+func TestTracks_ListPagination(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUser(t, "User", uniqueEmail("tracks"), "password123")
+	app.createApprovedTracks(t, token, 5)
+
+	names, pages := app.collectTrackPages(t, "name", 2, 5)
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, []string{"Track 00", "Track 01", "Track 02", "Track 03", "Track 04"}, names)
+
+	names, _ = app.collectTrackPages(t, "newest", 2, 5)
+	assert.Len(t, names, 5)
+
+	// A cursor from one sort can't be reused with another
+	rec := app.doRequest(http.MethodGet, "/api/tracks?sort=name&limit=2", "", "")
+	cursor := parseJSON(t, rec)["nextCursor"].(string)
+	rec = app.doRequest(http.MethodGet, "/api/tracks?sort=rating&cursor="+cursor, "", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestTracks_ListSortByReviews(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUser(t, "User", uniqueEmail("tracks"), "password123")
+	ids := app.createApprovedTracks(t, token, 3)
+
+	// Track 01 gets two reviews, Track 02 one
+	for _, id := range []string{ids[1], ids[1], ids[2]} {
+		rec := app.doRequest(http.MethodPost, "/api/tracks/"+id+"/reviews", `{"rating":4,"conditions":"DRY"}`, token)
+		require.Equal(t, http.StatusCreated, rec.Code)
+	}
+
+	rec := app.doRequest(http.MethodGet, "/api/tracks?sort=reviews", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	tracks := parseTrackList(t, rec)
+	require.Len(t, tracks, 3)
+	assert.Equal(t, ids[1], tracks[0]["id"])
+	assert.Equal(t, ids[2], tracks[1]["id"])
+	assert.Equal(t, ids[0], tracks[2]["id"])
+
+	counts := tracks[0]["_count"].(map[string]interface{})
+	assert.Equal(t, float64(2), counts["reviews"])
+	assert.Equal(t, float64(4), tracks[0]["avgRating"])
+	assert.NotEmpty(t, tracks[0]["events"])
+}
+
+func TestTracks_ListInvalidParams(t *testing.T) {
+	app := setupTestApp(t)
+
+	for _, path := range []string{
+		"/api/tracks?sort=fastest",
+		"/api/tracks?limit=0",
+		"/api/tracks?limit=500",
+		"/api/tracks?limit=abc",
+		"/api/tracks?cursor=not-a-cursor",
+		"/api/tracks?sort=distance",
+		"/api/tracks?lat=33.5",
+		"/api/tracks?lat=95&lng=-86.8",
+		"/api/tracks?radius=50",
+		"/api/tracks?lat=33.5&lng=-86.8&radius=-1",
+		"/api/tracks?bbox=-90,30,-80",
+		"/api/tracks?bbox=-90,35,-80,30",
+		"/api/tracks?sort=relevance",
+	} {
+		rec := app.doRequest(http.MethodGet, path, "", "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code, path)
+	}
+}
+
+// createLocatedTracks creates approved tracks at fixed coordinates:
+// Barber (near Birmingham, AL), Road Atlanta (GA) and Laguna Seca (CA).
+func (app *testApp) createLocatedTracks(t *testing.T, token string) (barber, atlanta, laguna string) {
+	t.Helper()
+	ids := app.createApprovedTracks(t, token, 4)
+	coords := [][2]float64{{33.5326, -86.6193}, {34.1486, -83.8150}, {36.5844, -121.7534}}
+	for i, c := range coords {
+		_, err := app.db.Exec(`UPDATE "Track" SET latitude = ?, longitude = ? WHERE id = ?`, c[0], c[1], ids[i])
+		require.NoError(t, err)
+	}
+	// ids[3] has no coordinates and is never returned by geo queries
+	return ids[0], ids[1], ids[2]
+}
+
+func TestTracks_ListNearby(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUser(t, "User", uniqueEmail("tracks"), "password123")
+	barber, atlanta, laguna := app.createLocatedTracks(t, token)
+
+	// Birmingham, AL
+	rec := app.doRequest(http.MethodGet, "/api/tracks?lat=33.5186&lng=-86.8104", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	tracks := parseTrackList(t, rec)
+	require.Len(t, tracks, 3)
+	assert.Equal(t, barber, tracks[0]["id"])
+	assert.Equal(t, atlanta, tracks[1]["id"])
+	assert.Equal(t, laguna, tracks[2]["id"])
+	assert.InDelta(t, 17.8, tracks[0]["distanceKm"], 0.5)
+	assert.InDelta(t, 11.1, tracks[0]["distanceMi"], 0.5)
+
+	rec = app.doRequest(http.MethodGet, "/api/tracks?lat=33.5186&lng=-86.8104&radius=500", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	page := parseJSON(t, rec)
+	assert.Equal(t, float64(2), page["total"])
+
+	// Distance is still reported when another sort is chosen
+	rec = app.doRequest(http.MethodGet, "/api/tracks?lat=33.5186&lng=-86.8104&radius=500&sort=name", "", "")
+	tracks = parseTrackList(t, rec)
+	require.Len(t, tracks, 2)
+	assert.NotNil(t, tracks[0]["distanceKm"])
+
+	// Distance pagination walks the same order
+	rec = app.doRequest(http.MethodGet, "/api/tracks?lat=33.5186&lng=-86.8104&limit=2", "", "")
+	page = parseJSON(t, rec)
+	require.NotNil(t, page["nextCursor"])
+	rec = app.doRequest(http.MethodGet, "/api/tracks?lat=33.5186&lng=-86.8104&limit=2&cursor="+page["nextCursor"].(string), "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	tracks = parseTrackList(t, rec)
+	require.Len(t, tracks, 1)
+	assert.Equal(t, laguna, tracks[0]["id"])
+
+	// Plain listings don't include distances
+	rec = app.doRequest(http.MethodGet, "/api/tracks", "", "")
+	for _, tr := range parseTrackList(t, rec) {
+		assert.NotContains(t, tr, "distanceKm")
+	}
+}
+
+func TestTracks_ListBoundingBox(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUser(t, "User", uniqueEmail("tracks"), "password123")
+	barber, atlanta, _ := app.createLocatedTracks(t, token)
+
+	// Roughly the southeastern US
+	rec := app.doRequest(http.MethodGet, "/api/tracks?bbox=-90,30,-80,36&sort=name", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	tracks := parseTrackList(t, rec)
+	require.Len(t, tracks, 2)
+	assert.ElementsMatch(t, []string{barber, atlanta}, []string{tracks[0]["id"].(string), tracks[1]["id"].(string)})
+}
+
+// createSearchTracks creates approved tracks with descriptions for search tests.
+func (app *testApp) createSearchTracks(t *testing.T, token string) (barber, laguna, sebring string) {
+	t.Helper()
+	barber = app.createPendingTrack(t, token, `{"name":"Barber Motorsports Park","location":"Birmingham, Alabama","description":"State-of-the-art 2.38-mile road course","eventTypes":["ROADCOURSE"]}`)
+	laguna = app.createPendingTrack(t, token, `{"name":"Laguna Seca","location":"Monterey, California","description":"World-class road course with famous Corkscrew","eventTypes":["ROADCOURSE"]}`)
+	sebring = app.createPendingTrack(t, token, `{"name":"Sebring International Raceway","location":"Sebring, FL","description":"Historic endurance circuit on a former airfield","eventTypes":["ROADCOURSE"]}`)
+	for _, id := range []string{barber, laguna, sebring} {
+		app.approveTrack(t, id)
+	}
+	return barber, laguna, sebring
+}
+
+func TestTracks_FullTextSearch(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUser(t, "User", uniqueEmail("tracks"), "password123")
+	barber, laguna, sebring := app.createSearchTracks(t, token)
+
+	// Words can come from any indexed column
+	rec := app.doRequest(http.MethodGet, "/api/tracks?search=birmingham+road+course", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	tracks := parseTrackList(t, rec)
+	require.Len(t, tracks, 1)
+	assert.Equal(t, barber, tracks[0]["id"])
+	assert.Contains(t, tracks[0]["snippet"], "<mark>")
+
+	// Misspellings still find the track
+	rec = app.doRequest(http.MethodGet, "/api/tracks?search=laguna+seka", "", "")
+	tracks = parseTrackList(t, rec)
+	require.Len(t, tracks, 1)
+	assert.Equal(t, laguna, tracks[0]["id"])
+
+	// Partial words match as prefixes
+	rec = app.doRequest(http.MethodGet, "/api/tracks?search=road+cour", "", "")
+	assert.Equal(t, float64(2), parseJSON(t, rec)["total"])
+
+	rec = app.doRequest(http.MethodGet, "/api/tracks?search=sebring", "", "")
+	tracks = parseTrackList(t, rec)
+	require.Len(t, tracks, 1)
+	assert.Equal(t, sebring, tracks[0]["id"])
+	assert.Equal(t, "<mark>Sebring</mark> International Raceway", tracks[0]["snippet"])
+}
+
+func TestTracks_SearchIndexFollowsUpdates(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUser(t, "User", uniqueEmail("tracks"), "password123")
+	trackID := app.createTestTrack(t, token)
+
+	rec := app.doRequest(http.MethodPatch, "/api/tracks/"+trackID, `{"name":"Thunderhill Raceway"}`, token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	app.approveTrack(t, trackID)
+
+	rec = app.doRequest(http.MethodGet, "/api/tracks?search=thunderhill", "", "")
+	assert.Len(t, parseTrackList(t, rec), 1)
+	rec = app.doRequest(http.MethodGet, "/api/tracks?search=test+track", "", "")
+	assert.Empty(t, parseTrackList(t, rec))
+
+	// Snippets are escaped before highlighting
+	rec = app.doRequest(http.MethodPatch, "/api/tracks/"+trackID, `{"description":"<b>thunder</b> road"}`, token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	app.approveTrack(t, trackID)
+	rec = app.doRequest(http.MethodGet, "/api/tracks?search=road", "", "")
+	tracks := parseTrackList(t, rec)
+	require.Len(t, tracks, 1)
+	assert.NotContains(t, tracks[0]["snippet"], "<b>")
+	assert.Contains(t, tracks[0]["snippet"], "<mark>road</mark>")
+}
+
+func TestTracks_Autocomplete(t *testing.T) {
+	app := setupTestApp(t)
+	_, token := app.createTestUser(t, "User", uniqueEmail("tracks"), "password123")
+	_, laguna, _ := app.createSearchTracks(t, token)
+	app.createPendingTrack(t, token, `{"name":"Lagoon Pending","location":"Nowhere","eventTypes":["DRAG"]}`)
+
+	rec := app.doRequest(http.MethodGet, "/api/tracks/autocomplete?q=lag", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var suggestions []map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &suggestions))
+	require.Len(t, suggestions, 1)
+	assert.Equal(t, laguna, suggestions[0]["id"])
+	assert.Equal(t, "<mark>Laguna</mark> Seca", suggestions[0]["highlight"])
+
+	rec = app.doRequest(http.MethodGet, "/api/tracks/autocomplete?q=", "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+
+	rec = app.doRequest(http.MethodGet, "/api/tracks/autocomplete?q=a&limit=50", "", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,261 @@
+package tests
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joezmuda/trackside-backend/internal/config"
+	"github.com/joezmuda/trackside-backend/internal/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// enableTwoFactor turns on two-factor authentication for the user and
+// returns the TOTP secret and recovery codes.
+func (app *testApp) enableTwoFactor(t *testing.T, userID, token string) (string, []string) {
+	t.Helper()
+	rec := app.doRequest(http.MethodPost, "/api/auth/2fa/setup", "", token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	secret := parseJSON(t, rec)["secret"].(string)
+
+	rec = app.doRequest(http.MethodPost, "/api/auth/2fa/enable", `{"code":"`+app.totpCode(t, userID, secret)+`"}`, token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var codes []string
+	for _, c := range parseJSON(t, rec)["recoveryCodes"].([]interface{}) {
+		codes = append(codes, c.(string))
+	}
+	return secret, codes
+}
+
+// totpCode returns the user's current TOTP code. It forgets the last code
+// the user used first, since a test can need more codes than one 30 second
+// step has.
+func (app *testApp) totpCode(t *testing.T, userID, secret string) string {
+	t.Helper()
+	_, err := app.db.Exec(`UPDATE "TwoFactor" SET lastStep = 0 WHERE userId = ?`, userID)
+	require.NoError(t, err)
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+	return code
+}
+
+// loginChallenge logs in with a password and returns the challenge token.
+func (app *testApp) loginChallenge(t *testing.T, email, password string) string {
+	t.Helper()
+	rec := app.doRequest(http.MethodPost, "/api/auth/login", `{"email":"`+email+`","password":"`+password+`"}`, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	body := parseJSON(t, rec)
+	require.Equal(t, true, body["twoFactorRequired"])
+	assert.Nil(t, body["token"])
+	return body["challengeToken"].(string)
+}
+
+func (app *testApp) verifyTwoFactor(challenge, field, code string) int {
+	rec := app.doRequest(http.MethodPost, "/api/auth/2fa/verify",
+		`{"challengeToken":"`+challenge+`","`+field+`":"`+code+`"}`, "")
+	return rec.Code
+}
+
+func TestTOTP_Codes(t *testing.T) {
+	// RFC 6238 test key, with the last six digits of its SHA-1 test values
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	for unix, want := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"} {
+		code, err := totp.Code(secret, totp.Step(time.Unix(unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, want, code)
+	}
+
+	at := time.Unix(1234567890, 0)
+	step, ok := totp.Validate(secret, "005924", at.Add(totp.Period))
+	assert.True(t, ok)
+	assert.Equal(t, totp.Step(at), step)
+	_, ok = totp.Validate(secret, "005924", at.Add(2*totp.Period))
+	assert.False(t, ok)
+
+	assert.Equal(t, "otpauth://totp/Trackside:racer@test.com?algorithm=SHA1&digits=6&issuer=Trackside&period=30&secret="+secret,
+		totp.URI("Trackside", "racer@test.com", secret))
+}
+
+func TestTwoFactor_EnableAndLogin(t *testing.T) {
+	app := setupTestApp(t)
+	email := uniqueEmail("twofactor")
+	userID, token := app.createTestUser(t, "Two Factor", email, "password123")
+	earlier := app.login(t, email, "password123")
+
+	rec := app.doRequest(http.MethodGet, "/api/auth/2fa", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, map[string]interface{}{"enabled": false, "required": false, "recoveryCodesLeft": float64(0)}, parseJSON(t, rec))
+	rec = app.doRequest(http.MethodPost, "/api/auth/2fa/enable", `{"code":"123456"}`, token)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = app.doRequest(http.MethodPost, "/api/auth/2fa/setup", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	setup := parseJSON(t, rec)
+	secret := setup["secret"].(string)
+	assert.True(t, strings.HasPrefix(setup["uri"].(string), "otpauth://totp/Trackside:"))
+	assert.Contains(t, setup["uri"], "secret="+secret)
+	rec = app.doRequest(http.MethodPost, "/api/auth/2fa/enable", `{"code":"12345x"}`, token)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	code := app.totpCode(t, userID, secret)
+	rec = app.doRequest(http.MethodPost, "/api/auth/2fa/enable", `{"code":"`+code+`"}`, token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Len(t, parseJSON(t, rec)["recoveryCodes"], 10)
+	recoveryCode := parseJSON(t, rec)["recoveryCodes"].([]interface{})[0].(string)
+
+	// Sessions that never gave a code are logged out
+	rec = app.doRequest(http.MethodGet, "/api/profile", "", earlier["token"].(string))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/profile", "", token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodPost, "/api/auth/2fa/setup", "", token)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Logging in takes a password and then a code
+	challenge := app.loginChallenge(t, email, "password123")
+	rec = app.doRequest(http.MethodGet, "/api/profile", "", challenge)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, http.StatusUnauthorized, app.verifyTwoFactor(challenge, "code", "12345x"))
+	assert.Equal(t, http.StatusUnauthorized, app.verifyTwoFactor(challenge, "code", code), "the code used to enable works once")
+	assert.Equal(t, http.StatusUnauthorized, app.verifyTwoFactor(token, "code", app.totpCode(t, userID, secret)))
+
+	code = app.totpCode(t, userID, secret)
+	rec = app.doRequest(http.MethodPost, "/api/auth/2fa/verify", `{"challengeToken":"`+challenge+`","code":"`+code+`"}`, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = app.doRequest(http.MethodGet, "/api/profile", "", parseJSON(t, rec)["token"].(string))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, http.StatusUnauthorized, app.verifyTwoFactor(challenge, "code", code))
+
+	// Recovery codes work once, typed any which way
+	typed := strings.ToUpper(strings.ReplaceAll(recoveryCode, "-", ""))
+	assert.Equal(t, http.StatusOK, app.verifyTwoFactor(challenge, "recoveryCode", typed))
+	assert.Equal(t, http.StatusUnauthorized, app.verifyTwoFactor(challenge, "recoveryCode", recoveryCode))
+	rec = app.doRequest(http.MethodGet, "/api/auth/2fa", "", token)
+	assert.Equal(t, map[string]interface{}{"enabled": true, "required": false, "recoveryCodesLeft": float64(9)}, parseJSON(t, rec))
+}
+
+func TestTwoFactor_Lockout(t *testing.T) {
+	app := setupTestApp(t)
+	email := uniqueEmail("locked")
+	userID, token := app.createTestUser(t, "Locked", email, "password123")
+	secret, _ := app.enableTwoFactor(t, userID, token)
+
+	challenge := app.loginChallenge(t, email, "password123")
+	for range 5 {
+		assert.Equal(t, http.StatusUnauthorized, app.verifyTwoFactor(challenge, "code", "12345x"))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, app.verifyTwoFactor(challenge, "code", app.totpCode(t, userID, secret)))
+
+	_, err := app.db.Exec(`UPDATE "TwoFactor" SET lockedUntil = datetime('now', '-1 minute') WHERE userId = ?`, userID)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, app.verifyTwoFactor(challenge, "code", app.totpCode(t, userID, secret)))
+}
+
+func TestTwoFactor_RecoveryCodesAndDisable(t *testing.T) {
+	app := setupTestApp(t)
+	email := uniqueEmail("recovery")
+	userID, token := app.createTestUser(t, "Recovery", email, "password123")
+	secret, codes := app.enableTwoFactor(t, userID, token)
+
+	rec := app.doRequest(http.MethodPost, "/api/auth/2fa/recovery-codes", "", token)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = app.doRequest(http.MethodPost, "/api/auth/2fa/recovery-codes", `{"code":"`+app.totpCode(t, userID, secret)+`"}`, token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	fresh := parseJSON(t, rec)["recoveryCodes"].([]interface{})
+	require.Len(t, fresh, 10)
+	assert.NotContains(t, fresh, codes[0])
+
+	challenge := app.loginChallenge(t, email, "password123")
+	assert.Equal(t, http.StatusUnauthorized, app.verifyTwoFactor(challenge, "recoveryCode", codes[0]))
+
+	rec = app.doRequest(http.MethodPost, "/api/auth/2fa/disable", `{"recoveryCode":"`+fresh[0].(string)+`"}`, token)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	app.login(t, email, "password123")
+	rec = app.doRequest(http.MethodPost, "/api/auth/2fa/disable", `{"code":"123456"}`, token)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	var left int
+	require.NoError(t, app.db.QueryRow(`SELECT COUNT(*) FROM "RecoveryCode" WHERE userId = ?`, userID).Scan(&left))
+	assert.Equal(t, 0, left)
+}
+
+func TestTwoFactor_RequiredForRole(t *testing.T) {
+	app := setupTestApp(t, func(cfg *config.Config) { cfg.TwoFactorRole = "MODERATOR" })
+	modID, modToken := app.createTestUserWithRole(t, "Mod", uniqueEmail("mod"), "MODERATOR")
+	adminID, adminToken := app.createTestUserWithRole(t, "Admin", uniqueEmail("admin"), "ADMIN")
+	_, userToken := app.createTestUser(t, "Driver", uniqueEmail("driver"), "password123")
+
+	rec := app.doRequest(http.MethodGet, "/api/moderation/tracks", "", modToken)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/admin/sync-runs", "", adminToken)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/cars", "", modToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/auth/2fa", "", modToken)
+	assert.Equal(t, true, parseJSON(t, rec)["required"])
+	rec = app.doRequest(http.MethodGet, "/api/auth/2fa", "", userToken)
+	assert.Equal(t, false, parseJSON(t, rec)["required"])
+
+	modSecret, _ := app.enableTwoFactor(t, modID, modToken)
+	app.enableTwoFactor(t, adminID, adminToken)
+	rec = app.doRequest(http.MethodGet, "/api/moderation/tracks", "", modToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/admin/sync-runs", "", adminToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = app.doRequest(http.MethodPost, "/api/auth/2fa/disable", `{"code":"`+app.totpCode(t, modID, modSecret)+`"}`, modToken)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestTwoFactor_OIDCLogin(t *testing.T) {
+	app, mock := setupOIDCApp(t)
+	driver := mockOIDCUser{Subject: "2fa-1", Email: uniqueEmail("oidc2fa"), EmailVerified: true}
+	rec := app.oidcLogin(t, mock, driver)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	login := parseJSON(t, rec)
+	userID := login["user"].(map[string]interface{})["id"].(string)
+	secret, _ := app.enableTwoFactor(t, userID, login["token"].(string))
+
+	// Signing in with a provider needs a code too
+	rec = app.oidcLogin(t, mock, driver)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	body := parseJSON(t, rec)
+	require.Equal(t, true, body["twoFactorRequired"])
+	assert.Equal(t, http.StatusOK, app.verifyTwoFactor(body["challengeToken"].(string), "code", app.totpCode(t, userID, secret)))
+}
+
+func TestTwoFactor_RequiredForModeratorPowers(t *testing.T) {
+	app := setupTestApp(t, func(cfg *config.Config) { cfg.TwoFactorRole = "MODERATOR" })
+	modID, modToken := app.createTestUserWithRole(t, "Mod", uniqueEmail("mod"), "MODERATOR")
+	_, userToken := app.createTestUser(t, "Driver", uniqueEmail("driver"), "password123")
+	pendingID := app.createPendingTrack(t, userToken, `{"name":"Pending","location":"Somewhere, CA","eventTypes":["ROADCOURSE"]}`)
+	path := "/api/tracks/" + pendingID
+
+	// Without two-factor the moderator is treated as a regular user
+	ownID := app.createPendingTrack(t, modToken, `{"name":"Mod Track","location":"Elsewhere, CA","eventTypes":["ROADCOURSE"]}`)
+	track, err := app.trackRepo.FindByID(ownID)
+	require.NoError(t, err)
+	assert.Equal(t, "PENDING", track.Status)
+	rec := app.doRequest(http.MethodGet, path, "", modToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = app.doRequest(http.MethodGet, path+"/status-history", "", modToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = app.doRequest(http.MethodPut, path+"/sectors", `{"sectors":[{"name":"S1"}]}`, modToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	// and their edits to approved tracks go back for review
+	app.approveTrack(t, ownID)
+	rec = app.doRequest(http.MethodPatch, "/api/tracks/"+ownID, `{"name":"Renamed"}`, modToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "PENDING", parseJSON(t, rec)["status"])
+
+	app.enableTwoFactor(t, modID, modToken)
+	rec = app.doRequest(http.MethodGet, path, "", modToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodGet, path+"/status-history", "", modToken)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	app.approveTrack(t, ownID)
+	rec = app.doRequest(http.MethodPatch, "/api/tracks/"+ownID, `{"name":"Renamed again"}`, modToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "APPROVED", parseJSON(t, rec)["status"])
+}
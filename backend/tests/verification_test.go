@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var emailTokenPattern = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+// emails returns the emails sent to an address, oldest first.
+func (app *testApp) emails(t *testing.T, to string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(app.cfg.MailDir, "*.eml"))
+	require.NoError(t, err)
+	sort.Strings(files)
+	var emails []string
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		require.NoError(t, err)
+		if strings.Contains(string(data), "To: "+to+"\r\n") {
+			emails = append(emails, string(data))
+		}
+	}
+	return emails
+}
+
+// emailToken returns the token in the link of the latest email to an address.
+func (app *testApp) emailToken(t *testing.T, to string) string {
+	t.Helper()
+	emails := app.emails(t, to)
+	require.NotEmpty(t, emails, "no email to %s", to)
+	m := emailTokenPattern.FindStringSubmatch(emails[len(emails)-1])
+	require.NotNil(t, m, "no link in email to %s", to)
+	return m[1]
+}
+
+func TestRegister_InvalidEmail(t *testing.T) {
+	app := setupTestApp(t)
+	for _, email := range []string{"not-an-email", "Racer <racer@test.com>", "racer@localhost", "racer@test.com\r\nBcc: x@test.com"} {
+		body := `{"name":"Test","email":"` + strings.ReplaceAll(strings.ReplaceAll(email, "\r", `\r`), "\n", `\n`) + `","password":"password123","confirmPassword":"password123"}`
+		rec := app.doRequest(http.MethodPost, "/api/register", body, "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code, email)
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	app := setupTestApp(t)
+	_, ownerToken := app.createTestUser(t, "Owner", uniqueEmail("owner"), "password123")
+	trackID := app.createTestTrack(t, ownerToken)
+
+	email := uniqueEmail("verify")
+	rec := app.doRequest(http.MethodPost, "/api/register",
+		`{"name":"New Driver","email":"`+email+`","password":"password123","confirmPassword":"password123"}`, "")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Len(t, app.emails(t, email), 1)
+	firstToken := app.emailToken(t, email)
+	assert.Contains(t, app.emails(t, email)[0], "http://localhost:3000/verify-email?token="+firstToken)
+
+	login := app.login(t, email, "password123")
+	assert.Equal(t, false, login["user"].(map[string]interface{})["emailVerified"])
+	token := login["token"].(string)
+
+	// Unverified users can't add tracks, reviews or tips
+	rec = app.doRequest(http.MethodPost, "/api/tracks", `{"name":"Unverified Track","location":"Nowhere, CA","eventTypes":["ROADCOURSE"]}`, token)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = app.doRequest(http.MethodPost, "/api/tracks/"+trackID+"/reviews", `{"rating":5,"content":"Great","conditions":"DRY"}`, token)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = app.doRequest(http.MethodGet, "/api/cars", "", token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Asking again replaces the first link
+	rec = app.doRequest(http.MethodPost, "/api/auth/resend-verification", "", token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	secondToken := app.emailToken(t, email)
+	assert.NotEqual(t, firstToken, secondToken)
+	rec = app.doRequest(http.MethodPost, "/api/auth/verify-email", `{"token":"`+firstToken+`"}`, "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// A reset link doesn't verify an email
+	rec = app.doRequest(http.MethodPost, "/api/auth/forgot-password", `{"email":"`+email+`"}`, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = app.doRequest(http.MethodPost, "/api/auth/verify-email", `{"token":"`+app.emailToken(t, email)+`"}`, "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = app.doRequest(http.MethodPost, "/api/auth/verify-email", `{"token":"`+secondToken+`"}`, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = app.doRequest(http.MethodPost, "/api/auth/verify-email", `{"token":"`+secondToken+`"}`, "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = app.doRequest(http.MethodPost, "/api/tracks/"+trackID+"/reviews", `{"rating":5,"content":"Great","conditions":"DRY"}`, token)
+	assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = app.doRequest(http.MethodGet, "/api/profile", "", token)
+	assert.NotNil(t, parseJSON(t, rec)["emailVerified"])
+	rec = app.doRequest(http.MethodPost, "/api/auth/resend-verification", "", token)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestPasswordReset(t *testing.T) {
+	app := setupTestApp(t)
+	email := uniqueEmail("reset")
+	app.createTestUser(t, "Forgetful", email, "password123")
+	session := app.login(t, email, "password123")
+
+	// Unknown emails get the same answer and no email
+	rec := app.doRequest(http.MethodPost, "/api/auth/forgot-password", `{"email":"nobody@test.com"}`, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	known := app.doRequest(http.MethodPost, "/api/auth/forgot-password", `{"email":"`+email+`"}`, "")
+	require.Equal(t, http.StatusOK, known.Code)
+	assert.Equal(t, parseJSON(t, rec)["message"], parseJSON(t, known)["message"])
+	assert.Empty(t, app.emails(t, "nobody@test.com"))
+	resetToken := app.emailToken(t, email)
+	assert.Contains(t, app.emails(t, email)[0], "/reset-password?token=")
+
+	rec = app.doRequest(http.MethodPost, "/api/auth/reset-password",
+		`{"token":"`+resetToken+`","password":"newpassword1","confirmPassword":"different1"}`, "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = app.doRequest(http.MethodPost, "/api/auth/reset-password",
+		`{"token":"`+resetToken+`","password":"newpassword1","confirmPassword":"newpassword1"}`, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// Every session is logged out and only the new password works
+	rec = app.doRequest(http.MethodGet, "/api/profile", "", session["token"].(string))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = app.doRequest(http.MethodPost, "/api/auth/login", `{"email":"`+email+`","password":"password123"}`, "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	app.login(t, email, "newpassword1")
+
+	rec = app.doRequest(http.MethodPost, "/api/auth/reset-password",
+		`{"token":"`+resetToken+`","password":"another12","confirmPassword":"another12"}`, "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Expired links don't work
+	app.doRequest(http.MethodPost, "/api/auth/forgot-password", `{"email":"`+email+`"}`, "")
+	_, err := app.db.Exec(`UPDATE "VerificationToken" SET expires = datetime('now', '-1 minute')`)
+	require.NoError(t, err)
+	rec = app.doRequest(http.MethodPost, "/api/auth/reset-password",
+		`{"token":"`+app.emailToken(t, email)+`","password":"another12","confirmPassword":"another12"}`, "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
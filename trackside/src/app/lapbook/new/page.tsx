@@ -15,6 +15,13 @@ interface Track {
     events: { id: string; eventType: string }[];
 }
 
+interface TrackSuggestion {
+    id: string;
+    name: string;
+    location: string;
+    highlight: string;
+}
+
 interface Car {
     id: string;
     make: string;
@@ -28,7 +35,9 @@ function NewLapForm() {
     const { user } = useAuth();
     const preselectedTrackId = searchParams.get('trackId') || '';
 
-    const [tracks, setTracks] = useState<Track[]>([]);
+    const [selectedTrack, setSelectedTrack] = useState<Track | null>(null);
+    const [trackQuery, setTrackQuery] = useState('');
+    const [suggestions, setSuggestions] = useState<TrackSuggestion[]>([]);
     const [cars, setCars] = useState<Car[]>([]);
     const [loading, setLoading] = useState(true);
     const [submitting, setSubmitting] = useState(false);
@@ -73,21 +82,56 @@ function NewLapForm() {
     useEffect(() => {
         if (user) {
             Promise.all([
-                api('/api/tracks').then((r) => r.json()),
+                preselectedTrackId
+                    ? api(`/api/tracks/${preselectedTrackId}`).then((r) => (r.ok ? r.json() : null))
+                    : Promise.resolve(null),
                 api('/api/cars').then((r) => r.json()),
-            ]).then(([tracksData, carsData]) => {
-                setTracks(tracksData);
+            ]).then(([trackData, carsData]) => {
+                if (trackData) {
+                    setSelectedTrack(trackData);
+                    setTrackQuery(trackData.name);
+                }
                 setCars(carsData);
                 setLoading(false);
             });
         }
-    }, [user]);
+    }, [user, preselectedTrackId]);
 
-    const selectedTrack = tracks.find((t) => t.id === trackId);
+    // Debounced track autocomplete
+    useEffect(() => {
+        const q = trackQuery.trim();
+        if (!q || (selectedTrack && q === selectedTrack.name)) {
+            setSuggestions([]);
+            return;
+        }
+        const timer = setTimeout(() => {
+            api(`/api/tracks/autocomplete?q=${encodeURIComponent(q)}`)
+                .then((r) => r.json())
+                .then(setSuggestions)
+                .catch(() => setSuggestions([]));
+        }, 200);
+        return () => clearTimeout(timer);
+    }, [trackQuery, selectedTrack]);
+
+    const selectTrack = async (suggestion: TrackSuggestion) => {
+        setSuggestions([]);
+        setTrackQuery(suggestion.name);
+        setTrackEventId('');
+        const res = await api(`/api/tracks/${suggestion.id}`);
+        if (res.ok) {
+            const track: Track = await res.json();
+            setSelectedTrack(track);
+            setTrackId(track.id);
+        }
+    };
 
     const handleSubmit = async (e: React.FormEvent) => {
         e.preventDefault();
         setError('');
+        if (!trackId) {
+            setError('Select a track');
+            return;
+        }
         setSubmitting(true);
 
         const toNum = (v: string) => (v ? parseFloat(v) : undefined);
@@ -189,22 +233,41 @@ function NewLapForm() {
                 {/* Track Selection */}
                 <div>
                     <label className="label">Track</label>
-                    <select
-                        value={trackId}
-                        onChange={(e) => {
-                            setTrackId(e.target.value);
-                            setTrackEventId('');
-                        }}
-                        className="input-field"
-                        required
-                    >
-                        <option value="">Select a track</option>
-                        {tracks.map((t) => (
-                            <option key={t.id} value={t.id}>
-                                {t.name} — {t.location}
-                            </option>
-                        ))}
-                    </select>
+                    <div className="relative">
+                        <input
+                            type="text"
+                            value={trackQuery}
+                            onChange={(e) => {
+                                setTrackQuery(e.target.value);
+                                setTrackId('');
+                                setSelectedTrack(null);
+                                setTrackEventId('');
+                            }}
+                            placeholder="Search tracks"
+                            className="input-field"
+                            autoComplete="off"
+                        />
+                        {suggestions.length > 0 && (
+                            <ul className="absolute z-10 mt-1 w-full overflow-hidden rounded-xl border border-surface-700 bg-surface-900 shadow-lg">
+                                {suggestions.map((s) => (
+                                    <li key={s.id}>
+                                        <button
+                                            type="button"
+                                            onClick={() => selectTrack(s)}
+                                            className="w-full px-4 py-2 text-left hover:bg-surface-800"
+                                        >
+                                            {/* highlight is escaped server-side; only <mark> tags are added */}
+                                            <span
+                                                className="block text-sm text-white [&_mark]:bg-transparent [&_mark]:font-semibold [&_mark]:text-brand-400"
+                                                dangerouslySetInnerHTML={{ __html: s.highlight }}
+                                            />
+                                            <span className="block text-xs text-surface-400">{s.location}</span>
+                                        </button>
+                                    </li>
+                                ))}
+                            </ul>
+                        )}
+                    </div>
                 </div>
 
                 {/* Event Type */}
@@ -11,6 +11,8 @@ import { LoadingSpinner, EmptyState } from '@/components/ui/loading';
 interface LapRecord {
     id: string;
     lapTime: string;
+    isPersonalBest: boolean;
+    edited: boolean;
     conditions: string;
     notes: string | null;
     tirePressureFL: number | null;
@@ -116,7 +118,19 @@ export default function LapBookPage() {
                             >
                                 <div className="flex items-start justify-between">
                                     <div>
-                                        <p className="text-xl font-mono font-bold text-white">{record.lapTime}</p>
+                                        <p className="text-xl font-mono font-bold text-white">
+                                            {record.lapTime}
+                                            {record.isPersonalBest && (
+                                                <span className="ml-2 rounded bg-brand-600 px-1.5 py-0.5 align-middle font-sans text-xs font-semibold text-white">
+                                                    PB
+                                                </span>
+                                            )}
+                                            {record.edited && (
+                                                <span className="ml-2 align-middle font-sans text-xs font-normal text-surface-500">
+                                                    edited
+                                                </span>
+                                            )}
+                                        </p>
                                         <p className="text-sm text-surface-400 mt-0.5">{record.track.name}</p>
                                         <p className="text-xs text-surface-500">
                                             {record.car.year} {record.car.make} {record.car.model}
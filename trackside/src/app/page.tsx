@@ -20,6 +20,12 @@ interface Track {
     _count: { reviews: number; zones: number; lapRecords: number };
 }
 
+interface TrackPage {
+    tracks: Track[];
+    nextCursor: string | null;
+    total: number;
+}
+
 const EVENT_FILTERS = [
     { value: '', label: 'All' },
     { value: 'AUTOCROSS', label: 'Autocross' },
@@ -30,31 +36,39 @@ const EVENT_FILTERS = [
 
 export default function HomePage() {
     const [tracks, setTracks] = useState<Track[]>([]);
+    const [total, setTotal] = useState(0);
+    const [nextCursor, setNextCursor] = useState<string | null>(null);
     const [loading, setLoading] = useState(true);
+    const [loadingMore, setLoadingMore] = useState(false);
     const [search, setSearch] = useState('');
     const [eventFilter, setEventFilter] = useState('');
 
-    const fetchTracks = useCallback(async () => {
-        setLoading(true);
+    const fetchTracks = useCallback(async (cursor?: string) => {
+        if (cursor) setLoadingMore(true);
+        else setLoading(true);
         const params = new URLSearchParams();
         if (search) params.set('search', search);
         if (eventFilter) params.set('eventType', eventFilter);
+        if (cursor) params.set('cursor', cursor);
 
         try {
             const res = await api(`/api/tracks?${params}`);
             if (!res.ok) {
                 const errorText = await res.text();
                 console.error('API Error:', res.status, errorText);
-                setTracks([]);
+                if (!cursor) setTracks([]);
                 return;
             }
-            const data = await res.json();
-            setTracks(Array.isArray(data) ? data : []);
+            const data: TrackPage = await res.json();
+            setTracks((prev) => (cursor ? [...prev, ...data.tracks] : data.tracks));
+            setTotal(data.total);
+            setNextCursor(data.nextCursor);
         } catch (error) {
             console.error('Failed to fetch tracks:', error);
-            setTracks([]);
+            if (!cursor) setTracks([]);
         } finally {
             setLoading(false);
+            setLoadingMore(false);
         }
     }, [search, eventFilter]);
 
@@ -69,7 +83,7 @@ export default function HomePage() {
                 <div>
                     <h1 className="text-2xl font-bold text-white">Tracks</h1>
                     <p className="text-sm text-surface-400">
-                        {tracks.length} track{tracks.length !== 1 ? 's' : ''} available
+                        {total} track{total !== 1 ? 's' : ''} available
                     </p>
                 </div>
                 <Link href="/tracks/new" className="btn-primary text-sm">
@@ -201,6 +215,16 @@ export default function HomePage() {
                             </div>
                         </Link>
                     ))}
+
+                    {nextCursor && (
+                        <button
+                            onClick={() => fetchTracks(nextCursor)}
+                            disabled={loadingMore}
+                            className="btn-secondary w-full text-sm"
+                        >
+                            {loadingMore ? 'Loading...' : 'Load more tracks'}
+                        </button>
+                    )}
                 </div>
             )}
         </div>